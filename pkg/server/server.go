@@ -614,9 +614,9 @@ func NewServer(cfg Config, stopper *stop.Stopper) (*Server, error) {
 	var spanConfigAccessor spanconfig.KVAccessor
 	if cfg.SpanConfigsEnabled {
 		storeCfg.SpanConfigsEnabled = true
-		spanConfigAccessor = spanconfigkvaccessor.New(
+		spanConfigAccessor = spanconfigkvaccessor.NewWithTableName(
 			db, internalExecutor, cfg.Settings,
-			systemschema.SpanConfigurationsTableName.FQString(),
+			*systemschema.SpanConfigurationsTableName,
 		)
 	} else {
 		spanConfigAccessor = spanconfigkvaccessor.DisabledAccessor{}
@@ -0,0 +1,195 @@
+// Copyright 2021 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package spanconfigkvaccessor_test
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/spanconfig/spanconfigkvaccessor"
+	"github.com/cockroachdb/cockroach/pkg/testutils/testcluster"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/randutil"
+	"github.com/stretchr/testify/require"
+)
+
+// startBatchTestCluster is like startTestCluster, except that the span
+// configurations table is seeded with numEntries adjacent entries, every
+// tenth of which is missing.
+func startBatchTestCluster(
+	t testing.TB, numEntries int,
+) (*testcluster.TestCluster, *spanconfigkvaccessor.KVAccessor) {
+	ctx := context.Background()
+	tc, accessor := startTestCluster(t)
+
+	var toUpsert []roachpb.SpanConfigEntry
+	for i := 0; i < numEntries; i++ {
+		if i%10 == 9 {
+			continue
+		}
+		toUpsert = append(toUpsert, roachpb.SpanConfigEntry{
+			Span:   batchTestSpan(i, i+1),
+			Config: roachpb.SpanConfig{RangeMinBytes: int64(i)},
+		})
+	}
+	require.NoError(t, accessor.UpdateSpanConfigEntries(ctx, nil /* toDelete */, toUpsert))
+	return tc, accessor
+}
+
+// batchTestSpan returns the span covering the entries [start, end) seeded by
+// startBatchTestCluster.
+func batchTestSpan(start, end int) roachpb.Span {
+	return roachpb.Span{
+		Key:    roachpb.Key(fmt.Sprintf("%06d", start)),
+		EndKey: roachpb.Key(fmt.Sprintf("%06d", end)),
+	}
+}
+
+// TestBatchedGet ensures that GetSpanConfigEntriesFor, which normalizes the
+// spans before querying, returns the same entries as querying for each span
+// individually.
+func TestBatchedGet(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	const numEntries = 100
+	ctx := context.Background()
+	tc, accessor := startBatchTestCluster(t, numEntries)
+	defer tc.Stopper().Stop(ctx)
+
+	rng, _ := randutil.NewTestRand()
+	for i := 0; i < 20; i++ {
+		// Generate a mix of overlapping, adjacent and disjoint spans, which
+		// may fall within gaps between entries.
+		spans := make([]roachpb.Span, 1+rng.Intn(10))
+		for j := range spans {
+			start := rng.Intn(numEntries)
+			spans[j] = batchTestSpan(start, start+1+rng.Intn(10))
+		}
+		exp, err := accessor.TestingGetSpanConfigEntriesForUnbatched(ctx, spans)
+		require.NoError(t, err)
+		entries, err := accessor.GetSpanConfigEntriesFor(ctx, spans)
+		require.NoError(t, err)
+		require.Equal(t, exp, entries, "spans: %v", spans)
+	}
+}
+
+// BenchmarkGetSpanConfigEntriesFor compares GetSpanConfigEntriesFor against
+// querying for each span individually, for many adjacent spans.
+func BenchmarkGetSpanConfigEntriesFor(b *testing.B) {
+	defer leaktest.AfterTest(b)()
+
+	const numEntries = 1000
+	ctx := context.Background()
+	tc, accessor := startBatchTestCluster(b, numEntries)
+	defer tc.Stopper().Stop(ctx)
+
+	for _, numSpans := range []int{10, 100, 1000} {
+		spans := make([]roachpb.Span, numSpans)
+		for i := range spans {
+			spans[i] = batchTestSpan(i, i+1)
+		}
+		for _, impl := range []struct {
+			name string
+			get  func(context.Context, []roachpb.Span) ([]roachpb.SpanConfigEntry, error)
+		}{
+			{name: "batched", get: accessor.GetSpanConfigEntriesFor},
+			{name: "unbatched", get: accessor.TestingGetSpanConfigEntriesForUnbatched},
+		} {
+			b.Run(fmt.Sprintf("%s/spans=%d", impl.name, numSpans), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					if _, err := impl.get(ctx, spans); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// TestUpdateSpanConfigEntriesBatched ensures that batched updates leave the
+// table in the same state regardless of the concurrency with which the
+// batches are applied, and that the error of a failed batch is reported
+// without affecting the entries the batch covers.
+func TestUpdateSpanConfigEntriesBatched(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	const numEntries = 100
+	ctx := context.Background()
+	// The concurrency of the last case exceeds
+	// spanconfig.kvaccessor.max_update_concurrency, and is capped.
+	for _, concurrency := range []int{1, 4, 1000} {
+		t.Run(fmt.Sprintf("concurrency=%d", concurrency), func(t *testing.T) {
+			tc, accessor := startBatchTestCluster(t, numEntries)
+			defer tc.Stopper().Stop(ctx)
+
+			var events int64
+			accessor.OnChange(func(spanconfigkvaccessor.ChangeEvent) {
+				atomic.AddInt64(&events, 1)
+			})
+			opts := spanconfigkvaccessor.BatchedUpdateOptions{BatchSize: 7, Concurrency: concurrency}
+
+			// Replace the entries seeded in each run of ten keys, and the gap
+			// which follows them, with a single entry. The deletions overlap
+			// with the upsert, so each run is applied in its own batch.
+			var toDelete []roachpb.Span
+			var toUpsert, exp []roachpb.SpanConfigEntry
+			for i := 0; i < numEntries; i += 10 {
+				for j := i; j < i+9; j++ {
+					toDelete = append(toDelete, batchTestSpan(j, j+1))
+				}
+				entry := roachpb.SpanConfigEntry{
+					Span:   batchTestSpan(i, i+10),
+					Config: roachpb.SpanConfig{RangeMinBytes: int64(1000 + i)},
+				}
+				toUpsert = append(toUpsert, entry)
+				exp = append(exp, entry)
+			}
+			require.NoError(t, accessor.UpdateSpanConfigEntriesBatched(ctx, toDelete, toUpsert, opts))
+			entries, err := accessor.GetSpanConfigEntriesFor(ctx, []roachpb.Span{batchTestSpan(0, numEntries)})
+			require.NoError(t, err)
+			require.Equal(t, exp, entries)
+			require.Equal(t, int64(numEntries/10), atomic.LoadInt64(&events))
+
+			// Split the entries in two, except for one of them, for which the
+			// deletion of a span with no entry fails the batch. Each entry is
+			// split in its own batch.
+			opts.BatchSize = 3
+			toDelete, toUpsert = nil, nil
+			for i := 0; i < numEntries; i += 10 {
+				toDelete = append(toDelete, batchTestSpan(i, i+10))
+				if i == 50 {
+					toDelete[len(toDelete)-1] = batchTestSpan(i, i+5)
+				}
+				toUpsert = append(toUpsert,
+					roachpb.SpanConfigEntry{Span: batchTestSpan(i, i+5)},
+					roachpb.SpanConfigEntry{Span: batchTestSpan(i+5, i+10)},
+				)
+			}
+			err = accessor.UpdateSpanConfigEntriesBatched(ctx, toDelete, toUpsert, opts)
+			require.Regexp(t, `applying batch 6 of 10: .*expected to delete 1 row\(s\), deleted 0`, err)
+
+			// The failed batch is rolled back in its entirety.
+			entries, err = accessor.GetSpanConfigEntriesFor(ctx, []roachpb.Span{batchTestSpan(50, 60)})
+			require.NoError(t, err)
+			require.Equal(t, exp[5:6], entries)
+			if concurrency == 1 {
+				// The batches preceding the failed one were applied, and
+				// none were started after it.
+				entries, err = accessor.GetSpanConfigEntriesFor(ctx, []roachpb.Span{batchTestSpan(0, numEntries)})
+				require.NoError(t, err)
+				require.Len(t, entries, 2*5+1+4)
+			}
+		})
+	}
+}
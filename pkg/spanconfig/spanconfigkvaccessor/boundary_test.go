@@ -0,0 +1,68 @@
+// Copyright 2021 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package spanconfigkvaccessor_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/spanconfig/spanconfigtestutils"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/stretchr/testify/require"
+)
+
+// TestFindBoundaryViolations ensures that FindBoundaryViolations reports the
+// entries overlapping with the span which straddle a boundary, and not those
+// which start or end at one.
+func TestFindBoundaryViolations(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	tc, accessor := startTestCluster(t)
+	defer tc.Stopper().Stop(ctx)
+
+	entry := spanconfigtestutils.Entry
+	entries := []roachpb.SpanConfigEntry{
+		entry("a", "c").WithReplicas(3).Build(),
+		entry("c", "f").WithReplicas(3).Build(),
+		entry("f", "h").WithReplicas(3).Build(),
+		entry("h", "k").WithReplicas(3).Build(),
+		entry("x", "z").WithReplicas(3).Build(),
+	}
+	require.NoError(t, accessor.UpdateSpanConfigEntries(ctx, nil /* toDelete */, entries))
+
+	span := func(start, end string) roachpb.Span {
+		return entry(start, end).Build().Span
+	}
+	// The boundaries are given in no particular order. Those at c and h
+	// separate entries, while those at d and y are straddled.
+	boundaries := []roachpb.Key{roachpb.Key("y"), roachpb.Key("c"), roachpb.Key("h"), roachpb.Key("d")}
+	violations, err := accessor.FindBoundaryViolations(ctx, span("a", "z"), boundaries)
+	require.NoError(t, err)
+	require.Equal(t, []roachpb.SpanConfigEntry{entries[1], entries[4]}, violations)
+
+	// Only the entries overlapping with the span are checked, including those
+	// extending past it.
+	violations, err = accessor.FindBoundaryViolations(ctx, span("e", "g"), boundaries)
+	require.NoError(t, err)
+	require.Equal(t, []roachpb.SpanConfigEntry{entries[1]}, violations)
+	violations, err = accessor.FindBoundaryViolations(ctx, span("f", "x"), boundaries)
+	require.NoError(t, err)
+	require.Empty(t, violations)
+
+	// No entry straddles the boundaries between entries.
+	violations, err = accessor.FindBoundaryViolations(ctx, span("a", "z"), []roachpb.Key{
+		roachpb.Key("c"), roachpb.Key("f"), roachpb.Key("h"), roachpb.Key("k"), roachpb.Key("x"),
+	})
+	require.NoError(t, err)
+	require.Empty(t, violations)
+}
@@ -0,0 +1,164 @@
+// Copyright 2021 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package spanconfigkvaccessor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/spanconfig"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+)
+
+// maxCachedSpanSets bounds the number of sets of spans for which a
+// CachingAccessor caches entries.
+const maxCachedSpanSets = 1024
+
+// CachingAccessor is a KVAccessor which caches the entries read through it
+// for a short while, for the benefit of callers repeatedly reading the same
+// spans. Entries are cached for each set of spans read, once normalized, and
+// expire after a fixed duration. Updates routed through the CachingAccessor
+// invalidate all the cached entries, but updates made otherwise, including
+// through the KVAccessor it wraps, are only observed once the cached entries
+// expire.
+type CachingAccessor struct {
+	inner      spanconfig.KVAccessor
+	ttl        time.Duration
+	timeSource timeutil.TimeSource
+
+	mu struct {
+		syncutil.Mutex
+		// cache maps the keys of sets of normalized spans to the entries
+		// overlapping with them.
+		cache map[string]cachedEntries
+		// generation is incremented whenever the cache is invalidated, so that
+		// reads which started beforehand don't populate it.
+		generation int64
+	}
+}
+
+var _ spanconfig.KVAccessor = &CachingAccessor{}
+
+// cachedEntries are the sorted, non-overlapping entries read for a set of
+// spans.
+type cachedEntries struct {
+	entries []roachpb.SpanConfigEntry
+	expiry  time.Time
+}
+
+// NewCachingAccessor constructs a CachingAccessor which reads through the
+// given KVAccessor, caching the entries it returns for the given duration.
+func NewCachingAccessor(inner *KVAccessor, ttl time.Duration) *CachingAccessor {
+	return newCachingAccessor(inner, ttl, timeutil.DefaultTimeSource{})
+}
+
+func newCachingAccessor(
+	inner spanconfig.KVAccessor, ttl time.Duration, timeSource timeutil.TimeSource,
+) *CachingAccessor {
+	c := &CachingAccessor{inner: inner, ttl: ttl, timeSource: timeSource}
+	c.mu.cache = make(map[string]cachedEntries)
+	return c
+}
+
+// GetSpanConfigEntriesFor is part of the KVAccessor interface. The entries
+// are returned like they are by (*KVAccessor).GetSpanConfigEntriesFor, from
+// the cache if it holds unexpired entries for the normalized spans, and
+// otherwise from the wrapped KVAccessor.
+func (c *CachingAccessor) GetSpanConfigEntriesFor(
+	ctx context.Context, spans []roachpb.Span,
+) ([]roachpb.SpanConfigEntry, error) {
+	if len(spans) == 0 {
+		return nil, nil
+	}
+	if err := validateSpans(spans); err != nil {
+		return nil, err
+	}
+	normalized := NormalizeSpans(spans)
+	key := spanSetKey(normalized)
+
+	c.mu.Lock()
+	cached, ok := c.mu.cache[key]
+	generation := c.mu.generation
+	c.mu.Unlock()
+	if ok && c.timeSource.Now().Before(cached.expiry) {
+		return mapEntriesToSpans(spans, cached.entries), nil
+	}
+
+	entries, err := c.inner.GetSpanConfigEntriesFor(ctx, normalized)
+	if err != nil {
+		return nil, err
+	}
+	// Entries overlapping with more than one of the normalized spans are
+	// returned once for each.
+	entries = sortAndDedupEntries(entries)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.mu.generation == generation {
+		c.maybeEvictLocked()
+		c.mu.cache[key] = cachedEntries{
+			entries: entries,
+			expiry:  c.timeSource.Now().Add(c.ttl),
+		}
+	}
+	return mapEntriesToSpans(spans, entries), nil
+}
+
+// UpdateSpanConfigEntries is part of the KVAccessor interface. The cache is
+// invalidated, whether or not the update succeeds.
+func (c *CachingAccessor) UpdateSpanConfigEntries(
+	ctx context.Context, toDelete []roachpb.Span, toUpsert []roachpb.SpanConfigEntry,
+) error {
+	defer c.invalidate()
+	return c.inner.UpdateSpanConfigEntries(ctx, toDelete, toUpsert)
+}
+
+// invalidate drops all the cached entries.
+func (c *CachingAccessor) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.mu.generation++
+	c.mu.cache = make(map[string]cachedEntries)
+}
+
+// maybeEvictLocked makes room in the cache, if it's full, by dropping the
+// expired entries, or all of them if none are expired.
+func (c *CachingAccessor) maybeEvictLocked() {
+	if len(c.mu.cache) < maxCachedSpanSets {
+		return
+	}
+	now := c.timeSource.Now()
+	for key, cached := range c.mu.cache {
+		if !now.Before(cached.expiry) {
+			delete(c.mu.cache, key)
+		}
+	}
+	if len(c.mu.cache) >= maxCachedSpanSets {
+		c.mu.cache = make(map[string]cachedEntries)
+	}
+}
+
+// spanSetKey returns a key identifying the given spans. The keys are length
+// prefixed so that distinct sets of spans map to distinct keys.
+func spanSetKey(spans []roachpb.Span) string {
+	var b strings.Builder
+	for _, sp := range spans {
+		for _, k := range []roachpb.Key{sp.Key, sp.EndKey} {
+			fmt.Fprintf(&b, "%d:", len(k))
+			b.Write(k)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,149 @@
+// Copyright 2021 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package spanconfigkvaccessor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeAccessor is an in-memory KVAccessor which counts the reads made
+// through it.
+type fakeAccessor struct {
+	entries []roachpb.SpanConfigEntry
+	reads   int
+	err     error
+}
+
+func (f *fakeAccessor) GetSpanConfigEntriesFor(
+	_ context.Context, spans []roachpb.Span,
+) ([]roachpb.SpanConfigEntry, error) {
+	f.reads++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return mapEntriesToSpans(spans, sortAndDedupEntries(append(
+		[]roachpb.SpanConfigEntry(nil), f.entries...,
+	))), nil
+}
+
+func (f *fakeAccessor) UpdateSpanConfigEntries(
+	_ context.Context, toDelete []roachpb.Span, toUpsert []roachpb.SpanConfigEntry,
+) error {
+	if f.err != nil {
+		return f.err
+	}
+	var kept []roachpb.SpanConfigEntry
+	for _, e := range f.entries {
+		deleted := false
+		for _, sp := range toDelete {
+			deleted = deleted || e.Span.Equal(sp)
+		}
+		for _, u := range toUpsert {
+			deleted = deleted || e.Span.Equal(u.Span)
+		}
+		if !deleted {
+			kept = append(kept, e)
+		}
+	}
+	f.entries = append(kept, toUpsert...)
+	return nil
+}
+
+func TestCachingAccessor(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	span := func(start, end string) roachpb.Span {
+		return roachpb.Span{Key: roachpb.Key(start), EndKey: roachpb.Key(end)}
+	}
+	entry := func(start, end string, rangeMaxBytes int64) roachpb.SpanConfigEntry {
+		return roachpb.SpanConfigEntry{
+			Span:   span(start, end),
+			Config: roachpb.SpanConfig{RangeMaxBytes: rangeMaxBytes},
+		}
+	}
+	const ttl = time.Minute
+	setup := func() (*fakeAccessor, *timeutil.ManualTime, *CachingAccessor) {
+		inner := &fakeAccessor{entries: []roachpb.SpanConfigEntry{
+			entry("a", "c", 1), entry("c", "e", 2), entry("x", "z", 3),
+		}}
+		ts := timeutil.NewManualTime(timeutil.Unix(0, 0))
+		return inner, ts, newCachingAccessor(inner, ttl, ts)
+	}
+	get := func(t *testing.T, c *CachingAccessor, spans ...roachpb.Span) []roachpb.SpanConfigEntry {
+		entries, err := c.GetSpanConfigEntriesFor(ctx, spans)
+		require.NoError(t, err)
+		return entries
+	}
+
+	t.Run("miss then hit", func(t *testing.T) {
+		inner, _, c := setup()
+		exp := []roachpb.SpanConfigEntry{entry("a", "c", 1), entry("c", "e", 2)}
+		require.Equal(t, exp, get(t, c, span("b", "d")))
+		require.Equal(t, 1, inner.reads)
+		require.Equal(t, exp, get(t, c, span("b", "d")))
+		require.Equal(t, 1, inner.reads)
+
+		// Spans are cached once normalized, and the entries are mapped back to
+		// the spans requested.
+		require.Equal(t, []roachpb.SpanConfigEntry{
+			entry("c", "e", 2), entry("a", "c", 1),
+		}, get(t, c, span("c", "d"), span("b", "c")))
+		require.Equal(t, 1, inner.reads)
+
+		// Other spans miss.
+		require.Equal(t, []roachpb.SpanConfigEntry{entry("x", "z", 3)}, get(t, c, span("y", "z")))
+		require.Equal(t, 2, inner.reads)
+		require.Equal(t, []roachpb.SpanConfigEntry{
+			entry("a", "c", 1), entry("x", "z", 3),
+		}, get(t, c, span("a", "b"), span("y", "z")))
+		require.Equal(t, 3, inner.reads)
+	})
+
+	t.Run("expiry", func(t *testing.T) {
+		inner, ts, c := setup()
+		get(t, c, span("a", "b"))
+		ts.Advance(ttl - time.Nanosecond)
+		get(t, c, span("a", "b"))
+		require.Equal(t, 1, inner.reads)
+		ts.Advance(time.Nanosecond)
+		get(t, c, span("a", "b"))
+		require.Equal(t, 2, inner.reads)
+	})
+
+	t.Run("invalidation after write", func(t *testing.T) {
+		inner, _, c := setup()
+		require.Equal(t, []roachpb.SpanConfigEntry{entry("a", "c", 1)}, get(t, c, span("a", "b")))
+		require.NoError(t, c.UpdateSpanConfigEntries(ctx, nil /* toDelete */, []roachpb.SpanConfigEntry{
+			entry("a", "c", 4),
+		}))
+		require.Equal(t, []roachpb.SpanConfigEntry{entry("a", "c", 4)}, get(t, c, span("a", "b")))
+		require.Equal(t, 2, inner.reads)
+	})
+
+	t.Run("errors are not cached", func(t *testing.T) {
+		inner, _, c := setup()
+		inner.err = errors.New("boom")
+		_, err := c.GetSpanConfigEntriesFor(ctx, []roachpb.Span{span("a", "b")})
+		require.EqualError(t, err, "boom")
+		inner.err = nil
+		require.Equal(t, []roachpb.SpanConfigEntry{entry("a", "c", 1)}, get(t, c, span("a", "b")))
+		require.Equal(t, 2, inner.reads)
+	})
+}
@@ -0,0 +1,110 @@
+// Copyright 2021 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package spanconfigkvaccessor_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/spanconfig/spanconfigkvaccessor"
+	"github.com/cockroachdb/cockroach/pkg/spanconfig/spanconfigtestutils"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// TestOnChange ensures that subscribers are notified exactly once of each
+// committed update, and not at all of updates which are rolled back.
+func TestOnChange(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	tc, accessor := startTestCluster(t)
+	defer tc.Stopper().Stop(ctx)
+
+	var events []spanconfigkvaccessor.ChangeEvent
+	accessor.OnChange(func(ev spanconfigkvaccessor.ChangeEvent) {
+		events = append(events, ev)
+	})
+	// requireEvent asserts that a single event was published since the last
+	// call, with the given contents, and at a later timestamp than the
+	// previous one.
+	var last spanconfigkvaccessor.ChangeEvent
+	requireEvent := func(t *testing.T, deleted []roachpb.Span, upserted []roachpb.SpanConfigEntry) {
+		t.Helper()
+		require.Len(t, events, 1)
+		ev := events[0]
+		events = nil
+		require.Equal(t, deleted, ev.Deleted)
+		require.Equal(t, upserted, ev.Upserted)
+		require.True(t, last.Timestamp.Less(ev.Timestamp))
+		last = ev
+	}
+
+	entry := spanconfigtestutils.Entry
+	span := func(start, end string) roachpb.Span {
+		return entry(start, end).Build().Span
+	}
+	toUpsert := []roachpb.SpanConfigEntry{
+		entry("a", "b").WithTag("A").Build(),
+		entry("b", "c").WithTag("B").Build(),
+		entry("c", "d").WithTag("C").Build(),
+	}
+	require.NoError(t, accessor.UpdateSpanConfigEntries(ctx, nil /* toDelete */, toUpsert))
+	requireEvent(t, nil, toUpsert)
+
+	// Updates which fail are rolled back.
+	require.Error(t, accessor.UpdateSpanConfigEntries(
+		ctx, []roachpb.Span{span("x", "y")}, nil, /* toUpsert */
+	))
+	require.Empty(t, events)
+
+	// As are updates rejected by ApplyWithValidation.
+	toDelete := []roachpb.Span{span("b", "c")}
+	require.Error(t, accessor.ApplyWithValidation(ctx, toDelete, nil, /* toUpsert */
+		func(*spanconfigkvaccessor.UpdateSummary) error {
+			return errors.New("rejected")
+		}))
+	require.Empty(t, events)
+	require.NoError(t, accessor.ApplyWithValidation(ctx, toDelete, nil, /* toUpsert */
+		func(*spanconfigkvaccessor.UpdateSummary) error {
+			return nil
+		}))
+	requireEvent(t, toDelete, nil)
+
+	// Split entries are reported as deleted and upserted.
+	split := []roachpb.SpanConfigEntry{entry("ab", "cd").WithTag("X").Build()}
+	require.NoError(t, accessor.UpdateSpanConfigEntriesWithSplits(ctx, nil /* toDelete */, split))
+	requireEvent(t,
+		[]roachpb.Span{span("a", "b"), span("c", "d")},
+		[]roachpb.SpanConfigEntry{
+			entry("a", "ab").WithTag("A").Build(),
+			entry("cd", "d").WithTag("C").Build(),
+			split[0],
+		},
+	)
+
+	deleted, err := accessor.DeleteWhere(ctx, span("a", "z"), func(conf roachpb.SpanConfig) bool {
+		return conf.Equal(split[0].Config)
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, deleted)
+	requireEvent(t, []roachpb.Span{span("ab", "cd")}, nil)
+
+	// Updates which don't change anything publish no events.
+	deleted, err = accessor.DeleteWhere(ctx, span("a", "z"), func(roachpb.SpanConfig) bool {
+		return false
+	})
+	require.NoError(t, err)
+	require.Zero(t, deleted)
+	require.Empty(t, events)
+}
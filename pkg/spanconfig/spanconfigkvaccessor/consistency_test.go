@@ -0,0 +1,94 @@
+// Copyright 2021 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package spanconfigkvaccessor_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/spanconfig/spanconfigkvaccessor"
+	"github.com/cockroachdb/cockroach/pkg/spanconfig/spanconfigtestutils"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/stretchr/testify/require"
+)
+
+// TestCheckConsistency ensures that CheckConsistency classifies each kind of
+// drift between the stored and the desired entries, without writing.
+func TestCheckConsistency(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	tc, accessor := startTestCluster(t)
+	defer tc.Stopper().Stop(ctx)
+
+	entry := spanconfigtestutils.Entry
+	span := func(start, end string) roachpb.Span {
+		return entry(start, end).Build().Span
+	}
+	desired := []roachpb.SpanConfigEntry{
+		entry("a", "b").WithTag("A").Build(),
+		entry("b", "c").WithTag("B").Build(),
+		entry("c", "d").WithTag("C").Build(),
+	}
+	generator := func(sp roachpb.Span) (entries []roachpb.SpanConfigEntry) {
+		for _, e := range desired {
+			if sp.Contains(e.Span) {
+				entries = append(entries, e)
+			}
+		}
+		return entries
+	}
+	require.NoError(t, accessor.UpdateSpanConfigEntries(ctx, nil /* toDelete */, desired))
+
+	report, err := accessor.CheckConsistency(ctx, span("a", "m"), generator)
+	require.NoError(t, err)
+	require.True(t, report.Consistent())
+
+	// Seed drift of each kind: a missing entry, an extra one, and one with a
+	// mismatched config.
+	extra := entry("e", "f").WithTag("E").Build()
+	require.NoError(t, accessor.UpdateSpanConfigEntries(ctx,
+		[]roachpb.Span{span("a", "b")},
+		[]roachpb.SpanConfigEntry{entry("b", "c").WithTag("X").Build(), extra},
+	))
+	_, since, err := accessor.Poll(ctx, []roachpb.Span{span("a", "z")}, hlc.Timestamp{})
+	require.NoError(t, err)
+
+	report, err = accessor.CheckConsistency(ctx, span("a", "m"), generator)
+	require.NoError(t, err)
+	require.False(t, report.Consistent())
+	require.Equal(t, &spanconfigkvaccessor.ConsistencyReport{
+		Missing: []roachpb.SpanConfigEntry{desired[0]},
+		Extra:   []roachpb.SpanConfigEntry{extra},
+		Mismatched: []spanconfigkvaccessor.ConfigMismatch{{
+			Span:    span("b", "c"),
+			Stored:  entry("b", "c").WithTag("X").Build().Config,
+			Desired: desired[1].Config,
+		}},
+	}, report)
+
+	// The check wrote nothing.
+	written, _, err := accessor.Poll(ctx, []roachpb.Span{span("a", "z")}, since)
+	require.NoError(t, err)
+	require.Empty(t, written)
+
+	// Only the entries overlapping with the span are checked.
+	report, err = accessor.CheckConsistency(ctx, span("c", "d"), generator)
+	require.NoError(t, err)
+	require.True(t, report.Consistent())
+
+	// Desired entries must be contained in the span.
+	_, err = accessor.CheckConsistency(ctx, span("b", "c"),
+		func(roachpb.Span) []roachpb.SpanConfigEntry { return desired })
+	require.Regexp(t, "is not contained in", err)
+}
@@ -25,29 +25,111 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/testutils/testcluster"
 	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
 	"github.com/cockroachdb/datadriven"
+	"github.com/cockroachdb/errors"
 )
 
 // TestDataDriven runs datadriven tests against the kvaccessor interface.
-// The syntax is as follows:
+// Spans and entries are written as described in
+// spanconfigtestutils.Parse{Span,Config,SpanConfigEntry}. Errors are printed
+// as "err: <error>". The commands are:
+//
+// kvaccessor-get ties into GetSpanConfigEntriesFor, reading the entries
+// overlapping any of the listed spans:
 //
 // 		kvaccessor-get
 // 		span [a,e)
-// 		span [a,b)
 // 		span [b,c)
-//      ----
+// 		----
+//
+// kvaccessor-get-sorted is like kvaccessor-get, but ties into
+// GetSortedSpanConfigEntriesFor:
+//
+// 		kvaccessor-get-sorted
+// 		span [a,e)
+// 		----
+//
+// kvaccessor-get-grouped ties into GetSpanConfigEntriesGrouped, printing the
+// entries of each listed span prefixed with the index of the span:
+//
+// 		kvaccessor-get-grouped
+// 		span [a,e)
+// 		span [b,c)
+// 		----
+//
+// kvaccessor-get-effective ties into GetEffectiveConfigs, for a single span:
+//
+// 		kvaccessor-get-effective
+// 		span [a,e)
+// 		----
+//
+// kvaccessor-get-exact ties into GetSpanConfigEntryExact, for a single span,
+// printing "not found" if there's no entry with exactly the span:
+//
+// 		kvaccessor-get-exact
+// 		span [a,e)
+// 		----
+//
+// kvaccessor-update ties into UpdateSpanConfigEntries, deleting the spans
+// prefixed with "delete" and upserting the entries prefixed with "upsert". With
+// the split argument, it ties into UpdateSpanConfigEntriesWithSplits instead,
+// and with the count argument, into UpdateSpanConfigEntriesAndCount, printing
+// the count returned:
 //
 // 		kvaccessor-update
 // 		delete [c,e)
 // 		upsert [c,d):C
 // 		upsert [d,e):D
-//      ----
-//
-// They tie into GetSpanConfigEntriesFor and UpdateSpanConfigEntries
-// respectively. For kvaccessor-get, each listed span is added to the set of
-// spans being read. For kvaccessor-update, the lines prefixed with "delete"
-// count towards the spans being deleted, and for "upsert" they correspond to
-// the span config entries being upserted. See
-// spanconfigtestutils.Parse{Span,Config,SpanConfigEntry} for more details.
+// 		----
+//
+// kvaccessor-apply-with-validation is like kvaccessor-update, but ties into
+// ApplyWithValidation, printing the summary passed to the validation callback,
+// which rejects the update given the reject argument:
+//
+// 		kvaccessor-apply-with-validation reject
+// 		delete [c,e)
+// 		upsert [b,d):X
+// 		----
+//
+// kvaccessor-compact ties into Compact, for a single span, printing the number
+// of entries removed:
+//
+// 		kvaccessor-compact
+// 		span [a,e)
+// 		----
+//
+// kvaccessor-assert-coverage ties into AssertFullCoverage, for a single span,
+// printing "ok" if the span is fully covered:
+//
+// 		kvaccessor-assert-coverage
+// 		span [a,e)
+// 		----
+//
+// kvaccessor-distinct-configs ties into DistinctConfigs, for a single span,
+// printing each of the configs returned:
+//
+// 		kvaccessor-distinct-configs
+// 		span [a,e)
+// 		----
+//
+// kvaccessor-delete-where ties into DeleteWhere, for a single span, deleting
+// the entries with the given config and printing the number deleted:
+//
+// 		kvaccessor-delete-where config=A
+// 		span [a,e)
+// 		----
+//
+// kvaccessor-scan ties into Scan, walking the table in batches of the given
+// size, starting after the given key, if any, and printing each batch:
+//
+// 		kvaccessor-scan batch-size=2 after=b
+// 		----
+//
+// exec-sql executes the given SQL statement, and can be used to directly
+// manipulate the span configurations table:
+//
+// 		exec-sql
+// 		DELETE FROM defaultdb.public.dummy_span_configurations
+// 		----
 func TestDataDriven(t *testing.T) {
 	defer leaktest.AfterTest(t)()
 
@@ -73,7 +155,9 @@ func TestDataDriven(t *testing.T) {
 
 		datadriven.RunTest(t, path, func(t *testing.T, d *datadriven.TestData) string {
 			switch d.Cmd {
-			case "kvaccessor-get":
+			case "kvaccessor-get", "kvaccessor-get-sorted", "kvaccessor-get-effective",
+				"kvaccessor-get-exact", "kvaccessor-compact", "kvaccessor-assert-coverage",
+				"kvaccessor-distinct-configs", "kvaccessor-delete-where", "kvaccessor-get-grouped":
 				var spans []roachpb.Span
 				for _, line := range strings.Split(d.Input, "\n") {
 					line = strings.TrimSpace(line)
@@ -89,7 +173,87 @@ func TestDataDriven(t *testing.T) {
 					spans = append(spans, spanconfigtestutils.ParseSpan(t, line))
 				}
 
-				entries, err := accessor.GetSpanConfigEntriesFor(ctx, spans)
+				get := accessor.GetSpanConfigEntriesFor
+				switch d.Cmd {
+				case "kvaccessor-get-sorted":
+					get = accessor.GetSortedSpanConfigEntriesFor
+				case "kvaccessor-get-effective":
+					get = func(ctx context.Context, spans []roachpb.Span) ([]roachpb.SpanConfigEntry, error) {
+						if len(spans) != 1 {
+							t.Fatalf("expected a single span, found %d", len(spans))
+						}
+						return accessor.GetEffectiveConfigs(ctx, spans[0])
+					}
+				case "kvaccessor-get-exact":
+					if len(spans) != 1 {
+						t.Fatalf("expected a single span, found %d", len(spans))
+					}
+					entry, found, err := accessor.GetSpanConfigEntryExact(ctx, spans[0])
+					if err != nil {
+						return fmt.Sprintf("err: %s", err.Error())
+					}
+					if !found {
+						return "not found"
+					}
+					return spanconfigtestutils.PrintSpanConfigEntry(entry)
+				case "kvaccessor-compact":
+					if len(spans) != 1 {
+						t.Fatalf("expected a single span, found %d", len(spans))
+					}
+					removed, err := accessor.Compact(ctx, spans[0])
+					if err != nil {
+						return fmt.Sprintf("err: %s", err.Error())
+					}
+					return fmt.Sprintf("removed %d", removed)
+				case "kvaccessor-assert-coverage":
+					if len(spans) != 1 {
+						t.Fatalf("expected a single span, found %d", len(spans))
+					}
+					if err := accessor.AssertFullCoverage(ctx, spans[0]); err != nil {
+						return fmt.Sprintf("err: %s", err.Error())
+					}
+					return "ok"
+				case "kvaccessor-delete-where":
+					if len(spans) != 1 {
+						t.Fatalf("expected a single span, found %d", len(spans))
+					}
+					var conf string
+					d.ScanArgs(t, "config", &conf)
+					toMatch := spanconfigtestutils.ParseConfig(t, conf)
+					deleted, err := accessor.DeleteWhere(ctx, spans[0], func(c roachpb.SpanConfig) bool {
+						return c.Equal(toMatch)
+					})
+					if err != nil {
+						return fmt.Sprintf("err: %s", err.Error())
+					}
+					return fmt.Sprintf("deleted %d", deleted)
+				case "kvaccessor-get-grouped":
+					grouped, err := accessor.GetSpanConfigEntriesGrouped(ctx, spans)
+					if err != nil {
+						return fmt.Sprintf("err: %s", err.Error())
+					}
+					var output strings.Builder
+					for i := range spans {
+						for _, entry := range grouped[i] {
+							output.WriteString(fmt.Sprintf("%d: %s\n", i, spanconfigtestutils.PrintSpanConfigEntry(entry)))
+						}
+					}
+					return output.String()
+				case "kvaccessor-distinct-configs":
+					if len(spans) != 1 {
+						t.Fatalf("expected a single span, found %d", len(spans))
+					}
+					configs, err := accessor.DistinctConfigs(ctx, spans[0])
+					if err != nil {
+						return fmt.Sprintf("err: %s", err.Error())
+					}
+					var output strings.Builder
+					for _, conf := range configs {
+						output.WriteString(fmt.Sprintf("%s\n", spanconfigtestutils.PrintSpanConfig(conf)))
+					}
+					return output.String()
+				}
+				entries, err := get(ctx, spans)
 				if err != nil {
 					return fmt.Sprintf("err: %s", err.Error())
 				}
@@ -99,7 +263,7 @@ func TestDataDriven(t *testing.T) {
 					output.WriteString(fmt.Sprintf("%s\n", spanconfigtestutils.PrintSpanConfigEntry(entry)))
 				}
 				return output.String()
-			case "kvaccessor-update":
+			case "kvaccessor-update", "kvaccessor-apply-with-validation":
 				var toDelete []roachpb.Span
 				var toUpsert []roachpb.SpanConfigEntry
 				for _, line := range strings.Split(d.Input, "\n") {
@@ -122,10 +286,80 @@ func TestDataDriven(t *testing.T) {
 						toUpsert = append(toUpsert, spanconfigtestutils.ParseSpanConfigEntry(t, line))
 					}
 				}
-				if err := accessor.UpdateSpanConfigEntries(ctx, toDelete, toUpsert); err != nil {
+				if d.Cmd == "kvaccessor-apply-with-validation" {
+					var output strings.Builder
+					err := accessor.ApplyWithValidation(ctx, toDelete, toUpsert,
+						func(summary *spanconfigkvaccessor.UpdateSummary) error {
+							output.Reset()
+							for _, section := range []struct {
+								name    string
+								entries []roachpb.SpanConfigEntry
+							}{
+								{"deleted", summary.Deleted},
+								{"upserted", summary.Upserted},
+								{"before", summary.Before},
+								{"after", summary.After},
+							} {
+								output.WriteString(section.name + ":")
+								for _, entry := range section.entries {
+									output.WriteString(" " + spanconfigtestutils.PrintSpanConfigEntry(entry))
+								}
+								output.WriteString("\n")
+							}
+							if d.HasArg("reject") {
+								return errors.New("rejected")
+							}
+							return nil
+						})
+					if err != nil {
+						output.WriteString(fmt.Sprintf("err: %s", err.Error()))
+					} else {
+						output.WriteString("ok")
+					}
+					return output.String()
+				}
+				if d.HasArg("count") {
+					count, err := accessor.UpdateSpanConfigEntriesAndCount(ctx, toDelete, toUpsert)
+					if err != nil {
+						return fmt.Sprintf("err: %s", err.Error())
+					}
+					return fmt.Sprintf("ok, count=%d", count)
+				}
+				update := accessor.UpdateSpanConfigEntries
+				if d.HasArg("split") {
+					update = accessor.UpdateSpanConfigEntriesWithSplits
+				}
+				if err := update(ctx, toDelete, toUpsert); err != nil {
 					return fmt.Sprintf("err: %s", err.Error())
 				}
 				return "ok"
+			case "kvaccessor-scan":
+				var batchSize int
+				d.ScanArgs(t, "batch-size", &batchSize)
+				var cursor spanconfigkvaccessor.Cursor
+				if d.HasArg("after") {
+					var after string
+					d.ScanArgs(t, "after", &after)
+					cursor = spanconfigkvaccessor.CursorAfter(roachpb.Key(after))
+				}
+				var output strings.Builder
+				for !cursor.Done() {
+					var entries []roachpb.SpanConfigEntry
+					var err error
+					entries, cursor, err = accessor.Scan(ctx, cursor, batchSize)
+					if err != nil {
+						return fmt.Sprintf("err: %s", err.Error())
+					}
+					output.WriteString("batch:")
+					for _, entry := range entries {
+						output.WriteString(fmt.Sprintf(" %s", spanconfigtestutils.PrintSpanConfigEntry(entry)))
+					}
+					output.WriteString("\n")
+				}
+				return output.String()
+			case "exec-sql":
+				tdb.Exec(t, d.Input)
+				return ""
 			default:
 				t.Fatalf("unknown command: %s", d.Cmd)
 			}
@@ -0,0 +1,84 @@
+// Copyright 2021 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package spanconfigkvaccessor_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/base"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/spanconfig/spanconfigkvaccessor"
+	"github.com/cockroachdb/cockroach/pkg/spanconfig/spanconfigtestutils"
+	"github.com/cockroachdb/cockroach/pkg/sql/sqlutil"
+	"github.com/cockroachdb/cockroach/pkg/testutils/sqlutils"
+	"github.com/cockroachdb/cockroach/pkg/testutils/testcluster"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// TestDuplicateSpans ensures that duplicate entries in the span
+// configurations table are surfaced as a DuplicateSpanError when reading and
+// updating span configs.
+func TestDuplicateSpans(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	tc := testcluster.StartTestCluster(t, 1, base.TestClusterArgs{
+		ServerArgs: base.TestServerArgs{
+			EnableSpanConfigs: true,
+		},
+	})
+	defer tc.Stopper().Stop(ctx)
+
+	// The table doesn't have a primary key on start_key, which lets us seed it
+	// with entries having the same start key.
+	const dummySpanConfigurationsFQN = "defaultdb.public.dummy_span_configurations"
+	tdb := sqlutils.MakeSQLRunner(tc.ServerConn(0))
+	tdb.Exec(t, `SET CLUSTER SETTING spanconfig.experimental_kvaccessor.enabled = true`)
+	tdb.Exec(t, `CREATE TABLE `+dummySpanConfigurationsFQN+` (start_key BYTES, end_key BYTES, config BYTES)`)
+	accessor := spanconfigkvaccessor.New(
+		tc.Server(0).DB(),
+		tc.Server(0).InternalExecutor().(sqlutil.InternalExecutor),
+		tc.Server(0).ClusterSettings(),
+		dummySpanConfigurationsFQN,
+	)
+
+	require.NoError(t, accessor.UpdateSpanConfigEntries(ctx, nil /* toDelete */, []roachpb.SpanConfigEntry{
+		spanconfigtestutils.ParseSpanConfigEntry(t, "[a,c):A"),
+		spanconfigtestutils.ParseSpanConfigEntry(t, "[c,e):C"),
+	}))
+	entries, err := accessor.GetSpanConfigEntriesFor(ctx, []roachpb.Span{
+		spanconfigtestutils.ParseSpan(t, "[a,e)"),
+		spanconfigtestutils.ParseSpan(t, "[b,d)"),
+	})
+	require.NoError(t, err)
+	require.Len(t, entries, 4)
+
+	tdb.Exec(t, `INSERT INTO `+dummySpanConfigurationsFQN+
+		` SELECT start_key, 'd', config FROM `+dummySpanConfigurationsFQN+` WHERE start_key = 'c'`)
+
+	requireDuplicateSpanError := func(t *testing.T, err error) {
+		var dupErr *spanconfigkvaccessor.DuplicateSpanError
+		require.True(t, errors.As(err, &dupErr), "expected DuplicateSpanError, got %v", err)
+		require.Equal(t, roachpb.Key("c"), dupErr.StartKey)
+	}
+	_, err = accessor.GetSpanConfigEntriesFor(ctx, []roachpb.Span{
+		spanconfigtestutils.ParseSpan(t, "[a,e)"),
+	})
+	requireDuplicateSpanError(t, err)
+
+	err = accessor.UpdateSpanConfigEntries(ctx, nil /* toDelete */, []roachpb.SpanConfigEntry{
+		spanconfigtestutils.ParseSpanConfigEntry(t, "[c,e):X"),
+	})
+	requireDuplicateSpanError(t, err)
+}
@@ -0,0 +1,34 @@
+// Copyright 2021 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package spanconfigkvaccessor
+
+import (
+	"context"
+
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+)
+
+// TestingGetSpanConfigEntriesForUnbatched is like GetSpanConfigEntriesFor,
+// except it queries for each of the spans individually instead of
+// normalizing them first. It serves as the reference implementation.
+func (k *KVAccessor) TestingGetSpanConfigEntriesForUnbatched(
+	ctx context.Context, spans []roachpb.Span,
+) ([]roachpb.SpanConfigEntry, error) {
+	var resp []roachpb.SpanConfigEntry
+	for _, sp := range spans {
+		entries, err := k.getSpanConfigEntriesFor(ctx, nil /* txn */, []roachpb.Span{sp})
+		if err != nil {
+			return nil, err
+		}
+		resp = append(resp, sortAndDedupEntries(entries)...)
+	}
+	return resp, nil
+}
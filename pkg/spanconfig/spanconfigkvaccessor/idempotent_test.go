@@ -0,0 +1,84 @@
+// Copyright 2021 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package spanconfigkvaccessor_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/spanconfig/spanconfigkvaccessor"
+	"github.com/cockroachdb/cockroach/pkg/spanconfig/spanconfigtestutils"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/stretchr/testify/require"
+)
+
+// TestApplyIdempotent ensures that ApplyIdempotent only applies the delta
+// between the existing and the desired entries, such that re-applying the
+// same desired entries writes no rows and publishes no change events.
+func TestApplyIdempotent(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	tc, accessor := startTestCluster(t)
+	defer tc.Stopper().Stop(ctx)
+	var events []spanconfigkvaccessor.ChangeEvent
+	accessor.OnChange(func(ev spanconfigkvaccessor.ChangeEvent) {
+		events = append(events, ev)
+	})
+
+	entry := spanconfigtestutils.Entry
+	span := func(start, end string) roachpb.Span {
+		return entry(start, end).Build().Span
+	}
+	outside := entry("x", "y").WithTag("O").Build()
+	require.NoError(t, accessor.UpdateSpanConfigEntries(ctx, nil /* toDelete */, []roachpb.SpanConfigEntry{
+		entry("a", "b").WithTag("A").Build(),
+		entry("b", "d").WithTag("B").Build(),
+		outside,
+	}))
+	events = nil
+
+	desired := []roachpb.SpanConfigEntry{
+		entry("a", "b").WithTag("A").Build(),
+		entry("b", "c").WithTag("X").Build(),
+		entry("c", "d").WithTag("Y").Build(),
+	}
+	changed, err := accessor.ApplyIdempotent(ctx, desired, span("a", "m"))
+	require.NoError(t, err)
+	require.True(t, changed)
+	require.Len(t, events, 1)
+	require.Equal(t, []roachpb.Span{span("b", "d")}, events[0].Deleted)
+	require.Equal(t, desired[1:], events[0].Upserted)
+	entries, err := accessor.GetSpanConfigEntriesFor(ctx, []roachpb.Span{span("a", "z")})
+	require.NoError(t, err)
+	require.Equal(t, append(desired, outside), entries)
+
+	// Re-applying the same entries changes nothing, and writes no rows.
+	_, since, err := accessor.Poll(ctx, []roachpb.Span{span("a", "z")}, hlc.Timestamp{})
+	require.NoError(t, err)
+	events = nil
+	changed, err = accessor.ApplyIdempotent(ctx, desired, span("a", "m"))
+	require.NoError(t, err)
+	require.False(t, changed)
+	require.Empty(t, events)
+	written, _, err := accessor.Poll(ctx, []roachpb.Span{span("a", "z")}, since)
+	require.NoError(t, err)
+	require.Empty(t, written)
+	entries, err = accessor.GetSpanConfigEntriesFor(ctx, []roachpb.Span{span("a", "z")})
+	require.NoError(t, err)
+	require.Equal(t, append(desired, outside), entries)
+
+	// Desired entries must be contained in the span.
+	_, err = accessor.ApplyIdempotent(ctx, desired, span("b", "m"))
+	require.Regexp(t, "is not contained in", err)
+}
@@ -11,45 +11,236 @@
 package spanconfigkvaccessor
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"sort"
 	"strings"
+	"sync/atomic"
+	"time"
 
+	"github.com/cockroachdb/cockroach/pkg/keys"
 	"github.com/cockroachdb/cockroach/pkg/kv"
 	"github.com/cockroachdb/cockroach/pkg/roachpb"
 	"github.com/cockroachdb/cockroach/pkg/security"
 	"github.com/cockroachdb/cockroach/pkg/settings"
 	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
 	"github.com/cockroachdb/cockroach/pkg/spanconfig"
+	"github.com/cockroachdb/cockroach/pkg/sql/parser"
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
 	"github.com/cockroachdb/cockroach/pkg/sql/sessiondata"
 	"github.com/cockroachdb/cockroach/pkg/sql/sqlutil"
+	"github.com/cockroachdb/cockroach/pkg/util/contextutil"
+	"github.com/cockroachdb/cockroach/pkg/util/ctxgroup"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
 	"github.com/cockroachdb/cockroach/pkg/util/protoutil"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
 	"github.com/cockroachdb/errors"
 )
 
 // KVAccessor provides read/write access to all the span configurations for a
 // CRDB cluster. It's a concrete implementation of the KVAccessor interface.
 type KVAccessor struct {
-	db        *kv.DB
-	ie        sqlutil.InternalExecutor
-	settings  *cluster.Settings
-	tableName string // typically system.span_configurations, but overridable for testing purposes
+	db       *kv.DB
+	ie       sqlutil.InternalExecutor
+	settings *cluster.Settings
+	// tableName is the formatted, and appropriately quoted, name of the table
+	// storing span configurations. It's typically system.span_configurations,
+	// but overridable for testing purposes. It may be stale if the table was
+	// changed using SwapTable; operations use the KVAccessor returned by
+	// pinned, for which it is not.
+	tableName string
+	// target holds the current name of the table, which SwapTable changes. It
+	// is nil for the KVAccessors returned by pinned.
+	target *tableTarget
+	// additional are accessors for the tables which GetSpanConfigEntriesFor
+	// reads from in addition to tableName, in order of precedence. See
+	// NewMulti.
+	additional []*KVAccessor
+	// priority, if non-zero, is the user priority of the transactions run by
+	// the KVAccessor. See WithUserPriority.
+	priority roachpb.UserPriority
+	// tenantID, if set, is the tenant to whose keyspace the spans read and
+	// written are restricted, and tenantSpan is that keyspace. See
+	// NewForTenant.
+	tenantID   roachpb.TenantID
+	tenantSpan roachpb.Span
+	// subscribers are notified of the changes made through the KVAccessor,
+	// and the copies of it made using WithUserPriority. See OnChange.
+	subscribers *changeSubscribers
 }
 
 var _ spanconfig.KVAccessor = &KVAccessor{}
 
-// New constructs a new Manager.
+// New constructs a new KVAccessor. The table name is parsed using SQL
+// syntax, so name parts containing special characters or upper-case
+// characters need to be enclosed in double quotes. New panics if the name
+// cannot be parsed; see NewWithTableName for constructing a KVAccessor from
+// a structured table name.
 func New(
 	db *kv.DB, ie sqlutil.InternalExecutor, settings *cluster.Settings, tableFQN string,
+) *KVAccessor {
+	tableName, err := parser.ParseQualifiedTableName(tableFQN)
+	if err != nil {
+		panic(errors.NewAssertionErrorWithWrappedErrf(err, "invalid table name %q", tableFQN))
+	}
+	return NewWithTableName(db, ie, settings, *tableName)
+}
+
+// NewWithTableName constructs a new KVAccessor that reads from and writes to
+// the given table. Each part of the table name is quoted as needed when
+// constructing statements.
+func NewWithTableName(
+	db *kv.DB, ie sqlutil.InternalExecutor, settings *cluster.Settings, tableName tree.TableName,
 ) *KVAccessor {
 	return &KVAccessor{
-		db:        db,
-		ie:        ie,
-		settings:  settings,
-		tableName: tableFQN,
+		db:          db,
+		ie:          ie,
+		settings:    settings,
+		tableName:   tableName.String(),
+		target:      &tableTarget{tableName: tableName.String()},
+		subscribers: &changeSubscribers{},
+	}
+}
+
+// NewForTenant is like New, except that the KVAccessor is scoped to the
+// keyspace of the given tenant: the spans read and written through it, and
+// those of the entries upserted, must be contained within the tenant's
+// keyspace, and Scan only returns the entries starting within it. Operations
+// on spans outside the keyspace fail without reading or writing anything;
+// operations within it behave as they do for a KVAccessor constructed using
+// New. Spans aren't prefixed on the way in nor stripped on the way out: the
+// spans passed to the KVAccessor, and those of the entries it returns, are
+// the tenant-prefixed ones stored in the table. The keyspace of the system
+// tenant isn't prefixed, and spans the entire keyspace, so a KVAccessor scoped
+// to it isn't restricted.
+func NewForTenant(
+	db *kv.DB,
+	ie sqlutil.InternalExecutor,
+	settings *cluster.Settings,
+	tableFQN string,
+	tenantID roachpb.TenantID,
+) *KVAccessor {
+	k := New(db, ie, settings, tableFQN)
+	if tenantID != roachpb.SystemTenantID {
+		prefix := keys.MakeTenantPrefix(tenantID)
+		k.tenantID = tenantID
+		k.tenantSpan = roachpb.Span{Key: prefix, EndKey: prefix.PrefixEnd()}
+	}
+	return k
+}
+
+// tableTarget holds the name of the table targeted by a KVAccessor.
+type tableTarget struct {
+	syncutil.RWMutex
+	tableName string
+}
+
+// pinned returns a copy of the KVAccessor which targets the current table,
+// and is unaffected by subsequent calls to SwapTable. Operations pin the
+// KVAccessor once, on entry, so that they run against a single table
+// throughout.
+func (k *KVAccessor) pinned() *KVAccessor {
+	if k.target == nil {
+		return k
+	}
+	c := *k
+	k.target.RLock()
+	defer k.target.RUnlock()
+	c.tableName, c.target = k.target.tableName, nil
+	return &c
+}
+
+// SwapTable changes the table the KVAccessor reads from and writes to. The
+// table name is parsed like it is by New, and the table is validated using
+// ValidateTable before the change is made. Operations started after SwapTable
+// returns use the new table, while operations already in flight complete
+// against the table they started with. The copies of the KVAccessor made
+// using WithUserPriority share its table, so the change applies to them as
+// well. For KVAccessors constructed using NewMulti, only the primary table is
+// changed.
+func (k *KVAccessor) SwapTable(ctx context.Context, newFQN string) error {
+	if err := k.ValidateTable(ctx, newFQN); err != nil {
+		return errors.Wrapf(err, "cannot swap to table %q", newFQN)
+	}
+	// The name was parsed successfully by ValidateTable.
+	tableName, _ := parser.ParseQualifiedTableName(newFQN)
+	k.target.Lock()
+	defer k.target.Unlock()
+	k.target.tableName = tableName.String()
+	return nil
+}
+
+// ValidateTable returns an error if the table with the given name, which is
+// parsed like it is by New, doesn't exist or can't store span
+// configurations: it must have start_key, end_key and config columns of type
+// BYTES.
+func (k *KVAccessor) ValidateTable(ctx context.Context, fqn string) error {
+	tableName, err := parser.ParseQualifiedTableName(fqn)
+	if err != nil {
+		return errors.Wrapf(err, "invalid table name %q", fqn)
+	}
+	var rows []tree.Datums
+	if err := k.withStatementTimeout(ctx, "validate-span-cfgs-table", func(ctx context.Context) (err error) {
+		rows, err = k.ie.QueryBufferedEx(ctx, "validate-span-cfgs-table", nil, /* txn */
+			sessiondata.InternalExecutorOverride{User: security.RootUserName()},
+			fmt.Sprintf(`SELECT column_name, data_type FROM [SHOW COLUMNS FROM %s]`, tableName),
+		)
+		return err
+	}); err != nil {
+		return err
+	}
+	columnTypes := make(map[string]string, len(rows))
+	for _, row := range rows {
+		columnTypes[string(tree.MustBeDString(row[0]))] = string(tree.MustBeDString(row[1]))
+	}
+	return checkTableColumns(columnTypes)
+}
+
+// NewMulti constructs a new KVAccessor which writes to the primary table, but
+// for which GetSpanConfigEntriesFor (and GetSortedSpanConfigEntriesFor and
+// DistinctConfigs) reads from the additional tables as well, merging the
+// results. This is intended for migrating from one span configurations table
+// to another. The table names are parsed like they are by New.
+//
+// Where entries from different tables overlap, the entries of the primary
+// table take precedence, followed by those of the additional tables in the
+// order given. Entries are clipped to the portions of their spans not covered
+// by entries of tables with higher precedence, so that the merged entries are
+// non-overlapping. All other methods only read from the primary table.
+func NewMulti(
+	db *kv.DB,
+	ie sqlutil.InternalExecutor,
+	settings *cluster.Settings,
+	primaryFQN string,
+	additionalFQNs ...string,
+) *KVAccessor {
+	k := New(db, ie, settings, primaryFQN)
+	for _, fqn := range additionalFQNs {
+		k.additional = append(k.additional, New(db, ie, settings, fqn))
+	}
+	return k
+}
+
+// WithUserPriority returns a copy of the KVAccessor which runs its
+// transactions with the given user priority, for instance
+// roachpb.MinUserPriority for reconciliation flows which would rather back
+// off than contend with foreground traffic. Transactions are always
+// serializable; their priority is the only aspect of them which can be
+// configured.
+//
+// By default, reads which consist of a single statement don't run in an
+// explicit transaction, leaving the internal executor to run the statement in
+// one of its own at normal priority. With a priority set, such reads run in a
+// transaction too, so that the priority applies to them as well.
+func (k *KVAccessor) WithUserPriority(priority roachpb.UserPriority) *KVAccessor {
+	c := *k
+	c.priority = priority
+	c.additional = make([]*KVAccessor, len(k.additional))
+	for i, a := range k.additional {
+		c.additional[i] = a.WithUserPriority(priority)
 	}
+	return &c
 }
 
 // enabledSetting gates usage of the KVAccessor. It has no effect unless
@@ -58,139 +249,1700 @@ var enabledSetting = settings.RegisterBoolSetting(
 	"spanconfig.experimental_kvaccessor.enabled",
 	"enable the use of the kv accessor", false).WithSystemOnly()
 
+// verifySortedReadsSetting is a debug setting which has
+// GetSortedSpanConfigEntriesFor verify that the entries it returns are
+// non-overlapping.
+var verifySortedReadsSetting = settings.RegisterBoolSetting(
+	"spanconfig.experimental_kvaccessor.verify_sorted_reads.enabled",
+	"verify that span configs read in sorted order are non-overlapping", false).WithSystemOnly()
+
+// statementTimeoutSetting bounds the time taken by each of the statements
+// issued by the KVAccessor, so that a runaway query against a large span
+// configurations table doesn't hold on to resources indefinitely. Statements
+// that don't complete in time fail with a *contextutil.TimeoutError. A value
+// of zero disables the timeout.
+var statementTimeoutSetting = settings.RegisterDurationSetting(
+	"spanconfig.kvaccessor.statement_timeout",
+	"the maximum duration of each statement issued by the kv accessor; 0 disables the timeout",
+	0,
+	settings.NonNegativeDuration,
+).WithSystemOnly()
+
+// maxUpdateConcurrencySetting bounds the number of batches which
+// UpdateSpanConfigEntriesBatched applies concurrently. Each batch is applied in
+// its own transaction, so this bounds the number of transactions, and of
+// internal executor sessions, that a single batched update holds open.
+var maxUpdateConcurrencySetting = settings.RegisterIntSetting(
+	"spanconfig.kvaccessor.max_update_concurrency",
+	"the maximum number of batches of a batched update applied concurrently by the kv accessor",
+	8,
+	settings.PositiveInt,
+).WithSystemOnly()
+
 // errDisabled is returned if the setting gating usage of the KVAccessor is
 // disabled.
 var errDisabled = errors.New("span config kv accessor disabled")
 
-// GetSpanConfigEntriesFor is part of the KVAccessor interface.
-func (k *KVAccessor) GetSpanConfigEntriesFor(
-	ctx context.Context, spans []roachpb.Span,
-) (resp []roachpb.SpanConfigEntry, retErr error) {
+// DuplicateSpanError is returned when the span configurations table is found
+// to contain more than one entry with the same start key, which is indicative
+// of corruption.
+type DuplicateSpanError struct {
+	// StartKey is the start key shared by the duplicate entries.
+	StartKey roachpb.Key
+}
+
+func (e *DuplicateSpanError) Error() string {
+	return fmt.Sprintf("span config table inconsistent: duplicate entries with start key %s", e.StartKey)
+}
+
+// GetSpanConfigEntriesFor is part of the KVAccessor interface. The entries
+// overlapping with each of the given spans are returned in the order of the
+// spans, and sorted by start key for each span; entries overlapping with more
+// than one of the spans are returned once for each.
+//
+// The spans are normalized before querying, so that callers passing many
+// overlapping or adjacent spans don't pay for a predicate per span. The
+// entries found are then mapped back to the given spans, which, given the
+// table invariants, is equivalent to querying for each span individually. For
+// KVAccessors constructed using NewMulti, the entries of all the tables are
+// merged before doing so.
+func (k *KVAccessor) GetSpanConfigEntriesFor(
+	ctx context.Context, spans []roachpb.Span,
+) (resp []roachpb.SpanConfigEntry, retErr error) {
+	k = k.pinned()
+	if !enabledSetting.Get(&k.settings.SV) {
+		return nil, errDisabled
+	}
+
+	if len(spans) == 0 {
+		return resp, nil
+	}
+	if err := k.validateSpans(spans); err != nil {
+		return nil, err
+	}
+	entries, err := k.getMergedSpanConfigEntriesFor(ctx, NormalizeSpans(spans))
+	if err != nil {
+		return nil, err
+	}
+	return mapEntriesToSpans(spans, entries), nil
+}
+
+// GetSpanConfigEntriesGrouped is like GetSpanConfigEntriesFor, except that the
+// entries overlapping with each of the given spans are returned keyed by the
+// index of the span, sorted by start key. Entries overlapping with more than
+// one of the spans are returned under each of them; spans overlapping with no
+// entries have no key.
+func (k *KVAccessor) GetSpanConfigEntriesGrouped(
+	ctx context.Context, spans []roachpb.Span,
+) (map[int][]roachpb.SpanConfigEntry, error) {
+	k = k.pinned()
+	if !enabledSetting.Get(&k.settings.SV) {
+		return nil, errDisabled
+	}
+
+	if len(spans) == 0 {
+		return nil, nil
+	}
+	if err := k.validateSpans(spans); err != nil {
+		return nil, err
+	}
+	entries, err := k.getMergedSpanConfigEntriesFor(ctx, NormalizeSpans(spans))
+	if err != nil {
+		return nil, err
+	}
+	return groupEntriesBySpan(spans, entries), nil
+}
+
+// TimestampedEntry is a span config entry along with the commit timestamp of
+// the last write to its row.
+type TimestampedEntry struct {
+	Entry     roachpb.SpanConfigEntry
+	Timestamp hlc.Timestamp
+}
+
+// GetSpanConfigEntriesWithTimestamps is like GetSpanConfigEntriesFor, except
+// that each entry is annotated with the commit timestamp of the last write to
+// its row, read from the row's MVCC timestamp. Rewriting an entry, even with
+// the same config, advances its timestamp. For KVAccessors constructed using
+// NewMulti, the portions of entries of tables with lower precedence which are
+// returned carry the timestamps of those entries.
+func (k *KVAccessor) GetSpanConfigEntriesWithTimestamps(
+	ctx context.Context, spans []roachpb.Span,
+) ([]TimestampedEntry, error) {
+	k = k.pinned()
+	if !enabledSetting.Get(&k.settings.SV) {
+		return nil, errDisabled
+	}
+
+	if len(spans) == 0 {
+		return nil, nil
+	}
+	if err := k.validateSpans(spans); err != nil {
+		return nil, err
+	}
+	normalized := NormalizeSpans(spans)
+	var entries []roachpb.SpanConfigEntry
+	var tables [][]TimestampedEntry
+	if err := k.maybeTxn(ctx, func(ctx context.Context, txn *kv.Txn) error {
+		entries, tables = nil, nil
+		for i, a := range append([]*KVAccessor{k}, k.additional...) {
+			var read []TimestampedEntry
+			if err := a.withStatementTimeout(ctx, "get-span-cfgs", func(ctx context.Context) error {
+				return a.iterateRowsFor(ctx, txn, normalized, "", /* asOf */
+					true /* withTimestamps */, func(entry TimestampedEntry) error {
+						read = append(read, entry)
+						return nil
+					})
+			}); err != nil {
+				return err
+			}
+			read = sortAndDedupTimestampedEntries(read)
+			tables = append(tables, read)
+			plain := make([]roachpb.SpanConfigEntry, len(read))
+			for i := range read {
+				plain[i] = read[i].Entry
+			}
+			if i == 0 {
+				entries = plain
+			} else {
+				entries = mergeEntries(entries, plain)
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	entries = mapEntriesToSpans(spans, entries)
+	resp := make([]TimestampedEntry, len(entries))
+	for i, entry := range entries {
+		resp[i] = TimestampedEntry{Entry: entry, Timestamp: findTimestamp(entry, tables)}
+	}
+	return resp, nil
+}
+
+// getMergedSpanConfigEntriesFor fetches the span configs for the given
+// normalized spans, sorted and deduplicated. For KVAccessors constructed using
+// NewMulti, the entries of all the tables are merged.
+func (k *KVAccessor) getMergedSpanConfigEntriesFor(
+	ctx context.Context, normalized []roachpb.Span,
+) (entries []roachpb.SpanConfigEntry, _ error) {
+	if err := k.maybeTxn(ctx, func(ctx context.Context, txn *kv.Txn) (err error) {
+		entries, err = k.getSpanConfigEntriesFor(ctx, txn, normalized)
+		if err != nil {
+			return err
+		}
+		entries = sortAndDedupEntries(entries)
+		for _, a := range k.additional {
+			additional, err := a.getSpanConfigEntriesFor(ctx, txn, normalized)
+			if err != nil {
+				return err
+			}
+			entries = mergeEntries(entries, sortAndDedupEntries(additional))
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// GetSpanConfigEntriesForStale is like GetSpanConfigEntriesFor, except that
+// the entries are read as of maxStaleness ago, using AS OF SYSTEM TIME, which
+// must be at least a microsecond. The entries returned may thus be behind,
+// missing the updates made in the last maxStaleness, but the read doesn't
+// block on concurrent writes, and may be served by a follower replica.
+// Bounded staleness reads (with_max_staleness) aren't used: besides being
+// only available in CCL distributions, they're only permitted for statements
+// touching at most one range, using the operators the optimizer allows for
+// them, which the statement used, a union of scans over the normalized spans,
+// doesn't satisfy. An exact staleness is used instead.
+//
+// The read doesn't run in a transaction, so the priority set using
+// WithUserPriority doesn't apply. For KVAccessors constructed using NewMulti,
+// each table is read as of maxStaleness before its own statement.
+func (k *KVAccessor) GetSpanConfigEntriesForStale(
+	ctx context.Context, spans []roachpb.Span, maxStaleness time.Duration,
+) ([]roachpb.SpanConfigEntry, error) {
+	k = k.pinned()
+	if !enabledSetting.Get(&k.settings.SV) {
+		return nil, errDisabled
+	}
+
+	if maxStaleness < time.Microsecond {
+		return nil, errors.Errorf("invalid max staleness %s", maxStaleness)
+	}
+	if len(spans) == 0 {
+		return nil, nil
+	}
+	if err := k.validateSpans(spans); err != nil {
+		return nil, err
+	}
+	normalized := NormalizeSpans(spans)
+	asOf := fmt.Sprintf("'-%dus'", maxStaleness.Microseconds())
+	entries, err := k.getSpanConfigEntriesAsOf(ctx, nil /* txn */, normalized, asOf)
+	if err != nil {
+		return nil, err
+	}
+	entries = sortAndDedupEntries(entries)
+	for _, a := range k.additional {
+		additional, err := a.getSpanConfigEntriesAsOf(ctx, nil /* txn */, normalized, asOf)
+		if err != nil {
+			return nil, err
+		}
+		entries = mergeEntries(entries, sortAndDedupEntries(additional))
+	}
+	return mapEntriesToSpans(spans, entries), nil
+}
+
+// getSpanConfigEntriesFor fetches the span configs for the given spans using
+// the given transaction, if any. A DuplicateSpanError is returned if more than
+// one entry with the same start key is found for any of the spans.
+func (k *KVAccessor) getSpanConfigEntriesFor(
+	ctx context.Context, txn *kv.Txn, spans []roachpb.Span,
+) ([]roachpb.SpanConfigEntry, error) {
+	return k.getSpanConfigEntriesAsOf(ctx, txn, spans, "" /* asOf */)
+}
+
+// getSpanConfigEntriesAsOf is like getSpanConfigEntriesFor, except that, if
+// asOf is set, the span configs are read as of the given AS OF SYSTEM TIME
+// expression. No transaction can be given in that case.
+func (k *KVAccessor) getSpanConfigEntriesAsOf(
+	ctx context.Context, txn *kv.Txn, spans []roachpb.Span, asOf string,
+) (resp []roachpb.SpanConfigEntry, _ error) {
+	if err := k.withStatementTimeout(ctx, "get-span-cfgs", func(ctx context.Context) (err error) {
+		resp, err = k.queryEntriesFor(ctx, txn, spans, asOf)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// queryEntriesFor is like getSpanConfigEntriesFor, without the statement
+// timeout. The statement is only done once its results have been iterated
+// over, so the timeout needs to cover the iteration as well.
+func (k *KVAccessor) queryEntriesFor(
+	ctx context.Context, txn *kv.Txn, spans []roachpb.Span, asOf string,
+) (resp []roachpb.SpanConfigEntry, _ error) {
+	if err := k.iterateEntriesFor(ctx, txn, spans, asOf, func(entry roachpb.SpanConfigEntry) error {
+		resp = append(resp, entry)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// iterateEntriesFor is like queryEntriesFor, except that the entries are
+// passed to fn as they're read rather than accumulated. Iteration stops at
+// the first error returned by fn, which is returned.
+func (k *KVAccessor) iterateEntriesFor(
+	ctx context.Context,
+	txn *kv.Txn,
+	spans []roachpb.Span,
+	asOf string,
+	fn func(entry roachpb.SpanConfigEntry) error,
+) error {
+	return k.iterateRowsFor(ctx, txn, spans, asOf, false, /* withTimestamps */
+		func(entry TimestampedEntry) error {
+			return fn(entry.Entry)
+		})
+}
+
+// iterateRowsFor is like iterateEntriesFor, except that, if withTimestamps is
+// set, the MVCC timestamps of the rows are also read and passed along with the
+// entries.
+func (k *KVAccessor) iterateRowsFor(
+	ctx context.Context,
+	txn *kv.Txn,
+	spans []roachpb.Span,
+	asOf string,
+	withTimestamps bool,
+	fn func(entry TimestampedEntry) error,
+) (retErr error) {
+	columns := getColumns
+	if withTimestamps {
+		columns = getColumnsWithTimestamp
+	}
+	getStmt, getQueryArgs := k.constructGetStmtAndArgsSelecting(spans, columns)
+	if asOf != "" {
+		// AS OF SYSTEM TIME must be provided on the top-level statement.
+		getStmt = fmt.Sprintf("SELECT * FROM (%s) AS OF SYSTEM TIME %s", getStmt, asOf)
+	}
+	it, err := k.ie.QueryIteratorEx(ctx, "get-span-cfgs", txn,
+		sessiondata.InternalExecutorOverride{User: security.RootUserName()},
+		getStmt, getQueryArgs...,
+	)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := it.Close(); closeErr != nil {
+			retErr = errors.CombineErrors(retErr, closeErr)
+		}
+	}()
+
+	// Entries overlapping with more than one of the spans are returned once
+	// for each, so start keys are only expected to be unique per span.
+	type spanStartKey struct {
+		spanIdx  int
+		startKey string
+	}
+	seen := make(map[spanStartKey]struct{})
+	var ok bool
+	for ok, err = it.Next(ctx); ok; ok, err = it.Next(ctx) {
+		row := it.Cur()
+		span := roachpb.Span{
+			Key:    []byte(*row[1].(*tree.DBytes)),
+			EndKey: []byte(*row[2].(*tree.DBytes)),
+		}
+		key := spanStartKey{
+			spanIdx:  int(tree.MustBeDInt(row[0])),
+			startKey: string(span.Key),
+		}
+		if _, found := seen[key]; found {
+			return &DuplicateSpanError{StartKey: span.Key}
+		}
+		seen[key] = struct{}{}
+		var conf roachpb.SpanConfig
+		if err := protoutil.Unmarshal(([]byte)(*row[3].(*tree.DBytes)), &conf); err != nil {
+			return err
+		}
+		entry := TimestampedEntry{Entry: roachpb.SpanConfigEntry{
+			Span:   span,
+			Config: conf,
+		}}
+		if withTimestamps {
+			if entry.Timestamp, err = tree.DecimalToHLC(&row[4].(*tree.DDecimal).Decimal); err != nil {
+				return err
+			}
+		}
+
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// GetSpanConfigEntryExact returns the entry whose span is exactly the given
+// span, that is, with the same start and end keys. Unlike
+// GetSpanConfigEntriesFor, entries which merely overlap with the span are not
+// returned. If there's no such entry, false is returned, not an error.
+func (k *KVAccessor) GetSpanConfigEntryExact(
+	ctx context.Context, span roachpb.Span,
+) (roachpb.SpanConfigEntry, bool, error) {
+	k = k.pinned()
+	if !enabledSetting.Get(&k.settings.SV) {
+		return roachpb.SpanConfigEntry{}, false, errDisabled
+	}
+
+	if err := k.validateSpans([]roachpb.Span{span}); err != nil {
+		return roachpb.SpanConfigEntry{}, false, err
+	}
+	getExactStmt, getExactQueryArgs := k.constructGetExactStmtAndArgs(span)
+	var row tree.Datums
+	if err := k.maybeTxn(ctx, func(ctx context.Context, txn *kv.Txn) error {
+		return k.withStatementTimeout(ctx, "get-span-cfg-exact", func(ctx context.Context) (err error) {
+			row, err = k.ie.QueryRowEx(ctx, "get-span-cfg-exact", txn,
+				sessiondata.InternalExecutorOverride{User: security.RootUserName()},
+				getExactStmt, getExactQueryArgs...,
+			)
+			return err
+		})
+	}); err != nil {
+		return roachpb.SpanConfigEntry{}, false, err
+	}
+	if row == nil {
+		return roachpb.SpanConfigEntry{}, false, nil
+	}
+	var conf roachpb.SpanConfig
+	if err := protoutil.Unmarshal(([]byte)(*row[0].(*tree.DBytes)), &conf); err != nil {
+		return roachpb.SpanConfigEntry{}, false, err
+	}
+	return roachpb.SpanConfigEntry{Span: span, Config: conf}, true, nil
+}
+
+// GetSortedSpanConfigEntriesFor is like GetSpanConfigEntriesFor, except the
+// entries are returned sorted by start key and without duplicates (entries
+// overlapping with more than one of the given spans are otherwise returned
+// once for each). Given the table invariants, the entries are non-overlapping.
+// If spanconfig.experimental_kvaccessor.verify_sorted_reads.enabled is set,
+// this is verified, and an error is returned if the table is found to be
+// inconsistent. The spans are normalized before querying, which doesn't affect
+// the result since it is deduplicated.
+func (k *KVAccessor) GetSortedSpanConfigEntriesFor(
+	ctx context.Context, spans []roachpb.Span,
+) ([]roachpb.SpanConfigEntry, error) {
+	k = k.pinned()
+	if err := k.validateSpans(spans); err != nil {
+		return nil, err
+	}
+	entries, err := k.GetSpanConfigEntriesFor(ctx, NormalizeSpans(spans))
+	if err != nil {
+		return nil, err
+	}
+	entries = sortAndDedupEntries(entries)
+	if verifySortedReadsSetting.Get(&k.settings.SV) {
+		if err := validateSortedEntries(entries); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// GetEffectiveConfigs returns a sorted, non-overlapping sequence of entries
+// describing the effective span configs across the given span. Given the
+// table invariants, these are simply the entries overlapping with the span,
+// clipped to it. If the table nonetheless contains overlapping entries, the
+// entry with the latest start key takes precedence where they overlap; for
+// nested entries, that's the innermost, most specific one. Portions of the
+// span without any entries are omitted from the result.
+//
+// Unlike GetSpanConfigEntriesFor, this doesn't rely on entries being
+// non-overlapping to find them, and so scans the entire table.
+func (k *KVAccessor) GetEffectiveConfigs(
+	ctx context.Context, span roachpb.Span,
+) ([]roachpb.SpanConfigEntry, error) {
+	k = k.pinned()
+	if !enabledSetting.Get(&k.settings.SV) {
+		return nil, errDisabled
+	}
+
+	if err := k.validateSpans([]roachpb.Span{span}); err != nil {
+		return nil, err
+	}
+
+	entries, err := k.scanEntriesOverlapping(ctx, "get-effective-span-cfgs", span)
+	if err != nil {
+		return nil, err
+	}
+	return flattenEntries(span, entries), nil
+}
+
+// AssertFullCoverage returns an error if the given span isn't fully covered
+// by non-overlapping entries, identifying the first gap or overlap found.
+// Entries may extend past the span. Like GetEffectiveConfigs, this doesn't
+// rely on entries being non-overlapping to find them, and so scans the entire
+// table.
+func (k *KVAccessor) AssertFullCoverage(ctx context.Context, span roachpb.Span) error {
+	k = k.pinned()
+	if !enabledSetting.Get(&k.settings.SV) {
+		return errDisabled
+	}
+
+	if err := k.validateSpans([]roachpb.Span{span}); err != nil {
+		return err
+	}
+
+	entries, err := k.scanEntriesOverlapping(ctx, "assert-span-cfgs-coverage", span)
+	if err != nil {
+		return err
+	}
+	return checkCoverage(span, entries)
+}
+
+// DistinctConfigs returns the distinct span configs of the entries
+// overlapping with the given span, each once. Configs are compared using
+// proto equality, and are returned in a canonical order, that of their
+// encodings, which doesn't depend on where in the span they are used. For
+// KVAccessors constructed using NewMulti, the entries are merged across the
+// tables as they are by GetSpanConfigEntriesFor, so that configs of entries
+// shadowed by those of tables with higher precedence aren't returned.
+func (k *KVAccessor) DistinctConfigs(
+	ctx context.Context, span roachpb.Span,
+) ([]roachpb.SpanConfig, error) {
+	k = k.pinned()
+	if !enabledSetting.Get(&k.settings.SV) {
+		return nil, errDisabled
+	}
+
+	if err := k.validateSpans([]roachpb.Span{span}); err != nil {
+		return nil, err
+	}
+
+	entries, err := k.getMergedSpanConfigEntriesFor(ctx, []roachpb.Span{span})
+	if err != nil {
+		return nil, err
+	}
+	return distinctConfigs(entries)
+}
+
+// StorageSize returns the number of bytes stored in the span configurations
+// table for the entries overlapping with the given span: the sum of the
+// lengths of their encoded start keys, end keys and configs. Entries are
+// counted whole, even if they extend past the span. The size is computed by
+// the SQL statement, so the entries aren't read back. For KVAccessors
+// constructed using NewMulti, only the primary table is considered.
+func (k *KVAccessor) StorageSize(ctx context.Context, span roachpb.Span) (size int64, _ error) {
+	k = k.pinned()
+	if !enabledSetting.Get(&k.settings.SV) {
+		return 0, errDisabled
+	}
+
+	if err := k.validateSpans([]roachpb.Span{span}); err != nil {
+		return 0, err
+	}
+
+	sizeStmt, sizeQueryArgs := k.constructStorageSizeStmtAndArgs(span)
+	if err := k.maybeTxn(ctx, func(ctx context.Context, txn *kv.Txn) error {
+		return k.withStatementTimeout(ctx, "get-span-cfgs-size", func(ctx context.Context) error {
+			row, err := k.ie.QueryRowEx(ctx, "get-span-cfgs-size", txn,
+				sessiondata.InternalExecutorOverride{User: security.RootUserName()},
+				sizeStmt, sizeQueryArgs...,
+			)
+			if err != nil {
+				return err
+			}
+			size = int64(tree.MustBeDInt(row[0]))
+			return nil
+		})
+	}); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// ViolationReport describes an entry whose config violates a rule checked by
+// FindViolations.
+type ViolationReport struct {
+	Entry roachpb.SpanConfigEntry
+	// Message describes the violation, as reported by the check.
+	Message string
+}
+
+// FindViolations returns a report for each entry overlapping with the given
+// span whose config violates a rule, sorted by start key. The check returns
+// true if the config satisfies the rule, and otherwise a message describing
+// the violation. The entries are streamed from the table, so that only the
+// violating ones are held in memory, and check is called once for each entry
+// read.
+func (k *KVAccessor) FindViolations(
+	ctx context.Context, span roachpb.Span, check func(roachpb.SpanConfig) (bool, string),
+) ([]ViolationReport, error) {
+	k = k.pinned()
+	if !enabledSetting.Get(&k.settings.SV) {
+		return nil, errDisabled
+	}
+
+	if err := k.validateSpans([]roachpb.Span{span}); err != nil {
+		return nil, err
+	}
+
+	var reports []ViolationReport
+	if err := k.maybeTxn(ctx, func(ctx context.Context, txn *kv.Txn) error {
+		reports = nil // the transaction may be retried
+		return k.withStatementTimeout(ctx, "get-span-cfgs", func(ctx context.Context) error {
+			return k.iterateEntriesFor(ctx, txn, []roachpb.Span{span}, "", /* asOf */
+				func(entry roachpb.SpanConfigEntry) error {
+					if ok, msg := check(entry.Config); !ok {
+						reports = append(reports, ViolationReport{Entry: entry, Message: msg})
+					}
+					return nil
+				})
+		})
+	}); err != nil {
+		return nil, err
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].Entry.Span.Key.Compare(reports[j].Entry.Span.Key) < 0
+	})
+	return reports, nil
+}
+
+// FindBoundaryViolations returns the entries overlapping with the given span
+// which straddle any of the given boundaries, sorted by start key. An entry
+// straddles a boundary if the boundary is strictly inside its span: entries
+// starting or ending at a boundary don't straddle it. Entries which extend
+// past the span are checked against all the boundaries, including those
+// outside of the span. It's intended to detect entries spanning across
+// boundaries which span configs shouldn't cross, such as those of tenants or
+// tables.
+func (k *KVAccessor) FindBoundaryViolations(
+	ctx context.Context, span roachpb.Span, boundaries []roachpb.Key,
+) ([]roachpb.SpanConfigEntry, error) {
+	k = k.pinned()
+	if !enabledSetting.Get(&k.settings.SV) {
+		return nil, errDisabled
+	}
+
+	if err := k.validateSpans([]roachpb.Span{span}); err != nil {
+		return nil, err
+	}
+
+	sorted := append([]roachpb.Key(nil), boundaries...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Compare(sorted[j]) < 0
+	})
+	var violations []roachpb.SpanConfigEntry
+	if err := k.maybeTxn(ctx, func(ctx context.Context, txn *kv.Txn) error {
+		violations = nil // the transaction may be retried
+		return k.withStatementTimeout(ctx, "get-span-cfgs", func(ctx context.Context) error {
+			return k.iterateEntriesFor(ctx, txn, []roachpb.Span{span}, "", /* asOf */
+				func(entry roachpb.SpanConfigEntry) error {
+					if straddlesBoundary(entry.Span, sorted) {
+						violations = append(violations, entry)
+					}
+					return nil
+				})
+		})
+	}); err != nil {
+		return nil, err
+	}
+	sort.Slice(violations, func(i, j int) bool {
+		return violations[i].Span.Key.Compare(violations[j].Span.Key) < 0
+	})
+	return violations, nil
+}
+
+// straddlesBoundary returns true if any of the given boundaries, which are
+// sorted, is strictly inside the span.
+func straddlesBoundary(span roachpb.Span, boundaries []roachpb.Key) bool {
+	i := sort.Search(len(boundaries), func(i int) bool {
+		return boundaries[i].Compare(span.Key) > 0
+	})
+	return i < len(boundaries) && boundaries[i].Compare(span.EndKey) < 0
+}
+
+// scanEntriesOverlapping returns all the entries overlapping with the given
+// span, in no particular order, by scanning the entire table.
+func (k *KVAccessor) scanEntriesOverlapping(
+	ctx context.Context, opName string, span roachpb.Span,
+) ([]roachpb.SpanConfigEntry, error) {
+	var rows []tree.Datums
+	if err := k.maybeTxn(ctx, func(ctx context.Context, txn *kv.Txn) error {
+		return k.withStatementTimeout(ctx, opName, func(ctx context.Context) (err error) {
+			rows, err = k.ie.QueryBufferedEx(ctx, opName, txn,
+				sessiondata.InternalExecutorOverride{User: security.RootUserName()},
+				fmt.Sprintf(`SELECT start_key, end_key, config FROM %s WHERE start_key < $2 AND end_key > $1`,
+					k.tableName),
+				span.Key, span.EndKey,
+			)
+			return err
+		})
+	}); err != nil {
+		return nil, err
+	}
+	entries := make([]roachpb.SpanConfigEntry, len(rows))
+	for i, row := range rows {
+		entries[i].Span = roachpb.Span{
+			Key:    []byte(*row[0].(*tree.DBytes)),
+			EndKey: []byte(*row[1].(*tree.DBytes)),
+		}
+		if err := protoutil.Unmarshal(([]byte)(*row[2].(*tree.DBytes)), &entries[i].Config); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// Poll returns the span config entries overlapping with the given spans that
+// were written after the given timestamp, along with a high-water mark:
+// a timestamp such that all entries written at or below it have been
+// observed. Subsequent calls can pass the high-water mark to only retrieve
+// entries written since. The entries are found using the MVCC timestamps of
+// the rows, so deletions are not observed; callers that need to find out
+// about deleted entries should read the full set of entries instead.
+//
+// Unlike GetSpanConfigEntriesFor, this scans the entire table, as there's no
+// index over the MVCC timestamps. For KVAccessors constructed using NewMulti,
+// only the primary table is polled: the entries written to the additional
+// tables aren't observed, as whether they're shadowed by entries of tables
+// with higher precedence can't be determined from the entries polled.
+func (k *KVAccessor) Poll(
+	ctx context.Context, spans []roachpb.Span, since hlc.Timestamp,
+) (entries []roachpb.SpanConfigEntry, highWater hlc.Timestamp, _ error) {
+	k = k.pinned()
+	if !enabledSetting.Get(&k.settings.SV) {
+		return nil, hlc.Timestamp{}, errDisabled
+	}
+
+	if len(spans) == 0 {
+		return nil, since, nil
+	}
+	if err := k.validateSpans(spans); err != nil {
+		return nil, hlc.Timestamp{}, err
+	}
+
+	if err := k.txn(ctx, func(ctx context.Context, txn *kv.Txn) (err error) {
+		entries, err = k.pollWithTxn(ctx, txn, spans, since)
+		if err != nil {
+			return err
+		}
+		// The transaction is read-only, so everything written at or below its
+		// read timestamp has been observed.
+		highWater = txn.ReadTimestamp()
+		return nil
+	}); err != nil {
+		return nil, hlc.Timestamp{}, err
+	}
+	return entries, highWater, nil
+}
+
+// pollWithTxn returns the entries overlapping with the given spans that were
+// written after the given timestamp, using the given transaction.
+func (k *KVAccessor) pollWithTxn(
+	ctx context.Context, txn *kv.Txn, spans []roachpb.Span, since hlc.Timestamp,
+) (entries []roachpb.SpanConfigEntry, _ error) {
+	pollStmt, pollQueryArgs := k.constructPollStmtAndArgs(spans, since)
+	if err := k.withStatementTimeout(ctx, "poll-span-cfgs", func(ctx context.Context) error {
+		it, err := k.ie.QueryIteratorEx(ctx, "poll-span-cfgs", txn,
+			sessiondata.InternalExecutorOverride{User: security.RootUserName()},
+			pollStmt, pollQueryArgs...,
+		)
+		if err != nil {
+			return err
+		}
+		var ok bool
+		for ok, err = it.Next(ctx); ok; ok, err = it.Next(ctx) {
+			row := it.Cur()
+			var conf roachpb.SpanConfig
+			if err := protoutil.Unmarshal(([]byte)(*row[2].(*tree.DBytes)), &conf); err != nil {
+				return errors.CombineErrors(err, it.Close())
+			}
+			entries = append(entries, roachpb.SpanConfigEntry{
+				Span: roachpb.Span{
+					Key:    []byte(*row[0].(*tree.DBytes)),
+					EndKey: []byte(*row[1].(*tree.DBytes)),
+				},
+				Config: conf,
+			})
+		}
+		return errors.CombineErrors(err, it.Close())
+	}); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// ChangeEvent describes a committed change to the span configurations table.
+type ChangeEvent struct {
+	// Deleted are the spans of the entries deleted by the change.
+	Deleted []roachpb.Span
+	// Upserted are the entries upserted by the change.
+	Upserted []roachpb.SpanConfigEntry
+	// Timestamp is the commit timestamp of the transaction which made the
+	// change.
+	Timestamp hlc.Timestamp
+}
+
+// changeSubscribers are the functions registered using OnChange.
+type changeSubscribers struct {
+	syncutil.Mutex
+	fns []func(ev ChangeEvent)
+}
+
+// OnChange registers fn to be called with a ChangeEvent for each update
+// committed through the KVAccessor, or the copies of it made using
+// WithUserPriority: UpdateSpanConfigEntries and its variants,
+// ApplyWithValidation, Compact, and DeleteWhere. Entries rewritten by an
+// update, such as those split by UpdateSpanConfigEntriesWithSplits, are
+// reported as deleted and upserted. The functions are called once the
+// transaction has committed, exactly once per update, in the order they were
+// registered, and before the update returns; they should not block. Updates
+// which fail, or don't change anything, produce no events.
+func (k *KVAccessor) OnChange(fn func(ev ChangeEvent)) {
+	k.subscribers.Lock()
+	defer k.subscribers.Unlock()
+	k.subscribers.fns = append(k.subscribers.fns, fn)
+}
+
+// UpdateSpanConfigEntries is part of the KVAccessor interface.
+func (k *KVAccessor) UpdateSpanConfigEntries(
+	ctx context.Context, toDelete []roachpb.Span, toUpsert []roachpb.SpanConfigEntry,
+) error {
+	return k.updateSpanConfigEntries(ctx, toDelete, toUpsert, false /* split */)
+}
+
+// UpdateSpanConfigEntriesWithSplits is like UpdateSpanConfigEntries, except
+// that entries being upserted are allowed to partially overlap with existing
+// entries. Existing entries that overlap with upserted ones are split: the
+// portions outside the upserted spans retain their existing config, while the
+// overlapping portions take on the upserted config. Existing entries that are
+// fully contained within upserted spans are replaced. Deletions are applied
+// before upserts, and still need to match existing entries exactly.
+func (k *KVAccessor) UpdateSpanConfigEntriesWithSplits(
+	ctx context.Context, toDelete []roachpb.Span, toUpsert []roachpb.SpanConfigEntry,
+) error {
+	return k.updateSpanConfigEntries(ctx, toDelete, toUpsert, true /* split */)
+}
+
+// SpanOverride is an entry to upsert using UpsertWithTemplate, whose config is
+// expressed relative to a base config.
+type SpanOverride struct {
+	// Span is the span of the entry.
+	Span roachpb.Span
+	// Override changes the fields of the config of the entry which differ
+	// from the base config. It's given a copy of the base config, which it's
+	// free to modify. If nil, the entry has the base config.
+	Override func(conf *roachpb.SpanConfig)
+}
+
+// UpsertWithTemplate is like UpdateSpanConfigEntries, without deletions,
+// except that the configs of the entries to upsert are those obtained by
+// applying each override to a copy of the base config. This allows callers
+// upserting many entries whose configs differ from one another in few fields
+// to only specify those fields for each entry. The full configs are
+// materialized before being written.
+func (k *KVAccessor) UpsertWithTemplate(
+	ctx context.Context, base roachpb.SpanConfig, overrides []SpanOverride,
+) error {
+	return k.UpdateSpanConfigEntries(ctx, nil /* toDelete */, materializeOverrides(base, overrides))
+}
+
+// UpdateSpanConfigEntriesAndCount is like UpdateSpanConfigEntries, except
+// that it also returns the number of entries overlapping with the union of the
+// deleted and upserted spans once the update is applied. The entries are
+// counted in the same transaction as the update, so the count isn't affected
+// by concurrent updates.
+func (k *KVAccessor) UpdateSpanConfigEntriesAndCount(
+	ctx context.Context, toDelete []roachpb.Span, toUpsert []roachpb.SpanConfigEntry,
+) (count int, _ error) {
+	k = k.pinned()
+	if !enabledSetting.Get(&k.settings.SV) {
+		return 0, errDisabled
+	}
+
+	if len(toDelete) == 0 && len(toUpsert) == 0 {
+		return 0, nil
+	}
+	if err := k.validateUpdateArgs(toDelete, toUpsert); err != nil {
+		return 0, err
+	}
+
+	affected := make([]roachpb.Span, 0, len(toDelete)+len(toUpsert))
+	affected = append(affected, toDelete...)
+	for _, entry := range toUpsert {
+		affected = append(affected, entry.Span)
+	}
+	affected = NormalizeSpans(affected)
+	if err := k.txn(ctx, func(ctx context.Context, txn *kv.Txn) error {
+		if err := k.updateSpanConfigEntriesWithTxn(ctx, txn, toDelete, toUpsert); err != nil {
+			return err
+		}
+		k.publishOnCommit(txn, toDelete, toUpsert)
+		entries, err := k.getSpanConfigEntriesFor(ctx, txn, affected)
+		if err != nil {
+			return err
+		}
+		// Entries spanning more than one of the normalized spans are found
+		// once for each.
+		count = len(sortAndDedupEntries(entries))
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// UpdateSummary describes the effect of an update, as observed by the
+// transaction applying it. All the entries are sorted by start key.
+type UpdateSummary struct {
+	// Deleted are the entries deleted by the update, with their configs.
+	Deleted []roachpb.SpanConfigEntry
+	// Upserted are the entries upserted by the update.
+	Upserted []roachpb.SpanConfigEntry
+	// Before and After are the entries overlapping with the deleted and
+	// upserted spans before and after the update is applied.
+	Before, After []roachpb.SpanConfigEntry
+}
+
+// ApplyWithValidation is like UpdateSpanConfigEntries, except that the
+// update is only committed if validate, which is called with a summary of the
+// update once it has been applied in the transaction, returns nil. Otherwise,
+// the transaction is rolled back and the error returned by validate is
+// returned. The transaction may be retried, so validate may be called more
+// than once; only its last call determines whether the update is committed.
+func (k *KVAccessor) ApplyWithValidation(
+	ctx context.Context,
+	toDelete []roachpb.Span,
+	toUpsert []roachpb.SpanConfigEntry,
+	validate func(summary *UpdateSummary) error,
+) error {
+	k = k.pinned()
+	if !enabledSetting.Get(&k.settings.SV) {
+		return errDisabled
+	}
+
+	if len(toDelete) == 0 && len(toUpsert) == 0 {
+		return nil
+	}
+	if err := k.validateUpdateArgs(toDelete, toUpsert); err != nil {
+		return err
+	}
+
+	affected := make([]roachpb.Span, 0, len(toDelete)+len(toUpsert))
+	affected = append(affected, toDelete...)
+	for _, entry := range toUpsert {
+		affected = append(affected, entry.Span)
+	}
+	affected = NormalizeSpans(affected)
+	return k.txn(ctx, func(ctx context.Context, txn *kv.Txn) error {
+		before, err := k.getSpanConfigEntriesFor(ctx, txn, affected)
+		if err != nil {
+			return err
+		}
+		if err := k.updateSpanConfigEntriesWithTxn(ctx, txn, toDelete, toUpsert); err != nil {
+			return err
+		}
+		after, err := k.getSpanConfigEntriesFor(ctx, txn, affected)
+		if err != nil {
+			return err
+		}
+		if err := validate(newUpdateSummary(
+			toDelete, toUpsert, sortAndDedupEntries(before), sortAndDedupEntries(after),
+		)); err != nil {
+			return err
+		}
+		k.publishOnCommit(txn, toDelete, toUpsert)
+		return nil
+	})
+}
+
+// BatchedUpdateOptions control how UpdateSpanConfigEntriesBatched applies an
+// update.
+type BatchedUpdateOptions struct {
+	// BatchSize is the number of deletions and upserts after which a batch is
+	// cut. It must be positive.
+	BatchSize int
+	// Concurrency is the number of batches applied concurrently. It's capped
+	// by spanconfig.kvaccessor.max_update_concurrency. If zero, the batches
+	// are applied one after the other.
+	Concurrency int
+}
+
+// UpdateSpanConfigEntriesBatched is like UpdateSpanConfigEntries, except that
+// the update is split into batches which are each applied in their own
+// transaction, so that very large updates don't run in a single transaction.
+//
+// Each batch is applied atomically, but the update as a whole isn't: if a
+// batch fails, the batches which were already applied, or which are applied
+// concurrently, remain committed. The batches cover disjoint parts of the
+// keyspace, with deletions and upserts overlapping with one another always in
+// the same batch, so they can be applied in any order. Batches may thus exceed
+// the batch size, for runs of overlapping deletions and upserts. Once a batch
+// fails, no further batches are started, and the error of the first batch to
+// fail is returned. Subscribers registered using OnChange are notified of
+// each batch separately.
+func (k *KVAccessor) UpdateSpanConfigEntriesBatched(
+	ctx context.Context,
+	toDelete []roachpb.Span,
+	toUpsert []roachpb.SpanConfigEntry,
+	opts BatchedUpdateOptions,
+) error {
+	k = k.pinned()
+	if !enabledSetting.Get(&k.settings.SV) {
+		return errDisabled
+	}
+
+	if opts.BatchSize <= 0 {
+		return errors.AssertionFailedf("invalid batch size %d", opts.BatchSize)
+	}
+	if opts.Concurrency < 0 {
+		return errors.AssertionFailedf("invalid concurrency %d", opts.Concurrency)
+	}
+	if len(toDelete) == 0 && len(toUpsert) == 0 {
+		return nil
+	}
+	if err := k.validateUpdateArgs(toDelete, toUpsert); err != nil {
+		return err
+	}
+
+	batches := makeUpdateBatches(toDelete, toUpsert, opts.BatchSize)
+	concurrency := opts.Concurrency
+	if maxConcurrency := int(maxUpdateConcurrencySetting.Get(&k.settings.SV)); concurrency > maxConcurrency {
+		concurrency = maxConcurrency
+	}
+	if concurrency > len(batches) {
+		concurrency = len(batches)
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var next int64
+	return ctxgroup.GroupWorkers(ctx, concurrency, func(ctx context.Context, _ int) error {
+		for {
+			i := int(atomic.AddInt64(&next, 1)) - 1
+			if i >= len(batches) {
+				return nil
+			}
+			// Don't start any more batches once one of them failed.
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			b := batches[i]
+			if err := k.txn(ctx, func(ctx context.Context, txn *kv.Txn) error {
+				if err := k.updateSpanConfigEntriesWithTxn(ctx, txn, b.toDelete, b.toUpsert); err != nil {
+					return err
+				}
+				k.publishOnCommit(txn, b.toDelete, b.toUpsert)
+				return nil
+			}); err != nil {
+				return errors.Wrapf(err, "applying batch %d of %d", i+1, len(batches))
+			}
+		}
+	})
+}
+
+// updateBatch is a batch of an update applied by
+// UpdateSpanConfigEntriesBatched.
+type updateBatch struct {
+	toDelete []roachpb.Span
+	toUpsert []roachpb.SpanConfigEntry
+}
+
+// makeUpdateBatches splits the update into batches of at least batchSize
+// deletions and upserts, except for the last one, in key order. Deletions and
+// upserts overlapping with one another, directly or through others, are kept
+// in the same batch, so that the batches cover disjoint parts of the
+// keyspace.
+func makeUpdateBatches(
+	toDelete []roachpb.Span, toUpsert []roachpb.SpanConfigEntry, batchSize int,
+) []updateBatch {
+	toDelete = append([]roachpb.Span(nil), toDelete...)
+	sort.Sort(roachpb.Spans(toDelete))
+	toUpsert = append([]roachpb.SpanConfigEntry(nil), toUpsert...)
+	sort.Slice(toUpsert, func(i, j int) bool {
+		return toUpsert[i].Span.Key.Compare(toUpsert[j].Span.Key) < 0
+	})
+
+	var batches []updateBatch
+	var cur updateBatch
+	var curEnd roachpb.Key
+	for i, j := 0, 0; i < len(toDelete) || j < len(toUpsert); {
+		// Take the deletion or upsert with the smaller start key, preferring
+		// deletions.
+		var span roachpb.Span
+		isDelete := j == len(toUpsert) ||
+			(i < len(toDelete) && toDelete[i].Key.Compare(toUpsert[j].Span.Key) <= 0)
+		if isDelete {
+			span = toDelete[i]
+		} else {
+			span = toUpsert[j].Span
+		}
+		// Only cut the batch if the span doesn't overlap with those in it.
+		if len(cur.toDelete)+len(cur.toUpsert) >= batchSize && span.Key.Compare(curEnd) >= 0 {
+			batches = append(batches, cur)
+			cur = updateBatch{}
+		}
+		if isDelete {
+			cur.toDelete = append(cur.toDelete, toDelete[i])
+			i++
+		} else {
+			cur.toUpsert = append(cur.toUpsert, toUpsert[j])
+			j++
+		}
+		if span.EndKey.Compare(curEnd) > 0 {
+			curEnd = span.EndKey
+		}
+	}
+	return append(batches, cur)
+}
+
+func (k *KVAccessor) updateSpanConfigEntries(
+	ctx context.Context, toDelete []roachpb.Span, toUpsert []roachpb.SpanConfigEntry, split bool,
+) error {
+	k = k.pinned()
+	if !enabledSetting.Get(&k.settings.SV) {
+		return errDisabled
+	}
+
+	if len(toDelete) == 0 && len(toUpsert) == 0 {
+		return nil
+	}
+	if err := k.validateUpdateArgs(toDelete, toUpsert); err != nil {
+		return err
+	}
+
+	if !split {
+		return k.txn(ctx, func(ctx context.Context, txn *kv.Txn) error {
+			if err := k.updateSpanConfigEntriesWithTxn(ctx, txn, toDelete, toUpsert); err != nil {
+				return err
+			}
+			k.publishOnCommit(txn, toDelete, toUpsert)
+			return nil
+		})
+	}
+
+	return k.txn(ctx, func(ctx context.Context, txn *kv.Txn) error {
+		if err := k.updateSpanConfigEntriesWithTxn(ctx, txn, toDelete, nil /* toUpsert */); err != nil {
+			return err
+		}
+		if len(toUpsert) == 0 {
+			k.publishOnCommit(txn, toDelete, nil /* upserted */)
+			return nil
+		}
+
+		spans := make([]roachpb.Span, len(toUpsert))
+		for i, entry := range toUpsert {
+			spans[i] = entry.Span
+		}
+		existing, err := k.getSpanConfigEntriesFor(ctx, txn, spans)
+		if err != nil {
+			return err
+		}
+		splitDeletes, remainders := splitOverlappingEntries(sortAndDedupEntries(existing), toUpsert)
+		upserted := append(remainders, toUpsert...)
+		if err := k.updateSpanConfigEntriesWithTxn(ctx, txn, splitDeletes, upserted); err != nil {
+			return err
+		}
+		k.publishOnCommit(txn, append(append([]roachpb.Span(nil), toDelete...), splitDeletes...), upserted)
+		return nil
+	})
+}
+
+// updateSpanConfigEntriesWithTxn deletes and upserts the given span configs
+// using the given transaction, validating that the table invariants are
+// upheld.
+func (k *KVAccessor) updateSpanConfigEntriesWithTxn(
+	ctx context.Context, txn *kv.Txn, toDelete []roachpb.Span, toUpsert []roachpb.SpanConfigEntry,
+) error {
+	if len(toDelete) > 0 {
+		deleteStmt, deleteQueryArgs := k.constructDeleteStmtAndArgs(toDelete)
+		var n int
+		if err := k.withStatementTimeout(ctx, "delete-span-cfgs", func(ctx context.Context) (err error) {
+			n, err = k.ie.ExecEx(ctx, "delete-span-cfgs", txn,
+				sessiondata.InternalExecutorOverride{User: security.RootUserName()},
+				deleteStmt, deleteQueryArgs...,
+			)
+			return err
+		}); err != nil {
+			return err
+		}
+		if n != len(toDelete) {
+			return errors.AssertionFailedf("expected to delete %d row(s), deleted %d", len(toDelete), n)
+		}
+	}
+
+	if len(toUpsert) == 0 {
+		// Nothing left to do
+		return nil
+	}
+
+	upsertStmt, upsertQueryArgs, err := k.constructUpsertStmtAndArgs(toUpsert)
+	if err != nil {
+		return err
+	}
+	var n int
+	if err := k.withStatementTimeout(ctx, "upsert-span-cfgs", func(ctx context.Context) (err error) {
+		n, err = k.ie.ExecEx(ctx, "upsert-span-cfgs", txn,
+			sessiondata.InternalExecutorOverride{User: security.RootUserName()},
+			upsertStmt, upsertQueryArgs...,
+		)
+		return err
+	}); err != nil {
+		return err
+	} else if n != len(toUpsert) {
+		return errors.AssertionFailedf("expected to upsert %d row(s), upserted %d", len(toUpsert), n)
+	}
+
+	validationStmt, validationQueryArgs := k.constructValidationStmtAndArgs(toUpsert)
+	var datums tree.Datums
+	if err := k.withStatementTimeout(ctx, "validate-span-cfgs", func(ctx context.Context) (err error) {
+		datums, err = k.ie.QueryRowEx(ctx, "validate-span-cfgs", txn,
+			sessiondata.InternalExecutorOverride{User: security.RootUserName()},
+			validationStmt, validationQueryArgs...,
+		)
+		return err
+	}); err != nil {
+		return err
+	} else if valid := bool(tree.MustBeDBool(datums[0])); !valid {
+		// Surface duplicate entries in the table, if that's what caused
+		// the validation to fail.
+		spans := make([]roachpb.Span, len(toUpsert))
+		for i, entry := range toUpsert {
+			spans[i] = entry.Span
+		}
+		if _, err := k.getSpanConfigEntriesFor(ctx, txn, spans); err != nil {
+			return err
+		}
+		return errors.AssertionFailedf("expected to find single row containing upserted spans")
+	}
+
+	return nil
+}
+
+// Compact merges runs of adjacent entries with equal configs, overlapping with
+// the given span, into single entries, and returns the number of entries
+// eliminated. Entries are adjacent if the end key of one is the start key of
+// the other. Merged entries may extend past the given span, as they cover
+// exactly the entries they replace; the effective config for every key is
+// thus unchanged. The entries are read and rewritten in a single transaction.
+func (k *KVAccessor) Compact(ctx context.Context, within roachpb.Span) (removed int, _ error) {
+	k = k.pinned()
+	if !enabledSetting.Get(&k.settings.SV) {
+		return 0, errDisabled
+	}
+
+	if err := k.validateSpans([]roachpb.Span{within}); err != nil {
+		return 0, err
+	}
+
+	if err := k.txn(ctx, func(ctx context.Context, txn *kv.Txn) error {
+		removed = 0 // the transaction may be retried
+		existing, err := k.getSpanConfigEntriesFor(ctx, txn, []roachpb.Span{within})
+		if err != nil {
+			return err
+		}
+		existing = sortAndDedupEntries(existing)
+		// Merging entries is only safe if they don't overlap with others.
+		if err := validateSortedEntries(existing); err != nil {
+			return err
+		}
+		toDelete, toUpsert := compactEntries(existing)
+		if len(toDelete) == 0 {
+			return nil
+		}
+		if err := k.updateSpanConfigEntriesWithTxn(ctx, txn, toDelete, toUpsert); err != nil {
+			return err
+		}
+		k.publishOnCommit(txn, toDelete, toUpsert)
+		removed = len(toDelete) - len(toUpsert)
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
+
+// DeleteWhere deletes the entries overlapping with the given span whose
+// configs satisfy match, and returns the number of entries deleted. Entries
+// are deleted whole, even if they extend past the span. The entries are read,
+// and the matching ones deleted, in a single transaction; match may thus be
+// called more than once for an entry if the transaction is retried.
+func (k *KVAccessor) DeleteWhere(
+	ctx context.Context, span roachpb.Span, match func(roachpb.SpanConfig) bool,
+) (deleted int, _ error) {
+	k = k.pinned()
+	if !enabledSetting.Get(&k.settings.SV) {
+		return 0, errDisabled
+	}
+
+	if err := k.validateSpans([]roachpb.Span{span}); err != nil {
+		return 0, err
+	}
+
+	if err := k.txn(ctx, func(ctx context.Context, txn *kv.Txn) error {
+		deleted = 0 // the transaction may be retried
+		existing, err := k.getSpanConfigEntriesFor(ctx, txn, []roachpb.Span{span})
+		if err != nil {
+			return err
+		}
+		var toDelete []roachpb.Span
+		for _, entry := range sortAndDedupEntries(existing) {
+			if match(entry.Config) {
+				toDelete = append(toDelete, entry.Span)
+			}
+		}
+		if len(toDelete) == 0 {
+			return nil
+		}
+		if err := k.updateSpanConfigEntriesWithTxn(ctx, txn, toDelete, nil /* toUpsert */); err != nil {
+			return err
+		}
+		k.publishOnCommit(txn, toDelete, nil /* upserted */)
+		deleted = len(toDelete)
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	return deleted, nil
+}
+
+// ApplyIdempotent makes the entries overlapping with the given span match the
+// desired entries, which must be contained within the span and
+// non-overlapping, and returns whether anything changed. Only the delta is
+// applied: existing entries which aren't desired are deleted, whole, even if
+// they extend past the span, and desired entries which don't already exist
+// with the same config are upserted. Re-applying the same desired entries is
+// thus a no-op, which writes no rows and publishes no change events. The
+// entries are read and the delta applied in a single transaction.
+func (k *KVAccessor) ApplyIdempotent(
+	ctx context.Context, desired []roachpb.SpanConfigEntry, within roachpb.Span,
+) (changed bool, _ error) {
+	k = k.pinned()
+	if !enabledSetting.Get(&k.settings.SV) {
+		return false, errDisabled
+	}
+
+	if err := k.validateDesiredEntries(desired, within); err != nil {
+		return false, err
+	}
+
+	if err := k.txn(ctx, func(ctx context.Context, txn *kv.Txn) error {
+		changed = false // the transaction may be retried
+		existing, err := k.getSpanConfigEntriesFor(ctx, txn, []roachpb.Span{within})
+		if err != nil {
+			return err
+		}
+		toDelete, toUpsert := diffEntries(sortAndDedupEntries(existing), desired)
+		if len(toDelete) == 0 && len(toUpsert) == 0 {
+			return nil
+		}
+		if err := k.updateSpanConfigEntriesWithTxn(ctx, txn, toDelete, toUpsert); err != nil {
+			return err
+		}
+		k.publishOnCommit(txn, toDelete, toUpsert)
+		changed = true
+		return nil
+	}); err != nil {
+		return false, err
+	}
+	return changed, nil
+}
+
+// RelocateEntry moves the entry whose span is exactly from to the span to,
+// retaining its config. An error is returned if there's no entry at from, or
+// if to overlaps with any entry other than the one being moved; the entry may
+// thus be moved to a span overlapping with its current one. The entry is
+// read, deleted, and upserted at its new span in a single transaction.
+func (k *KVAccessor) RelocateEntry(ctx context.Context, from, to roachpb.Span) error {
+	k = k.pinned()
+	if !enabledSetting.Get(&k.settings.SV) {
+		return errDisabled
+	}
+
+	if err := k.validateSpans([]roachpb.Span{from, to}); err != nil {
+		return err
+	}
+
+	return k.txn(ctx, func(ctx context.Context, txn *kv.Txn) error {
+		existing, err := k.getSpanConfigEntriesFor(ctx, txn, []roachpb.Span{from, to})
+		if err != nil {
+			return err
+		}
+		relocated, err := relocateEntry(sortAndDedupEntries(existing), from, to)
+		if err != nil {
+			return err
+		}
+		if from.Equal(to) {
+			return nil
+		}
+		toDelete := []roachpb.Span{from}
+		toUpsert := []roachpb.SpanConfigEntry{relocated}
+		if err := k.updateSpanConfigEntriesWithTxn(ctx, txn, toDelete, toUpsert); err != nil {
+			return err
+		}
+		k.publishOnCommit(txn, toDelete, toUpsert)
+		return nil
+	})
+}
+
+// ConsistencyReport describes the differences between the entries stored in
+// the span configurations table and the desired ones, as found by
+// CheckConsistency. All the entries are sorted by start key.
+type ConsistencyReport struct {
+	// Missing are the desired entries with no stored entry with the same
+	// span.
+	Missing []roachpb.SpanConfigEntry
+	// Extra are the stored entries with no desired entry with the same span.
+	Extra []roachpb.SpanConfigEntry
+	// Mismatched are the spans with both a stored and a desired entry, whose
+	// configs differ.
+	Mismatched []ConfigMismatch
+}
+
+// ConfigMismatch is a span whose stored config differs from the desired one.
+type ConfigMismatch struct {
+	Span            roachpb.Span
+	Stored, Desired roachpb.SpanConfig
+}
+
+// Consistent returns true if the stored entries are the desired ones.
+func (r *ConsistencyReport) Consistent() bool {
+	return len(r.Missing) == 0 && len(r.Extra) == 0 && len(r.Mismatched) == 0
+}
+
+// CheckConsistency compares the entries overlapping with the given span to
+// the desired ones, as returned by desired for the span, and reports the
+// differences. The desired entries must be contained within the span and
+// non-overlapping. Stored entries are compared whole, even if they extend
+// past the span. Nothing is written: this is an audit, which can be used to
+// verify that reconciliation is keeping the table up to date. Applying the
+// differences is left to the caller; see ApplyIdempotent.
+func (k *KVAccessor) CheckConsistency(
+	ctx context.Context, span roachpb.Span, desired func(roachpb.Span) []roachpb.SpanConfigEntry,
+) (*ConsistencyReport, error) {
+	k = k.pinned()
+	if !enabledSetting.Get(&k.settings.SV) {
+		return nil, errDisabled
+	}
+
+	if err := k.validateSpans([]roachpb.Span{span}); err != nil {
+		return nil, err
+	}
+	desiredEntries := desired(span)
+	if err := k.validateDesiredEntries(desiredEntries, span); err != nil {
+		return nil, err
+	}
+
+	var existing []roachpb.SpanConfigEntry
+	if err := k.maybeTxn(ctx, func(ctx context.Context, txn *kv.Txn) (err error) {
+		existing, err = k.getSpanConfigEntriesFor(ctx, txn, []roachpb.Span{span})
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return checkConsistency(sortAndDedupEntries(existing), desiredEntries), nil
+}
+
+// Reconcile is like ApplyIdempotent, except that it returns a summary of the
+// changes applied to make the entries overlapping with the given span match
+// the desired ones. The Before and After entries of the summary are those
+// overlapping with the span. If the entries already match, nothing is written
+// and the summary has no Deleted or Upserted entries.
+func (k *KVAccessor) Reconcile(
+	ctx context.Context, within roachpb.Span, desired []roachpb.SpanConfigEntry,
+) (*UpdateSummary, error) {
+	k = k.pinned()
+	if !enabledSetting.Get(&k.settings.SV) {
+		return nil, errDisabled
+	}
+
+	if err := k.validateDesiredEntries(desired, within); err != nil {
+		return nil, err
+	}
+
+	var summary *UpdateSummary
+	if err := k.txn(ctx, func(ctx context.Context, txn *kv.Txn) error {
+		before, err := k.getSpanConfigEntriesFor(ctx, txn, []roachpb.Span{within})
+		if err != nil {
+			return err
+		}
+		before = sortAndDedupEntries(before)
+		toDelete, toUpsert := diffEntries(before, desired)
+		if len(toDelete) == 0 && len(toUpsert) == 0 {
+			summary = newUpdateSummary(nil /* toDelete */, nil /* toUpsert */, before, before)
+			return nil
+		}
+		if err := k.updateSpanConfigEntriesWithTxn(ctx, txn, toDelete, toUpsert); err != nil {
+			return err
+		}
+		after, err := k.getSpanConfigEntriesFor(ctx, txn, []roachpb.Span{within})
+		if err != nil {
+			return err
+		}
+		summary = newUpdateSummary(toDelete, toUpsert, before, sortAndDedupEntries(after))
+		k.publishOnCommit(txn, toDelete, toUpsert)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+// Cursor is a position in the span configurations table, from which Scan
+// resumes. The zero Cursor is positioned at the start of the table.
+type Cursor struct {
+	// lastStartKey is the start key of the last entry returned by Scan, if
+	// started is set. The start key of an entry may be empty.
+	lastStartKey roachpb.Key
+	started      bool
+	done         bool
+}
+
+// CursorAfter returns a Cursor positioned after the entry with the given
+// start key, as if that entry was the last one returned by Scan.
+func CursorAfter(startKey roachpb.Key) Cursor {
+	return Cursor{lastStartKey: startKey, started: true}
+}
+
+// Done returns true if the cursor is positioned at the end of the table.
+func (c Cursor) Done() bool {
+	return c.done
+}
+
+// LastStartKey returns the start key of the last entry returned by Scan, and
+// false if the cursor is positioned at the start of the table.
+func (c Cursor) LastStartKey() (roachpb.Key, bool) {
+	return c.lastStartKey, c.started
+}
+
+// Scan returns the next batch of at most batchSize entries after the cursor,
+// sorted by start key, along with the cursor from which to scan the following
+// batch. Once the table is exhausted, the returned cursor is Done, and
+// scanning from it returns no entries.
+//
+// Each batch is read in its own transaction, so that walking a large table
+// doesn't hold on to resources, and the table may change in between. As the
+// cursor is positioned on start keys, the walk doesn't skip entries which are
+// present throughout: these are returned exactly once. Entries added, removed
+// or changed during the walk may or may not be returned, depending on whether
+// they sort after the cursor at the time.
+func (k *KVAccessor) Scan(
+	ctx context.Context, cursor Cursor, batchSize int,
+) (entries []roachpb.SpanConfigEntry, next Cursor, _ error) {
+	k = k.pinned()
 	if !enabledSetting.Get(&k.settings.SV) {
-		return nil, errDisabled
+		return nil, Cursor{}, errDisabled
 	}
 
-	if len(spans) == 0 {
-		return resp, nil
+	if batchSize <= 0 {
+		return nil, Cursor{}, errors.AssertionFailedf("invalid batch size %d", batchSize)
 	}
-	if err := validateSpans(spans); err != nil {
-		return nil, err
+	if cursor.done {
+		return nil, cursor, nil
 	}
-
-	getStmt, getQueryArgs := k.constructGetStmtAndArgs(spans)
-	it, err := k.ie.QueryIteratorEx(ctx, "get-span-cfgs", nil,
-		sessiondata.InternalExecutorOverride{User: security.RootUserName()},
-		getStmt, getQueryArgs...,
-	)
-	if err != nil {
-		return nil, err
+	scanStmt, scanQueryArgs := k.constructScanStmtAndArgs(cursor, batchSize)
+	var rows []tree.Datums
+	if err := k.maybeTxn(ctx, func(ctx context.Context, txn *kv.Txn) error {
+		return k.withStatementTimeout(ctx, "scan-span-cfgs", func(ctx context.Context) (err error) {
+			rows, err = k.ie.QueryBufferedEx(ctx, "scan-span-cfgs", txn,
+				sessiondata.InternalExecutorOverride{User: security.RootUserName()},
+				scanStmt, scanQueryArgs...,
+			)
+			return err
+		})
+	}); err != nil {
+		return nil, Cursor{}, err
 	}
-	defer func() {
-		if closeErr := it.Close(); closeErr != nil {
-			resp, retErr = nil, errors.CombineErrors(retErr, closeErr)
-		}
-	}()
-
-	var ok bool
-	for ok, err = it.Next(ctx); ok; ok, err = it.Next(ctx) {
-		row := it.Cur()
-		span := roachpb.Span{
+	entries = make([]roachpb.SpanConfigEntry, len(rows))
+	for i, row := range rows {
+		entries[i].Span = roachpb.Span{
 			Key:    []byte(*row[0].(*tree.DBytes)),
 			EndKey: []byte(*row[1].(*tree.DBytes)),
 		}
-		var conf roachpb.SpanConfig
-		if err := protoutil.Unmarshal(([]byte)(*row[2].(*tree.DBytes)), &conf); err != nil {
-			return nil, err
+		if err := protoutil.Unmarshal(([]byte)(*row[2].(*tree.DBytes)), &entries[i].Config); err != nil {
+			return nil, Cursor{}, err
 		}
-
-		resp = append(resp, roachpb.SpanConfigEntry{
-			Span:   span,
-			Config: conf,
-		})
 	}
-	if err != nil {
-		return nil, err
+	next = cursor
+	if len(entries) > 0 {
+		next = CursorAfter(entries[len(entries)-1].Span.Key)
 	}
-	return resp, nil
+	next.done = len(entries) < batchSize
+	return entries, next, nil
 }
 
-// UpdateSpanConfigEntries is part of the KVAccessor interface.
-func (k *KVAccessor) UpdateSpanConfigEntries(
-	ctx context.Context, toDelete []roachpb.Span, toUpsert []roachpb.SpanConfigEntry,
-) error {
-	if !enabledSetting.Get(&k.settings.SV) {
-		return errDisabled
-	}
+// DebugStatement is a statement the KVAccessor would execute, along with the
+// values for its placeholders.
+type DebugStatement struct {
+	SQL  string
+	Args []interface{}
+}
 
-	if err := validateUpdateArgs(toDelete, toUpsert); err != nil {
-		return err
+// DebugGetStatement returns the statement, and its arguments, that
+// GetSpanConfigEntriesFor would execute for the given spans. Like
+// GetSpanConfigEntriesFor, the spans are normalized first. Nothing is executed;
+// this is intended for inspecting the generated SQL.
+func (k *KVAccessor) DebugGetStatement(spans []roachpb.Span) (string, []interface{}) {
+	k = k.pinned()
+	return k.constructGetStmtAndArgs(NormalizeSpans(spans))
+}
+
+// DebugUpdateStatements returns the statements, in order, that
+// UpdateSpanConfigEntries would execute for the given arguments. The arguments
+// are validated the same way. UpdateSpanConfigEntriesWithSplits additionally
+// reads and rewrites existing entries, which depends on the contents of the
+// table, so its statements aren't covered here.
+func (k *KVAccessor) DebugUpdateStatements(
+	toDelete []roachpb.Span, toUpsert []roachpb.SpanConfigEntry,
+) ([]DebugStatement, error) {
+	k = k.pinned()
+	if err := k.validateUpdateArgs(toDelete, toUpsert); err != nil {
+		return nil, err
 	}
 
-	var deleteStmt string
-	var deleteQueryArgs []interface{}
+	var stmts []DebugStatement
 	if len(toDelete) > 0 {
-		deleteStmt, deleteQueryArgs = k.constructDeleteStmtAndArgs(toDelete)
+		deleteStmt, deleteQueryArgs := k.constructDeleteStmtAndArgs(toDelete)
+		stmts = append(stmts, DebugStatement{SQL: deleteStmt, Args: deleteQueryArgs})
 	}
-
-	var upsertStmt, validationStmt string
-	var upsertQueryArgs, validationQueryArgs []interface{}
 	if len(toUpsert) > 0 {
-		var err error
-		upsertStmt, upsertQueryArgs, err = k.constructUpsertStmtAndArgs(toUpsert)
+		upsertStmt, upsertQueryArgs, err := k.constructUpsertStmtAndArgs(toUpsert)
 		if err != nil {
-			return err
+			return nil, err
 		}
-
-		validationStmt, validationQueryArgs = k.constructValidationStmtAndArgs(toUpsert)
+		validationStmt, validationQueryArgs := k.constructValidationStmtAndArgs(toUpsert)
+		stmts = append(stmts,
+			DebugStatement{SQL: upsertStmt, Args: upsertQueryArgs},
+			DebugStatement{SQL: validationStmt, Args: validationQueryArgs},
+		)
 	}
+	return stmts, nil
+}
 
-	if err := k.db.Txn(ctx, func(ctx context.Context, txn *kv.Txn) error {
-		if len(toDelete) > 0 {
-			n, err := k.ie.ExecEx(ctx, "delete-span-cfgs", txn,
-				sessiondata.InternalExecutorOverride{User: security.RootUserName()},
-				deleteStmt, deleteQueryArgs...,
-			)
-			if err != nil {
+// txn runs f in a transaction with the KVAccessor's priority.
+func (k *KVAccessor) txn(ctx context.Context, f func(context.Context, *kv.Txn) error) error {
+	return k.db.Txn(ctx, func(ctx context.Context, txn *kv.Txn) error {
+		if k.priority != 0 {
+			if err := txn.SetUserPriority(k.priority); err != nil {
 				return err
 			}
-			if n != len(toDelete) {
-				return errors.AssertionFailedf("expected to delete %d row(s), deleted %d", len(toDelete), n)
-			}
 		}
+		return f(ctx, txn)
+	})
+}
 
-		if len(toUpsert) == 0 {
-			// Nothing left to do
-			return nil
+// publishOnCommit arranges for the subscribers registered using OnChange to be
+// notified of the change made by the transaction once it commits. Commit
+// triggers are dropped when the transaction is retried, so the subscribers are
+// only notified of the change made by the attempt which commits.
+func (k *KVAccessor) publishOnCommit(
+	txn *kv.Txn, deleted []roachpb.Span, upserted []roachpb.SpanConfigEntry,
+) {
+	if k.subscribers == nil {
+		return
+	}
+	txn.AddCommitTrigger(func(ctx context.Context) {
+		ev := ChangeEvent{
+			Deleted:   deleted,
+			Upserted:  upserted,
+			Timestamp: txn.ProvisionalCommitTimestamp(),
 		}
-
-		if n, err := k.ie.ExecEx(ctx, "upsert-span-cfgs", txn,
-			sessiondata.InternalExecutorOverride{User: security.RootUserName()},
-			upsertStmt, upsertQueryArgs...,
-		); err != nil {
-			return err
-		} else if n != len(toUpsert) {
-			return errors.AssertionFailedf("expected to upsert %d row(s), upserted %d", len(toUpsert), n)
+		k.subscribers.Lock()
+		fns := k.subscribers.fns
+		k.subscribers.Unlock()
+		for _, fn := range fns {
+			fn(ev)
 		}
+	})
+}
 
-		if datums, err := k.ie.QueryRowEx(ctx, "validate-span-cfgs", txn,
-			sessiondata.InternalExecutorOverride{User: security.RootUserName()},
-			validationStmt, validationQueryArgs...,
-		); err != nil {
-			return err
-		} else if valid := bool(tree.MustBeDBool(datums[0])); !valid {
-			return errors.AssertionFailedf("expected to find single row containing upserted spans")
-		}
+// maybeTxn is like txn, except that f is run without a transaction if no
+// priority is set.
+func (k *KVAccessor) maybeTxn(ctx context.Context, f func(context.Context, *kv.Txn) error) error {
+	if k.priority == 0 {
+		return f(ctx, nil /* txn */)
+	}
+	return k.txn(ctx, f)
+}
 
-		return nil
-	}); err != nil {
-		return err
+// withStatementTimeout runs the given function, which is expected to execute a
+// single statement, subject to spanconfig.kvaccessor.statement_timeout. If the
+// timeout expires, a *contextutil.TimeoutError is returned, which callers can
+// tell apart from the cancellation of the given context.
+func (k *KVAccessor) withStatementTimeout(
+	ctx context.Context, opName string, fn func(ctx context.Context) error,
+) error {
+	timeout := statementTimeoutSetting.Get(&k.settings.SV)
+	if timeout == 0 {
+		return fn(ctx)
 	}
-	return nil
+	return contextutil.RunWithTimeout(ctx, opName, timeout, fn)
 }
 
 // constructGetStmtAndArgs constructs the statement and query arguments needed
 // to fetch span configs for the given spans.
 func (k *KVAccessor) constructGetStmtAndArgs(spans []roachpb.Span) (string, []interface{}) {
+	return k.constructGetStmtAndArgsSelecting(spans, getColumns)
+}
+
+const (
+	// getColumns are the columns selected by the statement fetching the span
+	// configs.
+	getColumns = "start_key, end_key, config"
+	// getColumnsWithTimestamp are the columns selected by the statement
+	// fetching the span configs along with the MVCC timestamps of the rows.
+	getColumnsWithTimestamp = "start_key, end_key, config, crdb_internal_mvcc_timestamp"
+)
+
+// constructGetStmtAndArgsSelecting is like constructGetStmtAndArgs, except
+// that the given columns are selected, following the index of the query span.
+func (k *KVAccessor) constructGetStmtAndArgsSelecting(
+	spans []roachpb.Span, columns string,
+) (string, []interface{}) {
 	// We want to fetch the overlapping span configs for each requested span in
 	// a single round trip and using only constrained index scans. For a single
 	// requested span, we effectively want to query the following:
@@ -228,6 +1980,9 @@ func (k *KVAccessor) constructGetStmtAndArgs(spans []roachpb.Span) (string, []in
 	//   UNION ALL
 	//   ...
 	//
+	// Each row is also tagged with the index of the query span it was
+	// retrieved for, which lets us detect duplicate entries in the table.
+	//
 	var getStmtBuilder strings.Builder
 	queryArgs := make([]interface{}, len(spans)*2)
 	for i, sp := range spans {
@@ -240,22 +1995,99 @@ func (k *KVAccessor) constructGetStmtAndArgs(spans []roachpb.Span) (string, []in
 		queryArgs[endKeyIdx] = sp.EndKey
 
 		fmt.Fprintf(&getStmtBuilder, `
-SELECT start_key, end_key, config FROM %[1]s
+SELECT %[4]d, %[5]s FROM %[1]s
  WHERE start_key >= $%[2]d AND start_key < $%[3]d
 UNION ALL
-SELECT start_key, end_key, config FROM (
-  SELECT start_key, end_key, config FROM %[1]s
+SELECT %[4]d, %[5]s FROM (
+  SELECT %[5]s FROM %[1]s
   WHERE start_key < $%[2]d ORDER BY start_key DESC LIMIT 1
 ) WHERE end_key > $%[2]d
 `,
 			k.tableName,   // [1]
 			startKeyIdx+1, // [2] -- prepared statement placeholder (1-indexed)
 			endKeyIdx+1,   // [3] -- prepared statement placeholder (1-indexed)
+			i,             // [4] -- index of the query span
+			columns,       // [5]
 		)
 	}
 	return getStmtBuilder.String(), queryArgs
 }
 
+// constructStorageSizeStmtAndArgs constructs the statement and query arguments
+// needed to sum the sizes of the rows of the span configs overlapping with
+// the span. The rows are found as they are by the statement fetching them, so
+// as to only use constrained index scans.
+func (k *KVAccessor) constructStorageSizeStmtAndArgs(span roachpb.Span) (string, []interface{}) {
+	getStmt, getQueryArgs := k.constructGetStmtAndArgs([]roachpb.Span{span})
+	sizeStmt := fmt.Sprintf(`SELECT COALESCE(sum(length(start_key) + length(end_key) + length(config)), 0)::INT8
+ FROM (%s)`, getStmt)
+	return sizeStmt, getQueryArgs
+}
+
+// constructGetExactStmtAndArgs constructs the statement and query arguments
+// needed to fetch the span config with exactly the given span.
+func (k *KVAccessor) constructGetExactStmtAndArgs(span roachpb.Span) (string, []interface{}) {
+	// Start keys are unique, so this is a point lookup on the primary key.
+	getExactStmt := fmt.Sprintf(`SELECT config FROM %[1]s
+ WHERE start_key = $1 AND end_key = $2`, k.tableName)
+	return getExactStmt, []interface{}{span.Key, span.EndKey}
+}
+
+// constructScanStmtAndArgs constructs the statement and query arguments
+// needed to fetch the batch of span configs after the cursor.
+func (k *KVAccessor) constructScanStmtAndArgs(cursor Cursor, batchSize int) (string, []interface{}) {
+	// Start keys are the primary key, so each batch is a constrained scan of
+	// the primary index.
+	var conds []string
+	var args []interface{}
+	if cursor.started {
+		args = append(args, cursor.lastStartKey)
+		conds = append(conds, fmt.Sprintf("start_key > $%d", len(args)))
+	}
+	if k.tenantID != (roachpb.TenantID{}) {
+		args = append(args, k.tenantSpan.Key, k.tenantSpan.EndKey)
+		conds = append(conds,
+			fmt.Sprintf("start_key >= $%d", len(args)-1), fmt.Sprintf("start_key < $%d", len(args)),
+		)
+	}
+	args = append(args, batchSize)
+	var stmt strings.Builder
+	fmt.Fprintf(&stmt, "SELECT start_key, end_key, config FROM %s\n", k.tableName)
+	if len(conds) > 0 {
+		fmt.Fprintf(&stmt, " WHERE %s", strings.Join(conds, " AND "))
+	}
+	fmt.Fprintf(&stmt, " ORDER BY start_key LIMIT $%d", len(args))
+	return stmt.String(), args
+}
+
+// constructPollStmtAndArgs constructs the statement and query arguments
+// needed to fetch span configs for the given spans that were written after
+// the given timestamp.
+func (k *KVAccessor) constructPollStmtAndArgs(
+	spans []roachpb.Span, since hlc.Timestamp,
+) (string, []interface{}) {
+	// The statement is of the form:
+	//
+	//   SELECT start_key, end_key, config FROM span_configurations
+	//    WHERE crdb_internal_mvcc_timestamp > $since
+	//      AND ((start_key < $end AND end_key > $start) OR ...)
+	//
+	overlaps := make([]string, len(spans))
+	pollQueryArgs := make([]interface{}, 1+len(spans)*2)
+	pollQueryArgs[0] = tree.TimestampToDecimalDatum(since)
+	for i, sp := range spans {
+		startKeyIdx, endKeyIdx := 1+i*2, 1+(i*2)+1
+		pollQueryArgs[startKeyIdx] = sp.Key
+		pollQueryArgs[endKeyIdx] = sp.EndKey
+		overlaps[i] = fmt.Sprintf("(start_key < $%d AND end_key > $%d)",
+			endKeyIdx+1, startKeyIdx+1) // prepared statement placeholders (1-indexed)
+	}
+	pollStmt := fmt.Sprintf(`SELECT start_key, end_key, config FROM %[1]s
+ WHERE crdb_internal_mvcc_timestamp > $1 AND (%[2]s)`,
+		k.tableName, strings.Join(overlaps, " OR "))
+	return pollStmt, pollQueryArgs
+}
+
 // constructDeleteStmtAndArgs constructs the statement and query arguments
 // needed to delete span configs for the given spans.
 func (k *KVAccessor) constructDeleteStmtAndArgs(toDelete []roachpb.Span) (string, []interface{}) {
@@ -378,6 +2210,95 @@ SELECT count(*) = 1 FROM (
 	return validationStmt, validationQueryArgs
 }
 
+// newUpdateSummary summarizes the update deleting and upserting the given
+// entries, given the sorted entries overlapping with the affected spans
+// before and after it was applied.
+func newUpdateSummary(
+	toDelete []roachpb.Span, toUpsert []roachpb.SpanConfigEntry, before, after []roachpb.SpanConfigEntry,
+) *UpdateSummary {
+	deleted := make(map[spanKey]struct{}, len(toDelete))
+	for _, sp := range toDelete {
+		deleted[makeSpanKey(sp)] = struct{}{}
+	}
+	summary := &UpdateSummary{
+		Upserted: append([]roachpb.SpanConfigEntry(nil), toUpsert...),
+		Before:   before,
+		After:    after,
+	}
+	for _, entry := range before {
+		if _, ok := deleted[makeSpanKey(entry.Span)]; ok {
+			summary.Deleted = append(summary.Deleted, entry)
+		}
+	}
+	sort.Slice(summary.Upserted, func(i, j int) bool {
+		return summary.Upserted[i].Span.Key.Compare(summary.Upserted[j].Span.Key) < 0
+	})
+	return summary
+}
+
+// spanKey identifies a span, for use as a map key.
+type spanKey struct {
+	startKey, endKey string
+}
+
+func makeSpanKey(sp roachpb.Span) spanKey {
+	return spanKey{startKey: string(sp.Key), endKey: string(sp.EndKey)}
+}
+
+// validateSpans is like the function of the same name, except that it also
+// returns an error if the KVAccessor is scoped to a tenant and any of the
+// spans isn't contained within its keyspace.
+func (k *KVAccessor) validateSpans(spans []roachpb.Span) error {
+	if err := validateSpans(spans); err != nil {
+		return err
+	}
+	if k.tenantID == (roachpb.TenantID{}) {
+		return nil
+	}
+	for _, span := range spans {
+		if !k.tenantSpan.Contains(span) {
+			return errors.Errorf("span %s is outside the keyspace of tenant %s", span, k.tenantID)
+		}
+	}
+	return nil
+}
+
+// validateUpdateArgs is like the function of the same name, except that it
+// also returns an error if the KVAccessor is scoped to a tenant and any of the
+// spans isn't contained within its keyspace.
+func (k *KVAccessor) validateUpdateArgs(
+	toDelete []roachpb.Span, toUpsert []roachpb.SpanConfigEntry,
+) error {
+	if err := validateUpdateArgs(toDelete, toUpsert); err != nil {
+		return err
+	}
+	spans := append([]roachpb.Span(nil), toDelete...)
+	for _, entry := range toUpsert {
+		spans = append(spans, entry.Span)
+	}
+	return k.validateSpans(spans)
+}
+
+// validateDesiredEntries returns an error if the desired entries passed to
+// ApplyIdempotent, Reconcile or CheckConsistency are malformed, overlap with
+// one another, or aren't contained within the given span.
+func (k *KVAccessor) validateDesiredEntries(
+	desired []roachpb.SpanConfigEntry, within roachpb.Span,
+) error {
+	if err := k.validateSpans([]roachpb.Span{within}); err != nil {
+		return err
+	}
+	if err := k.validateUpdateArgs(nil /* toDelete */, desired); err != nil {
+		return err
+	}
+	for _, entry := range desired {
+		if !within.Contains(entry.Span) {
+			return errors.Errorf("desired entry %s is not contained in %s", entry.Span, within)
+		}
+	}
+	return nil
+}
+
 // validateUpdateArgs returns an error the arguments to UpdateSpanConfigEntries
 // are malformed. All spans included in the toDelete and toUpsert list are
 // expected to be valid and to have non-empty end keys. Spans are also expected
@@ -414,6 +2335,422 @@ func validateUpdateArgs(toDelete []roachpb.Span, toUpsert []roachpb.SpanConfigEn
 	return nil
 }
 
+// splitOverlappingEntries returns the spans of the existing entries which
+// overlap with the entries being upserted, and thus need to be deleted, along
+// with the remainders of those existing entries that aren't covered by the
+// upserted entries. The remainders retain the config of the entry they were
+// split from. The existing entries are expected to be non-overlapping, as are
+// the entries being upserted.
+func splitOverlappingEntries(
+	existing, toUpsert []roachpb.SpanConfigEntry,
+) (toDelete []roachpb.Span, remainders []roachpb.SpanConfigEntry) {
+	upsertSpans := make(roachpb.Spans, len(toUpsert))
+	for i, entry := range toUpsert {
+		upsertSpans[i] = entry.Span
+	}
+	for _, entry := range existing {
+		toDelete = append(toDelete, entry.Span)
+		// SubtractSpans sorts its arguments in place, so hand it copies.
+		done := append(roachpb.Spans(nil), upsertSpans...)
+		for _, sp := range roachpb.SubtractSpans(roachpb.Spans{entry.Span}, done) {
+			remainders = append(remainders, roachpb.SpanConfigEntry{
+				Span:   sp,
+				Config: entry.Config,
+			})
+		}
+	}
+	return toDelete, remainders
+}
+
+// mergeEntries merges the entries read from a table with lower precedence
+// into the entries read from tables with higher precedence, clipping them to
+// the portions of their spans not already covered. Both sets of entries are
+// expected to be sorted and non-overlapping, and so are the merged entries.
+func mergeEntries(entries, lower []roachpb.SpanConfigEntry) []roachpb.SpanConfigEntry {
+	_, remainders := splitOverlappingEntries(lower, entries)
+	return sortAndDedupEntries(append(entries, remainders...))
+}
+
+// compactEntries returns the spans of the entries which are part of runs of
+// adjacent entries with equal configs, along with the entries replacing each
+// of the runs. The entries are expected to be sorted and non-overlapping.
+func compactEntries(
+	entries []roachpb.SpanConfigEntry,
+) (toDelete []roachpb.Span, toUpsert []roachpb.SpanConfigEntry) {
+	for i := 0; i < len(entries); {
+		j := i + 1
+		for ; j < len(entries); j++ {
+			if !entries[j].Span.Key.Equal(entries[j-1].Span.EndKey) ||
+				!entries[j].Config.Equal(&entries[i].Config) {
+				break
+			}
+		}
+		if j-i > 1 {
+			for _, entry := range entries[i:j] {
+				toDelete = append(toDelete, entry.Span)
+			}
+			toUpsert = append(toUpsert, roachpb.SpanConfigEntry{
+				Span:   roachpb.Span{Key: entries[i].Span.Key, EndKey: entries[j-1].Span.EndKey},
+				Config: entries[i].Config,
+			})
+		}
+		i = j
+	}
+	return toDelete, toUpsert
+}
+
+// flattenEntries returns a sorted, non-overlapping sequence of entries
+// describing the configs in effect across the given span, clipped to it. See
+// GetEffectiveConfigs for the precedence rules. The entries are sorted in
+// place.
+func flattenEntries(
+	span roachpb.Span, entries []roachpb.SpanConfigEntry,
+) []roachpb.SpanConfigEntry {
+	entries = sortAndDedupEntries(entries)
+
+	// Every start and end key within the span is a boundary at which the
+	// effective config can change.
+	boundaries := []roachpb.Key{span.Key, span.EndKey}
+	for _, entry := range entries {
+		for _, key := range []roachpb.Key{entry.Span.Key, entry.Span.EndKey} {
+			if span.ContainsKey(key) {
+				boundaries = append(boundaries, key)
+			}
+		}
+	}
+	sort.Slice(boundaries, func(i, j int) bool {
+		return boundaries[i].Compare(boundaries[j]) < 0
+	})
+
+	var flattened []roachpb.SpanConfigEntry
+	prevWinner := -1
+	for i := 1; i < len(boundaries); i++ {
+		piece := roachpb.Span{Key: boundaries[i-1], EndKey: boundaries[i]}
+		if piece.Key.Equal(piece.EndKey) {
+			continue
+		}
+		// The entries are sorted by start key, so the last entry containing
+		// the piece takes precedence.
+		winner := -1
+		for j := range entries {
+			if entries[j].Span.Contains(piece) {
+				winner = j
+			}
+		}
+		switch {
+		case winner == -1:
+		case winner == prevWinner && flattened[len(flattened)-1].Span.EndKey.Equal(piece.Key):
+			flattened[len(flattened)-1].Span.EndKey = piece.EndKey
+		default:
+			flattened = append(flattened, roachpb.SpanConfigEntry{
+				Span:   piece,
+				Config: entries[winner].Config,
+			})
+		}
+		prevWinner = winner
+	}
+	return flattened
+}
+
+// distinctConfigs returns the distinct configs of the entries, sorted by
+// their encodings.
+func distinctConfigs(entries []roachpb.SpanConfigEntry) ([]roachpb.SpanConfig, error) {
+	type encodedConfig struct {
+		conf    roachpb.SpanConfig
+		encoded []byte
+	}
+	encoded := make([]encodedConfig, len(entries))
+	for i := range entries {
+		b, err := protoutil.Marshal(&entries[i].Config)
+		if err != nil {
+			return nil, err
+		}
+		encoded[i] = encodedConfig{conf: entries[i].Config, encoded: b}
+	}
+	sort.Slice(encoded, func(i, j int) bool {
+		return bytes.Compare(encoded[i].encoded, encoded[j].encoded) < 0
+	})
+	// Equal configs have equal encodings, so they are adjacent once sorted.
+	var distinct []roachpb.SpanConfig
+	for i := range encoded {
+		if n := len(distinct); n > 0 && distinct[n-1].Equal(&encoded[i].conf) {
+			continue
+		}
+		distinct = append(distinct, encoded[i].conf)
+	}
+	return distinct, nil
+}
+
+// sortAndDedupEntries sorts the entries by span, removing entries with
+// duplicate spans. The sorting happens in place.
+func sortAndDedupEntries(entries []roachpb.SpanConfigEntry) []roachpb.SpanConfigEntry {
+	sort.Slice(entries, func(i, j int) bool {
+		if c := entries[i].Span.Key.Compare(entries[j].Span.Key); c != 0 {
+			return c < 0
+		}
+		return entries[i].Span.EndKey.Compare(entries[j].Span.EndKey) < 0
+	})
+	deduped := entries[:0]
+	for i := range entries {
+		if i > 0 && entries[i].Span.Equal(entries[i-1].Span) {
+			continue
+		}
+		deduped = append(deduped, entries[i])
+	}
+	return deduped
+}
+
+// mapEntriesToSpans returns, for each of the given spans in order, the entries
+// overlapping with the span. The entries are expected to be sorted and
+// non-overlapping, as is the case for entries read from the table, which lets
+// the entries for each span be found using a binary search.
+func mapEntriesToSpans(
+	spans []roachpb.Span, entries []roachpb.SpanConfigEntry,
+) []roachpb.SpanConfigEntry {
+	var resp []roachpb.SpanConfigEntry
+	for _, sp := range spans {
+		// Since the entries are non-overlapping, the end keys are sorted too.
+		i := sort.Search(len(entries), func(i int) bool {
+			return entries[i].Span.EndKey.Compare(sp.Key) > 0
+		})
+		for ; i < len(entries) && entries[i].Span.Key.Compare(sp.EndKey) < 0; i++ {
+			resp = append(resp, entries[i])
+		}
+	}
+	return resp
+}
+
+// diffEntries returns the spans of the existing entries to delete, and the
+// desired entries to upsert, to turn the existing entries into the desired
+// ones. Existing entries with the span of a desired entry are replaced by the
+// upsert, if their configs differ, rather than deleted.
+func diffEntries(
+	existing, desired []roachpb.SpanConfigEntry,
+) (toDelete []roachpb.Span, toUpsert []roachpb.SpanConfigEntry) {
+	configs := make(map[spanKey]roachpb.SpanConfig, len(existing))
+	for _, entry := range existing {
+		configs[makeSpanKey(entry.Span)] = entry.Config
+	}
+	desiredSpans := make(map[spanKey]struct{}, len(desired))
+	for _, entry := range desired {
+		desiredSpans[makeSpanKey(entry.Span)] = struct{}{}
+		if conf, ok := configs[makeSpanKey(entry.Span)]; !ok || !conf.Equal(entry.Config) {
+			toUpsert = append(toUpsert, entry)
+		}
+	}
+	for _, entry := range existing {
+		if _, ok := desiredSpans[makeSpanKey(entry.Span)]; !ok {
+			toDelete = append(toDelete, entry.Span)
+		}
+	}
+	return toDelete, toUpsert
+}
+
+// materializeOverrides returns the entries with the spans of the overrides
+// and the configs obtained by applying them to copies of the base config. The
+// copies are deep, so overrides modifying repeated fields of the config don't
+// affect one another, nor the base config.
+func materializeOverrides(
+	base roachpb.SpanConfig, overrides []SpanOverride,
+) []roachpb.SpanConfigEntry {
+	entries := make([]roachpb.SpanConfigEntry, len(overrides))
+	for i, o := range overrides {
+		conf := *protoutil.Clone(&base).(*roachpb.SpanConfig)
+		if o.Override != nil {
+			o.Override(&conf)
+		}
+		entries[i] = roachpb.SpanConfigEntry{Span: o.Span, Config: conf}
+	}
+	return entries
+}
+
+// relocateEntry returns the entry with the span from, out of the existing
+// entries, moved to the span to. An error is returned if there's no such
+// entry, or if to overlaps with any other existing entry.
+func relocateEntry(
+	existing []roachpb.SpanConfigEntry, from, to roachpb.Span,
+) (roachpb.SpanConfigEntry, error) {
+	var found bool
+	var relocated roachpb.SpanConfigEntry
+	for _, entry := range existing {
+		if entry.Span.Equal(from) {
+			found = true
+			relocated = roachpb.SpanConfigEntry{Span: to, Config: entry.Config}
+			continue
+		}
+		if entry.Span.Overlaps(to) {
+			return roachpb.SpanConfigEntry{}, errors.Errorf(
+				"cannot relocate entry %s to %s: overlaps with entry %s", from, to, entry.Span,
+			)
+		}
+	}
+	if !found {
+		return roachpb.SpanConfigEntry{}, errors.Errorf("no entry exists at %s", from)
+	}
+	return relocated, nil
+}
+
+// checkConsistency returns the differences between the existing entries,
+// which are expected to be sorted, and the desired ones. Entries are matched
+// by span, as they are by diffEntries.
+func checkConsistency(existing, desired []roachpb.SpanConfigEntry) *ConsistencyReport {
+	desired = sortAndDedupEntries(append([]roachpb.SpanConfigEntry(nil), desired...))
+	configs := make(map[spanKey]roachpb.SpanConfig, len(existing))
+	for _, entry := range existing {
+		configs[makeSpanKey(entry.Span)] = entry.Config
+	}
+	desiredSpans := make(map[spanKey]struct{}, len(desired))
+	report := &ConsistencyReport{}
+	for _, entry := range desired {
+		desiredSpans[makeSpanKey(entry.Span)] = struct{}{}
+		conf, ok := configs[makeSpanKey(entry.Span)]
+		switch {
+		case !ok:
+			report.Missing = append(report.Missing, entry)
+		case !conf.Equal(entry.Config):
+			report.Mismatched = append(report.Mismatched, ConfigMismatch{
+				Span:    entry.Span,
+				Stored:  conf,
+				Desired: entry.Config,
+			})
+		}
+	}
+	for _, entry := range existing {
+		if _, ok := desiredSpans[makeSpanKey(entry.Span)]; !ok {
+			report.Extra = append(report.Extra, entry)
+		}
+	}
+	return report
+}
+
+// sortAndDedupTimestampedEntries is like sortAndDedupEntries, for entries
+// annotated with their timestamps.
+func sortAndDedupTimestampedEntries(entries []TimestampedEntry) []TimestampedEntry {
+	sort.Slice(entries, func(i, j int) bool {
+		if c := entries[i].Entry.Span.Key.Compare(entries[j].Entry.Span.Key); c != 0 {
+			return c < 0
+		}
+		return entries[i].Entry.Span.EndKey.Compare(entries[j].Entry.Span.EndKey) < 0
+	})
+	deduped := entries[:0]
+	for i := range entries {
+		if i > 0 && entries[i].Entry.Span.Equal(entries[i-1].Entry.Span) {
+			continue
+		}
+		deduped = append(deduped, entries[i])
+	}
+	return deduped
+}
+
+// findTimestamp returns the timestamp of the entry read from the table with
+// the highest precedence whose span contains the span of the given entry,
+// which was merged from the tables' sorted and non-overlapping entries.
+func findTimestamp(entry roachpb.SpanConfigEntry, tables [][]TimestampedEntry) hlc.Timestamp {
+	for _, table := range tables {
+		i := sort.Search(len(table), func(i int) bool {
+			return table[i].Entry.Span.EndKey.Compare(entry.Span.Key) > 0
+		})
+		if i < len(table) && table[i].Entry.Span.Contains(entry.Span) {
+			return table[i].Timestamp
+		}
+	}
+	return hlc.Timestamp{}
+}
+
+// groupEntriesBySpan is like mapEntriesToSpans, except that the entries
+// overlapping with each span are keyed by the index of the span. Spans
+// overlapping with no entries have no key.
+func groupEntriesBySpan(
+	spans []roachpb.Span, entries []roachpb.SpanConfigEntry,
+) map[int][]roachpb.SpanConfigEntry {
+	grouped := make(map[int][]roachpb.SpanConfigEntry)
+	for i, sp := range spans {
+		if overlapping := mapEntriesToSpans([]roachpb.Span{sp}, entries); len(overlapping) > 0 {
+			grouped[i] = overlapping
+		}
+	}
+	return grouped
+}
+
+// NormalizeSpans returns the given spans sorted, with overlapping and adjacent
+// spans merged. Adjacent spans are ones where the end key of one is the start
+// key of the other; gaps between spans are preserved, regardless of how small.
+// Normalizing the spans passed to GetSpanConfigEntriesFor reduces the size of
+// the query, but note that entries overlapping with more than one of the
+// original spans are then returned fewer times. The given slice is not
+// modified. All spans are expected to have non-empty end keys.
+func NormalizeSpans(spans []roachpb.Span) []roachpb.Span {
+	normalized := make([]roachpb.Span, len(spans))
+	copy(normalized, spans)
+	normalized, _ = roachpb.MergeSpans(&normalized)
+	return normalized
+}
+
+// validateSortedEntries returns an error if any of the sorted entries
+// overlap, which is indicative of an inconsistent span configurations table.
+func validateSortedEntries(entries []roachpb.SpanConfigEntry) error {
+	for i := 1; i < len(entries); i++ {
+		if entries[i].Span.Overlaps(entries[i-1].Span) {
+			return errors.AssertionFailedf("span config table inconsistent: overlapping spans %s and %s",
+				entries[i-1].Span, entries[i].Span)
+		}
+	}
+	return nil
+}
+
+// checkCoverage returns an error identifying the first gap in the coverage of
+// the span by the entries, or the first pair of overlapping entries, if any.
+func checkCoverage(span roachpb.Span, entries []roachpb.SpanConfigEntry) error {
+	sorted := make([]roachpb.SpanConfigEntry, len(entries))
+	copy(sorted, entries)
+	sort.Slice(sorted, func(i, j int) bool {
+		if c := sorted[i].Span.Key.Compare(sorted[j].Span.Key); c != 0 {
+			return c < 0
+		}
+		return sorted[i].Span.EndKey.Compare(sorted[j].Span.EndKey) < 0
+	})
+	covered := span.Key
+	for i, entry := range sorted {
+		if i > 0 && entry.Span.Overlaps(sorted[i-1].Span) {
+			return errors.Errorf("span config coverage of %s: overlapping entries %s and %s",
+				span, sorted[i-1].Span, entry.Span)
+		}
+		if entry.Span.Key.Compare(covered) > 0 {
+			return errors.Errorf("span config coverage of %s: gap %s",
+				span, roachpb.Span{Key: covered, EndKey: entry.Span.Key})
+		}
+		covered = entry.Span.EndKey
+	}
+	if covered.Compare(span.EndKey) < 0 {
+		return errors.Errorf("span config coverage of %s: gap %s",
+			span, roachpb.Span{Key: covered, EndKey: span.EndKey})
+	}
+	return nil
+}
+
+// spanConfigColumns are the columns, and their types, which a table storing
+// span configurations must have.
+var spanConfigColumns = []struct{ name, typ string }{
+	{"start_key", "BYTES"},
+	{"end_key", "BYTES"},
+	{"config", "BYTES"},
+}
+
+// checkTableColumns returns an error if the given columns, mapped to their
+// types, don't include the columns of a table storing span configurations.
+func checkTableColumns(columnTypes map[string]string) error {
+	for _, c := range spanConfigColumns {
+		typ, ok := columnTypes[c.name]
+		if !ok {
+			return errors.Errorf("missing column %s", c.name)
+		}
+		if typ != c.typ {
+			return errors.Errorf("column %s is of type %s, not %s", c.name, typ, c.typ)
+		}
+	}
+	return nil
+}
+
 // validateSpans returns an error if any of the spans are invalid or have an
 // empty end key.
 func validateSpans(spans []roachpb.Span) error {
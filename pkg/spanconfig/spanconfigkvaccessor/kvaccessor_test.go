@@ -0,0 +1,1707 @@
+// Copyright 2021 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package spanconfigkvaccessor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/keys"
+	"github.com/cockroachdb/cockroach/pkg/kv"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/sessiondata"
+	"github.com/cockroachdb/cockroach/pkg/sql/sqlutil"
+	"github.com/cockroachdb/cockroach/pkg/testutils"
+	"github.com/cockroachdb/cockroach/pkg/util/contextutil"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/protoutil"
+	"github.com/cockroachdb/cockroach/pkg/util/randutil"
+	"github.com/cockroachdb/cockroach/pkg/util/stop"
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// TestTableNameQuoting ensures that table names which need quoting are
+// quoted when constructing statements, regardless of the constructor used.
+func TestTableNameQuoting(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	for _, tc := range []struct {
+		tableName tree.TableName
+		tableFQN  string
+		exp       string
+	}{
+		{
+			tableName: tree.MakeTableNameWithSchema("system", "public", "span_configurations"),
+			tableFQN:  "system.public.span_configurations",
+			exp:       "system.public.span_configurations",
+		},
+		{
+			// Mixed case.
+			tableName: tree.MakeTableNameWithSchema("MyDB", "public", "Span_Configurations"),
+			tableFQN:  `"MyDB".public."Span_Configurations"`,
+			exp:       `"MyDB".public."Span_Configurations"`,
+		},
+		{
+			// Reserved words and special characters.
+			tableName: tree.MakeTableNameWithSchema("defaultdb", "my schema", "select"),
+			tableFQN:  `defaultdb."my schema"."select"`,
+			exp:       `defaultdb."my schema"."select"`,
+		},
+	} {
+		t.Run(tc.exp, func(t *testing.T) {
+			for _, k := range []*KVAccessor{
+				NewWithTableName(nil /* db */, nil /* ie */, nil /* settings */, tc.tableName),
+				New(nil /* db */, nil /* ie */, nil /* settings */, tc.tableFQN),
+			} {
+				require.Equal(t, tc.exp, k.tableName)
+
+				span := roachpb.Span{Key: roachpb.Key("a"), EndKey: roachpb.Key("b")}
+				getStmt, _ := k.constructGetStmtAndArgs([]roachpb.Span{span})
+				require.Contains(t, getStmt, "FROM "+tc.exp+"\n")
+				sizeStmt, _ := k.constructStorageSizeStmtAndArgs(span)
+				require.Contains(t, sizeStmt, "FROM "+tc.exp+"\n")
+				getExactStmt, _ := k.constructGetExactStmtAndArgs(span)
+				require.Contains(t, getExactStmt, "FROM "+tc.exp+"\n")
+				pollStmt, _ := k.constructPollStmtAndArgs([]roachpb.Span{span}, hlc.Timestamp{})
+				require.Contains(t, pollStmt, "FROM "+tc.exp+"\n")
+				deleteStmt, _ := k.constructDeleteStmtAndArgs([]roachpb.Span{span})
+				require.Contains(t, deleteStmt, "DELETE FROM "+tc.exp+" WHERE")
+				upsertStmt, _, err := k.constructUpsertStmtAndArgs(
+					[]roachpb.SpanConfigEntry{{Span: span}},
+				)
+				require.NoError(t, err)
+				require.Contains(t, upsertStmt, "UPSERT INTO "+tc.exp+" (")
+			}
+		})
+	}
+
+	require.Panics(t, func() {
+		New(nil /* db */, nil /* ie */, nil /* settings */, "not a table name")
+	})
+}
+
+// countingExecutor is an internal executor which counts the statements it's
+// asked to execute, without executing them.
+type countingExecutor struct {
+	sqlutil.InternalExecutor
+	count int
+}
+
+var errNotExecuted = errors.New("statement not executed")
+
+func (c *countingExecutor) ExecEx(
+	context.Context, string, *kv.Txn, sessiondata.InternalExecutorOverride, string, ...interface{},
+) (int, error) {
+	c.count++
+	return 0, errNotExecuted
+}
+
+func (c *countingExecutor) QueryRowEx(
+	context.Context, string, *kv.Txn, sessiondata.InternalExecutorOverride, string, ...interface{},
+) (tree.Datums, error) {
+	c.count++
+	return nil, errNotExecuted
+}
+
+func (c *countingExecutor) QueryBufferedEx(
+	context.Context, string, *kv.Txn, sessiondata.InternalExecutorOverride, string, ...interface{},
+) ([]tree.Datums, error) {
+	c.count++
+	return nil, errNotExecuted
+}
+
+func (c *countingExecutor) QueryIteratorEx(
+	context.Context, string, *kv.Txn, sessiondata.InternalExecutorOverride, string, ...interface{},
+) (sqlutil.InternalRows, error) {
+	c.count++
+	return nil, errNotExecuted
+}
+
+// TestEmptyInputs ensures that no statements are executed, and that no
+// transactions are run, for empty inputs.
+func TestEmptyInputs(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+	enabledSetting.Override(ctx, &st.SV, true)
+	ie := &countingExecutor{}
+	// The nil DB ensures that no transactions are run.
+	k := New(nil /* db */, ie, st, "system.span_configurations")
+
+	entries, err := k.GetSpanConfigEntriesFor(ctx, nil /* spans */)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+	entries, err = k.GetSpanConfigEntriesFor(ctx, []roachpb.Span{})
+	require.NoError(t, err)
+	require.Empty(t, entries)
+	entries, err = k.GetSortedSpanConfigEntriesFor(ctx, []roachpb.Span{})
+	require.NoError(t, err)
+	require.Empty(t, entries)
+	require.NoError(t, k.UpdateSpanConfigEntries(ctx, nil /* toDelete */, nil /* toUpsert */))
+	require.NoError(t, k.UpdateSpanConfigEntries(
+		ctx, []roachpb.Span{}, []roachpb.SpanConfigEntry{},
+	))
+	require.NoError(t, k.UpdateSpanConfigEntriesWithSplits(
+		ctx, []roachpb.Span{}, []roachpb.SpanConfigEntry{},
+	))
+	count, err := k.UpdateSpanConfigEntriesAndCount(ctx, nil /* toDelete */, nil /* toUpsert */)
+	require.NoError(t, err)
+	require.Zero(t, count)
+	require.Zero(t, ie.count)
+
+	// Sanity check the counter.
+	_, err = k.GetSpanConfigEntriesFor(ctx, []roachpb.Span{
+		{Key: roachpb.Key("a"), EndKey: roachpb.Key("b")},
+	})
+	require.True(t, errors.Is(err, errNotExecuted))
+	require.Equal(t, 1, ie.count)
+}
+
+// slowExecutor is an internal executor whose statements don't complete until
+// their context is done.
+type slowExecutor struct {
+	sqlutil.InternalExecutor
+}
+
+func (slowExecutor) ExecEx(
+	ctx context.Context, _ string, _ *kv.Txn, _ sessiondata.InternalExecutorOverride, _ string, _ ...interface{},
+) (int, error) {
+	<-ctx.Done()
+	return 0, ctx.Err()
+}
+
+func (slowExecutor) QueryRowEx(
+	ctx context.Context, _ string, _ *kv.Txn, _ sessiondata.InternalExecutorOverride, _ string, _ ...interface{},
+) (tree.Datums, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (slowExecutor) QueryBufferedEx(
+	ctx context.Context, _ string, _ *kv.Txn, _ sessiondata.InternalExecutorOverride, _ string, _ ...interface{},
+) ([]tree.Datums, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (slowExecutor) QueryIteratorEx(
+	ctx context.Context, _ string, _ *kv.Txn, _ sessiondata.InternalExecutorOverride, _ string, _ ...interface{},
+) (sqlutil.InternalRows, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+// TestStatementTimeout ensures that statements which run past
+// spanconfig.kvaccessor.statement_timeout fail with a timeout error, which
+// is distinct from the error returned when the caller's context is canceled.
+func TestStatementTimeout(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+	enabledSetting.Override(ctx, &st.SV, true)
+	statementTimeoutSetting.Override(ctx, &st.SV, time.Millisecond)
+	k := New(nil /* db */, slowExecutor{}, st, "system.span_configurations")
+
+	span := roachpb.Span{Key: roachpb.Key("a"), EndKey: roachpb.Key("b")}
+	entry := roachpb.SpanConfigEntry{Span: span}
+	ops := map[string]func(ctx context.Context) error{
+		"get": func(ctx context.Context) error {
+			_, err := k.GetSpanConfigEntriesFor(ctx, []roachpb.Span{span})
+			return err
+		},
+		"get-exact": func(ctx context.Context) error {
+			_, _, err := k.GetSpanConfigEntryExact(ctx, span)
+			return err
+		},
+		"get-effective": func(ctx context.Context) error {
+			_, err := k.GetEffectiveConfigs(ctx, span)
+			return err
+		},
+		"validate-table": func(ctx context.Context) error {
+			return k.ValidateTable(ctx, "system.span_configurations")
+		},
+		// The nil DB precludes running transactions, so the updates and
+		// polls are exercised directly.
+		"delete": func(ctx context.Context) error {
+			return k.updateSpanConfigEntriesWithTxn(ctx, nil /* txn */, []roachpb.Span{span}, nil)
+		},
+		"upsert": func(ctx context.Context) error {
+			return k.updateSpanConfigEntriesWithTxn(ctx, nil /* txn */, nil, []roachpb.SpanConfigEntry{entry})
+		},
+		"poll": func(ctx context.Context) error {
+			_, err := k.pollWithTxn(ctx, nil /* txn */, []roachpb.Span{span}, hlc.Timestamp{})
+			return err
+		},
+	}
+	for name, op := range ops {
+		t.Run(name, func(t *testing.T) {
+			err := op(ctx)
+			require.True(t, errors.HasType(err, (*contextutil.TimeoutError)(nil)), "%v", err)
+			require.True(t, errors.Is(err, context.DeadlineExceeded), "%v", err)
+
+			// Cancellation of the caller's context isn't reported as a timeout.
+			canceledCtx, cancel := context.WithCancel(ctx)
+			cancel()
+			err = op(canceledCtx)
+			require.True(t, errors.Is(err, context.Canceled), "%v", err)
+			require.False(t, errors.HasType(err, (*contextutil.TimeoutError)(nil)), "%v", err)
+		})
+	}
+
+	// Without a timeout, statements run until the caller's context is done.
+	statementTimeoutSetting.Override(ctx, &st.SV, 0)
+	timeoutCtx, cancel := context.WithTimeout(ctx, time.Millisecond)
+	defer cancel()
+	err := ops["get"](timeoutCtx)
+	require.True(t, errors.Is(err, context.DeadlineExceeded), "%v", err)
+	require.False(t, errors.HasType(err, (*contextutil.TimeoutError)(nil)), "%v", err)
+}
+
+// priorityRecordingExecutor is an internal executor which records the user
+// priority of the transaction each statement is run in, or zero for
+// statements run without a transaction, and doesn't execute the statements.
+type priorityRecordingExecutor struct {
+	sqlutil.InternalExecutor
+	priorities []roachpb.UserPriority
+}
+
+func (r *priorityRecordingExecutor) record(txn *kv.Txn) error {
+	var priority roachpb.UserPriority
+	if txn != nil {
+		priority = txn.UserPriority()
+	}
+	r.priorities = append(r.priorities, priority)
+	return errNotExecuted
+}
+
+func (r *priorityRecordingExecutor) ExecEx(
+	_ context.Context, _ string, txn *kv.Txn, _ sessiondata.InternalExecutorOverride, _ string, _ ...interface{},
+) (int, error) {
+	return 0, r.record(txn)
+}
+
+func (r *priorityRecordingExecutor) QueryRowEx(
+	_ context.Context, _ string, txn *kv.Txn, _ sessiondata.InternalExecutorOverride, _ string, _ ...interface{},
+) (tree.Datums, error) {
+	return nil, r.record(txn)
+}
+
+func (r *priorityRecordingExecutor) QueryBufferedEx(
+	_ context.Context, _ string, txn *kv.Txn, _ sessiondata.InternalExecutorOverride, _ string, _ ...interface{},
+) ([]tree.Datums, error) {
+	return nil, r.record(txn)
+}
+
+func (r *priorityRecordingExecutor) QueryIteratorEx(
+	_ context.Context, _ string, txn *kv.Txn, _ sessiondata.InternalExecutorOverride, _ string, _ ...interface{},
+) (sqlutil.InternalRows, error) {
+	return nil, r.record(txn)
+}
+
+// stmtRecordingExecutor is an internal executor which records the statements
+// it's asked to run, and whether they're run in a transaction, without
+// executing them.
+type stmtRecordingExecutor struct {
+	sqlutil.InternalExecutor
+	stmts []string
+	inTxn []bool
+}
+
+func (r *stmtRecordingExecutor) QueryIteratorEx(
+	_ context.Context, _ string, txn *kv.Txn, _ sessiondata.InternalExecutorOverride, stmt string, _ ...interface{},
+) (sqlutil.InternalRows, error) {
+	r.stmts = append(r.stmts, stmt)
+	r.inTxn = append(r.inTxn, txn != nil)
+	return nil, errNotExecuted
+}
+
+// TestStaleReads ensures that GetSpanConfigEntriesForStale reads as of the
+// given staleness, without a transaction.
+func TestStaleReads(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+	enabledSetting.Override(ctx, &st.SV, true)
+	ie := &stmtRecordingExecutor{}
+	k := New(nil /* db */, ie, st, "system.span_configurations").
+		WithUserPriority(roachpb.MaxUserPriority)
+	spans := []roachpb.Span{{Key: roachpb.Key("a"), EndKey: roachpb.Key("b")}}
+
+	_, err := k.GetSpanConfigEntriesForStale(ctx, spans, 1500*time.Millisecond)
+	require.True(t, errors.Is(err, errNotExecuted), "%v", err)
+	require.Len(t, ie.stmts, 1)
+	getStmt, _ := k.DebugGetStatement(spans)
+	require.Equal(t,
+		fmt.Sprintf("SELECT * FROM (%s) AS OF SYSTEM TIME '-1500000us'", getStmt), ie.stmts[0])
+	require.Equal(t, []bool{false}, ie.inTxn)
+
+	for _, maxStaleness := range []time.Duration{0, time.Nanosecond, -time.Second} {
+		_, err := k.GetSpanConfigEntriesForStale(ctx, spans, maxStaleness)
+		require.Regexp(t, "invalid max staleness", err)
+	}
+	require.Len(t, ie.stmts, 1)
+}
+
+// TestTenantScoping ensures that KVAccessors scoped to a tenant reject spans
+// outside of its keyspace, before running any statement.
+func TestTenantScoping(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+	enabledSetting.Override(ctx, &st.SV, true)
+	tenantSpan := func(id uint64) roachpb.Span {
+		prefix := keys.MakeTenantPrefix(roachpb.MakeTenantID(id))
+		return roachpb.Span{Key: prefix, EndKey: prefix.PrefixEnd()}
+	}
+	ten10, ten11 := tenantSpan(10), tenantSpan(11)
+	within := roachpb.Span{Key: ten10.Key.Next(), EndKey: ten10.EndKey}
+	for _, tc := range []struct {
+		name string
+		span roachpb.Span
+		// ok is set if the span is within the keyspace of tenant 10.
+		ok bool
+	}{
+		{name: "keyspace", span: ten10, ok: true},
+		{name: "within", span: within, ok: true},
+		{name: "other tenant", span: ten11},
+		{name: "system tenant", span: roachpb.Span{Key: roachpb.Key("a"), EndKey: roachpb.Key("b")}},
+		{name: "straddling", span: roachpb.Span{Key: ten10.Key.Next(), EndKey: ten11.Key.Next()}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ie := &stmtRecordingExecutor{}
+			k := NewForTenant(nil /* db */, ie, st, "system.span_configurations", roachpb.MakeTenantID(10))
+			_, err := k.GetSpanConfigEntriesFor(ctx, []roachpb.Span{within, tc.span})
+			if tc.ok {
+				require.True(t, errors.Is(err, errNotExecuted), "%v", err)
+				require.Len(t, ie.stmts, 1)
+				return
+			}
+			require.Regexp(t, "outside the keyspace of tenant 10", err)
+			_, err = k.GetEffectiveConfigs(ctx, tc.span)
+			require.Regexp(t, "outside the keyspace of tenant 10", err)
+			// Updates are rejected before running a transaction, whether the
+			// span is deleted or upserted.
+			err = k.UpdateSpanConfigEntries(ctx, []roachpb.Span{tc.span}, nil /* toUpsert */)
+			require.Regexp(t, "outside the keyspace of tenant 10", err)
+			err = k.UpdateSpanConfigEntries(ctx, nil /* toDelete */, []roachpb.SpanConfigEntry{
+				{Span: tc.span},
+			})
+			require.Regexp(t, "outside the keyspace of tenant 10", err)
+			_, err = k.Compact(ctx, tc.span)
+			require.Regexp(t, "outside the keyspace of tenant 10", err)
+			require.Empty(t, ie.stmts)
+		})
+	}
+
+	t.Run("system tenant", func(t *testing.T) {
+		ie := &stmtRecordingExecutor{}
+		k := NewForTenant(nil /* db */, ie, st, "system.span_configurations", roachpb.SystemTenantID)
+		_, err := k.GetSpanConfigEntriesFor(ctx, []roachpb.Span{ten10, ten11, {
+			Key: roachpb.Key("a"), EndKey: roachpb.Key("b"),
+		}})
+		require.True(t, errors.Is(err, errNotExecuted), "%v", err)
+	})
+
+	t.Run("scan", func(t *testing.T) {
+		k := NewForTenant(nil /* db */, nil /* ie */, st, "system.span_configurations", roachpb.MakeTenantID(10))
+		stmt, args := k.constructScanStmtAndArgs(CursorAfter(within.Key), 2)
+		require.Equal(t, `SELECT start_key, end_key, config FROM system.span_configurations
+ WHERE start_key > $1 AND start_key >= $2 AND start_key < $3 ORDER BY start_key LIMIT $4`, stmt)
+		require.Equal(t, []interface{}{within.Key, ten10.Key, ten10.EndKey, 2}, args)
+	})
+}
+
+// TestUserPriority ensures that the priority set using WithUserPriority
+// applies to all the statements issued by the KVAccessor, and that by
+// default, statements run at normal priority, or without a transaction.
+func TestUserPriority(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+	clock := hlc.NewClock(hlc.UnixNano, time.Nanosecond)
+	factory := kv.MakeMockTxnSenderFactory(
+		func(_ context.Context, _ *roachpb.Transaction, ba roachpb.BatchRequest,
+		) (*roachpb.BatchResponse, *roachpb.Error) {
+			return ba.CreateReply(), nil
+		})
+	db := kv.NewDB(testutils.MakeAmbientCtx(), factory, clock, stopper)
+	st := cluster.MakeTestingClusterSettings()
+	enabledSetting.Override(ctx, &st.SV, true)
+
+	span := roachpb.Span{Key: roachpb.Key("a"), EndKey: roachpb.Key("b")}
+	entry := roachpb.SpanConfigEntry{Span: span}
+	ops := []struct {
+		name string
+		op   func(k *KVAccessor) error
+		// inTxn is set if the operation runs in a transaction by default.
+		inTxn bool
+	}{
+		{name: "get", op: func(k *KVAccessor) error {
+			_, err := k.GetSpanConfigEntriesFor(ctx, []roachpb.Span{span})
+			return err
+		}},
+		{name: "get-exact", op: func(k *KVAccessor) error {
+			_, _, err := k.GetSpanConfigEntryExact(ctx, span)
+			return err
+		}},
+		{name: "get-effective", op: func(k *KVAccessor) error {
+			_, err := k.GetEffectiveConfigs(ctx, span)
+			return err
+		}},
+		{name: "poll", inTxn: true, op: func(k *KVAccessor) error {
+			_, _, err := k.Poll(ctx, []roachpb.Span{span}, hlc.Timestamp{})
+			return err
+		}},
+		{name: "update", inTxn: true, op: func(k *KVAccessor) error {
+			return k.UpdateSpanConfigEntries(ctx, nil /* toDelete */, []roachpb.SpanConfigEntry{entry})
+		}},
+		{name: "update-with-splits", inTxn: true, op: func(k *KVAccessor) error {
+			return k.UpdateSpanConfigEntriesWithSplits(ctx, []roachpb.Span{span}, nil /* toUpsert */)
+		}},
+		{name: "compact", inTxn: true, op: func(k *KVAccessor) error {
+			_, err := k.Compact(ctx, span)
+			return err
+		}},
+	}
+	for _, tc := range ops {
+		t.Run(tc.name, func(t *testing.T) {
+			ie := &priorityRecordingExecutor{}
+			k := New(db, ie, st, "system.span_configurations")
+			low := k.WithUserPriority(roachpb.MinUserPriority)
+
+			require.True(t, errors.Is(tc.op(k), errNotExecuted))
+			require.True(t, errors.Is(tc.op(low), errNotExecuted))
+			// The priority of the original KVAccessor is unaffected.
+			require.True(t, errors.Is(tc.op(k), errNotExecuted))
+
+			var def roachpb.UserPriority
+			if tc.inTxn {
+				def = roachpb.NormalUserPriority
+			}
+			require.Equal(t, []roachpb.UserPriority{def, roachpb.MinUserPriority, def}, ie.priorities)
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		k := New(db, &priorityRecordingExecutor{}, st, "system.span_configurations")
+		err := ops[0].op(k.WithUserPriority(roachpb.MaxUserPriority * 2))
+		require.Regexp(t, `out of the allowed range`, err)
+	})
+}
+
+// TestDebugStatements asserts on the SQL generated for representative inputs.
+func TestDebugStatements(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	k := New(nil /* db */, nil /* ie */, nil /* settings */, `"MyDB".public."Span_Configurations"`)
+	sp := func(start, end string) roachpb.Span {
+		return roachpb.Span{Key: roachpb.Key(start), EndKey: roachpb.Key(end)}
+	}
+
+	t.Run("get", func(t *testing.T) {
+		// Adjacent and overlapping spans are normalized away.
+		stmt, args := k.DebugGetStatement([]roachpb.Span{sp("e", "f"), sp("a", "b"), sp("b", "c"), sp("e", "g")})
+		require.Equal(t, `
+SELECT 0, start_key, end_key, config FROM "MyDB".public."Span_Configurations"
+ WHERE start_key >= $1 AND start_key < $2
+UNION ALL
+SELECT 0, start_key, end_key, config FROM (
+  SELECT start_key, end_key, config FROM "MyDB".public."Span_Configurations"
+  WHERE start_key < $1 ORDER BY start_key DESC LIMIT 1
+) WHERE end_key > $1
+UNION ALL
+SELECT 1, start_key, end_key, config FROM "MyDB".public."Span_Configurations"
+ WHERE start_key >= $3 AND start_key < $4
+UNION ALL
+SELECT 1, start_key, end_key, config FROM (
+  SELECT start_key, end_key, config FROM "MyDB".public."Span_Configurations"
+  WHERE start_key < $3 ORDER BY start_key DESC LIMIT 1
+) WHERE end_key > $3
+`, stmt)
+		require.Equal(t, []interface{}{
+			roachpb.Key("a"), roachpb.Key("c"), roachpb.Key("e"), roachpb.Key("g"),
+		}, args)
+	})
+
+	t.Run("update", func(t *testing.T) {
+		conf := roachpb.SpanConfig{NumReplicas: 5}
+		marshaled, err := protoutil.Marshal(&conf)
+		require.NoError(t, err)
+
+		stmts, err := k.DebugUpdateStatements(
+			[]roachpb.Span{sp("a", "b"), sp("c", "d")},
+			[]roachpb.SpanConfigEntry{{Span: sp("a", "d"), Config: conf}},
+		)
+		require.NoError(t, err)
+		require.Len(t, stmts, 3)
+
+		require.Equal(t, `DELETE FROM "MyDB".public."Span_Configurations" WHERE (start_key, end_key) IN (VALUES ($1::BYTES, $2::BYTES), ($3::BYTES, $4::BYTES))`,
+			stmts[0].SQL)
+		require.Equal(t, []interface{}{
+			roachpb.Key("a"), roachpb.Key("b"), roachpb.Key("c"), roachpb.Key("d"),
+		}, stmts[0].Args)
+
+		require.Equal(t, `UPSERT INTO "MyDB".public."Span_Configurations" (start_key, end_key, config) VALUES ($1::BYTES, $2::BYTES, $3::BYTES)`,
+			stmts[1].SQL)
+		require.Equal(t, []interface{}{roachpb.Key("a"), roachpb.Key("d"), marshaled}, stmts[1].Args)
+
+		require.Equal(t, `SELECT true = ALL(
+SELECT count(*) = 1 FROM (
+  SELECT start_key, end_key, config FROM "MyDB".public."Span_Configurations"
+   WHERE start_key >= $1 AND start_key < $2
+  UNION ALL
+  SELECT start_key, end_key, config FROM (
+    SELECT start_key, end_key, config FROM "MyDB".public."Span_Configurations"
+    WHERE start_key < $1 ORDER BY start_key DESC LIMIT 1
+  ) WHERE end_key > $1
+)
+)`, stmts[2].SQL)
+		require.Equal(t, []interface{}{roachpb.Key("a"), roachpb.Key("d")}, stmts[2].Args)
+	})
+
+	t.Run("delete-only", func(t *testing.T) {
+		stmts, err := k.DebugUpdateStatements([]roachpb.Span{sp("a", "b")}, nil /* toUpsert */)
+		require.NoError(t, err)
+		require.Len(t, stmts, 1)
+		require.Contains(t, stmts[0].SQL, "DELETE FROM ")
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		stmts, err := k.DebugUpdateStatements(nil /* toDelete */, nil /* toUpsert */)
+		require.NoError(t, err)
+		require.Empty(t, stmts)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := k.DebugUpdateStatements([]roachpb.Span{sp("a", "c"), sp("b", "d")}, nil /* toUpsert */)
+		require.Error(t, err)
+	})
+}
+
+func TestNormalizeSpans(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	span := func(start, end string) roachpb.Span {
+		return roachpb.Span{Key: roachpb.Key(start), EndKey: roachpb.Key(end)}
+	}
+	for _, tc := range []struct {
+		name  string
+		spans []roachpb.Span
+		exp   []roachpb.Span
+	}{
+		{
+			name:  "empty",
+			spans: nil,
+			exp:   []roachpb.Span{},
+		},
+		{
+			name:  "overlapping",
+			spans: []roachpb.Span{span("c", "f"), span("a", "d"), span("d", "e")},
+			exp:   []roachpb.Span{span("a", "f")},
+		},
+		{
+			name:  "adjacent",
+			spans: []roachpb.Span{span("b", "c"), span("a", "b"), span("c", "d")},
+			exp:   []roachpb.Span{span("a", "d")},
+		},
+		{
+			name:  "disjoint",
+			spans: []roachpb.Span{span("c", "d"), span("a", "b"), span("b\x00", "c")},
+			exp:   []roachpb.Span{span("a", "b"), span("b\x00", "d")},
+		},
+		{
+			name:  "duplicates",
+			spans: []roachpb.Span{span("a", "b"), span("a", "b")},
+			exp:   []roachpb.Span{span("a", "b")},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			spans := append([]roachpb.Span(nil), tc.spans...)
+			require.Equal(t, tc.exp, NormalizeSpans(spans))
+			require.Equal(t, tc.spans, spans, "input was modified")
+		})
+	}
+}
+
+// TestMapEntriesToSpans ensures that mapping the entries found for the
+// normalized spans back to the requested spans gives the same result as
+// finding the entries overlapping with each of the requested spans in turn.
+func TestMapEntriesToSpans(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	rng, _ := randutil.NewTestRand()
+	randKey := func() roachpb.Key {
+		return roachpb.Key([]byte{byte('a' + rng.Intn(26))})
+	}
+	randSpan := func() roachpb.Span {
+		for {
+			if start, end := randKey(), randKey(); start.Compare(end) < 0 {
+				return roachpb.Span{Key: start, EndKey: end}
+			}
+		}
+	}
+	for i := 0; i < 100; i++ {
+		// Generate sorted, non-overlapping entries, with gaps in between.
+		var entries []roachpb.SpanConfigEntry
+		for _, sp := range NormalizeSpans([]roachpb.Span{randSpan(), randSpan(), randSpan()}) {
+			// Split the span into entries at random keys.
+			start := sp.Key
+			for c := sp.Key[0] + 1; c <= sp.EndKey[0]; c++ {
+				if end := roachpb.Key([]byte{c}); c == sp.EndKey[0] || rng.Intn(2) == 0 {
+					entries = append(entries, roachpb.SpanConfigEntry{
+						Span:   roachpb.Span{Key: start, EndKey: end},
+						Config: roachpb.SpanConfig{RangeMinBytes: int64(len(entries))},
+					})
+					start = end
+				}
+			}
+		}
+		spans := make([]roachpb.Span, 1+rng.Intn(5))
+		for j := range spans {
+			spans[j] = randSpan()
+		}
+
+		var exp []roachpb.SpanConfigEntry
+		for _, sp := range spans {
+			for _, entry := range entries {
+				if entry.Span.Overlaps(sp) {
+					exp = append(exp, entry)
+				}
+			}
+		}
+		require.Equal(t, exp, mapEntriesToSpans(spans, entries), "spans: %v, entries: %v", spans, entries)
+	}
+}
+
+func TestGroupEntriesBySpan(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	span := func(start, end string) roachpb.Span {
+		return roachpb.Span{Key: roachpb.Key(start), EndKey: roachpb.Key(end)}
+	}
+	entry := func(start, end string) roachpb.SpanConfigEntry {
+		return roachpb.SpanConfigEntry{Span: span(start, end)}
+	}
+	entries := []roachpb.SpanConfigEntry{
+		entry("a", "c"), entry("c", "e"), entry("g", "k"),
+	}
+	for _, tc := range []struct {
+		name  string
+		spans []roachpb.Span
+		exp   map[int][]roachpb.SpanConfigEntry
+	}{
+		{
+			name:  "disjoint",
+			spans: []roachpb.Span{span("g", "h"), span("a", "d"), span("e", "g")},
+			exp: map[int][]roachpb.SpanConfigEntry{
+				0: {entries[2]},
+				1: {entries[0], entries[1]},
+			},
+		},
+		{
+			name:  "overlapping",
+			spans: []roachpb.Span{span("b", "d"), span("d", "h"), span("c", "j")},
+			exp: map[int][]roachpb.SpanConfigEntry{
+				0: {entries[0], entries[1]},
+				1: {entries[1], entries[2]},
+				2: {entries[1], entries[2]},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.exp, groupEntriesBySpan(tc.spans, entries))
+		})
+	}
+}
+
+func TestSplitOverlappingEntries(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	entry := func(start, end, conf string) roachpb.SpanConfigEntry {
+		return roachpb.SpanConfigEntry{
+			Span:   roachpb.Span{Key: roachpb.Key(start), EndKey: roachpb.Key(end)},
+			Config: roachpb.SpanConfig{RangeMinBytes: int64(conf[0])},
+		}
+	}
+	existing := []roachpb.SpanConfigEntry{entry("c", "f", "X")}
+	for _, tc := range []struct {
+		name          string
+		toUpsert      []roachpb.SpanConfigEntry
+		expRemainders []roachpb.SpanConfigEntry
+	}{
+		{
+			name:          "left",
+			toUpsert:      []roachpb.SpanConfigEntry{entry("b", "d", "A")},
+			expRemainders: []roachpb.SpanConfigEntry{entry("d", "f", "X")},
+		},
+		{
+			name:          "right",
+			toUpsert:      []roachpb.SpanConfigEntry{entry("e", "g", "A")},
+			expRemainders: []roachpb.SpanConfigEntry{entry("c", "e", "X")},
+		},
+		{
+			name:          "middle",
+			toUpsert:      []roachpb.SpanConfigEntry{entry("d", "e", "A")},
+			expRemainders: []roachpb.SpanConfigEntry{entry("c", "d", "X"), entry("e", "f", "X")},
+		},
+		{
+			name:     "containment",
+			toUpsert: []roachpb.SpanConfigEntry{entry("a", "z", "A")},
+		},
+		{
+			name:     "exact",
+			toUpsert: []roachpb.SpanConfigEntry{entry("c", "f", "A")},
+		},
+		{
+			name: "multiple",
+			toUpsert: []roachpb.SpanConfigEntry{
+				entry("d", "e", "A"), entry("a", "c\x00", "B"),
+			},
+			expRemainders: []roachpb.SpanConfigEntry{entry("c\x00", "d", "X"), entry("e", "f", "X")},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			toDelete, remainders := splitOverlappingEntries(existing, tc.toUpsert)
+			require.Equal(t, []roachpb.Span{existing[0].Span}, toDelete)
+			require.Equal(t, tc.expRemainders, remainders)
+		})
+	}
+}
+
+// TestMergeEntries ensures that entries read from lower precedence tables are
+// clipped to the portions not already covered.
+func TestMergeEntries(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	entry := func(start, end, conf string) roachpb.SpanConfigEntry {
+		return roachpb.SpanConfigEntry{
+			Span:   roachpb.Span{Key: roachpb.Key(start), EndKey: roachpb.Key(end)},
+			Config: roachpb.SpanConfig{RangeMinBytes: int64(conf[0])},
+		}
+	}
+	for _, tc := range []struct {
+		name    string
+		entries []roachpb.SpanConfigEntry
+		lower   []roachpb.SpanConfigEntry
+		exp     []roachpb.SpanConfigEntry
+	}{
+		{
+			name:    "only-entries",
+			entries: []roachpb.SpanConfigEntry{entry("a", "c", "A")},
+			exp:     []roachpb.SpanConfigEntry{entry("a", "c", "A")},
+		},
+		{
+			name:  "only-lower",
+			lower: []roachpb.SpanConfigEntry{entry("a", "c", "X")},
+			exp:   []roachpb.SpanConfigEntry{entry("a", "c", "X")},
+		},
+		{
+			name:    "disjoint",
+			entries: []roachpb.SpanConfigEntry{entry("c", "d", "A")},
+			lower:   []roachpb.SpanConfigEntry{entry("a", "b", "X"), entry("e", "f", "Y")},
+			exp: []roachpb.SpanConfigEntry{
+				entry("a", "b", "X"), entry("c", "d", "A"), entry("e", "f", "Y"),
+			},
+		},
+		{
+			name:    "shadowed",
+			entries: []roachpb.SpanConfigEntry{entry("a", "d", "A")},
+			lower:   []roachpb.SpanConfigEntry{entry("a", "d", "X"), entry("b", "c", "Y")},
+			exp:     []roachpb.SpanConfigEntry{entry("a", "d", "A")},
+		},
+		{
+			name:    "clipped",
+			entries: []roachpb.SpanConfigEntry{entry("c", "d", "A"), entry("e", "f", "B")},
+			lower:   []roachpb.SpanConfigEntry{entry("b", "g", "X")},
+			exp: []roachpb.SpanConfigEntry{
+				entry("b", "c", "X"), entry("c", "d", "A"), entry("d", "e", "X"),
+				entry("e", "f", "B"), entry("f", "g", "X"),
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.exp, mergeEntries(tc.entries, tc.lower))
+		})
+	}
+
+	// Merging repeatedly gives precedence to the earlier tables.
+	merged := mergeEntries([]roachpb.SpanConfigEntry{entry("b", "c", "A")},
+		[]roachpb.SpanConfigEntry{entry("a", "c", "X")})
+	merged = mergeEntries(merged, []roachpb.SpanConfigEntry{entry("a", "d", "Y")})
+	require.Equal(t, []roachpb.SpanConfigEntry{
+		entry("a", "b", "X"), entry("b", "c", "A"), entry("c", "d", "Y"),
+	}, merged)
+}
+
+func TestNewUpdateSummary(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	sp := func(start, end string) roachpb.Span {
+		return roachpb.Span{Key: roachpb.Key(start), EndKey: roachpb.Key(end)}
+	}
+	entry := func(start, end, conf string) roachpb.SpanConfigEntry {
+		return roachpb.SpanConfigEntry{
+			Span:   sp(start, end),
+			Config: roachpb.SpanConfig{RangeMinBytes: int64(conf[0])},
+		}
+	}
+	before := []roachpb.SpanConfigEntry{entry("a", "b", "A"), entry("b", "c", "B"), entry("c", "d", "C")}
+	after := []roachpb.SpanConfigEntry{entry("a", "b", "A"), entry("c", "d", "D"), entry("e", "f", "E")}
+	toUpsert := []roachpb.SpanConfigEntry{entry("e", "f", "E"), entry("c", "d", "D")}
+	summary := newUpdateSummary(
+		// Deleted spans without a matching entry are left out.
+		[]roachpb.Span{sp("b", "c"), sp("x", "y")}, toUpsert, before, after,
+	)
+	require.Equal(t, &UpdateSummary{
+		Deleted:  []roachpb.SpanConfigEntry{entry("b", "c", "B")},
+		Upserted: []roachpb.SpanConfigEntry{entry("c", "d", "D"), entry("e", "f", "E")},
+		Before:   before,
+		After:    after,
+	}, summary)
+	// The entries to upsert aren't reordered in place.
+	require.Equal(t, entry("e", "f", "E"), toUpsert[0])
+}
+
+func TestDiffEntries(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	sp := func(start, end string) roachpb.Span {
+		return roachpb.Span{Key: roachpb.Key(start), EndKey: roachpb.Key(end)}
+	}
+	entry := func(start, end, conf string) roachpb.SpanConfigEntry {
+		return roachpb.SpanConfigEntry{
+			Span:   sp(start, end),
+			Config: roachpb.SpanConfig{RangeMinBytes: int64(conf[0])},
+		}
+	}
+	existing := []roachpb.SpanConfigEntry{
+		entry("a", "b", "A"), entry("b", "c", "B"), entry("c", "e", "C"),
+	}
+	desired := []roachpb.SpanConfigEntry{
+		entry("a", "b", "A"), entry("b", "c", "X"), entry("c", "d", "C"),
+	}
+	toDelete, toUpsert := diffEntries(existing, desired)
+	// Entries with a desired span but a different config are only upserted.
+	require.Equal(t, []roachpb.Span{sp("c", "e")}, toDelete)
+	require.Equal(t, []roachpb.SpanConfigEntry{entry("b", "c", "X"), entry("c", "d", "C")}, toUpsert)
+
+	toDelete, toUpsert = diffEntries(desired, desired)
+	require.Empty(t, toDelete)
+	require.Empty(t, toUpsert)
+}
+
+func TestCheckConsistency(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	sp := func(start, end string) roachpb.Span {
+		return roachpb.Span{Key: roachpb.Key(start), EndKey: roachpb.Key(end)}
+	}
+	conf := func(c string) roachpb.SpanConfig {
+		return roachpb.SpanConfig{RangeMinBytes: int64(c[0])}
+	}
+	entry := func(start, end, c string) roachpb.SpanConfigEntry {
+		return roachpb.SpanConfigEntry{Span: sp(start, end), Config: conf(c)}
+	}
+	existing := []roachpb.SpanConfigEntry{
+		entry("a", "b", "A"), entry("b", "c", "B"), entry("c", "e", "C"), entry("f", "g", "F"),
+	}
+	// The desired entries needn't be sorted.
+	desired := []roachpb.SpanConfigEntry{
+		entry("f", "g", "F"), entry("c", "d", "C"), entry("a", "b", "A"), entry("b", "c", "X"),
+		entry("d", "e", "C"),
+	}
+	require.Equal(t, &ConsistencyReport{
+		Missing: []roachpb.SpanConfigEntry{entry("c", "d", "C"), entry("d", "e", "C")},
+		Extra:   []roachpb.SpanConfigEntry{entry("c", "e", "C")},
+		Mismatched: []ConfigMismatch{
+			{Span: sp("b", "c"), Stored: conf("B"), Desired: conf("X")},
+		},
+	}, checkConsistency(existing, desired))
+	require.False(t, checkConsistency(existing, desired).Consistent())
+	require.True(t, checkConsistency(existing, existing).Consistent())
+	require.True(t, checkConsistency(nil, nil).Consistent())
+}
+
+func TestMaterializeOverrides(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	sp := func(start, end string) roachpb.Span {
+		return roachpb.Span{Key: roachpb.Key(start), EndKey: roachpb.Key(end)}
+	}
+	base := roachpb.SpanConfig{
+		NumReplicas:   3,
+		RangeMaxBytes: 512 << 20,
+		Constraints: []roachpb.ConstraintsConjunction{{
+			NumReplicas: 1,
+			Constraints: []roachpb.Constraint{{Key: "region", Value: "us-east1"}},
+		}},
+	}
+	orig := *protoutil.Clone(&base).(*roachpb.SpanConfig)
+	entries := materializeOverrides(base, []SpanOverride{
+		{Span: sp("a", "b")},
+		{Span: sp("b", "c"), Override: func(conf *roachpb.SpanConfig) {
+			conf.NumReplicas = 5
+		}},
+		{Span: sp("c", "d"), Override: func(conf *roachpb.SpanConfig) {
+			conf.Constraints[0].Constraints[0].Value = "us-west1"
+		}},
+	})
+
+	withReplicas := orig
+	withReplicas.NumReplicas = 5
+	withRegion := *protoutil.Clone(&orig).(*roachpb.SpanConfig)
+	withRegion.Constraints[0].Constraints[0].Value = "us-west1"
+	require.Equal(t, []roachpb.SpanConfigEntry{
+		{Span: sp("a", "b"), Config: orig},
+		{Span: sp("b", "c"), Config: withReplicas},
+		{Span: sp("c", "d"), Config: withRegion},
+	}, entries)
+	// Overrides modifying the repeated fields of their copies don't modify
+	// the base config.
+	require.Equal(t, orig, base)
+}
+
+func TestRelocateEntry(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	sp := func(start, end string) roachpb.Span {
+		return roachpb.Span{Key: roachpb.Key(start), EndKey: roachpb.Key(end)}
+	}
+	entry := func(start, end, conf string) roachpb.SpanConfigEntry {
+		return roachpb.SpanConfigEntry{
+			Span:   sp(start, end),
+			Config: roachpb.SpanConfig{RangeMinBytes: int64(conf[0])},
+		}
+	}
+	existing := []roachpb.SpanConfigEntry{
+		entry("a", "c", "A"), entry("c", "e", "C"), entry("g", "h", "G"),
+	}
+	for _, tc := range []struct {
+		from, to roachpb.Span
+		exp      roachpb.SpanConfigEntry
+		expErr   string
+	}{
+		{from: sp("c", "e"), to: sp("e", "g"), exp: entry("e", "g", "C")},
+		// The entry may be moved to a span overlapping with its own.
+		{from: sp("c", "e"), to: sp("d", "f"), exp: entry("d", "f", "C")},
+		{from: sp("c", "e"), to: sp("c", "e"), exp: entry("c", "e", "C")},
+		{from: sp("c", "d"), to: sp("e", "g"), expErr: `no entry exists at {?c-d}?`},
+		{from: sp("c", "e"), to: sp("b", "d"), expErr: `overlaps with entry {?a-c}?`},
+		{from: sp("c", "e"), to: sp("f", "z"), expErr: `overlaps with entry {?g-h}?`},
+	} {
+		relocated, err := relocateEntry(existing, tc.from, tc.to)
+		if tc.expErr != "" {
+			require.Regexp(t, tc.expErr, err)
+			continue
+		}
+		require.NoError(t, err)
+		require.Equal(t, tc.exp, relocated)
+	}
+}
+
+func TestMakeUpdateBatches(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	sp := func(start, end string) roachpb.Span {
+		return roachpb.Span{Key: roachpb.Key(start), EndKey: roachpb.Key(end)}
+	}
+	entry := func(start, end string) roachpb.SpanConfigEntry {
+		return roachpb.SpanConfigEntry{Span: sp(start, end)}
+	}
+	// format prints the deleted spans, followed by the upserted ones, of each
+	// batch.
+	format := func(batches []updateBatch) []string {
+		var ret []string
+		for _, b := range batches {
+			var parts []string
+			for _, d := range b.toDelete {
+				parts = append(parts, "-"+string(d.Key)+string(d.EndKey))
+			}
+			for _, u := range b.toUpsert {
+				parts = append(parts, "+"+string(u.Span.Key)+string(u.Span.EndKey))
+			}
+			ret = append(ret, strings.Join(parts, " "))
+		}
+		return ret
+	}
+	for _, tc := range []struct {
+		toDelete  []roachpb.Span
+		toUpsert  []roachpb.SpanConfigEntry
+		batchSize int
+		exp       []string
+	}{
+		{
+			toUpsert:  []roachpb.SpanConfigEntry{entry("c", "d"), entry("a", "b"), entry("b", "c")},
+			batchSize: 2,
+			exp:       []string{"+ab +bc", "+cd"},
+		},
+		{
+			toUpsert:  []roachpb.SpanConfigEntry{entry("c", "d"), entry("a", "b"), entry("b", "c")},
+			batchSize: 5,
+			exp:       []string{"+ab +bc +cd"},
+		},
+		// Deletions are kept with the upserts overlapping with them, even if
+		// the batch exceeds the batch size.
+		{
+			toDelete:  []roachpb.Span{sp("a", "d"), sp("e", "f")},
+			toUpsert:  []roachpb.SpanConfigEntry{entry("a", "b"), entry("b", "c"), entry("c", "e")},
+			batchSize: 1,
+			exp:       []string{"-ad +ab +bc +ce", "-ef"},
+		},
+		{
+			toDelete:  []roachpb.Span{sp("a", "c"), sp("c", "e")},
+			toUpsert:  []roachpb.SpanConfigEntry{entry("b", "d")},
+			batchSize: 1,
+			exp:       []string{"-ac -ce +bd"},
+		},
+		{
+			toDelete:  []roachpb.Span{sp("a", "b"), sp("c", "d")},
+			toUpsert:  []roachpb.SpanConfigEntry{entry("a", "b"), entry("c", "d")},
+			batchSize: 1,
+			exp:       []string{"-ab +ab", "-cd +cd"},
+		},
+	} {
+		require.Equal(t, tc.exp, format(makeUpdateBatches(tc.toDelete, tc.toUpsert, tc.batchSize)))
+	}
+}
+
+func TestStraddlesBoundary(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	sp := func(start, end string) roachpb.Span {
+		return roachpb.Span{Key: roachpb.Key(start), EndKey: roachpb.Key(end)}
+	}
+	boundaries := []roachpb.Key{roachpb.Key("c"), roachpb.Key("f"), roachpb.Key("m")}
+	for _, tc := range []struct {
+		span roachpb.Span
+		exp  bool
+	}{
+		{span: sp("a", "b"), exp: false},
+		{span: sp("a", "c"), exp: false},
+		{span: sp("a", "d"), exp: true},
+		{span: sp("c", "f"), exp: false},
+		{span: sp("c", "g"), exp: true},
+		{span: sp("d", "e"), exp: false},
+		{span: sp("b", "z"), exp: true},
+		{span: sp("m", "z"), exp: false},
+		{span: sp("l", "ma"), exp: true},
+	} {
+		require.Equal(t, tc.exp, straddlesBoundary(tc.span, boundaries), "span %s", tc.span)
+	}
+	require.False(t, straddlesBoundary(sp("a", "z"), nil))
+}
+
+func TestFindTimestamp(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	entry := func(start, end string) roachpb.SpanConfigEntry {
+		return roachpb.SpanConfigEntry{
+			Span: roachpb.Span{Key: roachpb.Key(start), EndKey: roachpb.Key(end)},
+		}
+	}
+	ts := func(wallTime int64) hlc.Timestamp {
+		return hlc.Timestamp{WallTime: wallTime}
+	}
+	tables := [][]TimestampedEntry{
+		{{Entry: entry("b", "d"), Timestamp: ts(1)}, {Entry: entry("f", "g"), Timestamp: ts(2)}},
+		{{Entry: entry("a", "c"), Timestamp: ts(3)}, {Entry: entry("e", "h"), Timestamp: ts(4)}},
+	}
+	for _, tc := range []struct {
+		entry roachpb.SpanConfigEntry
+		exp   hlc.Timestamp
+	}{
+		{entry: entry("b", "d"), exp: ts(1)},
+		{entry: entry("f", "g"), exp: ts(2)},
+		// Portions of entries of tables with lower precedence carry the
+		// timestamps of those entries.
+		{entry: entry("a", "b"), exp: ts(3)},
+		{entry: entry("e", "f"), exp: ts(4)},
+		{entry: entry("g", "h"), exp: ts(4)},
+		{entry: entry("x", "y"), exp: hlc.Timestamp{}},
+	} {
+		require.Equal(t, tc.exp, findTimestamp(tc.entry, tables), "%s", tc.entry.Span)
+	}
+}
+
+func TestCompactEntries(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	entry := func(start, end, conf string) roachpb.SpanConfigEntry {
+		return roachpb.SpanConfigEntry{
+			Span:   roachpb.Span{Key: roachpb.Key(start), EndKey: roachpb.Key(end)},
+			Config: roachpb.SpanConfig{RangeMinBytes: int64(conf[0])},
+		}
+	}
+	span := func(start, end string) roachpb.Span {
+		return roachpb.Span{Key: roachpb.Key(start), EndKey: roachpb.Key(end)}
+	}
+
+	toDelete, toUpsert := compactEntries([]roachpb.SpanConfigEntry{
+		entry("a", "b", "A"), entry("b", "c", "A"), entry("c", "d", "A"), // run
+		entry("d", "e", "B"),                       // differing config
+		entry("f", "g", "B"),                       // gap
+		entry("g", "h", "C"), entry("h", "i", "C"), // run
+		entry("i", "j", "A"),
+	})
+	require.Equal(t, []roachpb.Span{
+		span("a", "b"), span("b", "c"), span("c", "d"), span("g", "h"), span("h", "i"),
+	}, toDelete)
+	require.Equal(t, []roachpb.SpanConfigEntry{entry("a", "d", "A"), entry("g", "i", "C")}, toUpsert)
+
+	toDelete, toUpsert = compactEntries([]roachpb.SpanConfigEntry{
+		entry("a", "b", "A"), entry("b", "c", "B"), entry("d", "e", "B"),
+	})
+	require.Empty(t, toDelete)
+	require.Empty(t, toUpsert)
+
+	// Randomly generate sorted, non-overlapping entries with few distinct
+	// configs and ensure that compacting them doesn't change the effective
+	// config of any key.
+	rng, _ := randutil.NewTestRand()
+	configAt := func(entries []roachpb.SpanConfigEntry, key roachpb.Key) (roachpb.SpanConfig, bool) {
+		for _, entry := range entries {
+			if entry.Span.ContainsKey(key) {
+				return entry.Config, true
+			}
+		}
+		return roachpb.SpanConfig{}, false
+	}
+	for i := 0; i < 100; i++ {
+		var entries []roachpb.SpanConfigEntry
+		for c := byte('a'); c < 'z'; c++ {
+			if rng.Intn(4) == 0 {
+				continue // leave a gap
+			}
+			entries = append(entries, roachpb.SpanConfigEntry{
+				Span:   roachpb.Span{Key: roachpb.Key([]byte{c}), EndKey: roachpb.Key([]byte{c + 1})},
+				Config: roachpb.SpanConfig{RangeMinBytes: int64(rng.Intn(2))},
+			})
+		}
+		toDelete, toUpsert := compactEntries(entries)
+
+		var compacted []roachpb.SpanConfigEntry
+		for _, entry := range entries {
+			var deleted bool
+			for _, sp := range toDelete {
+				deleted = deleted || sp.Equal(entry.Span)
+			}
+			if !deleted {
+				compacted = append(compacted, entry)
+			}
+		}
+		compacted = sortAndDedupEntries(append(compacted, toUpsert...))
+		require.NoError(t, validateSortedEntries(compacted))
+		require.Len(t, compacted, len(entries)-len(toDelete)+len(toUpsert))
+		for c := byte('a'); c <= 'z'; c++ {
+			key := roachpb.Key([]byte{c})
+			expConf, expOK := configAt(entries, key)
+			conf, ok := configAt(compacted, key)
+			require.Equal(t, expOK, ok, "key %s", key)
+			require.Equal(t, expConf, conf, "key %s", key)
+		}
+		// Compacting again is a no-op.
+		toDelete, _ = compactEntries(compacted)
+		require.Empty(t, toDelete)
+	}
+}
+
+// TestErrorsPrettyPrintSpans ensures that the spans in the errors returned
+// when validating spans and entries are rendered using the pretty-printed
+// form of their keys, rather than as raw bytes.
+func TestErrorsPrettyPrintSpans(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	span := func(start, end uint32) roachpb.Span {
+		return roachpb.Span{
+			Key:    keys.SystemSQLCodec.TablePrefix(start),
+			EndKey: keys.SystemSQLCodec.TablePrefix(end),
+		}
+	}
+	entries := []roachpb.SpanConfigEntry{{Span: span(53, 55)}, {Span: span(54, 56)}}
+	for _, tc := range []struct {
+		name string
+		err  error
+		exp  string
+	}{
+		{
+			name: "update args",
+			err:  validateUpdateArgs([]roachpb.Span{span(53, 55), span(54, 56)}, nil /* toUpsert */),
+			exp:  "overlapping spans /Table/5{3-5} and /Table/5{4-6} in same list",
+		},
+		{
+			name: "sorted entries",
+			err:  validateSortedEntries(entries),
+			exp:  "overlapping spans /Table/5{3-5} and /Table/5{4-6}",
+		},
+		{
+			name: "coverage",
+			err:  checkCoverage(span(53, 56), entries),
+			exp:  "span config coverage of /Table/5{3-6}: overlapping entries /Table/5{3-5} and /Table/5{4-6}",
+		},
+		{
+			name: "invalid span",
+			err:  validateSpans([]roachpb.Span{span(55, 53)}),
+			exp:  "invalid span: /Table/5{5-3}",
+		},
+		{
+			name: "duplicate",
+			err:  &DuplicateSpanError{StartKey: keys.SystemSQLCodec.TablePrefix(53)},
+			exp:  "duplicate entries with start key /Table/53",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Error(t, tc.err)
+			require.Contains(t, tc.err.Error(), tc.exp)
+		})
+	}
+}
+
+func TestCheckCoverage(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	span := func(start, end string) roachpb.Span {
+		return roachpb.Span{Key: roachpb.Key(start), EndKey: roachpb.Key(end)}
+	}
+	entries := func(spans ...roachpb.Span) []roachpb.SpanConfigEntry {
+		ret := make([]roachpb.SpanConfigEntry, len(spans))
+		for i, sp := range spans {
+			ret[i].Span = sp
+		}
+		return ret
+	}
+	for _, tc := range []struct {
+		name    string
+		entries []roachpb.SpanConfigEntry
+		exp     string
+	}{
+		{
+			name:    "covered",
+			entries: entries(span("d", "f"), span("b", "d")),
+		},
+		{
+			name:    "covered by larger entries",
+			entries: entries(span("a", "c"), span("c", "z")),
+		},
+		{
+			name: "no entries",
+			exp:  `gap \{b-f\}`,
+		},
+		{
+			name:    "gap at start",
+			entries: entries(span("c", "f")),
+			exp:     `gap \{b-c\}`,
+		},
+		{
+			name:    "gap in middle",
+			entries: entries(span("b", "c"), span("d", "e"), span("e", "f")),
+			exp:     `gap \{c-d\}`,
+		},
+		{
+			name:    "gap at end",
+			entries: entries(span("a", "e")),
+			exp:     `gap \{e-f\}`,
+		},
+		{
+			name:    "overlap",
+			entries: entries(span("b", "d"), span("c", "f"), span("g", "h")),
+			exp:     `overlapping entries \{b-d\} and \{c-f\}`,
+		},
+		{
+			name:    "overlap outside span",
+			entries: entries(span("a", "c"), span("b", "f")),
+			exp:     `overlapping entries \{a-c\} and \{b-f\}`,
+		},
+		{
+			name:    "overlap before gap",
+			entries: entries(span("b", "d"), span("c", "d")),
+			exp:     `overlapping entries \{b-d\} and \{c-d\}`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkCoverage(span("b", "f"), tc.entries)
+			if tc.exp == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.Regexp(t, `span config coverage of \{b-f\}: `+tc.exp, err)
+		})
+	}
+}
+
+func TestFlattenEntries(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	entry := func(start, end, conf string) roachpb.SpanConfigEntry {
+		return roachpb.SpanConfigEntry{
+			Span:   roachpb.Span{Key: roachpb.Key(start), EndKey: roachpb.Key(end)},
+			Config: roachpb.SpanConfig{RangeMinBytes: int64(conf[0])},
+		}
+	}
+	span := func(start, end string) roachpb.Span {
+		return roachpb.Span{Key: roachpb.Key(start), EndKey: roachpb.Key(end)}
+	}
+	for _, tc := range []struct {
+		name    string
+		span    roachpb.Span
+		entries []roachpb.SpanConfigEntry
+		exp     []roachpb.SpanConfigEntry
+	}{
+		{
+			name:    "empty",
+			span:    span("a", "z"),
+			entries: nil,
+			exp:     nil,
+		},
+		{
+			name:    "non-overlapping",
+			span:    span("c", "k"),
+			entries: []roachpb.SpanConfigEntry{entry("f", "h", "B"), entry("a", "d", "A"), entry("d", "f", "C")},
+			exp:     []roachpb.SpanConfigEntry{entry("c", "d", "A"), entry("d", "f", "C"), entry("f", "h", "B")},
+		},
+		{
+			name:    "nested",
+			span:    span("a", "z"),
+			entries: []roachpb.SpanConfigEntry{entry("b", "h", "A"), entry("d", "f", "B")},
+			exp:     []roachpb.SpanConfigEntry{entry("b", "d", "A"), entry("d", "f", "B"), entry("f", "h", "A")},
+		},
+		{
+			name:    "partially overlapping",
+			span:    span("a", "z"),
+			entries: []roachpb.SpanConfigEntry{entry("e", "k", "B"), entry("b", "g", "A"), entry("i", "m", "C")},
+			exp:     []roachpb.SpanConfigEntry{entry("b", "e", "A"), entry("e", "i", "B"), entry("i", "m", "C")},
+		},
+		{
+			name:    "clipped",
+			span:    span("c", "d"),
+			entries: []roachpb.SpanConfigEntry{entry("a", "z", "A"), entry("b", "c", "B")},
+			exp:     []roachpb.SpanConfigEntry{entry("c", "d", "A")},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.exp, flattenEntries(tc.span, tc.entries))
+		})
+	}
+}
+
+func TestSortAndValidateEntries(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	entry := func(start, end string) roachpb.SpanConfigEntry {
+		return roachpb.SpanConfigEntry{
+			Span: roachpb.Span{Key: roachpb.Key(start), EndKey: roachpb.Key(end)},
+		}
+	}
+	entries := sortAndDedupEntries([]roachpb.SpanConfigEntry{
+		entry("f", "h"), entry("a", "b"), entry("c", "f"), entry("a", "b"), entry("f", "h"),
+	})
+	require.Equal(t, []roachpb.SpanConfigEntry{
+		entry("a", "b"), entry("c", "f"), entry("f", "h"),
+	}, entries)
+	require.NoError(t, validateSortedEntries(entries))
+
+	entries = sortAndDedupEntries(append(entries, entry("e", "g")))
+	require.Equal(t, []roachpb.SpanConfigEntry{
+		entry("a", "b"), entry("c", "f"), entry("e", "g"), entry("f", "h"),
+	}, entries)
+	require.True(t, testutils.IsError(validateSortedEntries(entries),
+		"span config table inconsistent: overlapping spans"))
+}
+
+func TestCheckTableColumns(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	for _, tc := range []struct {
+		name    string
+		columns map[string]string
+		exp     string
+	}{
+		{
+			name: "valid",
+			columns: map[string]string{
+				"start_key": "BYTES", "end_key": "BYTES", "config": "BYTES",
+			},
+		},
+		{
+			name: "additional columns",
+			columns: map[string]string{
+				"start_key": "BYTES", "end_key": "BYTES", "config": "BYTES", "extra": "INT8",
+			},
+		},
+		{
+			name:    "missing column",
+			columns: map[string]string{"start_key": "BYTES", "config": "BYTES"},
+			exp:     "missing column end_key",
+		},
+		{
+			name: "wrong type",
+			columns: map[string]string{
+				"start_key": "BYTES", "end_key": "BYTES", "config": "STRING",
+			},
+			exp: "column config is of type STRING, not BYTES",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkTableColumns(tc.columns)
+			if tc.exp == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.EqualError(t, err, tc.exp)
+		})
+	}
+}
+
+// TestPinnedTable ensures that operations pin the table they run against, so
+// that changing the table only affects the operations started afterwards.
+func TestPinnedTable(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	k := New(nil /* db */, nil /* ie */, cluster.MakeTestingClusterSettings(), "db.public.old")
+	pinned := k.pinned()
+	require.Same(t, pinned, pinned.pinned())
+
+	// Swap the table as SwapTable does once the new table is validated.
+	k.target.Lock()
+	k.target.tableName = "db.public.new"
+	k.target.Unlock()
+
+	stmt, _ := k.DebugGetStatement([]roachpb.Span{{Key: roachpb.Key("a"), EndKey: roachpb.Key("b")}})
+	require.Contains(t, stmt, "db.public.new")
+	stmt, _ = pinned.DebugGetStatement([]roachpb.Span{{Key: roachpb.Key("a"), EndKey: roachpb.Key("b")}})
+	require.Contains(t, stmt, "db.public.old")
+
+	// Copies made using WithUserPriority share the table.
+	withPriority := k.WithUserPriority(roachpb.MinUserPriority)
+	k.target.Lock()
+	k.target.tableName = "db.public.newer"
+	k.target.Unlock()
+	stmt, _ = withPriority.DebugGetStatement([]roachpb.Span{{Key: roachpb.Key("a"), EndKey: roachpb.Key("b")}})
+	require.Contains(t, stmt, "db.public.newer")
+}
+
+func TestDistinctConfigs(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	entry := func(start, end string, conf roachpb.SpanConfig) roachpb.SpanConfigEntry {
+		return roachpb.SpanConfigEntry{
+			Span:   roachpb.Span{Key: roachpb.Key(start), EndKey: roachpb.Key(end)},
+			Config: conf,
+		}
+	}
+	confA := roachpb.SpanConfig{RangeMinBytes: 1, NumReplicas: 3}
+	confB := roachpb.SpanConfig{RangeMinBytes: 2, NumReplicas: 3}
+	confC := roachpb.SpanConfig{
+		RangeMinBytes: 1,
+		Constraints: []roachpb.ConstraintsConjunction{{
+			Constraints: []roachpb.Constraint{{Key: "region", Value: "us"}},
+		}},
+	}
+
+	for _, tc := range []struct {
+		name    string
+		entries []roachpb.SpanConfigEntry
+		exp     []roachpb.SpanConfig
+	}{
+		{name: "no entries"},
+		{
+			name: "identical configs",
+			entries: []roachpb.SpanConfigEntry{
+				entry("a", "b", confA), entry("c", "d", confA), entry("d", "e", confA),
+			},
+			exp: []roachpb.SpanConfig{confA},
+		},
+		{
+			// Configs with slices are compared by value.
+			name: "identical configs with slices",
+			entries: []roachpb.SpanConfigEntry{
+				entry("a", "b", confC), entry("b", "c", confC),
+			},
+			exp: []roachpb.SpanConfig{confC},
+		},
+		{
+			name: "distinct configs",
+			entries: []roachpb.SpanConfigEntry{
+				entry("a", "b", confB), entry("b", "c", confA), entry("c", "d", confC),
+				entry("d", "e", confB), entry("e", "f", confA),
+			},
+			exp: []roachpb.SpanConfig{confA, confC, confB},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			distinct, err := distinctConfigs(tc.entries)
+			require.NoError(t, err)
+			require.Equal(t, tc.exp, distinct)
+
+			// The order doesn't depend on the order of the entries.
+			reversed := make([]roachpb.SpanConfigEntry, len(tc.entries))
+			for i := range tc.entries {
+				reversed[len(reversed)-1-i] = tc.entries[i]
+			}
+			distinct, err = distinctConfigs(reversed)
+			require.NoError(t, err)
+			require.Equal(t, tc.exp, distinct)
+		})
+	}
+}
+
+// pagingExecutor is an internal executor which serves the statements
+// constructed by Scan from an in-memory table, which the test may modify in
+// between batches.
+type pagingExecutor struct {
+	sqlutil.InternalExecutor
+	entries []roachpb.SpanConfigEntry
+}
+
+func (p *pagingExecutor) QueryBufferedEx(
+	_ context.Context, _ string, _ *kv.Txn, _ sessiondata.InternalExecutorOverride, _ string, args ...interface{},
+) ([]tree.Datums, error) {
+	var after roachpb.Key
+	var started bool
+	if len(args) == 2 {
+		after, started = args[0].(roachpb.Key), true
+	}
+	limit := args[len(args)-1].(int)
+	sorted := sortAndDedupEntries(append([]roachpb.SpanConfigEntry(nil), p.entries...))
+	var rows []tree.Datums
+	for _, e := range sorted {
+		if len(rows) == limit {
+			break
+		}
+		if started && e.Span.Key.Compare(after) <= 0 {
+			continue
+		}
+		conf, err := protoutil.Marshal(&e.Config)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, tree.Datums{
+			tree.NewDBytes(tree.DBytes(e.Span.Key)),
+			tree.NewDBytes(tree.DBytes(e.Span.EndKey)),
+			tree.NewDBytes(tree.DBytes(conf)),
+		})
+	}
+	return rows, nil
+}
+
+func TestScan(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+	enabledSetting.Override(ctx, &st.SV, true)
+	entry := func(start, end string) roachpb.SpanConfigEntry {
+		return roachpb.SpanConfigEntry{
+			Span:   roachpb.Span{Key: roachpb.Key(start), EndKey: roachpb.Key(end)},
+			Config: roachpb.SpanConfig{RangeMinBytes: int64(start[0])},
+		}
+	}
+	newAccessor := func() (*pagingExecutor, *KVAccessor) {
+		ie := &pagingExecutor{entries: []roachpb.SpanConfigEntry{
+			entry("a", "b"), entry("b", "c"), entry("d", "e"), entry("e", "f"), entry("x", "y"),
+		}}
+		return ie, New(nil /* db */, ie, st, "system.span_configurations")
+	}
+	starts := func(entries []roachpb.SpanConfigEntry) (ret []string) {
+		for _, e := range entries {
+			ret = append(ret, string(e.Span.Key))
+		}
+		return ret
+	}
+	// walk scans from the cursor to the end of the table, calling between
+	// before each batch after the first, and returns the batches.
+	walk := func(
+		t *testing.T, k *KVAccessor, cursor Cursor, batchSize int, between func(),
+	) (batches [][]string) {
+		for i := 0; !cursor.Done(); i++ {
+			if i > 0 && between != nil {
+				between()
+			}
+			var entries []roachpb.SpanConfigEntry
+			var err error
+			entries, cursor, err = k.Scan(ctx, cursor, batchSize)
+			require.NoError(t, err)
+			batches = append(batches, starts(entries))
+		}
+		return batches
+	}
+
+	t.Run("batches", func(t *testing.T) {
+		_, k := newAccessor()
+		require.Equal(t, [][]string{{"a", "b"}, {"d", "e"}, {"x"}}, walk(t, k, Cursor{}, 2, nil))
+		// A final empty batch is needed to find that the table is exhausted
+		// when the last batch is full.
+		require.Equal(t, [][]string{{"a", "b", "d", "e", "x"}, nil}, walk(t, k, Cursor{}, 5, nil))
+		require.Equal(t, [][]string{{"a", "b", "d", "e", "x"}}, walk(t, k, Cursor{}, 10, nil))
+	})
+
+	t.Run("resume", func(t *testing.T) {
+		_, k := newAccessor()
+		entries, cursor, err := k.Scan(ctx, Cursor{}, 3)
+		require.NoError(t, err)
+		require.Equal(t, []string{"a", "b", "d"}, starts(entries))
+		key, started := cursor.LastStartKey()
+		require.True(t, started)
+		require.Equal(t, roachpb.Key("d"), key)
+		require.Equal(t, [][]string{{"e", "x"}}, walk(t, k, cursor, 3, nil))
+		// Cursors may be positioned at keys without entries.
+		require.Equal(t, [][]string{{"d", "e"}, {"x"}}, walk(t, k, CursorAfter(roachpb.Key("c")), 2, nil))
+		require.Equal(t, [][]string{nil}, walk(t, k, CursorAfter(roachpb.Key("y")), 2, nil))
+
+		// Scanning from a done cursor returns nothing.
+		done := Cursor{done: true}
+		entries, next, err := k.Scan(ctx, done, 2)
+		require.NoError(t, err)
+		require.Empty(t, entries)
+		require.True(t, next.Done())
+	})
+
+	t.Run("concurrent modification", func(t *testing.T) {
+		ie, k := newAccessor()
+		var i int
+		batches := walk(t, k, Cursor{}, 2, func() {
+			i++
+			switch i {
+			case 1:
+				// Remove an entry already returned, and one yet to be returned,
+				// and add entries before and after the cursor.
+				ie.entries = []roachpb.SpanConfigEntry{
+					entry("a", "b"), entry("aa", "ab"), entry("d", "e"), entry("e", "f"),
+					entry("f", "g"), entry("x", "y"),
+				}
+			case 2:
+				// Split an entry yet to be returned.
+				ie.entries = append(ie.entries[:len(ie.entries)-1], entry("x", "xa"), entry("xa", "y"))
+			}
+		})
+		// The entries present throughout, [a,b), [d,e), [e,f), are returned
+		// once.
+		require.Equal(t, [][]string{{"a", "b"}, {"d", "e"}, {"f", "x"}, {"xa"}}, batches)
+	})
+
+	t.Run("invalid batch size", func(t *testing.T) {
+		_, k := newAccessor()
+		_, _, err := k.Scan(ctx, Cursor{}, 0)
+		require.Regexp(t, "invalid batch size 0", err)
+	})
+}
@@ -0,0 +1,139 @@
+// Copyright 2021 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package spanconfigkvaccessor_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/base"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/spanconfig/spanconfigkvaccessor"
+	"github.com/cockroachdb/cockroach/pkg/spanconfig/spanconfigtestutils"
+	"github.com/cockroachdb/cockroach/pkg/sql/sqlutil"
+	"github.com/cockroachdb/cockroach/pkg/testutils/sqlutils"
+	"github.com/cockroachdb/cockroach/pkg/testutils/testcluster"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/stretchr/testify/require"
+)
+
+// TestMultiTable ensures that a KVAccessor constructed using NewMulti merges
+// the entries read from each of its tables, and only writes to the primary.
+func TestMultiTable(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	tc := testcluster.StartTestCluster(t, 1, base.TestClusterArgs{
+		ServerArgs: base.TestServerArgs{
+			EnableSpanConfigs: true,
+		},
+	})
+	defer tc.Stopper().Stop(ctx)
+
+	const (
+		newFQN   = "defaultdb.public.new_span_configurations"
+		oldFQN   = "defaultdb.public.old_span_configurations"
+		olderFQN = "defaultdb.public.older_span_configurations"
+	)
+	tdb := sqlutils.MakeSQLRunner(tc.ServerConn(0))
+	tdb.Exec(t, `SET CLUSTER SETTING spanconfig.experimental_kvaccessor.enabled = true`)
+	newAccessor := func(fqn string, additional ...string) *spanconfigkvaccessor.KVAccessor {
+		return spanconfigkvaccessor.NewMulti(
+			tc.Server(0).DB(),
+			tc.Server(0).InternalExecutor().(sqlutil.InternalExecutor),
+			tc.Server(0).ClusterSettings(),
+			fqn, additional...,
+		)
+	}
+	entry := spanconfigtestutils.Entry
+	for _, tbl := range []struct {
+		fqn     string
+		entries []roachpb.SpanConfigEntry
+	}{
+		{newFQN, []roachpb.SpanConfigEntry{
+			entry("a", "c").WithTag("P").Build(),
+			entry("e", "g").WithTag("Q").Build(),
+		}},
+		{oldFQN, []roachpb.SpanConfigEntry{
+			entry("a", "b").WithTag("X").Build(), // shadowed by [a,c)
+			entry("c", "d").WithTag("Y").Build(), // only in the old table
+			entry("f", "h").WithTag("Z").Build(), // partially shadowed by [e,g)
+			entry("x", "y").WithTag("W").Build(),
+		}},
+		{olderFQN, []roachpb.SpanConfigEntry{
+			entry("c", "d").WithTag("V").Build(), // shadowed by the old table
+			entry("h", "j").WithTag("U").Build(),
+		}},
+	} {
+		tdb.Exec(t, fmt.Sprintf("CREATE TABLE %s (LIKE system.span_configurations INCLUDING ALL)", tbl.fqn))
+		require.NoError(t, newAccessor(tbl.fqn).UpdateSpanConfigEntries(ctx, nil /* toDelete */, tbl.entries))
+	}
+
+	print := func(entries []roachpb.SpanConfigEntry) (ret []string) {
+		for _, e := range entries {
+			ret = append(ret, spanconfigtestutils.PrintSpanConfigEntry(e))
+		}
+		return ret
+	}
+	get := func(k *spanconfigkvaccessor.KVAccessor, spans ...roachpb.Span) []string {
+		entries, err := k.GetSpanConfigEntriesFor(ctx, spans)
+		require.NoError(t, err)
+		return print(entries)
+	}
+	span := func(start, end string) roachpb.Span {
+		return entry(start, end).Build().Span
+	}
+
+	multi := newAccessor(newFQN, oldFQN, olderFQN)
+	require.Equal(t, []string{
+		"[a,c):P", "[c,d):Y", "[e,g):Q", "[g,h):Z", "[h,j):U", "[x,y):W",
+	}, get(multi, span("a", "z")))
+	require.Equal(t, []string{
+		"[a,c):P", "[g,h):Z", "[h,j):U",
+	}, get(multi, span("a", "b"), span("g", "i")))
+	sorted, err := multi.GetSortedSpanConfigEntriesFor(ctx, []roachpb.Span{span("i", "z"), span("b", "d")})
+	require.NoError(t, err)
+	require.Equal(t, []string{"[a,c):P", "[c,d):Y", "[h,j):U", "[x,y):W"}, print(sorted))
+
+	// The configs of shadowed entries aren't distinct configs of the span.
+	configs, err := multi.DistinctConfigs(ctx, span("a", "z"))
+	require.NoError(t, err)
+	var expConfigs []roachpb.SpanConfig
+	for _, tag := range []string{"P", "Y", "Q", "Z", "U", "W"} {
+		expConfigs = append(expConfigs, entry("a", "b").WithTag(tag).Build().Config)
+	}
+	require.ElementsMatch(t, expConfigs, configs)
+
+	// The order of the additional tables determines their precedence.
+	require.Equal(t, []string{"[c,d):V"}, get(newAccessor(newFQN, olderFQN, oldFQN), span("c", "d")))
+	require.Equal(t, []string{"[a,b):X", "[b,c):P"}, get(newAccessor(oldFQN, newFQN), span("a", "c")))
+
+	// Writes only go to the primary table.
+	require.NoError(t, multi.UpdateSpanConfigEntries(ctx,
+		[]roachpb.Span{span("e", "g")},
+		[]roachpb.SpanConfigEntry{entry("d", "e").WithTag("N").Build()},
+	))
+	require.Equal(t, []string{"[a,c):P", "[d,e):N"}, get(newAccessor(newFQN), span("a", "z")))
+	require.Equal(t, []string{"[f,h):Z"}, get(newAccessor(oldFQN), span("e", "h")))
+	require.Equal(t, []string{
+		"[a,c):P", "[c,d):Y", "[d,e):N", "[f,h):Z", "[h,j):U", "[x,y):W",
+	}, get(multi, span("a", "z")))
+
+	// Other reads only use the primary table.
+	_, found, err := multi.GetSpanConfigEntryExact(ctx, span("c", "d"))
+	require.NoError(t, err)
+	require.False(t, found)
+	polled, _, err := multi.Poll(ctx, []roachpb.Span{span("a", "z")}, hlc.Timestamp{})
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"[a,c):P", "[d,e):N"}, print(polled))
+}
@@ -0,0 +1,72 @@
+// Copyright 2021 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package spanconfigkvaccessor_test
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/spanconfig/spanconfigtestutils"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/stretchr/testify/require"
+)
+
+// TestPoll ensures that polling for span configs only returns the entries
+// written since the given timestamp, and that the returned high-water mark
+// can be used to poll incrementally.
+func TestPoll(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	tc, accessor := startTestCluster(t)
+	defer tc.Stopper().Stop(ctx)
+
+	update := func(entries ...string) {
+		var toUpsert []roachpb.SpanConfigEntry
+		for _, entry := range entries {
+			toUpsert = append(toUpsert, spanconfigtestutils.ParseSpanConfigEntry(t, entry))
+		}
+		require.NoError(t, accessor.UpdateSpanConfigEntries(ctx, nil /* toDelete */, toUpsert))
+	}
+	poll := func(since hlc.Timestamp, exp ...string) hlc.Timestamp {
+		entries, highWater, err := accessor.Poll(ctx, []roachpb.Span{
+			spanconfigtestutils.ParseSpan(t, "[a,z)"),
+		}, since)
+		require.NoError(t, err)
+		require.True(t, since.Less(highWater))
+		var got []string
+		for _, entry := range entries {
+			got = append(got, spanconfigtestutils.PrintSpanConfigEntry(entry))
+		}
+		sort.Strings(got)
+		require.Equal(t, exp, got)
+		return highWater
+	}
+
+	update("[a,c):A", "[c,e):C")
+	ts := poll(hlc.Timestamp{}, "[a,c):A", "[c,e):C")
+	ts = poll(ts /* nothing written since */)
+
+	update("[c,e):X", "[e,g):E")
+	ts = poll(ts, "[c,e):X", "[e,g):E")
+	poll(ts /* nothing written since */)
+
+	// Spans outside of the polled spans are not returned.
+	entries, _, err := accessor.Poll(ctx, []roachpb.Span{
+		spanconfigtestutils.ParseSpan(t, "[a,b)"),
+	}, hlc.Timestamp{})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "[a,c):A", spanconfigtestutils.PrintSpanConfigEntry(entries[0]))
+}
@@ -0,0 +1,146 @@
+// Copyright 2021 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package spanconfigkvaccessor_test
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/spanconfig/spanconfigkvaccessor"
+	"github.com/cockroachdb/cockroach/pkg/spanconfig/spanconfigtestutils"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/randutil"
+	"github.com/stretchr/testify/require"
+)
+
+// TestReconcile ensures that Reconcile applies the changes needed for the
+// stored entries to match the desired ones, that reconciling again applies no
+// changes, and that arbitrary drift converges to the desired entries.
+func TestReconcile(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	tc, accessor := startTestCluster(t)
+	defer tc.Stopper().Stop(ctx)
+	var events []spanconfigkvaccessor.ChangeEvent
+	accessor.OnChange(func(ev spanconfigkvaccessor.ChangeEvent) {
+		events = append(events, ev)
+	})
+
+	entry := spanconfigtestutils.Entry
+	span := func(start, end string) roachpb.Span {
+		return entry(start, end).Build().Span
+	}
+	everything, within := span("a", "z"), span("a", "m")
+	get := func(sp roachpb.Span) []roachpb.SpanConfigEntry {
+		entries, err := accessor.GetSpanConfigEntriesFor(ctx, []roachpb.Span{sp})
+		require.NoError(t, err)
+		return entries
+	}
+
+	outside := entry("x", "y").WithTag("O").Build()
+	require.NoError(t, accessor.UpdateSpanConfigEntries(ctx, nil /* toDelete */, []roachpb.SpanConfigEntry{
+		entry("a", "b").WithTag("A").Build(),
+		entry("b", "d").WithTag("B").Build(),
+		entry("k", "p").WithTag("K").Build(),
+		outside,
+	}))
+	desired := []roachpb.SpanConfigEntry{
+		entry("a", "b").WithTag("A").Build(),
+		entry("b", "d").WithTag("X").Build(),
+		entry("e", "f").WithTag("E").Build(),
+	}
+	before := get(within)
+	summary, err := accessor.Reconcile(ctx, within, desired)
+	require.NoError(t, err)
+	// The entry extending past the span is deleted whole.
+	require.Equal(t, []roachpb.SpanConfigEntry{entry("k", "p").WithTag("K").Build()}, summary.Deleted)
+	require.Equal(t, desired[1:], summary.Upserted)
+	require.Equal(t, before, summary.Before)
+	require.Equal(t, desired, summary.After)
+	require.Equal(t, append(desired, outside), get(everything))
+
+	t.Run("reconciling again applies no changes", func(t *testing.T) {
+		_, since, err := accessor.Poll(ctx, []roachpb.Span{everything}, hlc.Timestamp{})
+		require.NoError(t, err)
+		events = nil
+		summary, err := accessor.Reconcile(ctx, within, desired)
+		require.NoError(t, err)
+		require.Empty(t, summary.Deleted)
+		require.Empty(t, summary.Upserted)
+		require.Equal(t, desired, summary.Before)
+		require.Equal(t, desired, summary.After)
+		require.Empty(t, events)
+		written, _, err := accessor.Poll(ctx, []roachpb.Span{everything}, since)
+		require.NoError(t, err)
+		require.Empty(t, written)
+	})
+
+	t.Run("drift converges", func(t *testing.T) {
+		rng, _ := randutil.NewTestRand()
+		for i := 0; i < 10; i++ {
+			// Drift the entries arbitrarily, including past the span, and
+			// reconcile them with arbitrary desired entries within it.
+			current := get(span("a", "t"))
+			toDelete := make([]roachpb.Span, len(current))
+			for j, e := range current {
+				toDelete[j] = e.Span
+			}
+			require.NoError(t, accessor.UpdateSpanConfigEntries(
+				ctx, toDelete, randomReconcileEntries(rng, 'a', 't'),
+			))
+			desired := randomReconcileEntries(rng, 'a', 'm')
+
+			summary, err := accessor.Reconcile(ctx, within, desired)
+			require.NoError(t, err)
+			requireEntries(t, desired, summary.After)
+			requireEntries(t, desired, get(within))
+			require.Equal(t, outside, get(span("x", "y"))[0])
+
+			summary, err = accessor.Reconcile(ctx, within, desired)
+			require.NoError(t, err)
+			require.Empty(t, summary.Deleted)
+			require.Empty(t, summary.Upserted)
+		}
+	})
+}
+
+// requireEntries is like require.Equal, except that nil and empty slices of
+// entries are considered equal.
+func requireEntries(t *testing.T, exp, got []roachpb.SpanConfigEntry) {
+	if len(exp) == 0 {
+		require.Empty(t, got)
+		return
+	}
+	require.Equal(t, exp, got)
+}
+
+// randomReconcileEntries returns sorted, non-overlapping entries with random
+// single-letter spans in [start, end), and random configs.
+func randomReconcileEntries(rng *rand.Rand, start, end byte) []roachpb.SpanConfigEntry {
+	var entries []roachpb.SpanConfigEntry
+	for k := start; k < end; {
+		n := byte(1 + rng.Intn(3))
+		if k+n > end {
+			n = end - k
+		}
+		if rng.Intn(3) > 0 {
+			tag := string([]byte{'A' + byte(rng.Intn(3))})
+			entries = append(entries,
+				spanconfigtestutils.Entry(string(k), string(k+n)).WithTag(tag).Build())
+		}
+		k += n
+	}
+	return entries
+}
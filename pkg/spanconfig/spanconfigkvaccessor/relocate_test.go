@@ -0,0 +1,83 @@
+// Copyright 2021 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package spanconfigkvaccessor_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/spanconfig/spanconfigkvaccessor"
+	"github.com/cockroachdb/cockroach/pkg/spanconfig/spanconfigtestutils"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRelocateEntry ensures that RelocateEntry moves an entry to its new span
+// in a single change, and leaves the table untouched if the source doesn't
+// exist or the destination conflicts with another entry.
+func TestRelocateEntry(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	tc, accessor := startTestCluster(t)
+	defer tc.Stopper().Stop(ctx)
+	var events []spanconfigkvaccessor.ChangeEvent
+	accessor.OnChange(func(ev spanconfigkvaccessor.ChangeEvent) {
+		events = append(events, ev)
+	})
+
+	entry := spanconfigtestutils.Entry
+	span := func(start, end string) roachpb.Span {
+		return entry(start, end).Build().Span
+	}
+	require.NoError(t, accessor.UpdateSpanConfigEntries(ctx, nil /* toDelete */, []roachpb.SpanConfigEntry{
+		entry("a", "c").WithTag("A").Build(),
+		entry("c", "e").WithTag("C").Build(),
+		entry("g", "h").WithTag("G").Build(),
+	}))
+	events = nil
+
+	t.Run("relocate", func(t *testing.T) {
+		require.NoError(t, accessor.RelocateEntry(ctx, span("c", "e"), span("d", "g")))
+		moved := entry("d", "g").WithTag("C").Build()
+		require.Len(t, events, 1)
+		require.Equal(t, []roachpb.Span{span("c", "e")}, events[0].Deleted)
+		require.Equal(t, []roachpb.SpanConfigEntry{moved}, events[0].Upserted)
+		entries, err := accessor.GetSpanConfigEntriesFor(ctx, []roachpb.Span{span("a", "z")})
+		require.NoError(t, err)
+		require.Equal(t, []roachpb.SpanConfigEntry{
+			entry("a", "c").WithTag("A").Build(),
+			moved,
+			entry("g", "h").WithTag("G").Build(),
+		}, entries)
+	})
+
+	before, err := accessor.GetSpanConfigEntriesFor(ctx, []roachpb.Span{span("a", "z")})
+	require.NoError(t, err)
+	events = nil
+
+	t.Run("missing source", func(t *testing.T) {
+		err := accessor.RelocateEntry(ctx, span("c", "e"), span("x", "y"))
+		require.Regexp(t, "no entry exists at", err)
+	})
+
+	t.Run("destination conflict", func(t *testing.T) {
+		err := accessor.RelocateEntry(ctx, span("d", "g"), span("b", "f"))
+		require.Regexp(t, "overlaps with entry", err)
+	})
+
+	// The failed relocations changed nothing.
+	require.Empty(t, events)
+	after, err := accessor.GetSpanConfigEntriesFor(ctx, []roachpb.Span{span("a", "z")})
+	require.NoError(t, err)
+	require.Equal(t, before, after)
+}
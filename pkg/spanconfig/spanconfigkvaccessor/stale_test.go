@@ -0,0 +1,62 @@
+// Copyright 2021 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package spanconfigkvaccessor_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/spanconfig/spanconfigtestutils"
+	"github.com/cockroachdb/cockroach/pkg/testutils"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// TestGetSpanConfigEntriesForStale ensures that stale reads return the
+// entries of a table which isn't being modified, once the staleness has
+// elapsed since it was last modified.
+func TestGetSpanConfigEntriesForStale(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	tc, accessor := startTestCluster(t)
+	defer tc.Stopper().Stop(ctx)
+
+	entry := spanconfigtestutils.Entry
+	require.NoError(t, accessor.UpdateSpanConfigEntries(ctx, nil /* toDelete */, []roachpb.SpanConfigEntry{
+		entry("a", "b").WithTag("A").Build(),
+		entry("c", "d").WithTag("B").Build(),
+	}))
+	spans := []roachpb.Span{entry("a", "z").Build().Span}
+	exp, err := accessor.GetSpanConfigEntriesFor(ctx, spans)
+	require.NoError(t, err)
+	require.Len(t, exp, 2)
+
+	// The entries were just written, so reads a short while in the past may
+	// not observe them, or even the table, but they eventually do.
+	const maxStaleness = 10 * time.Millisecond
+	testutils.SucceedsSoon(t, func() error {
+		entries, err := accessor.GetSpanConfigEntriesForStale(ctx, spans, maxStaleness)
+		if err != nil {
+			return err
+		}
+		if len(entries) != len(exp) {
+			return errors.Newf("expected %d entries, found %d", len(exp), len(entries))
+		}
+		return nil
+	})
+	entries, err := accessor.GetSpanConfigEntriesForStale(ctx, spans, maxStaleness)
+	require.NoError(t, err)
+	require.Equal(t, exp, entries)
+}
@@ -0,0 +1,68 @@
+// Copyright 2021 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package spanconfigkvaccessor_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/spanconfig/spanconfigtestutils"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/protoutil"
+	"github.com/stretchr/testify/require"
+)
+
+// TestStorageSize ensures that the size reported by StorageSize is the sum
+// of the sizes of the keys and encoded configs of the overlapping entries.
+func TestStorageSize(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	tc, accessor := startTestCluster(t)
+	defer tc.Stopper().Stop(ctx)
+
+	entry := spanconfigtestutils.Entry
+	span := func(start, end string) roachpb.Span {
+		return entry(start, end).Build().Span
+	}
+	entries := []roachpb.SpanConfigEntry{
+		entry("a", "c").WithTag("A").Build(),
+		entry("c", "e").WithTag("B").WithReplicas(5).Build(),
+		entry("e", "ggg").WithTTL(time.Hour).Build(),
+		entry("x", "y").WithTag("X").Build(),
+	}
+	require.NoError(t, accessor.UpdateSpanConfigEntries(ctx, nil /* toDelete */, entries))
+
+	size := func(entries ...roachpb.SpanConfigEntry) (size int64) {
+		for _, e := range entries {
+			conf, err := protoutil.Marshal(&e.Config)
+			require.NoError(t, err)
+			size += int64(len(e.Span.Key) + len(e.Span.EndKey) + len(conf))
+		}
+		return size
+	}
+	for _, tc := range []struct {
+		span roachpb.Span
+		exp  int64
+	}{
+		{span: span("a", "z"), exp: size(entries...)},
+		// Entries extending past the span are counted whole.
+		{span: span("b", "f"), exp: size(entries[:3]...)},
+		{span: span("x", "y"), exp: size(entries[3])},
+		{span: span("m", "n"), exp: 0},
+	} {
+		got, err := accessor.StorageSize(ctx, tc.span)
+		require.NoError(t, err)
+		require.Equal(t, tc.exp, got, "%s", tc.span)
+	}
+}
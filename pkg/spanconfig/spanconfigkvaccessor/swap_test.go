@@ -0,0 +1,95 @@
+// Copyright 2021 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package spanconfigkvaccessor_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/base"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/spanconfig/spanconfigkvaccessor"
+	"github.com/cockroachdb/cockroach/pkg/spanconfig/spanconfigtestutils"
+	"github.com/cockroachdb/cockroach/pkg/sql/sqlutil"
+	"github.com/cockroachdb/cockroach/pkg/testutils/sqlutils"
+	"github.com/cockroachdb/cockroach/pkg/testutils/testcluster"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/stretchr/testify/require"
+)
+
+// TestSwapTable ensures that SwapTable changes the table used by subsequent
+// operations, and that it rejects tables which can't store span
+// configurations.
+func TestSwapTable(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	tc := testcluster.StartTestCluster(t, 1, base.TestClusterArgs{
+		ServerArgs: base.TestServerArgs{
+			EnableSpanConfigs: true,
+		},
+	})
+	defer tc.Stopper().Stop(ctx)
+
+	const (
+		oldFQN     = "defaultdb.public.old_span_configurations"
+		newFQN     = "defaultdb.public.new_span_configurations"
+		invalidFQN = "defaultdb.public.invalid_span_configurations"
+	)
+	tdb := sqlutils.MakeSQLRunner(tc.ServerConn(0))
+	tdb.Exec(t, `SET CLUSTER SETTING spanconfig.experimental_kvaccessor.enabled = true`)
+	for _, fqn := range []string{oldFQN, newFQN} {
+		tdb.Exec(t, fmt.Sprintf("CREATE TABLE %s (LIKE system.span_configurations INCLUDING ALL)", fqn))
+	}
+	tdb.Exec(t, fmt.Sprintf("CREATE TABLE %s (start_key BYTES PRIMARY KEY, end_key BYTES, config STRING)", invalidFQN))
+
+	accessor := spanconfigkvaccessor.New(
+		tc.Server(0).DB(),
+		tc.Server(0).InternalExecutor().(sqlutil.InternalExecutor),
+		tc.Server(0).ClusterSettings(),
+		oldFQN,
+	)
+	entry := spanconfigtestutils.Entry
+	get := func() (ret []string) {
+		entries, err := accessor.GetSpanConfigEntriesFor(ctx, []roachpb.Span{entry("a", "z").Build().Span})
+		require.NoError(t, err)
+		for _, e := range entries {
+			ret = append(ret, spanconfigtestutils.PrintSpanConfigEntry(e))
+		}
+		return ret
+	}
+	require.NoError(t, accessor.UpdateSpanConfigEntries(ctx, nil /* toDelete */, []roachpb.SpanConfigEntry{
+		entry("a", "b").WithTag("X").Build(),
+	}))
+	require.Equal(t, []string{"[a,b):X"}, get())
+
+	t.Run("valid", func(t *testing.T) {
+		require.NoError(t, accessor.SwapTable(ctx, newFQN))
+		require.Empty(t, get())
+		require.NoError(t, accessor.UpdateSpanConfigEntries(ctx, nil /* toDelete */, []roachpb.SpanConfigEntry{
+			entry("c", "d").WithTag("Y").Build(),
+		}))
+		require.Equal(t, []string{"[c,d):Y"}, get())
+		tdb.CheckQueryResults(t, fmt.Sprintf("SELECT count(*) FROM %s", oldFQN), [][]string{{"1"}})
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		require.Regexp(t, `cannot swap to table .*: column config is of type STRING, not BYTES`,
+			accessor.SwapTable(ctx, invalidFQN))
+		require.Regexp(t, `cannot swap to table .*: relation .* does not exist`,
+			accessor.SwapTable(ctx, "defaultdb.public.missing"))
+		require.Regexp(t, `cannot swap to table .*: invalid table name`,
+			accessor.SwapTable(ctx, "not a table"))
+		// The accessor still uses the table it was swapped to.
+		require.Equal(t, []string{"[c,d):Y"}, get())
+	})
+}
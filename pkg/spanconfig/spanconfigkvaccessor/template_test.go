@@ -0,0 +1,62 @@
+// Copyright 2021 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package spanconfigkvaccessor_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/spanconfig/spanconfigkvaccessor"
+	"github.com/cockroachdb/cockroach/pkg/spanconfig/spanconfigtestutils"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/stretchr/testify/require"
+)
+
+// TestUpsertWithTemplate ensures that the configs stored by
+// UpsertWithTemplate are the base config with each override applied.
+func TestUpsertWithTemplate(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	tc, accessor := startTestCluster(t)
+	defer tc.Stopper().Stop(ctx)
+
+	entry := spanconfigtestutils.Entry
+	span := func(start, end string) roachpb.Span {
+		return entry(start, end).Build().Span
+	}
+	base := entry("", "").WithTag("base").WithReplicas(3).Build().Config
+	require.NoError(t, accessor.UpsertWithTemplate(ctx, base, []spanconfigkvaccessor.SpanOverride{
+		{Span: span("a", "b")},
+		{Span: span("b", "c"), Override: func(conf *roachpb.SpanConfig) {
+			conf.NumReplicas = 5
+		}},
+		{Span: span("c", "d"), Override: func(conf *roachpb.SpanConfig) {
+			conf.NumReplicas = 1
+		}},
+	}))
+
+	entries, err := accessor.GetSpanConfigEntriesFor(ctx, []roachpb.Span{span("a", "z")})
+	require.NoError(t, err)
+	require.Equal(t, []roachpb.SpanConfigEntry{
+		entry("a", "b").WithTag("base").WithReplicas(3).Build(),
+		entry("b", "c").WithTag("base").WithReplicas(5).Build(),
+		entry("c", "d").WithTag("base").WithReplicas(1).Build(),
+	}, entries)
+
+	// Overlapping overrides are rejected, as with UpdateSpanConfigEntries.
+	err = accessor.UpsertWithTemplate(ctx, base, []spanconfigkvaccessor.SpanOverride{
+		{Span: span("x", "z")},
+		{Span: span("y", "z")},
+	})
+	require.Regexp(t, "overlapping spans", err)
+}
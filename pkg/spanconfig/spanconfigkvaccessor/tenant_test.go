@@ -0,0 +1,84 @@
+// Copyright 2021 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package spanconfigkvaccessor_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/keys"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/spanconfig/spanconfigkvaccessor"
+	"github.com/cockroachdb/cockroach/pkg/spanconfig/spanconfigtestutils"
+	"github.com/cockroachdb/cockroach/pkg/sql/sqlutil"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/stretchr/testify/require"
+)
+
+// TestNewForTenant ensures that a KVAccessor scoped to a tenant operates on
+// the entries within its keyspace like an unscoped one does, and rejects
+// operations on spans of other tenants.
+func TestNewForTenant(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	tc, unscoped := startTestCluster(t)
+	defer tc.Stopper().Stop(ctx)
+
+	s := tc.Server(0)
+	ie := s.InternalExecutor().(sqlutil.InternalExecutor)
+	scoped := spanconfigkvaccessor.NewForTenant(
+		s.DB(), ie, s.ClusterSettings(), dummySpanConfigurationsFQN, roachpb.MakeTenantID(10),
+	)
+
+	// entry returns an entry spanning [start, end) within the keyspace of the
+	// given tenant.
+	entry := func(tenID uint64, start, end string) spanconfigtestutils.EntryBuilder {
+		prefix := string(keys.MakeTenantPrefix(roachpb.MakeTenantID(tenID)))
+		return spanconfigtestutils.Entry(prefix+start, prefix+end)
+	}
+	ten10 := []roachpb.SpanConfigEntry{
+		entry(10, "a", "b").WithTag("A").Build(),
+		entry(10, "b", "c").WithTag("B").Build(),
+	}
+	ten11 := []roachpb.SpanConfigEntry{
+		entry(11, "a", "b").WithTag("C").Build(),
+	}
+	require.NoError(t, unscoped.UpdateSpanConfigEntries(ctx, nil /* toDelete */, ten11))
+	require.NoError(t, scoped.UpdateSpanConfigEntries(ctx, nil /* toDelete */, ten10))
+
+	tenantSpan := func(tenID uint64) roachpb.Span {
+		prefix := keys.MakeTenantPrefix(roachpb.MakeTenantID(tenID))
+		return roachpb.Span{Key: prefix, EndKey: prefix.PrefixEnd()}
+	}
+	entries, err := scoped.GetSpanConfigEntriesFor(ctx, []roachpb.Span{tenantSpan(10)})
+	require.NoError(t, err)
+	require.Equal(t, ten10, entries)
+	exp, err := unscoped.GetSpanConfigEntriesFor(ctx, []roachpb.Span{tenantSpan(10)})
+	require.NoError(t, err)
+	require.Equal(t, exp, entries)
+
+	// Scans only return the entries within the tenant's keyspace.
+	entries, cursor, err := scoped.Scan(ctx, spanconfigkvaccessor.Cursor{}, 10 /* batchSize */)
+	require.NoError(t, err)
+	require.True(t, cursor.Done())
+	require.Equal(t, ten10, entries)
+
+	// Operations on other tenants' spans are rejected, and leave their entries
+	// intact.
+	_, err = scoped.GetSpanConfigEntriesFor(ctx, []roachpb.Span{tenantSpan(11)})
+	require.Regexp(t, "outside the keyspace of tenant 10", err)
+	err = scoped.UpdateSpanConfigEntries(ctx, []roachpb.Span{ten11[0].Span}, nil /* toUpsert */)
+	require.Regexp(t, "outside the keyspace of tenant 10", err)
+	entries, err = unscoped.GetSpanConfigEntriesFor(ctx, []roachpb.Span{tenantSpan(11)})
+	require.NoError(t, err)
+	require.Equal(t, ten11, entries)
+}
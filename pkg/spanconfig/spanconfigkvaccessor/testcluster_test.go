@@ -0,0 +1,48 @@
+// Copyright 2021 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package spanconfigkvaccessor_test
+
+import (
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/base"
+	"github.com/cockroachdb/cockroach/pkg/spanconfig/spanconfigkvaccessor"
+	"github.com/cockroachdb/cockroach/pkg/sql/sqlutil"
+	"github.com/cockroachdb/cockroach/pkg/testutils/sqlutils"
+	"github.com/cockroachdb/cockroach/pkg/testutils/testcluster"
+)
+
+// dummySpanConfigurationsFQN is the name of the span configurations table
+// created by startTestCluster.
+const dummySpanConfigurationsFQN = "defaultdb.public.dummy_span_configurations"
+
+// startTestCluster starts a single node test cluster with the KVAccessor
+// enabled, creates an empty span configurations table named
+// dummySpanConfigurationsFQN, and returns an accessor for it. The caller is
+// responsible for stopping the cluster.
+func startTestCluster(t testing.TB) (*testcluster.TestCluster, *spanconfigkvaccessor.KVAccessor) {
+	tc := testcluster.StartTestCluster(t, 1, base.TestClusterArgs{
+		ServerArgs: base.TestServerArgs{
+			EnableSpanConfigs: true,
+		},
+	})
+
+	tdb := sqlutils.MakeSQLRunner(tc.ServerConn(0))
+	tdb.Exec(t, `SET CLUSTER SETTING spanconfig.experimental_kvaccessor.enabled = true`)
+	tdb.Exec(t, "CREATE TABLE "+dummySpanConfigurationsFQN+" (LIKE system.span_configurations INCLUDING ALL)")
+	accessor := spanconfigkvaccessor.New(
+		tc.Server(0).DB(),
+		tc.Server(0).InternalExecutor().(sqlutil.InternalExecutor),
+		tc.Server(0).ClusterSettings(),
+		dummySpanConfigurationsFQN,
+	)
+	return tc, accessor
+}
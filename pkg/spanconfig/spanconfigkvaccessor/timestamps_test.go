@@ -0,0 +1,69 @@
+// Copyright 2021 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package spanconfigkvaccessor_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/spanconfig/spanconfigkvaccessor"
+	"github.com/cockroachdb/cockroach/pkg/spanconfig/spanconfigtestutils"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/stretchr/testify/require"
+)
+
+// TestGetSpanConfigEntriesWithTimestamps ensures that the entries are
+// annotated with the commit timestamps of the last writes to their rows.
+func TestGetSpanConfigEntriesWithTimestamps(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	tc, accessor := startTestCluster(t)
+	defer tc.Stopper().Stop(ctx)
+	var events []spanconfigkvaccessor.ChangeEvent
+	accessor.OnChange(func(ev spanconfigkvaccessor.ChangeEvent) {
+		events = append(events, ev)
+	})
+
+	entry := spanconfigtestutils.Entry
+	spans := []roachpb.Span{entry("a", "z").Build().Span}
+	entries := []roachpb.SpanConfigEntry{
+		entry("a", "b").WithTag("A").Build(),
+		entry("b", "c").WithTag("B").Build(),
+	}
+	require.NoError(t, accessor.UpdateSpanConfigEntries(ctx, nil /* toDelete */, entries))
+	first, err := accessor.GetSpanConfigEntriesWithTimestamps(ctx, spans)
+	require.NoError(t, err)
+	require.Len(t, first, 2)
+	for i, te := range first {
+		require.Equal(t, entries[i], te.Entry)
+		// The timestamps are those at which the entries were committed.
+		require.Equal(t, events[0].Timestamp, te.Timestamp)
+	}
+
+	// Rewriting an entry, even with the same config, advances its timestamp,
+	// and leaves the timestamps of the other entries as they were.
+	require.NoError(t, accessor.UpdateSpanConfigEntries(
+		ctx, nil /* toDelete */, []roachpb.SpanConfigEntry{entries[1]},
+	))
+	second, err := accessor.GetSpanConfigEntriesWithTimestamps(ctx, spans)
+	require.NoError(t, err)
+	require.Len(t, second, 2)
+	require.Equal(t, first[0], second[0])
+	require.Equal(t, entries[1], second[1].Entry)
+	require.True(t, first[1].Timestamp.Less(second[1].Timestamp))
+	require.Equal(t, events[1].Timestamp, second[1].Timestamp)
+
+	plain, err := accessor.GetSpanConfigEntriesFor(ctx, spans)
+	require.NoError(t, err)
+	require.Equal(t, entries, plain)
+}
@@ -0,0 +1,75 @@
+// Copyright 2021 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package spanconfigkvaccessor_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/spanconfig/spanconfigkvaccessor"
+	"github.com/cockroachdb/cockroach/pkg/spanconfig/spanconfigtestutils"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/stretchr/testify/require"
+)
+
+// TestFindViolations ensures that FindViolations reports the entries
+// overlapping with the span whose configs fail the check, with its messages.
+func TestFindViolations(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	tc, accessor := startTestCluster(t)
+	defer tc.Stopper().Stop(ctx)
+
+	entry := spanconfigtestutils.Entry
+	entries := []roachpb.SpanConfigEntry{
+		entry("a", "b").WithReplicas(3).Build(),
+		entry("b", "c").WithReplicas(1).Build(),
+		entry("c", "d").WithReplicas(5).Build(),
+		entry("d", "f").WithReplicas(2).Build(),
+		entry("x", "y").WithReplicas(1).Build(),
+	}
+	require.NoError(t, accessor.UpdateSpanConfigEntries(ctx, nil /* toDelete */, entries))
+
+	span := func(start, end string) roachpb.Span {
+		return entry(start, end).Build().Span
+	}
+	underReplicated := func(conf roachpb.SpanConfig) (bool, string) {
+		if conf.NumReplicas < 3 {
+			return false, fmt.Sprintf("num_replicas %d below minimum of 3", conf.NumReplicas)
+		}
+		return true, ""
+	}
+	reports, err := accessor.FindViolations(ctx, span("a", "z"), underReplicated)
+	require.NoError(t, err)
+	require.Equal(t, []spanconfigkvaccessor.ViolationReport{
+		{Entry: entries[1], Message: "num_replicas 1 below minimum of 3"},
+		{Entry: entries[3], Message: "num_replicas 2 below minimum of 3"},
+		{Entry: entries[4], Message: "num_replicas 1 below minimum of 3"},
+	}, reports)
+
+	// Only the entries overlapping with the span are checked, including those
+	// extending past it.
+	reports, err = accessor.FindViolations(ctx, span("ba", "e"), underReplicated)
+	require.NoError(t, err)
+	require.Equal(t, []spanconfigkvaccessor.ViolationReport{
+		{Entry: entries[1], Message: "num_replicas 1 below minimum of 3"},
+		{Entry: entries[3], Message: "num_replicas 2 below minimum of 3"},
+	}, reports)
+
+	reports, err = accessor.FindViolations(ctx, span("a", "z"), func(roachpb.SpanConfig) (bool, string) {
+		return true, ""
+	})
+	require.NoError(t, err)
+	require.Empty(t, reports)
+}
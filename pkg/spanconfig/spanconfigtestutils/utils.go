@@ -15,8 +15,10 @@ import (
 	"regexp"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/kr/pretty"
 )
 
 // spanRe matches strings of the form "[start, end)", capturing both the "start"
@@ -50,12 +52,18 @@ func ParseConfig(t *testing.T, conf string) roachpb.SpanConfig {
 		t.Fatalf("expected %s to match config regex", conf)
 	}
 	return roachpb.SpanConfig{
-		Constraints: []roachpb.ConstraintsConjunction{
-			{
-				Constraints: []roachpb.Constraint{
-					{
-						Key: conf,
-					},
+		Constraints: tagConstraints(conf),
+	}
+}
+
+// tagConstraints returns the constraints used to "tag" a config with the given
+// string.
+func tagConstraints(tag string) []roachpb.ConstraintsConjunction {
+	return []roachpb.ConstraintsConjunction{
+		{
+			Constraints: []roachpb.Constraint{
+				{
+					Key: tag,
 				},
 			},
 		},
@@ -76,6 +84,50 @@ func ParseSpanConfigEntry(t *testing.T, conf string) roachpb.SpanConfigEntry {
 	}
 }
 
+// EntryBuilder is a fluent builder for roachpb.SpanConfigEntry, for tests
+// written in Go rather than as datadriven files. Each method returns a copy of
+// the builder, so a partially configured builder can be reused.
+type EntryBuilder struct {
+	entry roachpb.SpanConfigEntry
+}
+
+// Entry returns an EntryBuilder for an entry spanning [start, end) with an
+// empty config.
+func Entry(start, end string) EntryBuilder {
+	return EntryBuilder{
+		entry: roachpb.SpanConfigEntry{
+			Span: roachpb.Span{
+				Key:    roachpb.Key(start),
+				EndKey: roachpb.Key(end),
+			},
+		},
+	}
+}
+
+// WithTag "tags" the config with the given string, the same way ParseConfig
+// does. Entries built with a tag can be printed using PrintSpanConfigEntry.
+func (b EntryBuilder) WithTag(tag string) EntryBuilder {
+	b.entry.Config.Constraints = tagConstraints(tag)
+	return b
+}
+
+// WithTTL sets the GC TTL of the config, truncated to seconds.
+func (b EntryBuilder) WithTTL(ttl time.Duration) EntryBuilder {
+	b.entry.Config.GCPolicy.TTLSeconds = int32(ttl.Seconds())
+	return b
+}
+
+// WithReplicas sets the number of replicas of the config.
+func (b EntryBuilder) WithReplicas(n int32) EntryBuilder {
+	b.entry.Config.NumReplicas = n
+	return b
+}
+
+// Build returns the entry.
+func (b EntryBuilder) Build() roachpb.SpanConfigEntry {
+	return b.entry
+}
+
 // PrintSpan is a helper function that transforms roachpb.Span into a string of
 // the form "[start,end)". The span is assumed to have been constructed by the
 // ParseSpan helper above.
@@ -98,3 +150,12 @@ func PrintSpanConfig(conf roachpb.SpanConfig) string {
 func PrintSpanConfigEntry(entry roachpb.SpanConfigEntry) string {
 	return fmt.Sprintf("%s:%s", PrintSpan(entry.Span), PrintSpanConfig(entry.Config))
 }
+
+// AssertSpanConfigEqual fails the test if the given span configs differ,
+// listing each of the differing fields.
+func AssertSpanConfigEqual(t testing.TB, expected, actual roachpb.SpanConfig) {
+	t.Helper()
+	if diff := pretty.Diff(expected, actual); len(diff) > 0 {
+		t.Errorf("span configs differ (expected != actual):\n%s", strings.Join(diff, "\n"))
+	}
+}
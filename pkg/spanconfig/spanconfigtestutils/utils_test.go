@@ -11,7 +11,9 @@
 package spanconfigtestutils
 
 import (
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -43,3 +45,50 @@ func TestSpanRe(t *testing.T) {
 		require.Equal(t, tc.expEnd, end)
 	}
 }
+
+// captureT is a testing.TB which captures test failures.
+type captureT struct {
+	testing.TB
+	errs []string
+}
+
+func (c *captureT) Helper() {}
+
+func (c *captureT) Errorf(format string, args ...interface{}) {
+	c.errs = append(c.errs, fmt.Sprintf(format, args...))
+}
+
+func TestAssertSpanConfigEqual(t *testing.T) {
+	expected := ParseConfig(t, "a")
+	actual := ParseConfig(t, "a")
+
+	ct := &captureT{TB: t}
+	AssertSpanConfigEqual(ct, expected, actual)
+	require.Empty(t, ct.errs)
+
+	actual.NumReplicas = 3
+	AssertSpanConfigEqual(ct, expected, actual)
+	require.Equal(t, []string{
+		"span configs differ (expected != actual):\nNumReplicas: 0 != 3",
+	}, ct.errs)
+}
+
+func TestEntryBuilder(t *testing.T) {
+	// The builder and the parser produce equal entries for equivalent inputs.
+	require.Equal(t, ParseSpanConfigEntry(t, "[a,b):A"), Entry("a", "b").WithTag("A").Build())
+	require.Equal(t, ParseSpanConfigEntry(t, "[acd, bfg):xyz"), Entry("acd", "bfg").WithTag("xyz").Build())
+	require.Equal(t, ParseSpan(t, "[a,b)"), Entry("a", "b").Build().Span)
+	require.Equal(t, "[a,b):A", PrintSpanConfigEntry(Entry("a", "b").WithTag("A").Build()))
+
+	base := Entry("a", "b").WithTag("A")
+	entry := base.WithTTL(90 * time.Minute).WithReplicas(5).Build()
+	expected := ParseConfig(t, "A")
+	expected.GCPolicy.TTLSeconds = 5400
+	expected.NumReplicas = 5
+	AssertSpanConfigEqual(t, expected, entry.Config)
+
+	// Deriving entries from a builder does not affect it.
+	require.Equal(t, ParseSpanConfigEntry(t, "[a,b):A"), base.Build())
+	require.Equal(t, ParseConfig(t, "B"), base.WithTag("B").Build().Config)
+	require.Equal(t, ParseConfig(t, "A"), base.Build().Config)
+}
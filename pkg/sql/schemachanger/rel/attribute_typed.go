@@ -0,0 +1,122 @@
+// Copyright 2021 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package rel
+
+import "reflect"
+
+// StringAttr is an Attr whose values are strings. It may be declared as a
+// constant, such as:
+//
+//	const Name rel.StringAttr = "name"
+//
+// Clauses constructed using its methods only accept strings, so that passing
+// a value of another type fails to compile rather than to construct the
+// query. It is otherwise an Attr like any other, and may be used with the
+// methods of Var. Mapping a StringAttr to fields which are not strings, or
+// pointers to strings, fails the construction of the schema.
+type StringAttr string
+
+var _ typedAttr = StringAttr("")
+
+// String is part of the Attr interface.
+func (a StringAttr) String() string { return string(a) }
+
+func (a StringAttr) valueType() reflect.Type {
+	return reflect.TypeOf((*string)(nil)).Elem()
+}
+
+// Eq is like v.AttrEq(a, value).
+func (a StringAttr) Eq(v Var, value string) Clause {
+	return v.AttrEq(a, value)
+}
+
+// In is like v.AttrIn(a, values...).
+func (a StringAttr) In(v Var, values ...string) Clause {
+	return v.AttrIn(a, stringValues(values)...)
+}
+
+// InOrUnset is like v.AttrInOrUnset(a, values...).
+func (a StringAttr) InOrUnset(v Var, values ...string) Clause {
+	return v.AttrInOrUnset(a, stringValues(values)...)
+}
+
+// Lt is like v.AttrLt(a, value).
+func (a StringAttr) Lt(v Var, value string) Clause {
+	return v.AttrLt(a, value)
+}
+
+// Gt is like v.AttrGt(a, value).
+func (a StringAttr) Gt(v Var, value string) Clause {
+	return v.AttrGt(a, value)
+}
+
+// Between is like v.AttrBetween(a, lo, hi).
+func (a StringAttr) Between(v Var, lo, hi string) Clause {
+	return v.AttrBetween(a, lo, hi)
+}
+
+func stringValues(values []string) []interface{} {
+	ret := make([]interface{}, len(values))
+	for i, v := range values {
+		ret[i] = v
+	}
+	return ret
+}
+
+// IntAttr is like StringAttr, for attributes whose values are ints.
+type IntAttr string
+
+var _ typedAttr = IntAttr("")
+
+// String is part of the Attr interface.
+func (a IntAttr) String() string { return string(a) }
+
+func (a IntAttr) valueType() reflect.Type {
+	return reflect.TypeOf((*int)(nil)).Elem()
+}
+
+// Eq is like v.AttrEq(a, value).
+func (a IntAttr) Eq(v Var, value int) Clause {
+	return v.AttrEq(a, value)
+}
+
+// In is like v.AttrIn(a, values...).
+func (a IntAttr) In(v Var, values ...int) Clause {
+	return v.AttrIn(a, intValues(values)...)
+}
+
+// InOrUnset is like v.AttrInOrUnset(a, values...).
+func (a IntAttr) InOrUnset(v Var, values ...int) Clause {
+	return v.AttrInOrUnset(a, intValues(values)...)
+}
+
+// Lt is like v.AttrLt(a, value).
+func (a IntAttr) Lt(v Var, value int) Clause {
+	return v.AttrLt(a, value)
+}
+
+// Gt is like v.AttrGt(a, value).
+func (a IntAttr) Gt(v Var, value int) Clause {
+	return v.AttrGt(a, value)
+}
+
+// Between is like v.AttrBetween(a, lo, hi).
+func (a IntAttr) Between(v Var, lo, hi int) Clause {
+	return v.AttrBetween(a, lo, hi)
+}
+
+func intValues(values []int) []interface{} {
+	ret := make([]interface{}, len(values))
+	for i, v := range values {
+		ret[i] = v
+	}
+	return ret
+}
@@ -0,0 +1,91 @@
+// Copyright 2021 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package rel_test
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/schemachanger/rel"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	typedName  rel.StringAttr = "name"
+	typedSize  rel.IntAttr    = "size"
+	typedAlias rel.StringAttr = "alias"
+)
+
+type typedItem struct {
+	Name  string
+	Size  int
+	Alias *string
+}
+
+// TestTypedAttrs ensures that the clauses constructed using the methods of
+// typed attributes match those constructed using the methods of Var, and that
+// typed attributes cannot be mapped to fields of another type. Passing values
+// of another type to the methods fails to compile.
+func TestTypedAttrs(t *testing.T) {
+	sc := rel.MustSchema("typed",
+		rel.EntityMapping(reflect.TypeOf((*typedItem)(nil)),
+			rel.EntityAttr(typedName, "Name"),
+			rel.EntityAttr(typedSize, "Size"),
+			// Attributes may be mapped to pointers to their type.
+			rel.EntityAttr(typedAlias, "Alias"),
+		),
+	)
+	db, err := rel.NewDatabase(sc, nil /* indexes */)
+	require.NoError(t, err)
+	alias := "bee"
+	for i, n := range []string{"a", "b", "c", "d"} {
+		it := &typedItem{Name: n, Size: i}
+		if n == "b" {
+			it.Alias = &alias
+		}
+		require.NoError(t, db.Insert(it))
+	}
+	names := func(clauses ...rel.Clause) (ret []string) {
+		var v rel.Var = "v"
+		q, err := rel.NewQuery(sc, append([]rel.Clause{v.Type((*typedItem)(nil))}, clauses...)...)
+		require.NoError(t, err)
+		require.NoError(t, q.Iterate(db, func(r rel.Result) error {
+			ret = append(ret, r.Var(v).(*typedItem).Name)
+			return nil
+		}))
+		sort.Strings(ret)
+		return ret
+	}
+	var v rel.Var = "v"
+	for _, tc := range []struct {
+		typed, untyped rel.Clause
+		exp            []string
+	}{
+		{typedName.Eq(v, "b"), v.AttrEq(typedName, "b"), []string{"b"}},
+		{typedName.In(v, "a", "c"), v.AttrIn(typedName, "a", "c"), []string{"a", "c"}},
+		{typedAlias.InOrUnset(v, "bee"), v.AttrInOrUnset(typedAlias, "bee"), []string{"a", "b", "c", "d"}},
+		{typedSize.Lt(v, 2), v.AttrLt(typedSize, 2), []string{"a", "b"}},
+		{typedSize.Gt(v, 2), v.AttrGt(typedSize, 2), []string{"d"}},
+		{typedSize.Between(v, 1, 2), v.AttrBetween(typedSize, 1, 2), []string{"b", "c"}},
+	} {
+		require.Equal(t, tc.exp, names(tc.typed))
+		require.Equal(t, tc.exp, names(tc.untyped))
+	}
+
+	// Mapping a typed attribute to a field of another type fails.
+	_, err = rel.NewSchema("mismatch",
+		rel.EntityMapping(reflect.TypeOf((*typedItem)(nil)),
+			rel.EntityAttr(typedSize, "Name"),
+		),
+	)
+	require.Regexp(t, `type mismatch for size: .*string`, err)
+}
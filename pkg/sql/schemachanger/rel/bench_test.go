@@ -184,3 +184,106 @@ func BenchmarkReverseLinkedListNoRel(b *testing.B) {
 		})
 	})
 }
+
+// BenchmarkEvaluateAll compares evaluating a set of rules which each scan
+// all of the nodes with EvaluateAll against evaluating them one after
+// another.
+func BenchmarkEvaluateAll(b *testing.B) {
+	sc := rel.MustSchema("bench",
+		rel.EntityMapping(reflect.TypeOf((*ListNode)(nil)),
+			rel.EntityAttr(idAttr, "ID"),
+			rel.EntityAttr(nextAttr, "Next"),
+		),
+	)
+	run := func(b *testing.B, nodes, rules int, shared bool) {
+		db, err := rel.NewDatabase(sc, nil)
+		require.NoError(b, err)
+		for i, j := range rand.Perm(nodes) {
+			require.NoError(b, db.Insert(&ListNode{ID: i, Next: j}))
+		}
+		var n rel.Var = "n"
+		queries := make([]*rel.Query, rules)
+		for i := range queries {
+			mod := i + 2
+			q, err := rel.NewQuery(sc,
+				n.Type((*ListNode)(nil)),
+				rel.Filter("mod", n)(func(ln *ListNode) bool {
+					return ln.Next%mod == 0
+				}),
+			)
+			require.NoError(b, err)
+			queries[i] = q
+		}
+		noop := func(r rel.Result) error { return nil }
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if shared {
+				_, err := rel.EvaluateAll(db, queries)
+				require.NoError(b, err)
+				continue
+			}
+			for _, q := range queries {
+				require.NoError(b, q.Iterate(db, noop))
+			}
+		}
+	}
+	for _, nodes := range []int{128, 1024} {
+		for _, rules := range []int{16, 128} {
+			for _, shared := range []bool{false, true} {
+				b.Run(fmt.Sprintf("nodes=%d,rules=%d,shared=%t", nodes, rules, shared), func(b *testing.B) {
+					run(b, nodes, rules, shared)
+				})
+			}
+		}
+	}
+}
+
+// BenchmarkOptimize compares evaluating a query whose clauses are ordered
+// such that all of the nodes are joined first against evaluating it after
+// it was optimized to first join the single node matching a constant.
+func BenchmarkOptimize(b *testing.B) {
+	sc := rel.MustSchema("bench",
+		rel.EntityMapping(reflect.TypeOf((*ListNode)(nil)),
+			rel.EntityAttr(idAttr, "ID"),
+			rel.EntityAttr(nextAttr, "Next"),
+		),
+	)
+	run := func(b *testing.B, nodes int, optimize bool) {
+		db, err := rel.NewDatabase(sc, [][]rel.Attr{{idAttr}, {nextAttr}})
+		require.NoError(b, err)
+		for i, j := range rand.Perm(nodes) {
+			require.NoError(b, db.Insert(&ListNode{ID: i, Next: j}))
+		}
+		// Find the predecessor of the predecessor of node 7.
+		var p, n rel.Var = "p", "n"
+		q, err := rel.NewQuery(sc,
+			p.Type((*ListNode)(nil)),
+			p.AttrEqVar(nextAttr, "id"),
+			n.Type((*ListNode)(nil)),
+			n.AttrEqVar(idAttr, "id"),
+			n.AttrEq(nextAttr, 7),
+		)
+		require.NoError(b, err)
+		if optimize {
+			q, err = q.Optimize(db.Stats())
+			require.NoError(b, err)
+		}
+		var results int
+		count := func(r rel.Result) error {
+			results++
+			return nil
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			require.NoError(b, q.Iterate(db, count))
+		}
+		require.Equal(b, b.N, results)
+	}
+	for _, nodes := range []int{128, 1024, 8192} {
+		for _, optimize := range []bool{false, true} {
+			b.Run(fmt.Sprintf("nodes=%d,optimize=%t", nodes, optimize), func(b *testing.B) {
+				run(b, nodes, optimize)
+			})
+		}
+	}
+}
@@ -12,10 +12,30 @@ package rel
 
 import (
 	"reflect"
+	"time"
 
 	"github.com/cockroachdb/errors"
 )
 
+// Comparator may be implemented by attribute value types to define their
+// order. CompareTo returns a negative number if the receiver sorts before
+// other, zero if they are equal, and a positive number otherwise; other is
+// always a value of the same type as the receiver. Values of a type which
+// implements Comparator, with a value receiver, are compared using CompareTo
+// rather than by their underlying kind, and can be used with the ordered
+// clauses such as AttrLt and AttrBetween.
+type Comparator interface {
+	CompareTo(other interface{}) int
+}
+
+var comparatorType = reflect.TypeOf((*Comparator)(nil)).Elem()
+
+// isComparatorType returns true if values of the type are compared using
+// Comparator.
+func isComparatorType(t reflect.Type) bool {
+	return t.Kind() != reflect.Ptr && t.Implements(comparatorType)
+}
+
 // compare assumes that a and b are comparable and of the same type.
 // comparable here
 func compare(a, b interface{}) (less, eq bool) {
@@ -93,6 +113,18 @@ func compare(a, b interface{}) (less, eq bool) {
 			return true, false
 		}
 		return false, *a == *b
+	case *bool:
+		b := b.(*bool)
+		if !*a && *b {
+			return true, false
+		}
+		return false, *a == *b
+	case *time.Time:
+		b := b.(*time.Time)
+		if a.Before(*b) {
+			return true, false
+		}
+		return false, a.Equal(*b)
 	case reflect.Type:
 		b := b.(reflect.Type)
 		switch {
@@ -109,6 +141,13 @@ func compare(a, b interface{}) (less, eq bool) {
 		// pointer value
 		av := reflect.ValueOf(a)
 		bv := reflect.ValueOf(b)
+		if av.Kind() == reflect.Ptr && isComparatorType(av.Type().Elem()) {
+			c := av.Elem().Interface().(Comparator).CompareTo(bv.Elem().Interface())
+			return c < 0, c == 0
+		}
+		if av.Kind() == reflect.Ptr && av.Type().Elem().Kind() == reflect.Slice {
+			return compareSlices(av.Elem(), bv.Elem())
+		}
 		if av.Type().Kind() != reflect.Ptr || av.Type().Elem().Kind() != reflect.Struct ||
 			bv.Type().Kind() != reflect.Ptr || bv.Type().Elem().Kind() != reflect.Struct {
 			panic(errors.AssertionFailedf("incomparable types %T and %T", a, b))
@@ -118,6 +157,21 @@ func compare(a, b interface{}) (less, eq bool) {
 	}
 }
 
+// compareSlices compares slices of scalars element-wise. A slice which is a
+// prefix of another sorts before it.
+func compareSlices(a, b reflect.Value) (less, eq bool) {
+	compType := reflect.PtrTo(getComparableType(a.Type().Elem()))
+	for i := 0; i < a.Len() && i < b.Len(); i++ {
+		if less, eq := compare(
+			a.Index(i).Addr().Convert(compType).Interface(),
+			b.Index(i).Addr().Convert(compType).Interface(),
+		); !eq {
+			return less, false
+		}
+	}
+	return a.Len() < b.Len(), a.Len() == b.Len()
+}
+
 var kindTypeMap = map[reflect.Kind]reflect.Type{
 	reflect.Int:     reflect.TypeOf((*int)(nil)).Elem(),
 	reflect.Int64:   reflect.TypeOf((*int64)(nil)).Elem(),
@@ -131,16 +185,59 @@ var kindTypeMap = map[reflect.Kind]reflect.Type{
 	reflect.Uint8:   reflect.TypeOf((*uint8)(nil)).Elem(),
 	reflect.Uintptr: reflect.TypeOf((*uintptr)(nil)).Elem(),
 	reflect.String:  reflect.TypeOf((*string)(nil)).Elem(),
+	reflect.Bool:    reflect.TypeOf((*bool)(nil)).Elem(),
 
 	// TODO(ajwerner): Fill out all of the kinds.
 }
 
+// timeType is supported as a scalar despite being a struct. Values of the
+// type are compared by time rather than by identity.
+var timeType = reflect.TypeOf((*time.Time)(nil)).Elem()
+
 func isSupportScalarKind(kind reflect.Kind) bool {
 	_, ok := kindTypeMap[kind]
 	return kind != reflect.Ptr && ok
 }
 
+// isSupportScalarType is like isSupportScalarKind but also permits the
+// scalar struct types and types which implement Comparator.
+func isSupportScalarType(t reflect.Type) bool {
+	return t == timeType || isComparatorType(t) || isSupportScalarKind(t.Kind())
+}
+
+// isSupportSliceType returns true if the type is a slice of a supported
+// scalar type. Such values are compared element-wise.
+func isSupportSliceType(t reflect.Type) bool {
+	return t.Kind() == reflect.Slice && isSupportScalarType(t.Elem())
+}
+
+// isOrderedType returns true if values of the type have a meaningful order.
+func isOrderedType(t reflect.Type) bool {
+	return isSupportScalarType(t) && t.Kind() != reflect.Bool
+}
+
+// isNumericType returns true if the type is an integer or floating point
+// type, compared as such.
+func isNumericType(t reflect.Type) bool {
+	if isComparatorType(t) || !isSupportScalarKind(t.Kind()) {
+		return false
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	default:
+		return false
+	}
+}
+
 func getComparableType(t reflect.Type) reflect.Type {
+	// Slices are compared using reflection and Comparator types using their
+	// method, so their values need not be converted.
+	if t == timeType || isComparatorType(t) || isSupportSliceType(t) {
+		return t
+	}
 	ct, ok := kindTypeMap[t.Kind()]
 	if !ok {
 		panic(errors.AssertionFailedf(
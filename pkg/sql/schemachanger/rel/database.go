@@ -12,6 +12,7 @@ package rel
 
 import (
 	"reflect"
+	"sync/atomic"
 
 	"github.com/cockroachdb/cockroach/pkg/util/iterutil"
 	"github.com/cockroachdb/errors"
@@ -31,8 +32,55 @@ type Database struct {
 	// One could easily envision a map-backed indexing structure which may well
 	// perform much better given the general lack of
 	indexes []index
-	// entities stores all the entities keyed on its pointer value.
+	// entities stores all the entities keyed on its pointer value. Pointers
+	// which were inserted but share their identity with an entity inserted
+	// before them map to that entity; see EntityIdentity.
 	entities map[interface{}]*entity
+	// identities stores the entities of types with an EntityIdentity keyed
+	// on their identity.
+	identities map[identityKey]*entity
+	// frozen is set once the database may no longer be modified.
+	frozen bool
+	// id uniquely identifies the database within the process.
+	id uint64
+	// version is incremented whenever an entity is inserted.
+	version uint64
+}
+
+// identityKey is the key of an entity of a type with an EntityIdentity.
+type identityKey struct {
+	typ reflect.Type
+	key interface{}
+}
+
+// databaseIDs is used to allocate the ids of databases.
+var databaseIDs uint64
+
+// DatabaseFingerprint identifies the contents of a Database at a point in
+// time. Fingerprints of the same database compare equal so long as the
+// database has not been modified in between. Fingerprints of different
+// databases never compare equal, even if they have the same contents.
+type DatabaseFingerprint struct {
+	id, version uint64
+}
+
+// Fingerprint returns the fingerprint of the current contents of the database.
+func (t *Database) Fingerprint() DatabaseFingerprint {
+	return DatabaseFingerprint{id: t.id, version: t.version}
+}
+
+// Freeze prevents any further modification of the database. Queries do not
+// modify the database, and a frozen database may be queried concurrently by
+// multiple goroutines. Freeze itself must not be called concurrently with
+// queries. Databases restored from a snapshot of a frozen database are not
+// frozen.
+func (t *Database) Freeze() {
+	t.frozen = true
+}
+
+// Frozen returns true if the database has been frozen.
+func (t *Database) Frozen() bool {
+	return t.frozen
 }
 
 // Schema returns the schema associated with the tree.
@@ -44,9 +92,11 @@ func (t *Database) Schema() *Schema {
 // Note that the schema must not contain more than 64 attributes.
 func NewDatabase(sc *Schema, indexes [][]Attr) (*Database, error) {
 	t := &Database{
-		schema:   sc,
-		indexes:  make([]index, len(indexes)+1),
-		entities: make(map[interface{}]*entity),
+		schema:     sc,
+		indexes:    make([]index, len(indexes)+1),
+		entities:   make(map[interface{}]*entity),
+		identities: make(map[identityKey]*entity),
+		id:         atomic.AddUint64(&databaseIDs, 1),
 	}
 	// Index everything by all the attributes. This serves as the "primary"
 	// index.
@@ -82,8 +132,15 @@ func NewDatabase(sc *Schema, indexes [][]Attr) (*Database, error) {
 // passed entity which do not already exist in the database.
 //
 // It is a no-op and not an error to insert an entity which
-// already exists.
+// already exists. It is an error to insert into a frozen database.
+//
+// For types with an EntityIdentity, inserting an entity which shares its
+// identity with an already inserted entity does not add a new entity.
+// Instead, the inserted pointer becomes an alias for the existing entity.
 func (t *Database) Insert(v interface{}) error {
+	if t.frozen {
+		return errors.Errorf("cannot insert %T into frozen database", v)
+	}
 	e, err := toEntity(t.schema, v)
 	if err != nil {
 		return err
@@ -91,6 +148,10 @@ func (t *Database) Insert(v interface{}) error {
 	if err := t.insert(e); err != nil {
 		return err
 	}
+	if t.entities[v] != e {
+		// The entity already existed, so the entities it refers to do too.
+		return nil
+	}
 	for _, v := range e.m {
 		_, isEntity := t.schema.entityTypeSchemas[reflect.TypeOf(v)]
 		_, alreadyDefined := t.entities[v]
@@ -105,7 +166,11 @@ func (t *Database) Insert(v interface{}) error {
 
 func (t *Database) insert(e *entity) error {
 	self := e.getComparableValue(t.schema, Self)
+	t.canonicalizeReferences(e)
 	if existing, exists := t.entities[self]; exists {
+		if existing.getComparableValue(t.schema, Self) != self {
+			return nil // self is an alias
+		}
 		// Sanity check that the entities really are equal.
 		if _, eq := compareEntities(e, existing); !eq {
 			return errors.AssertionFailedf(
@@ -114,7 +179,17 @@ func (t *Database) insert(e *entity) error {
 		}
 		return nil
 	}
+	if ti := e.getTypeInfo(t.schema); ti.identity != nil {
+		k := identityKey{typ: ti.typ, key: ti.identity(self)}
+		if existing, exists := t.identities[k]; exists {
+			t.entities[self] = existing
+			t.version++
+			return nil
+		}
+		t.identities[k] = e
+	}
 	t.entities[self] = e
+	t.version++
 	for i := range t.indexes {
 		idx := &t.indexes[i]
 		if g := idx.tree.ReplaceOrInsert(&containerItem{
@@ -129,6 +204,41 @@ func (t *Database) insert(e *entity) error {
 	return nil
 }
 
+// canonicalizeReferences replaces the references in e to entities which
+// share their identity with an entity in the database with references to
+// that entity.
+func (t *Database) canonicalizeReferences(e *entity) {
+	sc := t.schema
+	ti := e.getTypeInfo(sc)
+	e.attrs.forEach(func(a ordinal) (wantMore bool) {
+		if isSystemAttribute(sc.attrs[a]) || !ti.attrFields[a][0].isEntity {
+			return true
+		}
+		v := e.asMap().get(a)
+		if canonical := t.canonical(v); canonical != v {
+			e.asMap().add(a, canonical)
+		}
+		return true
+	})
+}
+
+// canonical returns the entity in the database identified by v, if v is an
+// entity sharing its identity with an entity in the database, and v
+// otherwise.
+func (t *Database) canonical(v interface{}) interface{} {
+	if e, exists := t.entities[v]; exists {
+		return e.getComparableValue(t.schema, Self)
+	}
+	ti, isEntity := t.schema.entityTypeSchemas[reflect.TypeOf(v)]
+	if !isEntity || ti.identity == nil {
+		return v
+	}
+	if e, exists := t.identities[identityKey{typ: ti.typ, key: ti.identity(v)}]; exists {
+		return e.getComparableValue(t.schema, Self)
+	}
+	return v
+}
+
 type index struct {
 	indexSpec
 	tree *btree.BTree
@@ -0,0 +1,74 @@
+// Copyright 2021 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package rel
+
+import (
+	"sync/atomic"
+
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+)
+
+// DatabaseSnapshot is an immutable snapshot of the contents of a Database.
+// It can be used to cheaply construct Databases with the same contents
+// without re-inserting the entities.
+type DatabaseSnapshot struct {
+	// mu serializes calls to Restore: cloning the underlying btrees mutates
+	// their copy-on-write contexts.
+	mu syncutil.Mutex
+	db *Database
+}
+
+// Snapshot captures the current contents of the database. Subsequent
+// insertions into the database are not reflected in the snapshot.
+//
+// The indexes of the database are copy-on-write: the snapshot and the
+// database initially share all of their nodes, which are copied lazily as
+// either side is modified. Taking a snapshot is thus cheap, though it
+// makes the next insertions into the database somewhat more expensive.
+// The entities themselves are shared; they must not be modified after having
+// been inserted, which is required of the database anyway.
+func (t *Database) Snapshot() *DatabaseSnapshot {
+	return &DatabaseSnapshot{db: t.clone()}
+}
+
+// Restore constructs a new Database with the contents of the snapshot. The
+// returned database can be queried and inserted into without affecting the
+// snapshot or other databases restored from it. It is safe to call Restore
+// concurrently.
+func (s *DatabaseSnapshot) Restore() *Database {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.db.clone()
+}
+
+// clone returns a copy-on-write copy of the database.
+func (t *Database) clone() *Database {
+	c := &Database{
+		schema:     t.schema,
+		indexes:    make([]index, len(t.indexes)),
+		entities:   make(map[interface{}]*entity, len(t.entities)),
+		identities: make(map[identityKey]*entity, len(t.identities)),
+		id:         atomic.AddUint64(&databaseIDs, 1),
+	}
+	for i := range t.indexes {
+		c.indexes[i] = index{
+			indexSpec: t.indexes[i].indexSpec,
+			tree:      t.indexes[i].tree.Clone(),
+		}
+	}
+	for k, e := range t.entities {
+		c.entities[k] = e
+	}
+	for k, e := range t.identities {
+		c.identities[k] = e
+	}
+	return c
+}
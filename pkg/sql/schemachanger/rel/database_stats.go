@@ -0,0 +1,94 @@
+// Copyright 2021 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package rel
+
+import "github.com/google/btree"
+
+// DatabaseStats summarizes the contents of a Database. It is used to
+// estimate the number of entities matching the clauses of a query; see
+// (*Query).Optimize. The statistics reflect the database at the time they
+// were collected and are not updated by later insertions.
+type DatabaseStats struct {
+	schema *Schema
+	// numEntities is the number of entities in the database.
+	numEntities int
+	// valueCounts is the number of entities with each value of each
+	// attribute.
+	valueCounts map[ordinal]map[interface{}]int
+}
+
+// Stats collects statistics about the contents of the database.
+func (t *Database) Stats() *DatabaseStats {
+	s := &DatabaseStats{
+		schema:      t.schema,
+		valueCounts: make(map[ordinal]map[interface{}]int),
+	}
+	t.indexes[0].tree.Ascend(func(i btree.Item) (wantMore bool) {
+		e := i.(*containerItem).entity
+		s.numEntities++
+		e.attrs.forEach(func(ord ordinal) (wantMore bool) {
+			tv, ok := e.getTypedValue(t.schema, ord)
+			if !ok {
+				return true
+			}
+			counts, ok := s.valueCounts[ord]
+			if !ok {
+				counts = make(map[interface{}]int)
+				s.valueCounts[ord] = counts
+			}
+			counts[tv.toInterface()]++
+			return true
+		})
+		return true
+	})
+	return s
+}
+
+// NumEntities returns the number of entities in the database.
+func (s *DatabaseStats) NumEntities() int { return s.numEntities }
+
+// Count returns the number of entities whose value for the attribute is
+// equal to the given value.
+func (s *DatabaseStats) Count(a Attr, value interface{}) (int, error) {
+	ord, err := s.schema.getOrdinal(a)
+	if err != nil {
+		return 0, err
+	}
+	return s.valueCounts[ord][value], nil
+}
+
+// estimate returns the estimated number of entities which may be bound to
+// the entity slot, given the facts constraining the values of its
+// attributes to constants.
+func (s *DatabaseStats) estimate(q *Query, entity slotIdx) int {
+	est := s.numEntities
+	for _, f := range q.facts {
+		if f.variable != entity {
+			continue
+		}
+		v := &q.slots[f.value]
+		var n int
+		switch {
+		case v.value != nil:
+			n = s.valueCounts[f.attr][v.toInterface()]
+		case v.any != nil:
+			for _, tv := range v.any {
+				n += s.valueCounts[f.attr][tv.toInterface()]
+			}
+		default:
+			continue
+		}
+		if n < est {
+			est = n
+		}
+	}
+	return est
+}
@@ -0,0 +1,112 @@
+// Copyright 2021 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package rel
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/google/btree"
+)
+
+// ValidationError is returned by (*Database).Validate. It lists all of the
+// violations found in the database.
+type ValidationError struct {
+	Violations []error
+}
+
+func (e *ValidationError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "database contains %d violation(s)", len(e.Violations))
+	for _, v := range e.Violations {
+		sb.WriteString("\n  ")
+		sb.WriteString(v.Error())
+	}
+	return sb.String()
+}
+
+// Validate checks that every entity in the database is well-formed with
+// regards to the schema. In particular, it checks that:
+//
+//   - entities have not been modified after having been inserted,
+//   - entities have values for all of their RequiredAttrs,
+//   - values of attributes which refer to other structs refer to entities
+//     of the schema of the appropriate type, and
+//   - such referenced entities exist in the database.
+//
+// All violations are reported in a *ValidationError. Such violations are
+// usually the result of bugs constructing the entities, which otherwise
+// manifest as queries silently returning fewer results than expected.
+//
+// Note that modifications of non-pointer fields after insertion cannot be
+// detected, as the database refers to their values directly.
+func (t *Database) Validate() error {
+	var violations []error
+	t.indexes[0].tree.Ascend(func(i btree.Item) (wantMore bool) {
+		violations = append(violations, t.validateEntity(i.(*containerItem).entity)...)
+		return true
+	})
+	if len(violations) == 0 {
+		return nil
+	}
+	return &ValidationError{Violations: violations}
+}
+
+// validateEntity returns the violations for the entity.
+func (t *Database) validateEntity(e *entity) (violations []error) {
+	sc := t.schema
+	self := e.getComparableValue(sc, Self)
+	addf := func(format string, args ...interface{}) {
+		violations = append(violations,
+			errors.Wrapf(errors.Errorf(format, args...), "%T(%p)", self, self))
+	}
+	cur, err := toEntity(sc, self)
+	if err != nil {
+		addf("%v", err)
+		return violations
+	}
+	defer putValues(cur.asMap())
+	t.canonicalizeReferences(cur)
+
+	if _, eq := compareEntities(cur, e); !eq {
+		var modified []Attr
+		ordinalSet.union(cur.attrs, e.attrs).forEach(func(a ordinal) (wantMore bool) {
+			if _, eq := compareOn(a, cur.asMap(), e.asMap()); !eq {
+				modified = append(modified, sc.attrs[a])
+			}
+			return true
+		})
+		addf("modified after insertion: %v", modified)
+	}
+
+	ti := cur.getTypeInfo(sc)
+	ti.required.without(cur.attrs).forEach(func(a ordinal) (wantMore bool) {
+		addf("missing required attribute %v", sc.ordinalName(a))
+		return true
+	})
+	cur.attrs.forEach(func(a ordinal) (wantMore bool) {
+		if isSystemAttribute(sc.attrs[a]) || !ti.attrFields[a][0].isEntity {
+			return true
+		}
+		v := cur.asMap().get(a)
+		if _, isEntity := sc.entityTypeSchemas[reflect.TypeOf(v)]; !isEntity {
+			addf("attribute %v refers to %T which is not an entity type", sc.ordinalName(a), v)
+		} else if err := checkType(reflect.TypeOf(v), sc.attrTypes[a]); err != nil {
+			addf("attribute %v: %v", sc.ordinalName(a), err)
+		} else if _, exists := t.entities[v]; !exists {
+			addf("attribute %v refers to %T(%p) which is not in the database", sc.ordinalName(a), v, v)
+		}
+		return true
+	})
+	return violations
+}
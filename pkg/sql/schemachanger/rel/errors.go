@@ -0,0 +1,70 @@
+// Copyright 2021 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package rel
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// UnboundVarError is returned when a variable is referenced which is not
+// bound by the query.
+type UnboundVarError struct {
+	Var Var
+	// Context describes the reference to the variable, if it is not a direct
+	// reference to a variable of the query.
+	Context string
+}
+
+func (e *UnboundVarError) Error() string {
+	if e.Context == "" {
+		return fmt.Sprintf("unknown variable %s", e.Var)
+	}
+	return fmt.Sprintf("variable %s in %s is not bound by the query", e.Var, e.Context)
+}
+
+// TypeMismatchError is returned when a value or an attribute is not of the
+// type required where it is used.
+type TypeMismatchError struct {
+	Type reflect.Type
+	// Expected describes the type which was required.
+	Expected string
+}
+
+func (e *TypeMismatchError) Error() string {
+	return fmt.Sprintf("%v is not %s", e.Type, e.Expected)
+}
+
+// FilterError is returned when the function passed to Filter is invalid.
+type FilterError struct {
+	Name string
+	Vars []Var
+	// cause describes why the function is invalid.
+	cause error
+}
+
+func (e *FilterError) Error() string { return e.cause.Error() }
+
+// Unwrap returns the underlying cause of the error.
+func (e *FilterError) Unwrap() error { return e.cause }
+
+// CrossJoinError is returned when a query evaluated with NoCrossJoins
+// contains entity variables which are not constrained relative to each
+// other.
+type CrossJoinError struct {
+	// Components are the sets of entity variables which are constrained
+	// relative to each other but not to those of the other sets.
+	Components [][]Var
+}
+
+func (e *CrossJoinError) Error() string {
+	return fmt.Sprintf("query contains a cross join between %v", e.Components)
+}
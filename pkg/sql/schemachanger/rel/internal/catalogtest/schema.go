@@ -0,0 +1,97 @@
+// Copyright 2021 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package catalogtest
+
+import (
+	"reflect"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/schemachanger/rel"
+)
+
+type table struct {
+	TableID uint32      `yaml:"tableID"`
+	Name    string      `yaml:"name"`
+	Dropped droppedFlag `yaml:"dropped"`
+}
+
+// droppedFlag is used to exercise bool attributes of named types.
+type droppedFlag bool
+
+type column struct {
+	TableID  uint32     `yaml:"tableID"`
+	ColumnID uint32     `yaml:"columnID"`
+	Name     string     `yaml:"name"`
+	Alias    *string    `yaml:"alias"`
+	OldName  *string    `yaml:"oldName"`
+	Hidden   bool       `yaml:"hidden"`
+	Kind     columnKind `yaml:"kind"`
+}
+
+// columnKind is used to exercise attributes backed by integer-coded enums.
+type columnKind int8
+
+const (
+	regularColumn columnKind = iota
+	computedColumn
+	virtualColumn
+)
+
+type index struct {
+	TableID         uint32   `yaml:"tableID"`
+	IndexID         uint32   `yaml:"indexID"`
+	Name            string   `yaml:"name"`
+	KeyColumnIDs    []uint32 `yaml:"keyColumnIDs"`
+	StoredColumnIDs []uint32 `yaml:"storedColumnIDs"`
+}
+
+// testAttr is a rel.Attr used for testing.
+type testAttr int8
+
+var _ rel.Attr = testAttr(0)
+
+//go:generate stringer --type testAttr  --tags test
+const (
+	tableID testAttr = iota
+	columnID
+	name
+	alias
+	oldName
+	dropped
+	hidden
+	kind
+	indexID
+	keyColumnIDs
+	storedColumnIDs
+)
+
+var schema = rel.MustSchema("testschema",
+	rel.EntityMapping(reflect.TypeOf((*table)(nil)),
+		rel.EntityAttr(tableID, "TableID"),
+		rel.EntityAttr(name, "Name"),
+		rel.EntityAttr(dropped, "Dropped"),
+	),
+	rel.EntityMapping(reflect.TypeOf((*column)(nil)),
+		rel.EntityAttr(tableID, "TableID"),
+		rel.EntityAttr(columnID, "ColumnID"),
+		rel.EntityAttr(name, "Name"),
+		rel.EntityAttr(alias, "Alias"),
+		rel.EntityAttr(oldName, "OldName"),
+		rel.EntityAttr(hidden, "Hidden"),
+		rel.EntityAttr(kind, "Kind"),
+	),
+	rel.EntityMapping(reflect.TypeOf((*index)(nil)),
+		rel.EntityAttr(tableID, "TableID"),
+		rel.EntityAttr(indexID, "IndexID"),
+		rel.EntityAttr(name, "Name"),
+		rel.EntityAttr(keyColumnIDs, "KeyColumnIDs"),
+		rel.EntityAttr(storedColumnIDs, "StoredColumnIDs"),
+	),
+)
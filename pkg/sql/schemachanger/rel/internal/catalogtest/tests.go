@@ -0,0 +1,1162 @@
+// Copyright 2021 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package catalogtest
+
+import (
+	"github.com/cockroachdb/cockroach/pkg/sql/schemachanger/rel"
+	"github.com/cockroachdb/cockroach/pkg/sql/schemachanger/rel/reltest"
+)
+
+type v = rel.Var
+
+var (
+	// Suite defines the catalog test suite.
+	Suite = reltest.Suite{
+		Name:           "catalog",
+		Schema:         schema,
+		Registry:       r,
+		DatabaseTests:  databaseTests,
+		AttributeTests: attributeCases,
+	}
+
+	r   = reltest.NewRegistry()
+	t1  = r.FromYAML("t1", `{tableID: 1, name: t}`, &table{}).(*table)
+	t1a = r.FromYAML("t1a", `{tableID: 1, columnID: 1, name: a, alias: a}`, &column{}).(*column)
+	t1b = r.FromYAML("t1b", `{tableID: 1, columnID: 2, name: b, alias: x, oldName: b}`, &column{}).(*column)
+	t1c = r.FromYAML("t1c", `{tableID: 1, columnID: 3, name: c, alias: d, oldName: e, hidden: true, kind: 1}`, &column{}).(*column)
+	t1d = r.FromYAML("t1d", `{tableID: 1, columnID: 4, name: d}`, &column{}).(*column)
+	t1e = r.FromYAML("t1e", `{tableID: 1, columnID: 5, name: a}`, &column{}).(*column)
+	t1f = r.FromYAML("t1f", `{tableID: 1, columnID: 6, name: f, alias: ""}`, &column{}).(*column)
+	t2  = r.FromYAML("t2", `{tableID: 2, name: u, dropped: true}`, &table{}).(*table)
+	t2a = r.FromYAML("t2a", `{tableID: 2, columnID: 1, name: a, kind: 7}`, &column{}).(*column)
+	t3a = r.FromYAML("t3a", `{tableID: 3, columnID: 1, name: a}`, &column{}).(*column)
+
+	t1i1 = r.FromYAML("t1i1", `{tableID: 1, indexID: 1, name: none}`, &index{}).(*index)
+	t1i2 = r.FromYAML("t1i2", `{tableID: 1, indexID: 2, name: empty, keyColumnIDs: []}`, &index{}).(*index)
+	t1i3 = r.FromYAML("t1i3", `{tableID: 1, indexID: 3, name: a, keyColumnIDs: [1]}`, &index{}).(*index)
+	t1i4 = r.FromYAML("t1i4", `{tableID: 1, indexID: 4, name: ab, keyColumnIDs: [1, 2]}`, &index{}).(*index)
+	t1i5 = r.FromYAML("t1i5", `{tableID: 1, indexID: 5, name: ba, keyColumnIDs: [2, 1]}`, &index{}).(*index)
+	t1i6 = r.FromYAML("t1i6", `{tableID: 1, indexID: 6, name: abc, keyColumnIDs: [1, 2, 3]}`, &index{}).(*index)
+
+	t1i7  = r.FromYAML("t1i7", `{tableID: 1, indexID: 7, name: storing_none}`, &index{}).(*index)
+	t1i8  = r.FromYAML("t1i8", `{tableID: 1, indexID: 8, name: storing_empty, storedColumnIDs: []}`, &index{}).(*index)
+	t1i9  = r.FromYAML("t1i9", `{tableID: 1, indexID: 9, name: storing_ac, storedColumnIDs: [1, 3]}`, &index{}).(*index)
+	t1i10 = r.FromYAML("t1i10", `{tableID: 1, indexID: 10, name: storing_c, storedColumnIDs: [3]}`, &index{}).(*index)
+
+	databaseTests = []reltest.DatabaseTest{
+		{
+			Data: []string{"t1", "t1a", "t1b", "t1c", "t1d", "t2", "t2a"},
+			Indexes: [][][]rel.Attr{
+				nil,
+				{{tableID, columnID}, {name}},
+			},
+			QueryCases: []reltest.QueryTest{
+				{
+					Name: "name equals alias or old name",
+					Query: rel.Clauses{
+						v("c").Type((*column)(nil)),
+						v("c").AttrEqAnyAttr(name, alias, oldName),
+					},
+					Entities: []v{"c"},
+					ResVars:  []v{"c"},
+					Results: [][]interface{}{
+						{t1a}, {t1b},
+					},
+				},
+				{
+					Name: "name equals alias",
+					Query: rel.Clauses{
+						v("c").Type((*column)(nil)),
+						v("c").AttrEqAnyAttr(name, alias),
+					},
+					Entities: []v{"c"},
+					ResVars:  []v{"c"},
+					Results: [][]interface{}{
+						{t1a},
+					},
+				},
+				{
+					Name: "name equals old name",
+					Query: rel.Clauses{
+						v("c").Type((*column)(nil)),
+						v("c").AttrEqAnyAttr(name, oldName),
+					},
+					Entities: []v{"c"},
+					ResVars:  []v{"c"},
+					Results: [][]interface{}{
+						{t1b},
+					},
+				},
+				{
+					Name: "alias equals old name",
+					Query: rel.Clauses{
+						v("c").Type((*column)(nil)),
+						v("c").AttrEqAnyAttr(alias, oldName),
+					},
+					Entities: []v{"c"},
+					ResVars:  []v{"c"},
+					Results:  [][]interface{}{},
+				},
+				{
+					Name: "name equals incomparable attribute",
+					Query: rel.Clauses{
+						v("c").AttrEqAnyAttr(name, alias, columnID),
+					},
+					ErrorRE: `failed to process invalid clause \$c\[name\] IN \[\$c\[alias\], \$c\[columnID\]\]: string is not comparable to uint32`,
+				},
+				{
+					Name: "table ID in subquery results",
+					Query: rel.Clauses{
+						v("c").Type((*column)(nil)),
+						v("c").AttrInResult(tableID, rel.And(
+							v("t").Type((*table)(nil)),
+							v("t").AttrEq(name, "t"),
+							v("t").AttrEqVar(tableID, "id"),
+						), "id"),
+					},
+					Entities: []v{"c"},
+					ResVars:  []v{"c"},
+					Results: [][]interface{}{
+						{t1a}, {t1b}, {t1c}, {t1d},
+					},
+				},
+				{
+					Name: "table ID in explicit join",
+					Query: rel.Clauses{
+						v("t").Type((*table)(nil)),
+						v("t").AttrEq(name, "t"),
+						v("t").AttrEqVar(tableID, "id"),
+						v("c").Type((*column)(nil)),
+						v("c").AttrEqVar(tableID, "id"),
+					},
+					Entities: []v{"t", "c"},
+					ResVars:  []v{"c"},
+					Results: [][]interface{}{
+						{t1a}, {t1b}, {t1c}, {t1d},
+					},
+				},
+				{
+					Name: "subquery variables are scoped to the subquery",
+					Query: rel.Clauses{
+						v("t").Type((*column)(nil)),
+						v("t").AttrEq(name, "a"),
+						v("t").AttrInResult(tableID, rel.And(
+							v("t").Type((*table)(nil)),
+							v("t").AttrEqVar(tableID, "id"),
+						), "id"),
+					},
+					Entities: []v{"t"},
+					ResVars:  []v{"t"},
+					Results: [][]interface{}{
+						{t1a}, {t2a},
+					},
+				},
+				{
+					Name: "empty subquery results",
+					Query: rel.Clauses{
+						v("c").Type((*column)(nil)),
+						v("c").AttrInResult(tableID, rel.And(
+							v("t").Type((*table)(nil)),
+							v("t").AttrEq(name, "v"),
+							v("t").AttrEqVar(tableID, "id"),
+						), "id"),
+					},
+					Entities: []v{"c"},
+					ResVars:  []v{"c"},
+					Results:  [][]interface{}{},
+				},
+				{
+					Name: "subquery variable not in subquery",
+					Query: rel.Clauses{
+						v("c").AttrInResult(tableID, v("t").AttrEqVar(tableID, "id"), "other"),
+					},
+					ErrorRE: `failed to process invalid clause \$c\[tableID\] IN subquery\(\$other\)(.|\n)*: variable other is not used in the subquery`,
+				},
+				{
+					Name: "negated disjunction",
+					Query: rel.Clauses{
+						v("c").Type((*column)(nil)),
+						v("c").AttrEq(tableID, uint32(1)),
+						rel.Not(rel.Or(
+							v("c").AttrEq(name, "a"),
+							v("c").AttrEq(name, "b"),
+						)),
+					},
+					Entities: []v{"c"},
+					ResVars:  []v{"c"},
+					Results: [][]interface{}{
+						{t1c}, {t1d},
+					},
+				},
+				{
+					Name: "conjunction of negations",
+					Query: rel.Clauses{
+						v("c").Type((*column)(nil)),
+						v("c").AttrEq(tableID, uint32(1)),
+						rel.And(
+							rel.Not(v("c").AttrEq(name, "a")),
+							rel.Not(v("c").AttrEq(name, "b")),
+						),
+					},
+					Entities: []v{"c"},
+					ResVars:  []v{"c"},
+					Results: [][]interface{}{
+						{t1c}, {t1d},
+					},
+				},
+				{
+					Name: "negated disjunction over bound variables",
+					Query: rel.Clauses{
+						v("c").Type((*column)(nil)),
+						v("c").AttrEqVar(name, "n"),
+						rel.Not(rel.Or(
+							v("c").AttrEqVar(alias, "n"),
+							v("c").AttrEqVar(oldName, "n"),
+						)),
+					},
+					Entities: []v{"c"},
+					ResVars:  []v{"c", "n"},
+					Results: [][]interface{}{
+						{t1c, "c"}, {t1d, "d"}, {t2a, "a"},
+					},
+				},
+				{
+					Name: "negated disjunction with unbound variable",
+					Query: rel.Clauses{
+						v("c").Type((*column)(nil)),
+						rel.Not(rel.Or(
+							v("c").AttrEq(name, "a"),
+							v("c").AttrEqVar(name, "n"),
+						)),
+					},
+					ErrorRE: `variable n in negated clause is not bound by the query`,
+				},
+				{
+					Name: "disjunction not beneath negation",
+					Query: rel.Clauses{
+						rel.Or(
+							v("c").AttrEq(name, "a"),
+							v("c").AttrEq(name, "b"),
+						),
+					},
+					ErrorRE: `disjunctions are only supported directly beneath Not`,
+				},
+				{
+					Name: "hidden columns",
+					Query: rel.Clauses{
+						v("c").Type((*column)(nil)),
+						v("c").AttrTrue(hidden),
+					},
+					Entities: []v{"c"},
+					ResVars:  []v{"c"},
+					Results: [][]interface{}{
+						{t1c},
+					},
+				},
+				{
+					Name: "visible columns",
+					Query: rel.Clauses{
+						v("c").Type((*column)(nil)),
+						v("c").AttrFalse(hidden),
+					},
+					Entities: []v{"c"},
+					ResVars:  []v{"c"},
+					Results: [][]interface{}{
+						{t1a}, {t1b}, {t1d}, {t2a},
+					},
+				},
+				{
+					Name: "dropped tables",
+					Query: rel.Clauses{
+						v("t").AttrTrue(dropped),
+					},
+					Entities: []v{"t"},
+					ResVars:  []v{"t"},
+					Results: [][]interface{}{
+						{t2},
+					},
+				},
+				{
+					Name: "public tables",
+					Query: rel.Clauses{
+						v("t").AttrFalse(dropped),
+					},
+					Entities: []v{"t"},
+					ResVars:  []v{"t"},
+					Results: [][]interface{}{
+						{t1},
+					},
+				},
+				{
+					Name: "true on non-bool attribute",
+					Query: rel.Clauses{
+						v("t").AttrTrue(name),
+					},
+					ErrorRE: `failed to process invalid clause \$t\[name\] = true: string is not a bool`,
+				},
+				{
+					Name: "names with prefix",
+					Query: rel.Clauses{
+						v("c").Type((*column)(nil)),
+						v("c").AttrHasPrefix(name, "a"),
+					},
+					Entities: []v{"c"},
+					ResVars:  []v{"c"},
+					Results: [][]interface{}{
+						{t1a}, {t2a},
+					},
+				},
+				{
+					Name: "names without prefix",
+					Query: rel.Clauses{
+						v("c").Type((*column)(nil)),
+						v("c").AttrHasPrefix(name, "aa"),
+					},
+					Entities: []v{"c"},
+					ResVars:  []v{"c"},
+					Results:  [][]interface{}{},
+				},
+				{
+					Name: "names with empty prefix",
+					Query: rel.Clauses{
+						v("c").Type((*column)(nil)),
+						v("c").AttrHasPrefix(name, ""),
+					},
+					Entities: []v{"c"},
+					ResVars:  []v{"c"},
+					Results: [][]interface{}{
+						{t1a}, {t1b}, {t1c}, {t1d}, {t2a},
+					},
+				},
+				{
+					Name: "old names with prefix",
+					Query: rel.Clauses{
+						v("c").Type((*column)(nil)),
+						v("c").AttrHasPrefix(oldName, ""),
+					},
+					Entities: []v{"c"},
+					ResVars:  []v{"c"},
+					Results: [][]interface{}{
+						{t1b}, {t1c},
+					},
+				},
+				{
+					Name: "prefix on non-string attribute",
+					Query: rel.Clauses{
+						v("c").AttrHasPrefix(columnID, "1"),
+					},
+					ErrorRE: `failed to process invalid clause \$c\[columnID\] HAS PREFIX "1": uint32 is not a string`,
+				},
+				{
+					Name: "columns per table within max fanout",
+					Query: rel.Clauses{
+						v("t").Type((*table)(nil)),
+						v("t").AttrEqVar(tableID, "id"),
+						rel.MaxFanout(4, rel.And(
+							v("c").Type((*column)(nil)),
+							v("c").AttrEqVar(tableID, "id"),
+						)),
+					},
+					Entities: []v{"t", "c"},
+					ResVars:  []v{"t", "c"},
+					Results: [][]interface{}{
+						{t1, t1a}, {t1, t1b}, {t1, t1c}, {t1, t1d}, {t2, t2a},
+					},
+				},
+				{
+					Name: "columns per table exceeds max fanout",
+					Query: rel.Clauses{
+						v("t").Type((*table)(nil)),
+						v("t").AttrEqVar(tableID, "id"),
+						rel.MaxFanout(3, rel.And(
+							v("c").Type((*column)(nil)),
+							v("c").AttrEqVar(tableID, "id"),
+						)),
+					},
+					ErrorRE: `bindings of \[c\] exceeded max fanout of 3`,
+				},
+				{
+					Name: "max fanout without entities",
+					Query: rel.Clauses{
+						v("t").Type((*table)(nil)),
+						rel.MaxFanout(1, v("t").AttrEqVar(tableID, "id")),
+					},
+					ErrorRE: `clause wrapped by MaxFanout introduces no entities`,
+				},
+				{
+					Name: "alias equals name of other column",
+					Query: rel.Clauses{
+						v("c1").Type((*column)(nil)),
+						v("c1").AttrEqOtherAttr(alias, "c2", name),
+						v("c2").Type((*column)(nil)),
+					},
+					Entities: []v{"c1", "c2"},
+					ResVars:  []v{"c1", "c2"},
+					Results: [][]interface{}{
+						{t1a, t1a}, {t1a, t2a}, {t1c, t1d},
+					},
+				},
+				{
+					Name: "column table ID equals table ID",
+					Query: rel.Clauses{
+						v("t").AttrEq(name, "u"),
+						v("t").AttrEqOtherAttr(tableID, "c", tableID),
+						v("c").Type((*column)(nil)),
+					},
+					Entities: []v{"t", "c"},
+					ResVars:  []v{"t", "c"},
+					Results: [][]interface{}{
+						{t2, t2a},
+					},
+				},
+				{
+					Name: "other attribute of incomparable type",
+					Query: rel.Clauses{
+						v("c1").AttrEqOtherAttr(name, "c2", columnID),
+					},
+					ErrorRE: `failed to process invalid clause \$c1\[name\] = \$c2\[columnID\]: string is not comparable to uint32`,
+				},
+				{
+					Name: "one of entities",
+					Query: rel.Clauses{
+						v("c").OneOfEntities(t1a, t2a, t2),
+						v("c").Type((*column)(nil)),
+					},
+					Entities: []v{"c"},
+					ResVars:  []v{"c"},
+					Results: [][]interface{}{
+						{t1a}, {t2a},
+					},
+				},
+				{
+					Name: "one of entities joined on attributes",
+					Query: rel.Clauses{
+						v("c").OneOfEntities(t1b, t2a),
+						v("c").AttrEqVar(tableID, "id"),
+						v("t").Type((*table)(nil)),
+						v("t").AttrEqVar(tableID, "id"),
+					},
+					Entities: []v{"c", "t"},
+					ResVars:  []v{"c", "t"},
+					Results: [][]interface{}{
+						{t1b, t1}, {t2a, t2},
+					},
+				},
+				{
+					Name: "one of unknown entities",
+					Query: rel.Clauses{
+						v("c").OneOfEntities(t3a),
+					},
+					Entities: []v{"c"},
+					ResVars:  []v{"c"},
+					Results:  [][]interface{}{},
+				},
+				{
+					Name: "contradictory literals",
+					Query: rel.Clauses{
+						v("c").AttrEq(name, "a"),
+						v("c").AttrEq(name, "b"),
+					},
+					ErrorRE: `query contains contradiction on name`,
+				},
+				{
+					Name: "disjoint sets of values",
+					Query: rel.Clauses{
+						v("c").AttrIn(name, "a", "b"),
+						v("c").AttrIn(name, "c", "d"),
+					},
+					ErrorRE: `query contains contradiction on name: no value is permitted by all clauses`,
+				},
+				{
+					Name: "literal not in set of values",
+					Query: rel.Clauses{
+						v("c").AttrEq(columnID, uint32(1)),
+						v("c").AttrIn(columnID, uint32(2), uint32(3)),
+					},
+					ErrorRE: `query contains contradiction on columnID`,
+				},
+				{
+					Name: "intersecting sets of values",
+					Query: rel.Clauses{
+						v("c").Type((*column)(nil)),
+						v("c").AttrIn(name, "a", "b", "c"),
+						v("c").AttrIn(name, "b", "c", "d"),
+					},
+					Entities: []v{"c"},
+					ResVars:  []v{"c"},
+					Results: [][]interface{}{
+						{t1b}, {t1c},
+					},
+				},
+				{
+					Name: "columns with valid kinds",
+					Query: rel.Clauses{
+						v("c").Type((*column)(nil)),
+						v("c").AttrValidEnum(kind, regularColumn, computedColumn, virtualColumn),
+					},
+					Entities: []v{"c"},
+					ResVars:  []v{"c"},
+					Results: [][]interface{}{
+						{t1a}, {t1b}, {t1c}, {t1d},
+					},
+				},
+				{
+					Name: "columns with invalid kinds",
+					Query: rel.Clauses{
+						v("c").Type((*column)(nil)),
+						rel.Not(v("c").AttrValidEnum(kind, regularColumn, computedColumn, virtualColumn)),
+					},
+					Entities: []v{"c"},
+					ResVars:  []v{"c"},
+					Results: [][]interface{}{
+						{t2a},
+					},
+				},
+				{
+					Name: "enum values of the wrong type",
+					Query: rel.Clauses{
+						v("c").AttrValidEnum(kind, 0, 1, 2),
+					},
+					ErrorRE: `int is not catalogtest.columnKind`,
+				},
+				{
+					Name: "highest column ID in each table",
+					Query: rel.Clauses{
+						v("c").IsMaxOf(columnID, rel.And(
+							v("c").Type((*column)(nil)),
+							v("c").AttrEqVar(tableID, "id"),
+						)),
+					},
+					Entities: []v{"c"},
+					ResVars:  []v{"c", "id"},
+					Results: [][]interface{}{
+						{t1d, uint32(1)},
+						{t2a, uint32(2)},
+					},
+				},
+				{
+					Name: "lowest column ID in each table",
+					Query: rel.Clauses{
+						v("c").IsMinOf(columnID, rel.And(
+							v("c").Type((*column)(nil)),
+							v("c").AttrEqVar(tableID, "id"),
+						)),
+					},
+					Entities: []v{"c"},
+					ResVars:  []v{"c", "id"},
+					Results: [][]interface{}{
+						{t1a, uint32(1)},
+						{t2a, uint32(2)},
+					},
+				},
+				{
+					Name: "highest column ID across tables",
+					Query: rel.Clauses{
+						v("c").IsMaxOf(columnID, v("c").Type((*column)(nil))),
+					},
+					Entities: []v{"c"},
+					ResVars:  []v{"c"},
+					Results: [][]interface{}{
+						{t1d},
+					},
+				},
+				{
+					Name: "highest column ID of a named table",
+					Query: rel.Clauses{
+						v("t").AttrEq(name, "t"),
+						v("t").AttrEqVar(tableID, "id"),
+						v("c").IsMaxOf(columnID, rel.And(
+							v("c").Type((*column)(nil)),
+							v("c").AttrEqVar(tableID, "id"),
+						)),
+					},
+					Entities: []v{"t", "c"},
+					ResVars:  []v{"t", "c"},
+					Results: [][]interface{}{
+						{t1, t1d},
+					},
+				},
+				{
+					// All the columns sharing the minimum kind match.
+					Name: "ties on the lowest kind",
+					Query: rel.Clauses{
+						v("c").IsMinOf(kind, rel.And(
+							v("c").Type((*column)(nil)),
+							v("c").AttrEq(tableID, uint32(1)),
+						)),
+					},
+					Entities: []v{"c"},
+					ResVars:  []v{"c"},
+					Results: [][]interface{}{
+						{t1a}, {t1b}, {t1d},
+					},
+				},
+				{
+					Name: "highest kind with ties below",
+					Query: rel.Clauses{
+						v("c").IsMaxOf(kind, rel.And(
+							v("c").Type((*column)(nil)),
+							v("c").AttrEq(tableID, uint32(1)),
+						)),
+					},
+					Entities: []v{"c"},
+					ResVars:  []v{"c"},
+					Results: [][]interface{}{
+						{t1c},
+					},
+				},
+				{
+					Name: "columns preceding a column in the same table",
+					Query: rel.Clauses{
+						v("c").AttrEq(name, "c"),
+						v("c").AttrEqVar(tableID, "id"),
+						v("p").AttrEqVar(tableID, "id"),
+						v("p").AttrLtVar(columnID, "c", columnID),
+					},
+					Entities: []v{"c", "p"},
+					ResVars:  []v{"p", "c"},
+					Results: [][]interface{}{
+						{t1a, t1c},
+						{t1b, t1c},
+					},
+				},
+				{
+					Name: "inequality join of incomparable attributes",
+					Query: rel.Clauses{
+						v("c").AttrEq(name, "c"),
+						v("p").AttrGeVar(columnID, "c", name),
+					},
+					ErrorRE: `uint32 is not comparable to string`,
+				},
+				{
+					// Tables have no kind at all, and so do not match.
+					Name: "entities with the zero kind",
+					Query: rel.Clauses{
+						v("c").AttrIsZero(kind),
+					},
+					Entities: []v{"c"},
+					ResVars:  []v{"c"},
+					Results: [][]interface{}{
+						{t1a}, {t1b}, {t1d},
+					},
+				},
+				{
+					Name: "extremum of an unordered attribute",
+					Query: rel.Clauses{
+						v("c").IsMaxOf(hidden, v("c").Type((*column)(nil))),
+					},
+					ErrorRE: `bool is not an ordered type`,
+				},
+				{
+					Name: "captured kinds in a set",
+					Query: rel.Clauses{
+						v("c").AttrCapture(kind, "k", rel.ValueIn(computedColumn, columnKind(7))),
+					},
+					Entities: []v{"c"},
+					ResVars:  []v{"c", "k"},
+					Results: [][]interface{}{
+						{t1c, computedColumn}, {t2a, columnKind(7)},
+					},
+				},
+				{
+					Name: "captured column IDs in a range",
+					Query: rel.Clauses{
+						v("c").AttrCapture(columnID, "id", rel.ValueBetween(uint32(2), uint32(4))),
+					},
+					Entities: []v{"c"},
+					ResVars:  []v{"c", "id"},
+					Results: [][]interface{}{
+						{t1b, uint32(2)}, {t1c, uint32(3)}, {t1d, uint32(4)},
+					},
+				},
+				{
+					// The captured table ID is used to join with the table.
+					Name: "captured table IDs of dropped tables",
+					Query: rel.Clauses{
+						v("c").Type((*column)(nil)),
+						v("c").AttrCapture(tableID, "id", rel.ValueIn(uint32(1), uint32(2))),
+						v("t").Type((*table)(nil)),
+						v("t").AttrEqVar(tableID, "id"),
+						v("t").AttrEq(dropped, droppedFlag(true)),
+					},
+					Entities: []v{"c", "t"},
+					ResVars:  []v{"c", "id"},
+					Results: [][]interface{}{
+						{t2a, uint32(2)},
+					},
+				},
+			},
+		},
+		{
+			Data: []string{"t1i1", "t1i2", "t1i3", "t1i4", "t1i5", "t1i6"},
+			Indexes: [][][]rel.Attr{
+				nil,
+				{{keyColumnIDs}},
+			},
+			QueryCases: []reltest.QueryTest{
+				{
+					// Nil and empty slices both have a length of zero.
+					Name: "no key columns",
+					Query: rel.Clauses{
+						v("i").AttrLenEq(keyColumnIDs, 0),
+					},
+					Entities: []v{"i"},
+					ResVars:  []v{"i"},
+					Results: [][]interface{}{
+						{t1i1}, {t1i2},
+					},
+				},
+				{
+					Name: "exact number of key columns",
+					Query: rel.Clauses{
+						v("i").AttrLenEq(keyColumnIDs, 2),
+					},
+					Entities: []v{"i"},
+					ResVars:  []v{"i"},
+					Results: [][]interface{}{
+						{t1i4}, {t1i5},
+					},
+				},
+				{
+					Name: "no such number of key columns",
+					Query: rel.Clauses{
+						v("i").AttrLenEq(keyColumnIDs, 4),
+					},
+					Entities: []v{"i"},
+					ResVars:  []v{"i"},
+					Results:  [][]interface{}{},
+				},
+				{
+					Name: "differing numbers of key columns",
+					Query: rel.Clauses{
+						v("i").AttrLenEq(keyColumnIDs, 1),
+						v("i").AttrLenEq(keyColumnIDs, 3),
+					},
+					Entities: []v{"i"},
+					ResVars:  []v{"i"},
+					Results:  [][]interface{}{},
+				},
+				{
+					Name: "equal key columns",
+					Query: rel.Clauses{
+						v("i").AttrEq(keyColumnIDs, []uint32{1, 2}),
+					},
+					Entities: []v{"i"},
+					ResVars:  []v{"i"},
+					Results: [][]interface{}{
+						{t1i4},
+					},
+				},
+				{
+					Name: "key columns in a set",
+					Query: rel.Clauses{
+						v("i").AttrIn(keyColumnIDs, []uint32{1}, []uint32{2, 1}),
+					},
+					Entities: []v{"i"},
+					ResVars:  []v{"i"},
+					Results: [][]interface{}{
+						{t1i3}, {t1i5},
+					},
+				},
+				{
+					Name: "key columns of an index",
+					Query: rel.Clauses{
+						v("i").AttrEq(name, "ba"),
+						v("i").AttrEqVar(keyColumnIDs, "keys"),
+					},
+					Entities: []v{"i"},
+					ResVars:  []v{"i", "keys"},
+					Results: [][]interface{}{
+						{t1i5, []uint32{2, 1}},
+					},
+				},
+				{
+					Name: "length of a non-slice attribute",
+					Query: rel.Clauses{
+						v("i").AttrLenEq(name, 1),
+					},
+					ErrorRE: `string is not a slice`,
+				},
+				{
+					Name: "negative length",
+					Query: rel.Clauses{
+						v("i").AttrLenEq(keyColumnIDs, -1),
+					},
+					ErrorRE: `invalid length -1`,
+				},
+			},
+		},
+		{
+			Data: []string{"t1a", "t1b", "t1e", "t2a"},
+			QueryCases: []reltest.QueryTest{
+				{
+					Name: "columns with the same name",
+					Query: rel.Clauses{
+						rel.SamePropertyDifferentEntity(name, "x", "y"),
+					},
+					Entities: []v{"x", "y"},
+					ResVars:  []v{"x", "y"},
+					Results: [][]interface{}{
+						{t1a, t1e}, {t1a, t2a},
+						{t1e, t1a}, {t1e, t2a},
+						{t2a, t1a}, {t2a, t1e},
+					},
+				},
+				{
+					Name: "columns with the same name in a table",
+					Query: rel.Clauses{
+						rel.SamePropertyDifferentEntity(name, "x", "y"),
+						v("x").AttrEqOtherAttr(tableID, "y", tableID),
+					},
+					Entities: []v{"x", "y"},
+					ResVars:  []v{"x", "y"},
+					Results: [][]interface{}{
+						{t1a, t1e}, {t1e, t1a},
+					},
+				},
+				{
+					// Columns are identified by their table and column IDs.
+					Name: "columns with the same identity",
+					Query: rel.Clauses{
+						rel.SamePropertyDifferentEntity(name, "x", "y"),
+						rel.SamePropertyDifferentEntity(columnID, "x", "y"),
+						v("x").AttrEqOtherAttr(tableID, "y", tableID),
+					},
+					Entities: []v{"x", "y"},
+					ResVars:  []v{"x", "y"},
+					Results:  [][]interface{}{},
+				},
+			},
+		},
+		{
+			Data: []string{"t1a", "t1b", "t1c", "t1i7", "t1i8", "t1i9", "t1i10"},
+			QueryCases: []reltest.QueryTest{
+				{
+					Name: "columns stored in indexes",
+					Query: rel.Clauses{
+						v("c").AttrInOtherSlice(columnID, "i", storedColumnIDs),
+						v("c").Type((*column)(nil)),
+						v("i").Type((*index)(nil)),
+					},
+					Entities: []v{"c", "i"},
+					ResVars:  []v{"c", "i"},
+					Results: [][]interface{}{
+						{t1a, t1i9}, {t1c, t1i9}, {t1c, t1i10},
+					},
+				},
+				{
+					Name: "columns not stored in indexes",
+					Query: rel.Clauses{
+						rel.Not(v("c").AttrInOtherSlice(columnID, "i", storedColumnIDs)),
+						v("c").Type((*column)(nil)),
+						v("i").Type((*index)(nil)),
+					},
+					Entities: []v{"c", "i"},
+					ResVars:  []v{"c", "i"},
+					Results: [][]interface{}{
+						{t1a, t1i7}, {t1a, t1i8}, {t1a, t1i10},
+						{t1b, t1i7}, {t1b, t1i8}, {t1b, t1i9}, {t1b, t1i10},
+						{t1c, t1i7}, {t1c, t1i8},
+					},
+				},
+				{
+					Name: "columns stored in indexes storing no columns",
+					Query: rel.Clauses{
+						v("c").AttrInOtherSlice(columnID, "i", storedColumnIDs),
+						v("i").AttrLenEq(storedColumnIDs, 0),
+						v("c").Type((*column)(nil)),
+						v("i").Type((*index)(nil)),
+					},
+					Entities: []v{"c", "i"},
+					ResVars:  []v{"c", "i"},
+					Results:  [][]interface{}{},
+				},
+				{
+					Name: "column in a non-slice attribute",
+					Query: rel.Clauses{
+						v("c").AttrInOtherSlice(columnID, "i", name),
+					},
+					ErrorRE: `string is not a slice`,
+				},
+				{
+					Name: "column in a slice of another type",
+					Query: rel.Clauses{
+						v("c").AttrInOtherSlice(name, "i", storedColumnIDs),
+					},
+					ErrorRE: `string is not comparable to uint32`,
+				},
+			},
+		},
+		{
+			Data: []string{"t1", "t2", "t1i1"},
+			QueryCases: []reltest.QueryTest{
+				{
+					Name: "entities of the same type",
+					Query: rel.Clauses{
+						rel.SameType("x", "y"),
+					},
+					Entities: []v{"x", "y"},
+					ResVars:  []v{"x", "y"},
+					Results: [][]interface{}{
+						{t1, t1}, {t1, t2}, {t2, t1}, {t2, t2},
+						{t1i1, t1i1},
+					},
+				},
+				{
+					Name: "distinct entities of the same type",
+					Query: rel.Clauses{
+						rel.SameType("x", "y"),
+						rel.Not(v("x").AttrEqVar(rel.Self, "y")),
+					},
+					Entities: []v{"x", "y"},
+					ResVars:  []v{"x", "y"},
+					Results: [][]interface{}{
+						{t1, t2}, {t2, t1},
+					},
+				},
+				{
+					// Constraining the vars to differing types is found to be a
+					// contradiction when building the query.
+					Name: "entities of the same type constrained to differing types",
+					Query: rel.Clauses{
+						v("x").Type((*table)(nil)),
+						v("y").Type((*index)(nil)),
+						rel.SameType("x", "y"),
+					},
+					ErrorRE: `query contains contradiction on Type`,
+				},
+				{
+					Name: "entities of differing types",
+					Query: rel.Clauses{
+						v("x").Type((*index)(nil)),
+						v("y").Type((*table)(nil)),
+						rel.Not(rel.SameType("x", "y")),
+					},
+					Entities: []v{"x", "y"},
+					ResVars:  []v{"x", "y"},
+					Results: [][]interface{}{
+						{t1i1, t1}, {t1i1, t2},
+					},
+				},
+			},
+		},
+		{
+			Data: []string{"t1", "t1a", "t1b", "t1c", "t1d", "t1f"},
+			QueryCases: []reltest.QueryTest{
+				{
+					Name: "alias in a value or unset",
+					Query: rel.Clauses{
+						v("x").Type((*column)(nil)),
+						v("x").AttrInOrUnset(alias, "x"),
+					},
+					Entities: []v{"x"},
+					ResVars:  []v{"x"},
+					Results: [][]interface{}{
+						{t1b}, {t1d},
+					},
+				},
+				{
+					Name: "alias in values or unset",
+					Query: rel.Clauses{
+						v("x").Type((*column)(nil)),
+						v("x").AttrInOrUnset(alias, "x", "d"),
+					},
+					Entities: []v{"x"},
+					ResVars:  []v{"x"},
+					Results: [][]interface{}{
+						{t1b}, {t1c}, {t1d},
+					},
+				},
+				{
+					// The zero value only matches if it is listed.
+					Name: "alias in the zero value or unset",
+					Query: rel.Clauses{
+						v("x").Type((*column)(nil)),
+						v("x").AttrInOrUnset(alias, ""),
+					},
+					Entities: []v{"x"},
+					ResVars:  []v{"x"},
+					Results: [][]interface{}{
+						{t1d}, {t1f},
+					},
+				},
+				{
+					Name: "alias in a missing value or unset",
+					Query: rel.Clauses{
+						v("x").Type((*column)(nil)),
+						v("x").AttrInOrUnset(alias, "z"),
+					},
+					Entities: []v{"x"},
+					ResVars:  []v{"x"},
+					Results: [][]interface{}{
+						{t1d},
+					},
+				},
+				{
+					// Non-pointer fields always have a value, which may be the zero value.
+					Name: "kind in the zero value or unset",
+					Query: rel.Clauses{
+						v("x").Type((*column)(nil)),
+						v("x").AttrInOrUnset(kind, regularColumn),
+					},
+					Entities: []v{"x"},
+					ResVars:  []v{"x"},
+					Results: [][]interface{}{
+						{t1a}, {t1b}, {t1d}, {t1f},
+					},
+				},
+				{
+					Name: "kind in a value or unset",
+					Query: rel.Clauses{
+						v("x").Type((*column)(nil)),
+						v("x").AttrInOrUnset(kind, computedColumn),
+					},
+					Entities: []v{"x"},
+					ResVars:  []v{"x"},
+					Results: [][]interface{}{
+						{t1c},
+					},
+				},
+				{
+					Name: "alias in no values or unset",
+					Query: rel.Clauses{
+						v("x").Type((*column)(nil)),
+						v("x").AttrInOrUnset(alias),
+					},
+					Entities: []v{"x"},
+					ResVars:  []v{"x"},
+					Results: [][]interface{}{
+						{t1d},
+					},
+				},
+				{
+					// Tables lack the alias attribute entirely.
+					Name: "entities with an alias in a value or unset",
+					Query: rel.Clauses{
+						v("x").AttrEqVar(name, "n"),
+						v("x").AttrInOrUnset(alias, "x"),
+					},
+					Entities: []v{"x"},
+					ResVars:  []v{"x"},
+					Results: [][]interface{}{
+						{t1}, {t1b}, {t1d},
+					},
+				},
+				{
+					Name: "alias in values of another type or unset",
+					Query: rel.Clauses{
+						v("x").Type((*column)(nil)),
+						v("x").AttrInOrUnset(alias, 1),
+					},
+					ErrorRE: `int is not comparable to string`,
+				},
+			},
+		},
+		{
+			Data: []string{"t1a", "t1b", "t1c", "t1d", "t1e", "t2a"},
+			Indexes: [][][]rel.Attr{
+				nil,
+				{{name}},
+			},
+			QueryCases: []reltest.QueryTest{
+				{
+					// The column t1a matches via both attributes, but only once.
+					Name: "name or alias equals a",
+					Query: rel.Clauses{
+						v("c").AnyAttrEq([]rel.Attr{name, alias}, "a"),
+					},
+					Entities: []v{"c"},
+					ResVars:  []v{"c"},
+					Results: [][]interface{}{
+						{t1a}, {t1e}, {t2a},
+					},
+				},
+				{
+					Name: "name or alias equals d",
+					Query: rel.Clauses{
+						v("c").AnyAttrEq([]rel.Attr{name, alias}, "d"),
+					},
+					Entities: []v{"c"},
+					ResVars:  []v{"c"},
+					Results: [][]interface{}{
+						{t1c}, {t1d},
+					},
+				},
+				{
+					Name: "alias equals x",
+					Query: rel.Clauses{
+						v("c").AnyAttrEq([]rel.Attr{alias}, "x"),
+					},
+					Entities: []v{"c"},
+					ResVars:  []v{"c"},
+					Results: [][]interface{}{
+						{t1b},
+					},
+				},
+				{
+					// The name isn't in the set, so t1b matches only via its old name.
+					Name: "alias or old name equals b",
+					Query: rel.Clauses{
+						v("c").AnyAttrEq([]rel.Attr{alias, oldName}, "b"),
+					},
+					Entities: []v{"c"},
+					ResVars:  []v{"c"},
+					Results: [][]interface{}{
+						{t1b},
+					},
+				},
+				{
+					Name: "name or alias equals y",
+					Query: rel.Clauses{
+						v("c").AnyAttrEq([]rel.Attr{name, alias}, "y"),
+					},
+					Entities: []v{"c"},
+					ResVars:  []v{"c"},
+					Results:  [][]interface{}{},
+				},
+				{
+					Name: "name or column ID equals a",
+					Query: rel.Clauses{
+						v("c").AnyAttrEq([]rel.Attr{name, columnID}, "a"),
+					},
+					ErrorRE: `uint32 is not comparable to string`,
+				},
+				{
+					Name: "no attribute equals a",
+					Query: rel.Clauses{
+						v("c").AnyAttrEq(nil, "a"),
+					},
+					ErrorRE: `AnyAttrEq requires at least one attribute`,
+				},
+			},
+		},
+	}
+	attributeCases = []reltest.AttributeTestCase{
+		{
+			Entity: "t1",
+			Expected: map[rel.Attr]interface{}{
+				tableID: uint32(1),
+				name:    "t",
+				dropped: droppedFlag(false),
+			},
+		},
+		{
+			Entity: "t1d",
+			Expected: map[rel.Attr]interface{}{
+				tableID:  uint32(1),
+				columnID: uint32(4),
+				name:     "d",
+				hidden:   false,
+				kind:     regularColumn,
+			},
+		},
+	}
+)
@@ -42,6 +42,27 @@ var (
 		return s1, s2, c1, c2
 	}()
 
+	// Here we create a chain and a cycle along the S1 attribute.
+	chain1, chain2, chain3, chain4 = func() (_, _, _, _ *struct1) {
+		c4 := &struct1{Name: "chain4"}
+		c3 := &struct1{Name: "chain3", S1: c4}
+		c2 := &struct1{Name: "chain2", S1: c3}
+		c1 := &struct1{Name: "chain1", S1: c2}
+		reg.Register("chain1", c1)
+		reg.Register("chain2", c2)
+		reg.Register("chain3", c3)
+		reg.Register("chain4", c4)
+		return c1, c2, c3, c4
+	}()
+	cycle1, cycle2, cycle3 = func() (_, _, _ *struct1) {
+		c1, c2, c3 := &struct1{Name: "cycle1"}, &struct1{Name: "cycle2"}, &struct1{Name: "cycle3"}
+		c1.S1, c2.S1, c3.S1 = c2, c3, c1
+		reg.Register("cycle1", c1)
+		reg.Register("cycle2", c2)
+		reg.Register("cycle3", c3)
+		return c1, c2, c3
+	}()
+
 	databaseTests = []reltest.DatabaseTest{
 		{
 			Data: []string{"container1"}, // recursively will add it all, test that
@@ -76,5 +97,102 @@ var (
 				},
 			},
 		},
+		{
+			Data: []string{"chain1", "cycle1"},
+			Indexes: [][][]rel.Attr{
+				nil,
+				{{s1}, {name}},
+			},
+			QueryCases: []reltest.QueryTest{
+				{
+					Name: "within hops on a chain",
+					Query: rel.Clauses{
+						rel.Var("from").AttrEq(name, "chain1"),
+						rel.Var("from").WithinHops(s1, 2, "to"),
+					},
+					ResVars:  []rel.Var{"to"},
+					Entities: []rel.Var{"from", "to"},
+					Results: [][]interface{}{
+						{chain2}, {chain3},
+					},
+				},
+				{
+					Name: "within hops beyond the end of a chain",
+					Query: rel.Clauses{
+						rel.Var("from").AttrEq(name, "chain2"),
+						rel.Var("from").WithinHops(s1, 5, "to"),
+					},
+					ResVars:  []rel.Var{"to"},
+					Entities: []rel.Var{"from", "to"},
+					Results: [][]interface{}{
+						{chain3}, {chain4},
+					},
+				},
+				{
+					Name: "exactly hops on a chain",
+					Query: rel.Clauses{
+						rel.Var("from").Type((*struct1)(nil)),
+						rel.Var("from").ExactlyHops(s1, 2, "to"),
+					},
+					ResVars:  []rel.Var{"from", "to"},
+					Entities: []rel.Var{"from", "to"},
+					Results: [][]interface{}{
+						{chain1, chain3},
+						{chain2, chain4},
+						{cycle1, cycle3},
+						{cycle2, cycle1},
+						{cycle3, cycle2},
+					},
+				},
+				{
+					Name: "within hops on a cycle",
+					Query: rel.Clauses{
+						rel.Var("from").AttrEq(name, "cycle1"),
+						rel.Var("from").WithinHops(s1, 5, "to"),
+					},
+					ResVars:  []rel.Var{"to"},
+					Entities: []rel.Var{"from", "to"},
+					Results: [][]interface{}{
+						{cycle1}, {cycle2}, {cycle3},
+					},
+				},
+				{
+					Name: "exactly hops around a cycle",
+					Query: rel.Clauses{
+						rel.Var("from").AttrEq(name, "cycle1"),
+						rel.Var("from").ExactlyHops(s1, 3, "to"),
+					},
+					ResVars:  []rel.Var{"to"},
+					Entities: []rel.Var{"from", "to"},
+					Results: [][]interface{}{
+						{cycle1},
+					},
+				},
+				{
+					Name: "exactly hops past a cycle",
+					Query: rel.Clauses{
+						rel.Var("from").AttrEq(name, "cycle1"),
+						rel.Var("from").ExactlyHops(s1, 4, "to"),
+					},
+					ResVars:  []rel.Var{"to"},
+					Entities: []rel.Var{"from", "to"},
+					Results:  [][]interface{}{},
+				},
+				{
+					Name: "hops along a non-entity attribute",
+					Query: rel.Clauses{
+						rel.Var("from").WithinHops(name, 1, "to"),
+					},
+					ErrorRE: `name of type string does not refer to entities`,
+				},
+				{
+					Name: "zero hops",
+					Query: rel.Clauses{
+						rel.Var("from").WithinHops(s1, 0, "to"),
+					},
+					ErrorRE: `invalid number of hops 0`,
+				},
+			},
+		},
 	}
 )
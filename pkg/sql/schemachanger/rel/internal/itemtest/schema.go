@@ -0,0 +1,118 @@
+// Copyright 2021 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+// Package itemtest defines a schema of items with attributes of many kinds
+// of values, for tests of clauses which constrain those values. The schema
+// is exported for tests which exercise the rel API directly.
+package itemtest
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/schemachanger/rel"
+)
+
+// Item is an entity which refers to no other entities.
+type Item struct {
+	Name       string     `yaml:"name"`
+	Alias      *string    `yaml:"alias"`
+	Label      string     `yaml:"label"`
+	Group      int        `yaml:"group"`
+	Value      int        `yaml:"value"`
+	Limit      *int       `yaml:"limit"`
+	Flag       bool       `yaml:"flag"`
+	Key        string     `yaml:"key"`
+	Created    time.Time  `yaml:"created"`
+	Modified   *time.Time `yaml:"modified"`
+	IDs        []uint32   `yaml:"ids"`
+	Release    Version    `yaml:"release"`
+	MinRelease *Version   `yaml:"minRelease"`
+	Rank       Descending `yaml:"rank"`
+}
+
+// Version is a struct type ordered via rel.Comparator.
+type Version struct {
+	Major int `yaml:"major"`
+	Minor int `yaml:"minor"`
+}
+
+// CompareTo implements rel.Comparator.
+func (v Version) CompareTo(other interface{}) int {
+	o := other.(Version)
+	switch {
+	case v.Major != o.Major:
+		return v.Major - o.Major
+	default:
+		return v.Minor - o.Minor
+	}
+}
+
+// Descending is an integer type which sorts in descending order via
+// rel.Comparator, which demonstrates that its method is used rather than its
+// underlying kind.
+type Descending uint32
+
+// CompareTo implements rel.Comparator.
+func (d Descending) CompareTo(other interface{}) int {
+	switch o := other.(Descending); {
+	case d > o:
+		return -1
+	case d < o:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// testAttr is a rel.Attr used for testing.
+type testAttr int8
+
+var _ rel.Attr = testAttr(0)
+
+//go:generate stringer --type testAttr  --tags test
+
+// The attributes of an Item.
+const (
+	Name testAttr = iota
+	Alias
+	Label
+	Group
+	Value
+	Limit
+	Flag
+	Key
+	Created
+	Modified
+	IDs
+	Release
+	MinRelease
+	Rank
+)
+
+// Schema is the schema of items.
+var Schema = rel.MustSchema("items",
+	rel.EntityMapping(reflect.TypeOf((*Item)(nil)),
+		rel.EntityAttr(Name, "Name"),
+		rel.EntityAttr(Alias, "Alias"),
+		rel.EntityAttr(Label, "Label"),
+		rel.EntityAttr(Group, "Group"),
+		rel.EntityAttr(Value, "Value"),
+		rel.EntityAttr(Limit, "Limit"),
+		rel.EntityAttr(Flag, "Flag"),
+		rel.EntityAttr(Key, "Key"),
+		rel.EntityAttr(Created, "Created"),
+		rel.EntityAttr(Modified, "Modified"),
+		rel.EntityAttr(IDs, "IDs"),
+		rel.EntityAttr(Release, "Release"),
+		rel.EntityAttr(MinRelease, "MinRelease"),
+		rel.EntityAttr(Rank, "Rank"),
+	),
+)
@@ -0,0 +1,874 @@
+// Copyright 2021 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package itemtest
+
+import (
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/schemachanger/rel"
+	"github.com/cockroachdb/cockroach/pkg/sql/schemachanger/rel/reltest"
+)
+
+type v = rel.Var
+
+// at returns the time the given number of hours after the start of the day
+// on which the items in the registry were created.
+func at(hours int) time.Time {
+	return time.Date(2021, 11, 1, hours, 0, 0, 0, time.UTC)
+}
+
+// atPtr is like at, but returns a pointer to the time.
+func atPtr(hours int) *time.Time {
+	ts := at(hours)
+	return &ts
+}
+
+var (
+	// Suite defines the items test suite.
+	Suite = reltest.Suite{
+		Name:          "items",
+		Schema:        Schema,
+		Registry:      r,
+		DatabaseTests: databaseTests,
+	}
+
+	r        = reltest.NewRegistry()
+	created1 = r.FromYAML("created1", `{name: a, created: 2021-11-01T01:00:00Z, modified: 2021-11-01T05:00:00Z}`, &Item{}).(*Item)
+	created2 = r.FromYAML("created2", `{name: b, created: 2021-11-01T02:00:00Z}`, &Item{}).(*Item)
+	created3 = r.FromYAML("created3", `{name: c, created: 2021-11-01T03:00:00Z, modified: 2021-11-01T03:00:00Z}`, &Item{}).(*Item)
+	created4 = r.FromYAML("created4", `{name: d, created: 2021-11-01T04:00:00Z}`, &Item{}).(*Item)
+
+	released1 = r.FromYAML("released1", `{name: a, release: {major: 1, minor: 0}, rank: 1}`, &Item{}).(*Item)
+	released2 = r.FromYAML("released2", `{name: b, release: {major: 1, minor: 2}, minRelease: {major: 1, minor: 0}, rank: 2}`, &Item{}).(*Item)
+	released3 = r.FromYAML("released3", `{name: c, release: {major: 2, minor: 1}, minRelease: {major: 1, minor: 0}, rank: 3}`, &Item{}).(*Item)
+	released4 = r.FromYAML("released4", `{name: d, release: {major: 2, minor: 10}, minRelease: {major: 2, minor: 1}, rank: 4}`, &Item{}).(*Item)
+
+	// The steps of two phases, labeled a and b, are at increasing positions
+	// given by their value, and some have a rank given by their limit.
+	a1 = r.FromYAML("a1", `{name: a1, label: a, value: 1, limit: 2}`, &Item{}).(*Item)
+	a2 = r.FromYAML("a2", `{name: a2, label: a, value: 3}`, &Item{}).(*Item)
+	a3 = r.FromYAML("a3", `{name: a3, label: a, value: 5, limit: 1}`, &Item{}).(*Item)
+	b1 = r.FromYAML("b1", `{name: b1, label: b, value: 2, limit: 1}`, &Item{}).(*Item)
+	b2 = r.FromYAML("b2", `{name: b2, label: b, value: 3, limit: 3}`, &Item{}).(*Item)
+	b3 = r.FromYAML("b3", `{name: b3, label: b, value: 6}`, &Item{}).(*Item)
+
+	// The limit of zeroLimit points to zero, and its ids are empty but not
+	// nil, which is not their zero value.
+	zero      = r.FromYAML("zero", `{name: zero}`, &Item{}).(*Item)
+	set       = r.FromYAML("set", `{name: set, value: 1, flag: true, created: 1970-01-01T00:00:01Z, limit: 2, ids: [0]}`, &Item{}).(*Item)
+	zeroLimit = r.FromYAML("zeroLimit", `{name: zeroLimit, value: 1, limit: 0, ids: []}`, &Item{}).(*Item)
+
+	// The items are priced by their value, and their group is a limit.
+	priced1 = r.FromYAML("priced1", `{name: a, value: 10, group: 20}`, &Item{}).(*Item)
+	priced2 = r.FromYAML("priced2", `{name: b, value: 20, group: 10}`, &Item{}).(*Item)
+	priced3 = r.FromYAML("priced3", `{name: c, value: 30, group: 30}`, &Item{}).(*Item)
+	priced4 = r.FromYAML("priced4", `{name: d, value: 40}`, &Item{}).(*Item)
+
+	// Each group has an item at version 1, given by its value, before a
+	// change, and an item at version 2 after it. The alias is a comment.
+	unchanged1   = r.FromYAML("unchanged1", `{name: unchanged, value: 1}`, &Item{}).(*Item)
+	unchanged2   = r.FromYAML("unchanged2", `{name: unchanged, value: 2}`, &Item{}).(*Item)
+	renamed1     = r.FromYAML("renamed1", `{name: renamed, group: 1, value: 1}`, &Item{}).(*Item)
+	renamed2     = r.FromYAML("renamed2", `{name: renamed2, group: 1, value: 2}`, &Item{}).(*Item)
+	commented1   = r.FromYAML("commented1", `{name: commented, group: 2, value: 1}`, &Item{}).(*Item)
+	commented2   = r.FromYAML("commented2", `{name: commented, group: 2, value: 2, alias: c}`, &Item{}).(*Item)
+	uncommented1 = r.FromYAML("uncommented1", `{name: uncommented, group: 3, value: 1, alias: c}`, &Item{}).(*Item)
+	uncommented2 = r.FromYAML("uncommented2", `{name: uncommented, group: 3, value: 2}`, &Item{}).(*Item)
+	recommented1 = r.FromYAML("recommented1", `{name: recommented, group: 4, value: 1, alias: c}`, &Item{}).(*Item)
+	recommented2 = r.FromYAML("recommented2", `{name: recommented, group: 4, value: 2, alias: d}`, &Item{}).(*Item)
+	sameComment1 = r.FromYAML("sameComment1", `{name: same comment, group: 5, value: 1, alias: c}`, &Item{}).(*Item)
+	sameComment2 = r.FromYAML("sameComment2", `{name: same comment, group: 5, value: 2, alias: c}`, &Item{}).(*Item)
+
+	// The children are labeled with the name of their parent. The group of a
+	// parent is its first child, its value the count of children, and its
+	// limit the capacity for children.
+	parent1 = r.FromYAML("parent1", `{name: p1, group: 0, value: 2, limit: 4}`, &Item{}).(*Item)
+	parent2 = r.FromYAML("parent2", `{name: p2, group: 1, value: 3}`, &Item{}).(*Item)
+	p1c0    = r.FromYAML("p1c0", `{name: p1-0, label: p1, value: 0}`, &Item{}).(*Item)
+	p1c1    = r.FromYAML("p1c1", `{name: p1-1, label: p1, value: 1}`, &Item{}).(*Item)
+	p1c2    = r.FromYAML("p1c2", `{name: p1-2, label: p1, value: 2}`, &Item{}).(*Item)
+	p1c3    = r.FromYAML("p1c3", `{name: p1-3, label: p1, value: 3}`, &Item{}).(*Item)
+	p2c0    = r.FromYAML("p2c0", `{name: p2-0, label: p2, value: 0}`, &Item{}).(*Item)
+	p2c2    = r.FromYAML("p2c2", `{name: p2-2, label: p2, value: 2}`, &Item{}).(*Item)
+	p2c3    = r.FromYAML("p2c3", `{name: p2-3, label: p2, value: 3}`, &Item{}).(*Item)
+
+	// The keys are hex-encoded, or not. Well-formed hex matches whatever
+	// bytes it decodes to.
+	keyValid     = r.FromYAML("keyValid", `{name: valid, key: bd8912666f6f}`, &Item{}).(*Item)
+	keyEmpty     = r.FromYAML("keyEmpty", `{name: empty}`, &Item{}).(*Item)
+	keyUpperCase = r.FromYAML("keyUpperCase", `{name: upper-case, key: BD89}`, &Item{}).(*Item)
+	keyOddLength = r.FromYAML("keyOddLength", `{name: odd-length, key: bd8}`, &Item{}).(*Item)
+	keyNonHex    = r.FromYAML("keyNonHex", `{name: non-hex, key: bd89zz}`, &Item{}).(*Item)
+	keyUnchecked = r.FromYAML("keyUnchecked", `{name: unchecked, key: ff}`, &Item{}).(*Item)
+	keyPretty    = r.FromYAML("keyPretty", `{name: pretty, key: /Table/53/1}`, &Item{}).(*Item)
+
+	// The names are matched by glob patterns.
+	primaryIdx = r.FromYAML("primaryIdx", `{name: primary_idx}`, &Item{}).(*Item)
+	idx        = r.FromYAML("idx", `{name: _idx}`, &Item{}).(*Item)
+	fooIdx2    = r.FromYAML("fooIdx2", `{name: foo_idx_2}`, &Item{}).(*Item)
+	col        = r.FromYAML("col", `{name: col}`, &Item{}).(*Item)
+	col1       = r.FromYAML("col1", `{name: col1}`, &Item{}).(*Item)
+	col12      = r.FromYAML("col12", `{name: col12}`, &Item{}).(*Item)
+	cola       = r.FromYAML("cola", `{name: cola}`, &Item{}).(*Item)
+	aDotB      = r.FromYAML("aDotB", `{name: a.b}`, &Item{}).(*Item)
+	axb        = r.FromYAML("axb", `{name: axb}`, &Item{}).(*Item)
+
+	databaseTests = []reltest.DatabaseTest{
+		{
+			Data: []string{"created1", "created2", "created3", "created4"},
+			Indexes: [][][]rel.Attr{
+				nil,
+				{{Created}},
+			},
+			QueryCases: []reltest.QueryTest{
+				{
+					Name: "created at a time",
+					Query: rel.Clauses{
+						v("i").Type((*Item)(nil)),
+						v("i").AttrEq(Created, at(2)),
+					},
+					Entities: []v{"i"},
+					ResVars:  []v{"i"},
+					Results: [][]interface{}{
+						{created2},
+					},
+				},
+				{
+					// Times are equal regardless of their location.
+					Name: "created at a time in another location",
+					Query: rel.Clauses{
+						v("i").Type((*Item)(nil)),
+						v("i").AttrEq(Created, at(2).In(time.FixedZone("x", 3600))),
+					},
+					Entities: []v{"i"},
+					ResVars:  []v{"i"},
+					Results: [][]interface{}{
+						{created2},
+					},
+				},
+				{
+					Name: "created before a time",
+					Query: rel.Clauses{
+						v("i").Type((*Item)(nil)),
+						v("i").AttrLt(Created, at(3)),
+					},
+					Entities: []v{"i"},
+					ResVars:  []v{"i"},
+					Results: [][]interface{}{
+						{created1}, {created2},
+					},
+				},
+				{
+					Name: "created after a time",
+					Query: rel.Clauses{
+						v("i").Type((*Item)(nil)),
+						v("i").AttrGt(Created, at(3)),
+					},
+					Entities: []v{"i"},
+					ResVars:  []v{"i"},
+					Results: [][]interface{}{
+						{created4},
+					},
+				},
+				{
+					Name: "created between times",
+					Query: rel.Clauses{
+						v("i").Type((*Item)(nil)),
+						v("i").AttrBetween(Created, at(2), at(3)),
+					},
+					Entities: []v{"i"},
+					ResVars:  []v{"i"},
+					Results: [][]interface{}{
+						{created2}, {created3},
+					},
+				},
+				{
+					Name: "created in an empty range",
+					Query: rel.Clauses{
+						v("i").Type((*Item)(nil)),
+						v("i").AttrBetween(Created, at(3), at(2)),
+					},
+					Entities: []v{"i"},
+					ResVars:  []v{"i"},
+					Results:  [][]interface{}{},
+				},
+				{
+					// Items which were never modified have no modification time.
+					Name: "modified before a time",
+					Query: rel.Clauses{
+						v("i").Type((*Item)(nil)),
+						v("i").AttrLt(Modified, at(4)),
+					},
+					Entities: []v{"i"},
+					ResVars:  []v{"i"},
+					Results: [][]interface{}{
+						{created3},
+					},
+				},
+				{
+					Name: "modified after a time given by pointer",
+					Query: rel.Clauses{
+						v("i").Type((*Item)(nil)),
+						v("i").AttrGt(Modified, atPtr(4)),
+					},
+					Entities: []v{"i"},
+					ResVars:  []v{"i"},
+					Results: [][]interface{}{
+						{created1},
+					},
+				},
+				{
+					Name: "names between strings",
+					Query: rel.Clauses{
+						v("i").Type((*Item)(nil)),
+						v("i").AttrBetween(Name, "b", "c"),
+					},
+					Entities: []v{"i"},
+					ResVars:  []v{"i"},
+					Results: [][]interface{}{
+						{created2}, {created3},
+					},
+				},
+				{
+					Name: "creation time of an item",
+					Query: rel.Clauses{
+						v("i").AttrEq(Name, "a"),
+						v("i").AttrEqVar(Created, "c"),
+					},
+					Entities: []v{"i"},
+					ResVars:  []v{"i", "c"},
+					Results: [][]interface{}{
+						{created1, at(1)},
+					},
+				},
+				{
+					Name: "time compared to an int",
+					Query: rel.Clauses{
+						v("i").AttrLt(Created, 1),
+					},
+					ErrorRE: `time.Time is not comparable to int`,
+				},
+				{
+					Name: "string compared to a time",
+					Query: rel.Clauses{
+						v("i").AttrGt(Name, at(1)),
+					},
+					ErrorRE: `string is not comparable to time.Time`,
+				},
+				{
+					Name: "type compared to a time",
+					Query: rel.Clauses{
+						v("i").AttrLt(rel.Type, at(1)),
+					},
+					ErrorRE: `reflect.Type is not an ordered type`,
+				},
+			},
+		},
+		{
+			Data: []string{"released1", "released2", "released3", "released4"},
+			Indexes: [][][]rel.Attr{
+				nil,
+				{{Release}, {Rank}},
+			},
+			QueryCases: []reltest.QueryTest{
+				{
+					Name: "released at a version",
+					Query: rel.Clauses{
+						v("i").AttrEq(Release, Version{1, 2}),
+					},
+					Entities: []v{"i"},
+					ResVars:  []v{"i"},
+					Results: [][]interface{}{
+						{released2},
+					},
+				},
+				{
+					Name: "released before a version",
+					Query: rel.Clauses{
+						v("i").AttrLt(Release, Version{2, 0}),
+					},
+					Entities: []v{"i"},
+					ResVars:  []v{"i"},
+					Results: [][]interface{}{
+						{released1}, {released2},
+					},
+				},
+				{
+					Name: "released after a version",
+					Query: rel.Clauses{
+						v("i").AttrGt(Release, Version{1, 2}),
+					},
+					Entities: []v{"i"},
+					ResVars:  []v{"i"},
+					Results: [][]interface{}{
+						{released3}, {released4},
+					},
+				},
+				{
+					Name: "released between versions",
+					Query: rel.Clauses{
+						v("i").AttrBetween(Release, Version{1, 1}, Version{2, 1}),
+					},
+					Entities: []v{"i"},
+					ResVars:  []v{"i"},
+					Results: [][]interface{}{
+						{released2}, {released3},
+					},
+				},
+				{
+					Name: "released between versions in the wrong order",
+					Query: rel.Clauses{
+						v("i").AttrBetween(Release, Version{2, 1}, Version{1, 1}),
+					},
+					Entities: []v{"i"},
+					ResVars:  []v{"i"},
+					Results:  [][]interface{}{},
+				},
+				{
+					// Ranks sort in descending order.
+					Name: "ranked before a rank",
+					Query: rel.Clauses{
+						v("i").AttrLt(Rank, Descending(2)),
+					},
+					Entities: []v{"i"},
+					ResVars:  []v{"i"},
+					Results: [][]interface{}{
+						{released3}, {released4},
+					},
+				},
+				{
+					Name: "ranked between ranks",
+					Query: rel.Clauses{
+						v("i").AttrBetween(Rank, Descending(3), Descending(2)),
+					},
+					Entities: []v{"i"},
+					ResVars:  []v{"i"},
+					Results: [][]interface{}{
+						{released2}, {released3},
+					},
+				},
+				{
+					Name: "minimum release before a version",
+					Query: rel.Clauses{
+						v("i").AttrLt(MinRelease, Version{2, 0}),
+					},
+					Entities: []v{"i"},
+					ResVars:  []v{"i"},
+					Results: [][]interface{}{
+						{released2}, {released3},
+					},
+				},
+				{
+					Name: "release of an item",
+					Query: rel.Clauses{
+						v("i").AttrEq(Name, "c"),
+						v("i").AttrEqVar(Release, "r"),
+					},
+					Entities: []v{"i"},
+					ResVars:  []v{"i", "r"},
+					Results: [][]interface{}{
+						{released3, Version{2, 1}},
+					},
+				},
+				{
+					Name: "rank compared to its underlying type",
+					Query: rel.Clauses{
+						v("i").AttrLt(Rank, uint32(1)),
+					},
+					ErrorRE: `itemtest.Descending is not comparable to uint32`,
+				},
+			},
+		},
+		{
+			Data: []string{"a1", "a2", "a3", "b1", "b2", "b3"},
+			Indexes: [][][]rel.Attr{
+				nil,
+				{{Label}},
+			},
+			QueryCases: []reltest.QueryTest{
+				{
+					Name: "strictly before",
+					Query: rel.Clauses{
+						v("a").AttrEq(Label, "a"),
+						v("b").AttrEq(Label, "b"),
+						v("a").AttrLtVar(Value, "b", Value),
+					},
+					Entities: []v{"a", "b"},
+					ResVars:  []v{"a", "b"},
+					Results: [][]interface{}{
+						{a1, b1},
+						{a1, b2},
+						{a1, b3},
+						{a2, b3},
+						{a3, b3},
+					},
+				},
+				{
+					Name: "before or equal",
+					Query: rel.Clauses{
+						v("a").AttrEq(Label, "a"),
+						v("b").AttrEq(Label, "b"),
+						v("a").AttrLeVar(Value, "b", Value),
+					},
+					Entities: []v{"a", "b"},
+					ResVars:  []v{"a", "b"},
+					Results: [][]interface{}{
+						{a1, b1},
+						{a1, b2},
+						{a1, b3},
+						{a2, b2},
+						{a2, b3},
+						{a3, b3},
+					},
+				},
+				{
+					Name: "strictly after",
+					Query: rel.Clauses{
+						v("a").AttrEq(Label, "a"),
+						v("b").AttrEq(Label, "b"),
+						v("a").AttrGtVar(Value, "b", Value),
+					},
+					Entities: []v{"a", "b"},
+					ResVars:  []v{"a", "b"},
+					Results: [][]interface{}{
+						{a2, b1},
+						{a3, b1},
+						{a3, b2},
+					},
+				},
+				{
+					Name: "after or equal",
+					Query: rel.Clauses{
+						v("a").AttrEq(Label, "a"),
+						v("b").AttrEq(Label, "b"),
+						v("a").AttrGeVar(Value, "b", Value),
+					},
+					Entities: []v{"a", "b"},
+					ResVars:  []v{"a", "b"},
+					Results: [][]interface{}{
+						{a2, b1},
+						{a2, b2},
+						{a3, b1},
+						{a3, b2},
+					},
+				},
+				{
+					Name: "reversed operands",
+					Query: rel.Clauses{
+						v("a").AttrEq(Label, "a"),
+						v("b").AttrEq(Label, "b"),
+						v("b").AttrGtVar(Value, "a", Value),
+					},
+					Entities: []v{"a", "b"},
+					ResVars:  []v{"a", "b"},
+					Results: [][]interface{}{
+						{a1, b1},
+						{a1, b2},
+						{a1, b3},
+						{a2, b3},
+						{a3, b3},
+					},
+				},
+				{
+					Name: "different attributes",
+					Query: rel.Clauses{
+						v("a").AttrEq(Label, "a"),
+						v("b").AttrEq(Label, "b"),
+						v("a").AttrLtVar(Limit, "b", Value),
+					},
+					Entities: []v{"a", "b"},
+					ResVars:  []v{"a", "b"},
+					Results: [][]interface{}{
+						{a1, b2},
+						{a1, b3},
+						{a3, b1},
+						{a3, b2},
+						{a3, b3},
+					},
+				},
+				{
+					// Steps without a rank do not match.
+					Name: "absent values",
+					Query: rel.Clauses{
+						v("a").AttrEq(Label, "a"),
+						v("b").AttrEq(Label, "b"),
+						v("a").AttrLeVar(Limit, "b", Limit),
+					},
+					Entities: []v{"a", "b"},
+					ResVars:  []v{"a", "b"},
+					Results: [][]interface{}{
+						{a1, b2},
+						{a3, b1},
+						{a3, b2},
+					},
+				},
+				{
+					Name: "strictly before within a phase",
+					Query: rel.Clauses{
+						v("a").AttrEqVar(Label, "p"),
+						v("b").AttrEqVar(Label, "p"),
+						v("a").AttrLtVar(Value, "b", Value),
+					},
+					Entities: []v{"a", "b"},
+					ResVars:  []v{"a", "b"},
+					Results: [][]interface{}{
+						{a1, a2}, {a1, a3}, {a2, a3},
+						{b1, b2}, {b1, b3}, {b2, b3},
+					},
+				},
+				{
+					Name: "inequality join of incomparable attributes",
+					Query: rel.Clauses{
+						v("a").AttrLtVar(Value, "b", Name),
+					},
+					ErrorRE: `int is not comparable to string`,
+				},
+				{
+					Name: "inequality join of unordered attributes",
+					Query: rel.Clauses{
+						v("a").AttrGeVar(rel.Type, "b", rel.Type),
+					},
+					ErrorRE: `reflect.Type is not an ordered type`,
+				},
+			},
+		},
+		{
+			Data: []string{"zero", "set", "zeroLimit"},
+			QueryCases: []reltest.QueryTest{
+				{
+					Name: "zero name",
+					Query: rel.Clauses{
+						v("i").AttrIsZero(Name),
+					},
+					Entities: []v{"i"},
+					ResVars:  []v{"i"},
+					Results:  [][]interface{}{},
+				},
+				{
+					Name: "zero value",
+					Query: rel.Clauses{
+						v("i").AttrIsZero(Value),
+					},
+					Entities: []v{"i"},
+					ResVars:  []v{"i"},
+					Results: [][]interface{}{
+						{zero},
+					},
+				},
+				{
+					Name: "zero flag",
+					Query: rel.Clauses{
+						v("i").AttrIsZero(Flag),
+					},
+					Entities: []v{"i"},
+					ResVars:  []v{"i"},
+					Results: [][]interface{}{
+						{zero}, {zeroLimit},
+					},
+				},
+				{
+					Name: "zero creation time",
+					Query: rel.Clauses{
+						v("i").AttrIsZero(Created),
+					},
+					Entities: []v{"i"},
+					ResVars:  []v{"i"},
+					Results: [][]interface{}{
+						{zero}, {zeroLimit},
+					},
+				},
+				{
+					Name: "zero limit",
+					Query: rel.Clauses{
+						v("i").AttrIsZero(Limit),
+					},
+					Entities: []v{"i"},
+					ResVars:  []v{"i"},
+					Results: [][]interface{}{
+						{zeroLimit},
+					},
+				},
+				{
+					Name: "zero ids",
+					Query: rel.Clauses{
+						v("i").AttrIsZero(IDs),
+					},
+					Entities: []v{"i"},
+					ResVars:  []v{"i"},
+					Results: [][]interface{}{
+						{zero},
+					},
+				},
+				{
+					Name: "zero type",
+					Query: rel.Clauses{
+						v("i").AttrIsZero(rel.Type),
+					},
+					ErrorRE: `reflect.Type is not a type with a non-nil zero value`,
+				},
+			},
+		},
+		{
+			Data: []string{"priced1", "priced2", "priced3", "priced4"},
+			QueryCases: []reltest.QueryTest{
+				{
+					Name: "price equal to a value",
+					Query: rel.Clauses{
+						v("x").AttrInto(Value, "p"),
+						v("p").Eq(20),
+					},
+					Entities: []v{"x"},
+					ResVars:  []v{"x"},
+					Results: [][]interface{}{
+						{priced2},
+					},
+				},
+				{
+					Name: "price in a set of values",
+					Query: rel.Clauses{
+						v("x").AttrInto(Value, "p"),
+						v("p").In(10, 30),
+					},
+					Entities: []v{"x"},
+					ResVars:  []v{"x"},
+					Results: [][]interface{}{
+						{priced1}, {priced3},
+					},
+				},
+				{
+					Name: "price equal to the limit of another item",
+					Query: rel.Clauses{
+						v("x").AttrInto(Value, "p"),
+						v("y").AttrEqVar(Group, "p"),
+					},
+					Entities: []v{"x", "y"},
+					ResVars:  []v{"x", "y"},
+					Results: [][]interface{}{
+						{priced1, priced2}, {priced2, priced1}, {priced3, priced3},
+					},
+				},
+				{
+					Name: "price under the limit of an item",
+					Query: rel.Clauses{
+						v("y").AttrEq(Name, "c"),
+						v("y").AttrInto(Group, "p"),
+						v("x").AttrLtVar(Value, "p", rel.Self),
+					},
+					Entities: []v{"y", "x"},
+					ResVars:  []v{"x", "y"},
+					Results: [][]interface{}{
+						{priced1, priced3}, {priced2, priced3},
+					},
+				},
+			},
+		},
+		{
+			Data: []string{
+				"unchanged1", "unchanged2", "renamed1", "renamed2",
+				"commented1", "commented2", "uncommented1", "uncommented2",
+				"recommented1", "recommented2", "sameComment1", "sameComment2",
+			},
+			Indexes: [][][]rel.Attr{
+				nil,
+				{{Group}},
+			},
+			QueryCases: []reltest.QueryTest{
+				{
+					Name: "changed names",
+					Query: rel.Clauses{
+						v("before").AttrEq(Value, 1),
+						v("before").AttrEqVar(Group, "group"),
+						v("after").AttrEq(Value, 2),
+						v("after").AttrEqVar(Group, "group"),
+						v("before").AttrChangedFrom(Name, "after"),
+					},
+					Entities: []v{"before", "after"},
+					ResVars:  []v{"before"},
+					Results: [][]interface{}{
+						{renamed1},
+					},
+				},
+				{
+					Name: "changed comments",
+					Query: rel.Clauses{
+						v("before").AttrEq(Value, 1),
+						v("before").AttrEqVar(Group, "group"),
+						v("after").AttrEq(Value, 2),
+						v("after").AttrEqVar(Group, "group"),
+						v("before").AttrChangedFrom(Alias, "after"),
+					},
+					Entities: []v{"before", "after"},
+					ResVars:  []v{"before"},
+					Results: [][]interface{}{
+						{commented1}, {uncommented1}, {recommented1},
+					},
+				},
+				{
+					Name: "changed groups",
+					Query: rel.Clauses{
+						v("before").AttrEq(Value, 1),
+						v("before").AttrEqVar(Group, "group"),
+						v("after").AttrEq(Value, 2),
+						v("after").AttrEqVar(Group, "group"),
+						v("before").AttrChangedFrom(Group, "after"),
+					},
+					Entities: []v{"before", "after"},
+					ResVars:  []v{"before"},
+					Results:  [][]interface{}{},
+				},
+			},
+		},
+		{
+			Data: []string{
+				"parent1", "parent2",
+				"p1c0", "p1c1", "p1c2", "p1c3", "p2c0", "p2c2", "p2c3",
+			},
+			QueryCases: []reltest.QueryTest{
+				{
+					// The range is half-open.
+					Name: "children in range of their parent",
+					Query: rel.Clauses{
+						v("c").AttrEqVar(Label, "parent"),
+						v("p").AttrEqVar(Name, "parent"),
+						v("c").AttrInRangeOf(Value, "p", Group, "p", Value),
+					},
+					Entities: []v{"c", "p"},
+					ResVars:  []v{"c"},
+					Results: [][]interface{}{
+						{p1c0}, {p1c1}, {p2c2},
+					},
+				},
+				{
+					// Parents without a value for a bound have no children in range.
+					Name: "children in range of the capacity of their parent",
+					Query: rel.Clauses{
+						v("c").AttrEqVar(Label, "parent"),
+						v("p").AttrEqVar(Name, "parent"),
+						v("c").AttrInRangeOf(Value, "p", Value, "p", Limit),
+					},
+					Entities: []v{"c", "p"},
+					ResVars:  []v{"c"},
+					Results: [][]interface{}{
+						{p1c2}, {p1c3},
+					},
+				},
+				{
+					Name: "names in range of their parent",
+					Query: rel.Clauses{
+						v("c").AttrEqVar(Label, "parent"),
+						v("p").AttrEqVar(Name, "parent"),
+						v("c").AttrInRangeOf(Name, "p", Group, "p", Value),
+					},
+					ErrorRE: `string is not a numeric type`,
+				},
+				{
+					Name: "ranks in range of their parent",
+					Query: rel.Clauses{
+						v("c").AttrEqVar(Label, "parent"),
+						v("p").AttrEqVar(Name, "parent"),
+						v("c").AttrInRangeOf(Rank, "p", Group, "p", Value),
+					},
+					ErrorRE: `itemtest.Descending is not a numeric type`,
+				},
+			},
+		},
+		{
+			Data: []string{
+				"keyValid", "keyEmpty", "keyUpperCase", "keyOddLength",
+				"keyNonHex", "keyUnchecked", "keyPretty",
+			},
+			QueryCases: []reltest.QueryTest{
+				{
+					Name:     "hex keys",
+					Query:    rel.Clauses{v("k").AttrIsHexKey(Key)},
+					Entities: []v{"k"},
+					ResVars:  []v{"k"},
+					Results: [][]interface{}{
+						{keyValid}, {keyEmpty}, {keyUpperCase}, {keyUnchecked},
+					},
+				},
+				{
+					Name:    "hex values",
+					Query:   rel.Clauses{v("k").AttrIsHexKey(Value)},
+					ErrorRE: `int is not a string`,
+				},
+			},
+		},
+		{
+			Data: []string{
+				"primaryIdx", "idx", "fooIdx2", "col", "col1", "col12", "cola",
+				"aDotB", "axb",
+			},
+			QueryCases: []reltest.QueryTest{
+				{
+					// The pattern must match the whole value.
+					Name:     "suffix",
+					Query:    rel.Clauses{v("o").AttrGlob(Name, "*_idx")},
+					Entities: []v{"o"},
+					ResVars:  []v{"o"},
+					Results: [][]interface{}{
+						{primaryIdx}, {idx},
+					},
+				},
+				{
+					Name:     "single character",
+					Query:    rel.Clauses{v("o").AttrGlob(Name, "col?")},
+					Entities: []v{"o"},
+					ResVars:  []v{"o"},
+					Results: [][]interface{}{
+						{col1}, {cola},
+					},
+				},
+				{
+					Name:     "prefix",
+					Query:    rel.Clauses{v("o").AttrGlob(Name, "col*")},
+					Entities: []v{"o"},
+					ResVars:  []v{"o"},
+					Results: [][]interface{}{
+						{col}, {col1}, {col12}, {cola},
+					},
+				},
+				{
+					// Characters other than wildcards match themselves.
+					Name:     "literal",
+					Query:    rel.Clauses{v("o").AttrGlob(Name, "a.b")},
+					Entities: []v{"o"},
+					ResVars:  []v{"o"},
+					Results: [][]interface{}{
+						{aDotB},
+					},
+				},
+				{
+					Name:     "anything",
+					Query:    rel.Clauses{v("o").AttrGlob(Name, "*")},
+					Entities: []v{"o"},
+					ResVars:  []v{"o"},
+					Results: [][]interface{}{
+						{primaryIdx}, {idx}, {fooIdx2}, {col}, {col1}, {col12}, {cola},
+						{aDotB}, {axb},
+					},
+				},
+				{
+					Name:     "nothing",
+					Query:    rel.Clauses{v("o").AttrGlob(Name, "tbl_?")},
+					Entities: []v{"o"},
+					ResVars:  []v{"o"},
+					Results:  [][]interface{}{},
+				},
+				{
+					Name:    "glob values",
+					Query:   rel.Clauses{v("o").AttrGlob(Value, "1*")},
+					ErrorRE: `int is not a string`,
+				},
+			},
+		},
+	}
+)
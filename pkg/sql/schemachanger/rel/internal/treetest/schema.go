@@ -0,0 +1,100 @@
+// Copyright 2021 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+// Package treetest defines a schema of nodes which refer to their parent,
+// for tests of clauses which follow references between entities. The schema
+// is exported for tests which exercise the rel API directly.
+package treetest
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/schemachanger/rel"
+	"github.com/cockroachdb/cockroach/pkg/sql/schemachanger/rel/reltest"
+	"gopkg.in/yaml.v3"
+)
+
+// Node refers to its parent. Nodes usually form trees, but the parent of a
+// node need not be in the same tree, so they may also form cycles.
+type Node struct {
+	Name    string
+	Parent  *Node
+	Kind    string
+	Ordinal uint32
+	Note    *Comment
+}
+
+// String helps ensure that serialization does not infinitely recurse.
+func (n *Node) String() string { return fmt.Sprintf("node(%s)", n.Name) }
+
+// EncodeToYAML encodes the parent of the node by its name in the registry.
+func (n *Node) EncodeToYAML(t *testing.T, r *reltest.Registry) interface{} {
+	yn := yaml.Node{Kind: yaml.MappingNode, Style: yaml.FlowStyle}
+	for _, f := range []struct {
+		name  string
+		value func() string
+		ok    bool
+	}{
+		{"name", func() string { return n.Name }, true},
+		{"parent", func() string { return r.MustGetName(t, n.Parent) }, n.Parent != nil},
+		{"kind", func() string { return n.Kind }, n.Kind != ""},
+		{"ordinal", func() string { return strconv.Itoa(int(n.Ordinal)) }, n.Ordinal != 0},
+		{"note", func() string { return n.Note.Text }, n.Note != nil},
+	} {
+		if !f.ok {
+			continue
+		}
+		yn.Content = append(yn.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Value: f.name},
+			&yaml.Node{Kind: yaml.ScalarNode, Value: f.value()},
+		)
+	}
+	return &yn
+}
+
+var _ reltest.RegistryYAMLEncoder = (*Node)(nil)
+
+// Comment is referenced by nodes, but is not an entity of the schema.
+type Comment struct {
+	Text string
+}
+
+// testAttr is a rel.Attr used for testing.
+type testAttr int8
+
+var _ rel.Attr = testAttr(0)
+
+//go:generate stringer --type testAttr  --tags test
+
+// The attributes of a Node.
+const (
+	Name testAttr = iota
+	Parent
+	Kind
+	Ordinal
+	Note
+)
+
+// Schema is the schema of nodes, which are identified by their name.
+var Schema = rel.MustSchema("tree",
+	rel.EntityMapping(reflect.TypeOf((*Node)(nil)),
+		rel.EntityAttr(Name, "Name"),
+		rel.EntityAttr(Parent, "Parent"),
+		rel.EntityAttr(Kind, "Kind"),
+		rel.EntityAttr(Ordinal, "Ordinal"),
+		rel.EntityAttr(Note, "Note"),
+		rel.EntityIdentity(func(entity interface{}) interface{} {
+			return entity.(*Node).Name
+		}),
+	),
+)
@@ -0,0 +1,649 @@
+// Copyright 2021 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package treetest
+
+import (
+	"github.com/cockroachdb/cockroach/pkg/sql/schemachanger/rel"
+	"github.com/cockroachdb/cockroach/pkg/sql/schemachanger/rel/reltest"
+)
+
+type v = rel.Var
+
+var (
+	// Suite defines the tree test suite.
+	Suite = reltest.Suite{
+		Name:          "tree",
+		Schema:        Schema,
+		Registry:      r,
+		DatabaseTests: databaseTests,
+	}
+
+	// The registry holds the following forest, and a cycle:
+	//
+	//  root      c      x ⇄ y
+	//  ├── a
+	//  │   ├── a1
+	//  │   └── a2
+	//  └── b
+	//      └── b1
+	//
+	r    = reltest.NewRegistry()
+	root = r.Register("root", &Node{Name: "root"}).(*Node)
+	a    = r.Register("a", &Node{Name: "a", Parent: root}).(*Node)
+	b    = r.Register("b", &Node{Name: "b", Parent: root}).(*Node)
+	a1   = r.Register("a1", &Node{Name: "a1", Parent: a}).(*Node)
+	a2   = r.Register("a2", &Node{Name: "a2", Parent: a}).(*Node)
+	b1   = r.Register("b1", &Node{Name: "b1", Parent: b}).(*Node)
+	c    = r.Register("c", &Node{Name: "c"}).(*Node)
+	x    = r.Register("x", &Node{Name: "x"}).(*Node)
+	y    = r.Register("y", &Node{Name: "y", Parent: x}).(*Node)
+
+	// The nodes p and pDup are distinct pointers with the same identity, so
+	// only the first one inserted is in a database.
+	p    = r.Register("p", &Node{Name: "p"}).(*Node)
+	pDup = r.Register("pDup", &Node{Name: "p"}).(*Node)
+	q    = r.Register("q", &Node{Name: "q"}).(*Node)
+	p1   = r.Register("p1", &Node{Name: "p1", Parent: p}).(*Node)
+	p2   = r.Register("p2", &Node{Name: "p2", Parent: pDup}).(*Node)
+	q1   = r.Register("q1", &Node{Name: "q1", Parent: q}).(*Node)
+
+	// childNamed binds the child with the given name, and uses a local
+	// variable for the parent of the child.
+	childNamed = rel.DefineRule("child-named", []rel.Var{"c", "n"}, func(params ...rel.Var) rel.Clause {
+		c, n := params[0], params[1]
+		var p rel.Var = "p"
+		return rel.And(
+			c.AttrEqVar(Name, n),
+			c.AttrEqVar(Parent, p),
+			p.Type((*Node)(nil)),
+		)
+	})
+
+	// hasChild constrains c to be a child of n.
+	hasChild = func(n, c rel.Var) rel.Clause {
+		return c.AttrEqVar(Parent, n)
+	}
+
+	databaseTests = []reltest.DatabaseTest{
+		{
+			Data: []string{"root", "a", "b", "a1", "a2", "b1", "c"},
+			Indexes: [][][]rel.Attr{
+				nil,
+				{{Parent}},
+			},
+			QueryCases: []reltest.QueryTest{
+				{
+					Name: "leaves",
+					Query: rel.Clauses{
+						v("n").HasNoChildVia(Parent),
+					},
+					Entities: []v{"n"},
+					ResVars:  []v{"n"},
+					Results: [][]interface{}{
+						{a1}, {a2}, {b1}, {c},
+					},
+				},
+				{
+					Name: "internal nodes",
+					Query: rel.Clauses{
+						v("n").AttrEqVar(rel.Self, "n"),
+						rel.Not(v("n").HasNoChildVia(Parent)),
+					},
+					Entities: []v{"n"},
+					ResVars:  []v{"n"},
+					Results: [][]interface{}{
+						{root}, {a}, {b},
+					},
+				},
+				{
+					Name: "leaves under a",
+					Query: rel.Clauses{
+						v("n").AttrEq(Parent, a),
+						v("n").HasNoChildVia(Parent),
+					},
+					Entities: []v{"n"},
+					ResVars:  []v{"n"},
+					Results: [][]interface{}{
+						{a1}, {a2},
+					},
+				},
+				{
+					Name: "children of root which are leaves",
+					Query: rel.Clauses{
+						v("n").AttrEq(Parent, root),
+						v("n").HasNoChildVia(Parent),
+					},
+					Entities: []v{"n"},
+					ResVars:  []v{"n"},
+					Results:  [][]interface{}{},
+				},
+				{
+					Name: "attribute does not refer to entities",
+					Query: rel.Clauses{
+						v("n").HasNoChildVia(Name),
+					},
+					ErrorRE: `failed to process invalid clause \$n hasNoChildVia\(Name\): ` +
+						`Name of type string does not refer to entities`,
+				},
+				{
+					Name: "zero parent",
+					Query: rel.Clauses{
+						v("n").AttrIsZero(Parent),
+					},
+					ErrorRE: `\*treetest.Node is not a type with a non-nil zero value`,
+				},
+				{
+					Name: "nodes with at least 0 children",
+					Query: rel.Clauses{
+						v("n").Type((*Node)(nil)),
+						rel.AtLeast(0, v("c").AttrEqVar(Parent, "n")),
+					},
+					Entities: []v{"n"},
+					ResVars:  []v{"n"},
+					Results: [][]interface{}{
+						{root}, {a}, {b}, {a1}, {a2}, {b1}, {c},
+					},
+				},
+				{
+					Name: "nodes with at least 1 child",
+					Query: rel.Clauses{
+						v("n").Type((*Node)(nil)),
+						rel.AtLeast(1, v("c").AttrEqVar(Parent, "n")),
+					},
+					Entities: []v{"n"},
+					ResVars:  []v{"n"},
+					Results: [][]interface{}{
+						{root}, {a}, {b},
+					},
+				},
+				{
+					Name: "nodes with at least 2 children",
+					Query: rel.Clauses{
+						v("n").Type((*Node)(nil)),
+						rel.AtLeast(2, v("c").AttrEqVar(Parent, "n")),
+					},
+					Entities: []v{"n"},
+					ResVars:  []v{"n"},
+					Results: [][]interface{}{
+						{root}, {a},
+					},
+				},
+				{
+					Name: "nodes with at least 3 children",
+					Query: rel.Clauses{
+						v("n").Type((*Node)(nil)),
+						rel.AtLeast(3, v("c").AttrEqVar(Parent, "n")),
+					},
+					Entities: []v{"n"},
+					ResVars:  []v{"n"},
+					Results:  [][]interface{}{},
+				},
+				{
+					Name: "nodes with at most 0 children",
+					Query: rel.Clauses{
+						v("n").Type((*Node)(nil)),
+						rel.AtMost(0, v("c").AttrEqVar(Parent, "n")),
+					},
+					Entities: []v{"n"},
+					ResVars:  []v{"n"},
+					Results: [][]interface{}{
+						{a1}, {a2}, {b1}, {c},
+					},
+				},
+				{
+					Name: "nodes with at most 1 child",
+					Query: rel.Clauses{
+						v("n").Type((*Node)(nil)),
+						rel.AtMost(1, v("c").AttrEqVar(Parent, "n")),
+					},
+					Entities: []v{"n"},
+					ResVars:  []v{"n"},
+					Results: [][]interface{}{
+						{b}, {a1}, {a2}, {b1}, {c},
+					},
+				},
+				{
+					Name: "nodes with at most 2 children",
+					Query: rel.Clauses{
+						v("n").Type((*Node)(nil)),
+						rel.AtMost(2, v("c").AttrEqVar(Parent, "n")),
+					},
+					Entities: []v{"n"},
+					ResVars:  []v{"n"},
+					Results: [][]interface{}{
+						{root}, {a}, {b}, {a1}, {a2}, {b1}, {c},
+					},
+				},
+				{
+					// The two applications use the same local variable, p, and the
+					// query uses it too; every child would share the parent of y if
+					// the local variables leaked.
+					Name: "applications of a rule",
+					Query: rel.Clauses{
+						childNamed.Apply("x", "xName"),
+						childNamed.Apply("y", "yName"),
+						v("p").AttrEq(Name, "b"),
+						v("y").AttrEqVar(Parent, "p"),
+					},
+					// Each application's local variables are named distinctly.
+					Entities: []v{"x", "child-named:1:p", "y", "child-named:2:p", "p"},
+					ResVars:  []v{"xName", "yName"},
+					Results: [][]interface{}{
+						{"a", "b1"}, {"b", "b1"},
+						{"a1", "b1"}, {"a2", "b1"}, {"b1", "b1"},
+					},
+				},
+				{
+					Name: "nodes without children",
+					Query: rel.Clauses{
+						v("n").Type((*Node)(nil)),
+						rel.AntiJoin("n", "c", hasChild),
+					},
+					Entities: []v{"n"},
+					ResVars:  []v{"n"},
+					Results: [][]interface{}{
+						{a1}, {a2}, {b1}, {c},
+					},
+				},
+				{
+					// It matches the formulation negating the existence of a solution.
+					Name: "nodes without children by count",
+					Query: rel.Clauses{
+						v("n").Type((*Node)(nil)),
+						rel.AtMost(0, hasChild("n", "c")),
+					},
+					Entities: []v{"n"},
+					ResVars:  []v{"n"},
+					Results: [][]interface{}{
+						{a1}, {a2}, {b1}, {c},
+					},
+				},
+				{
+					// The enclosing query binds c to a1, which neither correlates the
+					// anti-join nor is rebound by its local variable.
+					Name: "nodes without children with a bound local variable",
+					Query: rel.Clauses{
+						v("c").AttrEq(Name, "a1"),
+						v("n").Type((*Node)(nil)),
+						rel.AntiJoin("n", "c", hasChild),
+					},
+					Entities: []v{"c", "n"},
+					ResVars:  []v{"n", "c"},
+					Results: [][]interface{}{
+						{a1, a1}, {a2, a1}, {b1, a1}, {c, a1},
+					},
+				},
+				{
+					// With AtMost, c is correlated.
+					Name: "nodes which are not the parent of a bound node",
+					Query: rel.Clauses{
+						v("c").AttrEq(Name, "a1"),
+						v("n").Type((*Node)(nil)),
+						rel.AtMost(0, hasChild("n", "c")),
+					},
+					Entities: []v{"c", "n"},
+					ResVars:  []v{"n"},
+					Results: [][]interface{}{
+						{root}, {b}, {a1}, {a2}, {b1}, {c},
+					},
+				},
+				{
+					// The local variable of the inner anti-join, which is over the same
+					// variable, isn't correlated with that of the outer one.
+					Name: "nodes without leaf children",
+					Query: rel.Clauses{
+						v("n").Type((*Node)(nil)),
+						rel.AntiJoin("n", "c", func(n, c rel.Var) rel.Clause {
+							return rel.And(hasChild(n, c), rel.AntiJoin(c, c, hasChild))
+						}),
+					},
+					Entities: []v{"n"},
+					ResVars:  []v{"n"},
+					Results: [][]interface{}{
+						{root}, {a1}, {a2}, {b1}, {c},
+					},
+				},
+				{
+					Name: "anti-join of an unbound node",
+					Query: rel.Clauses{
+						v("c").Type((*Node)(nil)),
+						rel.AntiJoin("n", "x", hasChild),
+					},
+					ErrorRE: `variable n in anti-join is not bound by the query`,
+				},
+				{
+					Name: "nodes with at least a negative number of children",
+					Query: rel.Clauses{
+						v("n").Type((*Node)(nil)),
+						rel.AtLeast(-1, v("c").AttrEqVar(Parent, "n")),
+					},
+					ErrorRE: `invalid negative count -1`,
+				},
+			},
+		},
+		{
+			// Inserting p1 inserts p.
+			Data: []string{"p1", "pDup", "p2", "q1"},
+			Indexes: [][][]rel.Attr{
+				nil,
+				{{Parent}},
+			},
+			QueryCases: []reltest.QueryTest{
+				{
+					Name: "nodes with an identity",
+					Query: rel.Clauses{
+						v("n").AttrEq(Name, "p"),
+					},
+					Entities: []v{"n"},
+					ResVars:  []v{"n"},
+					Results: [][]interface{}{
+						{p},
+					},
+				},
+				{
+					Name: "distinct nodes with the same name",
+					Query: rel.Clauses{
+						rel.SamePropertyDifferentEntity(Name, "x", "y"),
+					},
+					Entities: []v{"x", "y"},
+					ResVars:  []v{"x", "y"},
+					Results:  [][]interface{}{},
+				},
+				{
+					// The parent of p2 refers to p.
+					Name: "distinct nodes with the same parent",
+					Query: rel.Clauses{
+						rel.SamePropertyDifferentEntity(Parent, "x", "y"),
+					},
+					Entities: []v{"x", "y"},
+					ResVars:  []v{"x", "y"},
+					Results: [][]interface{}{
+						{p1, p2}, {p2, p1},
+					},
+				},
+				{
+					// The identity of pDup is p, which was inserted first.
+					Name: "identities of nodes",
+					Query: rel.Clauses{
+						v("n").AttrHasPrefix(Name, "p"),
+						v("n").IdentityInto("id"),
+					},
+					Entities: []v{"n"},
+					ResVars:  []v{"id"},
+					Results: [][]interface{}{
+						{p}, {p1}, {p2},
+					},
+				},
+				{
+					Name: "identity equal to a node",
+					Query: rel.Clauses{
+						v("n").AttrHasPrefix(Name, "p"),
+						v("n").IdentityInto("id"),
+						v("id").Eq(p),
+					},
+					Entities: []v{"n"},
+					ResVars:  []v{"id"},
+					Results: [][]interface{}{
+						{p},
+					},
+				},
+				{
+					Name: "identity in a set of nodes",
+					Query: rel.Clauses{
+						v("n").AttrHasPrefix(Name, "p"),
+						v("n").IdentityInto("id"),
+						v("id").In(p, p2, q),
+					},
+					Entities: []v{"n"},
+					ResVars:  []v{"id"},
+					Results: [][]interface{}{
+						{p}, {p2},
+					},
+				},
+				{
+					Name: "identities of nodes which are not children",
+					Query: rel.Clauses{
+						v("n").AttrHasPrefix(Name, "p"),
+						v("n").IdentityInto("id"),
+						rel.Not(v("n").AttrInResult(rel.Self, rel.And(v("c").AttrIn(Parent, p, q), v("c").IdentityInto("cID")), "cID")),
+					},
+					Entities: []v{"n"},
+					ResVars:  []v{"id"},
+					Results: [][]interface{}{
+						{p},
+					},
+				},
+				{
+					Name: "identities of nodes which are children",
+					Query: rel.Clauses{
+						v("n").AttrHasPrefix(Name, "p"),
+						v("n").IdentityInto("id"),
+						v("n").AttrInResult(rel.Self, rel.And(v("c").AttrIn(Parent, p, q), v("c").IdentityInto("cID")), "cID"),
+					},
+					Entities: []v{"n"},
+					ResVars:  []v{"id"},
+					Results: [][]interface{}{
+						{p1}, {p2},
+					},
+				},
+			},
+		},
+		{
+			Data: []string{"root", "a", "b", "a1", "a2", "b1", "c", "x", "y"},
+			Indexes: [][][]rel.Attr{
+				nil,
+				{{Parent}},
+			},
+			QueryCases: []reltest.QueryTest{
+				{
+					Name: "nodes at depth 0 from root",
+					Query: rel.Clauses{
+						v("r").AttrEq(Name, "root"),
+						v("n").AtDepth(Parent, "r", 0, "n"),
+					},
+					Entities: []v{"r", "n"},
+					ResVars:  []v{"n"},
+					Results: [][]interface{}{
+						{root},
+					},
+				},
+				{
+					Name: "nodes at depth 1 from root",
+					Query: rel.Clauses{
+						v("r").AttrEq(Name, "root"),
+						v("n").AtDepth(Parent, "r", 1, "n"),
+					},
+					Entities: []v{"r", "n"},
+					ResVars:  []v{"n"},
+					Results: [][]interface{}{
+						{a}, {b},
+					},
+				},
+				{
+					Name: "nodes at depth 2 from root",
+					Query: rel.Clauses{
+						v("r").AttrEq(Name, "root"),
+						v("n").AtDepth(Parent, "r", 2, "n"),
+					},
+					Entities: []v{"r", "n"},
+					ResVars:  []v{"n"},
+					Results: [][]interface{}{
+						{a1}, {a2}, {b1},
+					},
+				},
+				{
+					Name: "nodes at depth 3 from root",
+					Query: rel.Clauses{
+						v("r").AttrEq(Name, "root"),
+						v("n").AtDepth(Parent, "r", 3, "n"),
+					},
+					Entities: []v{"r", "n"},
+					ResVars:  []v{"n"},
+					Results:  [][]interface{}{},
+				},
+				{
+					Name: "nodes at depth 1 from a",
+					Query: rel.Clauses{
+						v("r").AttrEq(Name, "a"),
+						v("n").AtDepth(Parent, "r", 1, "n"),
+					},
+					Entities: []v{"r", "n"},
+					ResVars:  []v{"n"},
+					Results: [][]interface{}{
+						{a1}, {a2},
+					},
+				},
+				{
+					Name: "nodes at depth 1 from b1",
+					Query: rel.Clauses{
+						v("r").AttrEq(Name, "b1"),
+						v("n").AtDepth(Parent, "r", 1, "n"),
+					},
+					Entities: []v{"r", "n"},
+					ResVars:  []v{"n"},
+					Results:  [][]interface{}{},
+				},
+				{
+					Name: "nodes at depth 0 from x",
+					Query: rel.Clauses{
+						v("r").AttrEq(Name, "x"),
+						v("n").AtDepth(Parent, "r", 0, "n"),
+					},
+					Entities: []v{"r", "n"},
+					ResVars:  []v{"n"},
+					Results: [][]interface{}{
+						{x},
+					},
+				},
+				{
+					Name: "nodes at depth 1 from x",
+					Query: rel.Clauses{
+						v("r").AttrEq(Name, "x"),
+						v("n").AtDepth(Parent, "r", 1, "n"),
+					},
+					Entities: []v{"r", "n"},
+					ResVars:  []v{"n"},
+					Results: [][]interface{}{
+						{y},
+					},
+				},
+				{
+					// As with ExactlyHops, the path around the cycle back to the root
+					// counts, but y, which reaches x in one hop, is not also at depth 3.
+					Name: "nodes at depth 2 from x",
+					Query: rel.Clauses{
+						v("r").AttrEq(Name, "x"),
+						v("n").AtDepth(Parent, "r", 2, "n"),
+					},
+					Entities: []v{"r", "n"},
+					ResVars:  []v{"n"},
+					Results: [][]interface{}{
+						{x},
+					},
+				},
+				{
+					Name: "nodes at depth 3 from x",
+					Query: rel.Clauses{
+						v("r").AttrEq(Name, "x"),
+						v("n").AtDepth(Parent, "r", 3, "n"),
+					},
+					Entities: []v{"r", "n"},
+					ResVars:  []v{"n"},
+					Results:  [][]interface{}{},
+				},
+				{
+					Name: "distinct target at depth 2 from root",
+					Query: rel.Clauses{
+						v("r").AttrEq(Name, "root"),
+						v("n").AtDepth(Parent, "r", 2, "target"),
+					},
+					Entities: []v{"r", "target", "n"},
+					ResVars:  []v{"n", "target"},
+					Results: [][]interface{}{
+						{a1, a1}, {a2, a2}, {b1, b1},
+					},
+				},
+				{
+					Name: "nodes at negative depth",
+					Query: rel.Clauses{
+						v("r").AttrEq(Name, "root"),
+						v("n").AtDepth(Parent, "r", -1, "n"),
+					},
+					ErrorRE: `invalid number of hops -1`,
+				},
+				{
+					Name: "nodes unreachable from a1, a2, b1",
+					Query: rel.Clauses{
+						v("r1").AttrEq(Name, "a1"),
+						v("r2").AttrEq(Name, "a2"),
+						v("r3").AttrEq(Name, "b1"),
+						rel.UnreachableFrom(Parent, []rel.Var{"r1", "r2", "r3"}, "n"),
+					},
+					Entities: []v{"r1", "r2", "r3", "n"},
+					ResVars:  []v{"n"},
+					Results: [][]interface{}{
+						{c}, {x}, {y},
+					},
+				},
+				{
+					Name: "nodes unreachable from a1",
+					Query: rel.Clauses{
+						v("r1").AttrEq(Name, "a1"),
+						rel.UnreachableFrom(Parent, []rel.Var{"r1"}, "n"),
+					},
+					Entities: []v{"r1", "n"},
+					ResVars:  []v{"n"},
+					Results: [][]interface{}{
+						{a2}, {b}, {b1}, {c}, {x}, {y},
+					},
+				},
+				{
+					// Nodes on a cycle with a root are reachable from it.
+					Name: "nodes unreachable from x",
+					Query: rel.Clauses{
+						v("r1").AttrEq(Name, "x"),
+						rel.UnreachableFrom(Parent, []rel.Var{"r1"}, "n"),
+					},
+					Entities: []v{"r1", "n"},
+					ResVars:  []v{"n"},
+					Results: [][]interface{}{
+						{root}, {a}, {b}, {a1}, {a2}, {b1}, {c},
+					},
+				},
+				{
+					Name: "nodes unreachable from b1, y",
+					Query: rel.Clauses{
+						v("r1").AttrEq(Name, "b1"),
+						v("r2").AttrEq(Name, "y"),
+						rel.UnreachableFrom(Parent, []rel.Var{"r1", "r2"}, "n"),
+					},
+					Entities: []v{"r1", "r2", "n"},
+					ResVars:  []v{"n"},
+					Results: [][]interface{}{
+						{a}, {a1}, {a2}, {c},
+					},
+				},
+				{
+					Name: "nodes unreachable via names",
+					Query: rel.Clauses{
+						rel.UnreachableFrom(Name, []rel.Var{"r"}, "n"),
+					},
+					ErrorRE: `failed to process invalid clause \$n unreachableFrom\(\$r\)\[Name\]: ` +
+						`Name of type string does not refer to entities`,
+				},
+			},
+		},
+	}
+)
+
+func init() {
+	x.Parent = y
+}
@@ -11,16 +11,21 @@
 package rel
 
 import (
+	"fmt"
 	"sort"
 
 	"github.com/cockroachdb/cockroach/pkg/util"
+	"github.com/cockroachdb/cockroach/pkg/util/iterutil"
 	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
 	"github.com/cockroachdb/errors"
+	"gopkg.in/yaml.v3"
 )
 
 // Query searches for sets of entities which uphold A set of constraints.
 type Query struct {
 	schema *Schema
+	// id uniquely identifies the query within the process.
+	id uint64
 	// clauses are the original clauses. They exist for debugging.
 	clauses []Clause
 	// variables is the set of variables used in the query
@@ -36,6 +41,36 @@ type Query struct {
 	facts []fact
 	// filters are the set of predicate filters to evaluate.
 	filters []filter
+	// predicates are the set of internal predicates to evaluate.
+	predicates []predicate
+	// hops are the set of reachability constraints to evaluate.
+	hops []hop
+	// noChildren are the set of constraints that entities have no children.
+	noChildren []noChild
+	// unreachables are the set of constraints that entities are not
+	// reachable from others.
+	unreachables []unreachable
+	// subqueries are evaluated before each iteration to constrain slots.
+	subqueries []subquery
+	// negations are the set of negated clauses to evaluate.
+	negations []negation
+	// cardinalities are the set of constraints on the number of results of
+	// clauses.
+	cardinalities []cardinality
+	// extrema are the set of max and min constraints to evaluate.
+	extrema []extremum
+	// fanouts are the set of limits on the number of results.
+	fanouts []fanout
+	// joinKeys are the attributes constrained to the values of variables,
+	// which can be projected into the results.
+	joinKeys []joinKey
+	// branches are the queries of the branches of a UnionQueries clause, one
+	// for each branch, if the query has one. Such a query is evaluated by
+	// evaluating each of its branches in turn.
+	branches []*Query
+	// branch is the tag of the UnionQueries branch the query evaluates, if
+	// it is one of the branches of a query.
+	branch string
 
 	// cache one evalContext for reuse to accelerate benchmarks and deal with
 	// the common case.
@@ -54,6 +89,36 @@ type Result interface {
 	// If the variable does not exist in the query, nil will be
 	// returned.
 	Var(name Var) interface{}
+
+	// Attributes returns the attributes which are populated for the entity
+	// bound to the given variable, in schema order. System attributes are not
+	// included. An error is returned if the variable does not exist in the
+	// query or if it is not bound to an entity.
+	Attributes(name Var) ([]Attr, error)
+
+	// Attr returns the value of the attribute for the entity bound to the
+	// given variable. If the variable is not bound to an entity or the entity
+	// does not have a value for the attribute, false is returned.
+	Attr(name Var, a Attr) (interface{}, bool)
+
+	// FollowRef returns the entity referred to by the value of the attribute
+	// for the entity bound to the given variable. If the entity does not have
+	// a value for the attribute, false is returned. An error is returned if
+	// the variable is not bound to an entity, if the attribute does not refer
+	// to entities, or if the referenced value is not an entity in the
+	// database.
+	FollowRef(name Var, a Attr) (interface{}, bool, error)
+
+	// JoinKey returns the value on which the first AttrEqVar clause for the
+	// given attribute matched, that is, the value bound to the variable of
+	// that clause. It is intended for debugging joins, and is only populated
+	// if the query was evaluated with ProjectJoinKeys; otherwise, or if there
+	// is no such clause, false is returned.
+	JoinKey(a Attr) (interface{}, bool)
+
+	// Branch returns the tag of the UnionQueries branch which produced the
+	// result. It is empty if the query has no UnionQueries clause.
+	Branch() string
 }
 
 // ResultIterator is used to iterate results of A query.
@@ -64,6 +129,12 @@ type ResultIterator func(r Result) error
 // conjunction of constraints on the results of the query when it is
 // evaluated against a database.
 func NewQuery(sc *Schema, clauses ...Clause) (_ *Query, err error) {
+	return newQueryWithInterner(sc, nil /* ci */, clauses)
+}
+
+// newQueryWithInterner is like NewQuery, except that the leaf clauses are
+// interned using the ClauseInterner, if not nil.
+func newQueryWithInterner(sc *Schema, ci *ClauseInterner, clauses Clauses) (_ *Query, err error) {
 	defer func() {
 		switch r := recover().(type) {
 		case nil:
@@ -74,17 +145,62 @@ func NewQuery(sc *Schema, clauses ...Clause) (_ *Query, err error) {
 			err = errors.AssertionFailedf("failed to construct query: %v", r)
 		}
 	}()
-	q := newQuery(sc, clauses)
-	return q, nil
+	return newTopLevelQuery(sc, ci, clauses), nil
 }
 
 // Iterate will call the result iterator for every valid binding of each
 // distinct entity variable such that all the variables in the query are
-// bound and all filters passing.
-func (q *Query) Iterate(db *Database, ri ResultIterator) error {
+// bound and all filters passing. The options may be used to control the
+// order and the set of results.
+//
+// A query may be iterated concurrently by multiple goroutines, provided that
+// the database is not concurrently modified; see (*Database).Freeze.
+//
+// The branches of a query with a UnionQueries clause are iterated one after
+// the other, in the order in which they were provided. The options apply to
+// each branch separately.
+func (q *Query) Iterate(db *Database, ri ResultIterator, opts ...EvalOption) error {
+	if len(q.branches) > 0 {
+		return q.iterateBranches(db, ri, opts)
+	}
+	p, err := q.makeEvalPlan(opts)
+	if err != nil {
+		return err
+	}
 	ec := q.getEvalContext()
 	defer q.putEvalContext(ec)
-	return ec.Iterate(db, ri)
+	if p.empty() {
+		return ec.Iterate(db, ri)
+	}
+	return ec.iterateWithPlan(db, ri, p)
+}
+
+// iterateBranches iterates each of the branches of a union query, stopping
+// once the iterator halts the iteration.
+func (q *Query) iterateBranches(db *Database, ri ResultIterator, opts []EvalOption) error {
+	var stopped bool
+	branchRI := func(r Result) error {
+		err := ri(r)
+		if iterutil.Done(err) {
+			stopped = true
+		}
+		return err
+	}
+	for _, b := range q.branches {
+		if err := b.Iterate(db, branchRI, opts...); err != nil || stopped {
+			return err
+		}
+	}
+	return nil
+}
+
+// evaluated returns the queries which are evaluated to evaluate the query:
+// its branches if it is a union, and otherwise the query itself.
+func (q *Query) evaluated() []*Query {
+	if len(q.branches) > 0 {
+		return q.branches
+	}
+	return []*Query{q}
 }
 
 // getEvalContext grabs a cached evalContext from the query
@@ -112,8 +228,13 @@ func (q *Query) putEvalContext(ec *evalContext) {
 }
 
 // Entities returns the entities in the query in their join order.
-// This method exists primarily for introspection.
+// This method exists primarily for introspection. For a query with a
+// UnionQueries clause, the entities are those of the first branch which are
+// entities in every branch.
 func (q *Query) Entities() []Var {
+	if len(q.branches) > 0 {
+		return q.branchEntities()
+	}
 	var entitySlots util.FastIntSet
 	for _, slotIdx := range q.entities {
 		entitySlots.Add(int(slotIdx))
@@ -131,7 +252,52 @@ func (q *Query) Entities() []Var {
 	return vars
 }
 
+func (q *Query) branchEntities() []Var {
+	vars := q.branches[0].Entities()
+	for _, b := range q.branches[1:] {
+		inBranch := make(map[Var]struct{})
+		for _, v := range b.Entities() {
+			inBranch[v] = struct{}{}
+		}
+		shared := vars[:0]
+		for _, v := range vars {
+			if _, ok := inBranch[v]; ok {
+				shared = append(shared, v)
+			}
+		}
+		vars = shared
+	}
+	return vars
+}
+
+// QueryFingerprint identifies a Query. Queries are immutable, so the
+// fingerprint of a query never changes. Queries constructed separately have
+// different fingerprints, even if they have the same clauses.
+type QueryFingerprint uint64
+
+// queryIDs is used to allocate the ids of queries.
+var queryIDs uint64
+
+// Fingerprint returns the fingerprint of the query.
+func (q *Query) Fingerprint() QueryFingerprint {
+	return QueryFingerprint(q.id)
+}
+
 // Clauses returns the query's Clauses.
 func (q *Query) Clauses() Clauses {
 	return q.clauses
 }
+
+// String returns the yaml representation of the query's clauses, in which
+// attributes are formatted using the names registered with NamedAttr.
+func (q *Query) String() string {
+	clauses := make(Clauses, len(q.clauses))
+	for i, c := range q.clauses {
+		clauses[i] = q.schema.withAttrNames(c)
+	}
+	out, err := yaml.Marshal(clauses)
+	if err != nil {
+		return fmt.Sprintf("failed to marshal query: %v", err)
+	}
+	return string(out)
+}
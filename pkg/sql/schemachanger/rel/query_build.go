@@ -11,9 +11,12 @@
 package rel
 
 import (
+	"fmt"
 	"reflect"
 	"sort"
+	"sync/atomic"
 
+	"github.com/cockroachdb/cockroach/pkg/util"
 	"github.com/cockroachdb/errors"
 	"gopkg.in/yaml.v2"
 )
@@ -25,28 +28,138 @@ type queryBuilder struct {
 	facts         []fact
 	slots         []slot
 	filters       []filter
+	predicates    []predicate
+	hops          []hop
+	noChildren    []noChild
+	unreachables  []unreachable
+	subqueries    []subquery
+	negations     []negation
+	cardinalities []cardinality
+	extrema       []extremum
+	fanouts       []fanout
+	joinKeys      []joinKey
+
+	// notDecls, countDecls, antiJoinDecls and extremumDecls are deferred until
+	// all the other clauses have been processed so that the variables bound by
+	// the query are known.
+	notDecls           []*notDecl
+	countDecls         []*countDecl
+	antiJoinDecls      []*antiJoinDecl
+	extremumDecls      []*extremumDecl
+	processingDeferred bool
 
 	// Track whether the slotIdx holds an entity separately. We want to
 	// know this in planning, but it'll be implicit during execution.
 	// This might be badly named. What we really mean here is that the
 	// slotIdx is a join target.
 	slotIsEntity []bool
+
+	// interner, if not nil, is used to share the values compiled from the
+	// constants of interned clauses.
+	interner *ClauseInterner
+}
+
+// newTopLevelQuery constructs a query which, unlike the queries nested in
+// other clauses, may contain a UnionQueries clause. Such a query is made of a
+// query for each branch, combining the branch's clause with the other
+// clauses.
+func newTopLevelQuery(sc *Schema, ci *ClauseInterner, clauses Clauses) *Query {
+	clauses = flattened(clauses)
+	unionIdx := -1
+	for i, c := range clauses {
+		if _, isUnion := c.(unionDecl); !isUnion {
+			continue
+		}
+		if unionIdx >= 0 {
+			panic(errors.Errorf("a query may contain at most one UnionQueries clause"))
+		}
+		unionIdx = i
+	}
+	if unionIdx < 0 {
+		return newInternedQuery(sc, ci, clauses)
+	}
+	u := clauses[unionIdx].(unionDecl)
+	if len(u) == 0 {
+		panic(errors.Errorf("UnionQueries requires at least one branch"))
+	}
+	rest := append(clauses[:unionIdx:unionIdx], clauses[unionIdx+1:]...)
+	q := &Query{
+		schema:  sc,
+		id:      atomic.AddUint64(&queryIDs, 1),
+		clauses: clauses,
+	}
+	tags := make(map[string]struct{}, len(u))
+	for _, b := range u {
+		if _, exists := tags[b.Tag]; exists {
+			panic(errors.Errorf("duplicate UnionQueries branch tag %q", b.Tag))
+		}
+		tags[b.Tag] = struct{}{}
+		bq, err := newBranchQuery(sc, ci, append(rest[:len(rest):len(rest)], b.Clause))
+		if err != nil {
+			panic(errors.Wrapf(err, "invalid UnionQueries branch %q", b.Tag))
+		}
+		bq.branch = b.Tag
+		q.branches = append(q.branches, bq)
+	}
+	return q
+}
+
+// newBranchQuery constructs the query for a branch of a UnionQueries clause,
+// returning the error with which its construction failed, if any.
+func newBranchQuery(sc *Schema, ci *ClauseInterner, clauses Clauses) (q *Query, err error) {
+	defer func() {
+		switch r := recover().(type) {
+		case nil:
+		case error:
+			err = r
+		default:
+			err = errors.AssertionFailedf("%v", r)
+		}
+	}()
+	return newInternedQuery(sc, ci, clauses), nil
 }
 
 // newQuery constructs a query. Errors are panicked and caught
 // in the calling NewQuery function.
 func newQuery(sc *Schema, clauses Clauses) *Query {
+	return newInternedQuery(sc, nil /* ci */, clauses)
+}
+
+// newInternedQuery is like newQuery, except that the leaf clauses are interned
+// using the ClauseInterner, if not nil.
+func newInternedQuery(sc *Schema, ci *ClauseInterner, clauses Clauses) *Query {
 	p := &queryBuilder{
 		sc:            sc,
 		variableSlots: map[Var]slotIdx{},
+		interner:      ci,
 	}
 	// Flatten away nested and clauses. We may need them at some point
 	// if we add something like or-join or not-join. At time of writing,
 	// the and case in processClause is an assertion failure.
 	clauses = flattened(clauses)
+	if ci != nil {
+		interned := make(Clauses, len(clauses))
+		for i, c := range clauses {
+			interned[i] = ci.intern(c)
+		}
+		clauses = interned
+	}
 	for _, t := range clauses {
 		p.processClause(t)
 	}
+	p.processingDeferred = true
+	for _, t := range p.notDecls {
+		p.processClause(t)
+	}
+	for _, t := range p.countDecls {
+		p.processClause(t)
+	}
+	for _, t := range p.antiJoinDecls {
+		p.processClause(t)
+	}
+	for _, t := range p.extremumDecls {
+		p.processClause(t)
+	}
 
 	// Order the facts for unification. The ordering is first by variable
 	// variable and then by attribute.
@@ -58,6 +171,8 @@ func newQuery(sc *Schema, clauses Clauses) *Query {
 	// However, we do need all the facts with the same variable and attribute
 	// to be adjacent for the unification fixed point evaluation to work.
 	entities := p.findEntitySlots()
+	p.setFanoutOuterSlots(entities)
+	p.setPredicatesBoundAt(entities)
 	sort.SliceStable(p.facts, func(i, j int) bool {
 		if p.facts[i].variable == p.facts[j].variable {
 			return p.facts[i].attr < p.facts[j].attr
@@ -70,11 +185,18 @@ func newQuery(sc *Schema, clauses Clauses) *Query {
 		p.facts, p.slots, nil,
 	); contradictionFound {
 		panic(errors.Errorf(
-			"query contains contradiction on %v", sc.attrs[contradiction.attr],
+			"query contains contradiction on %v", sc.ordinalName(contradiction.attr),
+		))
+	}
+	if contradiction, found := findDisjointValueSets(p.facts, p.slots); found {
+		panic(errors.Errorf(
+			"query contains contradiction on %v: no value is permitted by all clauses",
+			sc.ordinalName(contradiction.attr),
 		))
 	}
 	return &Query{
 		schema:        sc,
+		id:            atomic.AddUint64(&queryIDs, 1),
 		variables:     p.variables,
 		variableSlots: p.variableSlots,
 		clauses:       clauses,
@@ -82,6 +204,16 @@ func newQuery(sc *Schema, clauses Clauses) *Query {
 		facts:         p.facts,
 		slots:         p.slots,
 		filters:       p.filters,
+		predicates:    p.predicates,
+		hops:          p.hops,
+		noChildren:    p.noChildren,
+		unreachables:  p.unreachables,
+		subqueries:    p.subqueries,
+		negations:     p.negations,
+		cardinalities: p.cardinalities,
+		extrema:       p.extrema,
+		fanouts:       p.fanouts,
+		joinKeys:      p.joinKeys,
 	}
 }
 
@@ -92,7 +224,7 @@ func (p *queryBuilder) processClause(t Clause) {
 			if !ok {
 				rErr = errors.AssertionFailedf("processClause: panic: %v", r)
 			}
-			encoded, err := yaml.Marshal(t)
+			encoded, err := yaml.Marshal(p.sc.withAttrNames(t))
 			if err != nil {
 				panic(errors.CombineErrors(rErr, errors.Wrap(
 					err, "failed to encode clause",
@@ -108,8 +240,34 @@ func (p *queryBuilder) processClause(t Clause) {
 		p.processTripleDecl(t)
 	case *eqDecl:
 		p.processEqDecl(t)
+	case *attrEqAttrDecl:
+		p.processAttrEqAttrDecl(t)
 	case *filterDecl:
 		p.processFilterDecl(t)
+	case *predicateDecl:
+		p.processPredicateDecl(t)
+	case *hopsDecl:
+		p.processHopsDecl(t)
+	case *noChildDecl:
+		p.processNoChildDecl(t)
+	case *unreachableDecl:
+		p.processUnreachableDecl(t)
+	case *subqueryDecl:
+		p.processSubqueryDecl(t)
+	case *fanoutDecl:
+		p.processFanoutDecl(t)
+	case *notDecl:
+		p.processNotDecl(t)
+	case *countDecl:
+		p.processCountDecl(t)
+	case *antiJoinDecl:
+		p.processAntiJoinDecl(t)
+	case *extremumDecl:
+		p.processExtremumDecl(t)
+	case unionDecl:
+		panic(errors.Errorf("UnionQueries is only supported at the top level of a query"))
+	case or:
+		panic(errors.Errorf("disjunctions are only supported directly beneath Not"))
 	case and:
 		panic(errors.AssertionFailedf("and clauses should be flattened away"))
 	default:
@@ -121,10 +279,18 @@ func (p *queryBuilder) processTripleDecl(fd *tripleDecl) {
 	f := fact{
 		variable: p.maybeAddVar(fd.entity, true /* entity */),
 		attr:     p.sc.mustGetOrdinal(fd.attribute),
+		clause:   fd,
+	}
+	if b, isBool := fd.value.(boolExpr); isBool {
+		f.value = p.processBoolExpr(f.attr, b)
+	} else {
+		f.value = p.processValueExpr(fd.value)
 	}
-	f.value = p.processValueExpr(fd.value)
 	p.typeCheck(f)
 	p.facts = append(p.facts, f)
+	if _, isVar := fd.value.(Var); isVar {
+		p.joinKeys = append(p.joinKeys, joinKey{attr: f.attr, slot: f.value})
+	}
 }
 
 func (p *queryBuilder) processEqDecl(t *eqDecl) {
@@ -142,38 +308,83 @@ func (p *queryBuilder) processEqDecl(t *eqDecl) {
 			variable: varIdx,
 			attr:     p.sc.mustGetOrdinal(Self),
 			value:    valueIdx,
+			clause:   t,
 		},
 		fact{
 			variable: varIdx,
 			attr:     p.sc.mustGetOrdinal(Self),
 			value:    varIdx,
+			clause:   t,
+		})
+}
+
+func (p *queryBuilder) processAttrEqAttrDecl(t *attrEqAttrDecl) {
+	attr := p.sc.mustGetOrdinal(t.attribute)
+	otherAttr := p.sc.mustGetOrdinal(t.otherAttribute)
+	if err := checkComparableTypes(
+		p.sc.attrTypes[attr], p.sc.attrTypes[otherAttr],
+	); err != nil {
+		panic(err)
+	}
+	// The slot for the value is not associated with a variable.
+	value := p.fillSlot(slot{}, false /* isEntity */)
+	p.facts = append(p.facts,
+		fact{
+			variable: p.maybeAddVar(t.entity, true /* entity */),
+			attr:     attr,
+			value:    value,
+			clause:   t,
+		},
+		fact{
+			variable: p.maybeAddVar(t.other, true /* entity */),
+			attr:     otherAttr,
+			value:    value,
+			clause:   t,
 		})
 }
 
 func (p *queryBuilder) processFilterDecl(t *filterDecl) {
 	fv := reflect.ValueOf(t.predicateFunc)
+	invalid := func(cause error) error {
+		return &FilterError{Name: t.name, Vars: t.vars, cause: cause}
+	}
 	// Type check the function.
 	if err := checkNotNil(fv); err != nil {
-		panic(errors.Wrapf(err, "nil filter function for variables %s", t.vars))
+		panic(invalid(errors.Wrapf(err, "nil filter function for variables %s", t.vars)))
+	}
+	if t.slice {
+		if fv.IsNil() {
+			panic(invalid(errors.Errorf("nil filter function for variables %s", t.vars)))
+		}
+		slots := make([]slotIdx, len(t.vars))
+		for i, v := range t.vars {
+			slots[i] = p.maybeAddVar(v, false)
+		}
+		p.filters = append(p.filters, filter{
+			input:          slots,
+			slicePredicate: t.predicateFunc.(func([]interface{}) bool),
+			clause:         t,
+		})
+		return
 	}
 	if fv.Kind() != reflect.Func {
-		panic(errors.Errorf(
+		panic(invalid(errors.Errorf(
 			"non-function %T filter function for variables %s",
 			t.predicateFunc, t.vars,
-		))
+		)))
 	}
 	ft := fv.Type()
 	if ft.NumOut() != 1 || ft.Out(0) != boolType {
-		panic(errors.Errorf(
+		panic(invalid(errors.Errorf(
 			"invalid non-bool return from %T filter function for variables %s",
 			t.predicateFunc, t.vars,
-		))
+		)))
 	}
 	if ft.NumIn() != len(t.vars) {
-		panic(errors.Errorf(
+		panic(invalid(errors.Errorf(
 			"invalid %T filter function for variables %s accepts %d inputs",
 			t.predicateFunc, t.vars, ft.NumIn(),
-		))
+		)))
 	}
 
 	slots := make([]slotIdx, len(t.vars))
@@ -192,23 +403,368 @@ func (p *queryBuilder) processFilterDecl(t *filterDecl) {
 	p.filters = append(p.filters, filter{
 		input:     slots,
 		predicate: fv,
+		clause:    t,
+	})
+}
+
+func (p *queryBuilder) processPredicateDecl(t *predicateDecl) {
+	self := p.sc.mustGetOrdinal(Self)
+	types := make([]reflect.Type, len(t.operands))
+	operands := make([]operand, len(t.operands))
+	for i, ref := range t.operands {
+		ord := p.sc.mustGetOrdinal(ref.a)
+		operands[i] = operand{
+			slot: p.maybeAddVar(ref.v, ord != self),
+			attr: ord,
+		}
+		types[i] = p.sc.attrTypes[ord]
+	}
+	fn, err := t.newPredicate(types)
+	if err != nil {
+		panic(err)
+	}
+	p.predicates = append(p.predicates, predicate{
+		operands: operands,
+		fn:       fn,
+		clause:   t,
+	})
+}
+
+func (p *queryBuilder) processHopsDecl(t *hopsDecl) {
+	if t.hops < 1 && !(t.exact && t.hops == 0) {
+		panic(errors.Errorf("invalid number of hops %d", t.hops))
+	}
+	attr := p.sc.mustGetOrdinal(t.attribute)
+	if typ := p.sc.attrTypes[attr]; !isEntityType(typ) {
+		panic(errors.Errorf("%v of type %v does not refer to entities", p.sc.AttrName(t.attribute), typ))
+	}
+	p.hops = append(p.hops, hop{
+		src:    p.maybeAddVar(t.entity, true /* entity */),
+		target: p.maybeAddVar(t.target, true /* entity */),
+		attr:   attr,
+		hops:   t.hops,
+		exact:  t.exact,
+		clause: t,
+	})
+}
+
+func (p *queryBuilder) processNoChildDecl(t *noChildDecl) {
+	attr := p.sc.mustGetOrdinal(t.attribute)
+	if typ := p.sc.attrTypes[attr]; !isEntityType(typ) {
+		panic(errors.Errorf("%v of type %v does not refer to entities", p.sc.AttrName(t.attribute), typ))
+	}
+	p.noChildren = append(p.noChildren, noChild{
+		parent: p.maybeAddVar(t.entity, true /* entity */),
+		attr:   attr,
+		clause: t,
+	})
+}
+
+func (p *queryBuilder) processUnreachableDecl(t *unreachableDecl) {
+	if len(t.roots) == 0 {
+		panic(errors.Errorf("no roots"))
+	}
+	attr := p.sc.mustGetOrdinal(t.attribute)
+	if typ := p.sc.attrTypes[attr]; !isEntityType(typ) {
+		panic(errors.Errorf("%v of type %v does not refer to entities", p.sc.AttrName(t.attribute), typ))
+	}
+	u := unreachable{
+		roots:  make([]slotIdx, len(t.roots)),
+		target: p.maybeAddVar(t.target, true /* entity */),
+		attr:   attr,
+		clause: t,
+	}
+	for i, r := range t.roots {
+		u.roots[i] = p.maybeAddVar(r, true /* entity */)
+	}
+	p.unreachables = append(p.unreachables, u)
+}
+
+func (p *queryBuilder) processExtremumDecl(t *extremumDecl) {
+	if !p.processingDeferred {
+		// The entity must itself satisfy the clause.
+		for _, c := range flattened(Clauses{t.within}) {
+			p.processClause(c)
+		}
+		p.extremumDecls = append(p.extremumDecls, t)
+		return
+	}
+	attr := p.sc.mustGetOrdinal(t.attribute)
+	if typ := p.sc.attrTypes[attr]; !isOrderedType(typ) {
+		panic(&TypeMismatchError{Type: typ, Expected: "an ordered type"})
+	}
+	x := extremum{
+		q:      newQuery(p.sc, Clauses{t.within}),
+		entity: p.maybeAddVar(t.entity, true /* entity */),
+		attr:   attr,
+		max:    t.max,
+		clause: t,
+	}
+	sub, ok := x.q.variableSlots[t.entity]
+	if !ok {
+		panic(errors.Errorf("variable %s is not used in the clause", t.entity))
+	}
+	x.sub = sub
+	for _, v := range x.q.variables {
+		if v == t.entity {
+			continue
+		}
+		// All the variables are bound, as the clause is part of the query.
+		x.inputs = append(x.inputs, negationInput{
+			src: p.variableSlots[v],
+			dst: x.q.variableSlots[v],
+		})
+	}
+	p.extrema = append(p.extrema, x)
+}
+
+// findDisjointValueSets looks for an attribute of a variable which is
+// constrained by AttrEq and AttrIn clauses to sets of values which do not
+// intersect, such that the query can never produce results. Unification does
+// not find these contradictions because it does not look into the sets of
+// values. The facts must be sorted by variable and attribute.
+func findDisjointValueSets(facts []fact, slots []slot) (contradiction fact, found bool) {
+	for i := 0; i < len(facts); {
+		var permitted []typedValue
+		var constrained bool
+		j := i
+		for ; j < len(facts) && facts[j].variable == facts[i].variable &&
+			facts[j].attr == facts[i].attr; j++ {
+			var values []typedValue
+			switch s := &slots[facts[j].value]; {
+			case !s.empty():
+				values = []typedValue{s.typedValue}
+			case s.any != nil:
+				values = s.any
+			default:
+				continue
+			}
+			if !constrained {
+				permitted, constrained = values, true
+				continue
+			}
+			if permitted = intersectValues(permitted, values); len(permitted) == 0 {
+				return facts[j], true
+			}
+		}
+		i = j
+	}
+	return fact{}, false
+}
+
+// intersectValues returns the values of a which are also in b.
+func intersectValues(a, b []typedValue) []typedValue {
+	var ret []typedValue
+	for _, av := range a {
+		for _, bv := range b {
+			if _, eq := av.compare(bv); eq {
+				ret = append(ret, av)
+				break
+			}
+		}
+	}
+	return ret
+}
+
+// isEntityType returns true if values of the type may be entities.
+func isEntityType(typ reflect.Type) bool {
+	switch typ.Kind() {
+	case reflect.Interface:
+		return true
+	case reflect.Ptr:
+		return typ.Elem().Kind() == reflect.Struct
+	default:
+		return false
+	}
+}
+
+func (p *queryBuilder) processSubqueryDecl(t *subqueryDecl) {
+	sub := newQuery(p.sc, Clauses{t.sub})
+	v, ok := sub.variableSlots[t.subVar]
+	if !ok {
+		panic(errors.Errorf("variable %s is not used in the subquery", t.subVar))
+	}
+	// The slot is populated with the subquery results during evaluation. Until
+	// then, the empty set of values permits no value.
+	dst := p.fillSlot(slot{any: []typedValue{}}, false)
+	p.facts = append(p.facts, fact{
+		variable: p.maybeAddVar(t.entity, true /* entity */),
+		attr:     p.sc.mustGetOrdinal(t.attribute),
+		value:    dst,
+		clause:   t,
+	})
+	p.subqueries = append(p.subqueries, subquery{
+		q:   sub,
+		v:   v,
+		dst: dst,
 	})
 }
 
+func (p *queryBuilder) processFanoutDecl(t *fanoutDecl) {
+	if t.n < 1 {
+		panic(errors.Errorf("invalid max fanout %d", t.n))
+	}
+	numSlots := len(p.slots)
+	var entitiesBefore util.FastIntSet
+	for i, isEntity := range p.slotIsEntity {
+		if isEntity {
+			entitiesBefore.Add(i)
+		}
+	}
+	for _, c := range flattened(Clauses{t.c}) {
+		p.processClause(c)
+	}
+	f := fanout{n: t.n}
+	for _, v := range p.variables {
+		idx := p.variableSlots[v]
+		if p.slotIsEntity[idx] && !entitiesBefore.Contains(int(idx)) {
+			f.inner.Add(int(idx))
+			f.vars = append(f.vars, v)
+		}
+	}
+	// Slots which were introduced by the clause but are not variables may
+	// still be entities; they are bound by the clause too.
+	for i := numSlots; i < len(p.slots); i++ {
+		if p.slotIsEntity[i] {
+			f.inner.Add(i)
+		}
+	}
+	if f.inner.Empty() {
+		panic(errors.Errorf("clause wrapped by MaxFanout introduces no entities"))
+	}
+	p.fanouts = append(p.fanouts, f)
+}
+
+// setFanoutOuterSlots populates the outer slots of each fanout with the
+// entity slots not introduced by the clause it wraps.
+func (p *queryBuilder) setFanoutOuterSlots(entities []slotIdx) {
+	for i := range p.fanouts {
+		f := &p.fanouts[i]
+		for _, idx := range entities {
+			if !f.inner.Contains(int(idx)) {
+				f.outer = append(f.outer, idx)
+			}
+		}
+	}
+}
+
+// processNotDecl defers the processing of the notDecl until all the other
+// clauses have been processed. Negated disjunctions are rewritten into
+// a negation for each term.
+func (p *queryBuilder) processNotDecl(t *notDecl) {
+	if !p.processingDeferred {
+		p.notDecls = append(p.notDecls, t)
+		return
+	}
+	if terms, isOr := t.c.(or); isOr {
+		for _, term := range flattened(Clauses(terms)) {
+			p.processNotDecl(&notDecl{c: term})
+		}
+		return
+	}
+	n := negation{q: newQuery(p.sc, Clauses{t.c}), clause: t}
+	for _, v := range n.q.variables {
+		src, ok := p.variableSlots[v]
+		if !ok {
+			panic(&UnboundVarError{Var: v, Context: "negated clause"})
+		}
+		n.inputs = append(n.inputs, negationInput{
+			src: src,
+			dst: n.q.variableSlots[v],
+		})
+	}
+	p.negations = append(p.negations, n)
+}
+
+// processCountDecl defers the processing of the countDecl until all the other
+// clauses have been processed. The variables of the clause which are bound by
+// the query become the inputs of the cardinality.
+func (p *queryBuilder) processCountDecl(t *countDecl) {
+	if t.n < 0 {
+		panic(errors.Errorf("invalid negative count %d", t.n))
+	}
+	if !p.processingDeferred {
+		p.countDecls = append(p.countDecls, t)
+		return
+	}
+	p.addCardinality(cardinality{
+		q:       newQuery(p.sc, Clauses{t.c}),
+		n:       t.n,
+		atLeast: t.atLeast,
+		clause:  t,
+	})
+}
+
+// processAntiJoinDecl defers the processing of the antiJoinDecl until all the
+// other clauses have been processed. It is then processed like a countDecl
+// permitting no solutions, once the entity is known to be bound by the query.
+func (p *queryBuilder) processAntiJoinDecl(t *antiJoinDecl) {
+	if !p.processingDeferred {
+		p.antiJoinDecls = append(p.antiJoinDecls, t)
+		return
+	}
+	if _, ok := p.variableSlots[t.entity]; !ok {
+		panic(&UnboundVarError{Var: t.entity, Context: "anti-join"})
+	}
+	c := t.c
+	if _, ok := p.variableSlots[t.local]; ok {
+		// The local variable must not be correlated with the enclosing query.
+		local := p.unusedVar(t.local)
+		c = mapVars(t.c, func(v Var) Var {
+			if v == t.local {
+				return local
+			}
+			return v
+		})
+	}
+	p.addCardinality(cardinality{
+		q:      newQuery(p.sc, Clauses{c}),
+		clause: t,
+	})
+}
+
+// unusedVar returns the first of v:1, v:2, ... which isn't a variable of the
+// query.
+func (p *queryBuilder) unusedVar(v Var) Var {
+	for i := 1; ; i++ {
+		candidate := Var(fmt.Sprintf("%s:%d", v, i))
+		if _, ok := p.variableSlots[candidate]; !ok {
+			return candidate
+		}
+	}
+}
+
+// addCardinality adds the cardinality, whose inputs are the variables of its
+// query which are bound by the enclosing query.
+func (p *queryBuilder) addCardinality(c cardinality) {
+	for _, v := range c.q.variables {
+		if src, ok := p.variableSlots[v]; ok {
+			c.inputs = append(c.inputs, negationInput{
+				src: src,
+				dst: c.q.variableSlots[v],
+			})
+		}
+	}
+	p.cardinalities = append(p.cardinalities, c)
+}
+
 func (p *queryBuilder) processValueExpr(rawValue expr) slotIdx {
 	switch v := rawValue.(type) {
 	case Var:
 		return p.maybeAddVar(v, false)
 	case anyExpr:
 		sd := slot{
-			any: make([]typedValue, len(v)),
-		}
-		for i, vv := range v {
-			tv, err := makeComparableValue(vv)
-			if err != nil {
-				panic(err)
-			}
-			sd.any[i] = tv
+			any: p.interner.anyValues(v, func() []typedValue {
+				values := make([]typedValue, len(v))
+				for i, vv := range v {
+					tv, err := makeComparableValue(vv)
+					if err != nil {
+						panic(err)
+					}
+					values[i] = tv
+				}
+				return values
+			}),
 		}
 		return p.fillSlot(sd, false)
 	case valueExpr:
@@ -222,6 +778,20 @@ func (p *queryBuilder) processValueExpr(rawValue expr) slotIdx {
 	}
 }
 
+// processBoolExpr converts the value to the type of the attribute, which must
+// be of a bool kind.
+func (p *queryBuilder) processBoolExpr(attr ordinal, b boolExpr) slotIdx {
+	typ := p.sc.attrTypes[attr]
+	if typ.Kind() != reflect.Bool {
+		panic(&TypeMismatchError{Type: typ, Expected: "a bool"})
+	}
+	tv, err := makeComparableValue(reflect.ValueOf(bool(b)).Convert(typ).Interface())
+	if err != nil {
+		panic(err)
+	}
+	return p.fillSlot(slot{typedValue: tv}, false)
+}
+
 func (p *queryBuilder) maybeAddVar(v Var, entity bool) slotIdx {
 	id, exists := p.variableSlots[v]
 	if exists {
@@ -255,6 +825,29 @@ func (p *queryBuilder) findEntitySlots() (entitySlots []slotIdx) {
 	return entitySlots
 }
 
+// setPredicatesBoundAt determines the position in the join of entities at
+// which each of the predicates can be checked.
+func (p *queryBuilder) setPredicatesBoundAt(entities []slotIdx) {
+	position := make(map[slotIdx]int, len(entities))
+	for i, s := range entities {
+		position[s] = i
+	}
+	for i := range p.predicates {
+		pred := &p.predicates[i]
+		pred.boundAt = 0
+		for _, o := range pred.operands {
+			pos, isEntity := position[o.slot]
+			if !isEntity {
+				pred.boundAt = -1
+				break
+			}
+			if pos > pred.boundAt {
+				pred.boundAt = pos
+			}
+		}
+	}
+}
+
 // typeCheck asserts that the value types for the fact are sane given the
 // attribute.
 func (p *queryBuilder) typeCheck(f fact) {
@@ -272,6 +865,19 @@ func (p *queryBuilder) typeCheck(f fact) {
 
 var boolType = reflect.TypeOf((*bool)(nil)).Elem()
 
+// checkComparableTypes returns an error if values of the two types can
+// never be equal.
+func checkComparableTypes(a, b reflect.Type) error {
+	switch {
+	case a == b:
+	case a.Kind() == reflect.Interface && b.Implements(a):
+	case b.Kind() == reflect.Interface && a.Implements(b):
+	default:
+		return &TypeMismatchError{Type: a, Expected: fmt.Sprintf("comparable to %v", b)}
+	}
+	return nil
+}
+
 func checkSlotType(s *slot, exp reflect.Type) {
 	if !s.empty() {
 		if err := checkType(s.typ, exp); err != nil {
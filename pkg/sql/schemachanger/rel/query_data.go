@@ -22,6 +22,10 @@ type fact struct {
 	variable slotIdx
 	attr     ordinal
 	value    slotIdx
+	// clause is the clause from which the fact was derived. It is used to
+	// explain rejected candidates. The other constraints below carry their
+	// clauses for the same purpose.
+	clause Clause
 }
 
 // slot represents an potentially unbound value referenced in a query.
@@ -119,8 +123,164 @@ func maybeSet(
 	return false
 }
 
+// eq returns true if both values are populated, have the same type, and are
+// equal.
+func (tv typedValue) eq(other typedValue) bool {
+	if tv.value == nil || other.value == nil || tv.typ != other.typ {
+		return false
+	}
+	_, eq := compare(tv.value, other.value)
+	return eq
+}
+
 // filter is a user-provided predicate over some set of variables.
 type filter struct {
 	input     []slotIdx
 	predicate reflect.Value
+	// slicePredicate, if set, is called with the values of the inputs in
+	// place of the predicate; see FilterSlice.
+	slicePredicate func([]interface{}) bool
+	clause         Clause
+}
+
+// subquery is an independent query whose results constrain the values
+// which may occupy a slot.
+type subquery struct {
+	q *Query
+	// v is the slot in the subquery whose values are collected.
+	v slotIdx
+	// dst is the slot in the enclosing query which is constrained to the
+	// collected values.
+	dst slotIdx
+}
+
+// fanout limits the number of results for any binding of the outer slots.
+type fanout struct {
+	n int
+	// vars are the entity variables introduced by the wrapped clause.
+	vars []Var
+	// inner are the entity slots introduced by the wrapped clause.
+	inner util.FastIntSet
+	// outer are the entity slots of the query not in inner.
+	outer []slotIdx
+}
+
+// fanoutCounter counts results for bindings of a sequence of slots. It forms
+// a tree with a level for each slot.
+type fanoutCounter struct {
+	count    int
+	children map[interface{}]*fanoutCounter
+}
+
+// increment increments the count for the values bound to the slots and
+// returns the new count.
+func (c *fanoutCounter) increment(slots []slot, idxs []slotIdx) int {
+	for _, idx := range idxs {
+		if c.children == nil {
+			c.children = make(map[interface{}]*fanoutCounter)
+		}
+		child, ok := c.children[slots[idx].value]
+		if !ok {
+			child = &fanoutCounter{}
+			c.children[slots[idx].value] = child
+		}
+		c = child
+	}
+	c.count++
+	return c.count
+}
+
+// negation is a query which must have no results given the bindings of the
+// enclosing query.
+type negation struct {
+	q *Query
+	// inputs are the slots of q which are bound by the enclosing query.
+	inputs []negationInput
+	clause Clause
+}
+
+// negationInput maps a slot in the enclosing query to a slot in a negation,
+// or in another query evaluated for each of its results.
+type negationInput struct {
+	src, dst slotIdx
+}
+
+// cardinality constrains the number of results of q, which is evaluated
+// with the inputs bound by the enclosing query, to be at least (or at most) n.
+type cardinality struct {
+	q       *Query
+	inputs  []negationInput
+	n       int
+	atLeast bool
+	clause  Clause
+}
+
+// extremum constrains the entity in a slot to have the maximum (or minimum)
+// value for an attribute among the bindings of the entity in q, which is
+// evaluated with the inputs bound by the enclosing query.
+type extremum struct {
+	q      *Query
+	inputs []negationInput
+	// entity and sub are the slots of the entity in the enclosing query and
+	// in q, respectively.
+	entity, sub slotIdx
+	attr        ordinal
+	max         bool
+	clause      Clause
+}
+
+// predicate is an internal constraint over the values of attributes.
+type predicate struct {
+	operands []operand
+	fn       predicateFunc
+	// boundAt is the position in the join of the last entity to which the
+	// operands refer. The predicate is checked as soon as that entity is
+	// bound. If the operands refer to slots which are not entities, it is -1,
+	// and the predicate is checked along with the filters.
+	boundAt int
+	clause  Clause
+}
+
+// hop constrains the entity in the target slot to be reachable from the
+// entity in the source slot by following the attribute.
+type hop struct {
+	src, target slotIdx
+	attr        ordinal
+	hops        int
+	exact       bool
+	clause      Clause
 }
+
+// noChild constrains the entity in the parent slot to not be the value of
+// the attribute for any entity.
+type noChild struct {
+	parent slotIdx
+	attr   ordinal
+	clause Clause
+}
+
+// unreachable constrains the entity in the target slot to not be reachable
+// from the entities in any of the root slots by following the attribute.
+type unreachable struct {
+	roots  []slotIdx
+	target slotIdx
+	attr   ordinal
+	clause Clause
+}
+
+// joinKey records the slot of the variable an attribute is constrained to.
+type joinKey struct {
+	attr ordinal
+	slot slotIdx
+}
+
+// operand refers to the value of an attribute of the entity bound to a slot.
+// If the attribute is Self, it refers to the value in the slot itself.
+type operand struct {
+	slot slotIdx
+	attr ordinal
+}
+
+// predicateFunc is evaluated over the values of the operands of a predicate.
+// Absent attribute values are passed as the zero typedValue.
+type predicateFunc func(args []typedValue) bool
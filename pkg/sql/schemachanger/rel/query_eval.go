@@ -14,6 +14,7 @@ import (
 	"reflect"
 
 	"github.com/cockroachdb/cockroach/pkg/util"
+	"github.com/cockroachdb/cockroach/pkg/util/iterutil"
 	"github.com/cockroachdb/errors"
 )
 
@@ -27,6 +28,25 @@ type evalContext struct {
 	facts      []fact
 	depth, cur int
 	slots      []slot
+
+	// fanoutCounters are used to enforce the fanouts of the query. They are
+	// reset for each iteration.
+	fanoutCounters []fanoutCounter
+
+	// projectJoinKeys is set if join keys should be exposed in results.
+	projectJoinKeys bool
+
+	// trace, if non-nil, records the candidates rejected during evaluation.
+	trace *RejectionTrace
+
+	// explain, if non-nil, counts the candidates examined and rejected
+	// during evaluation; see (*Query).ExplainAnalyze.
+	explain *explainCounters
+
+	// stopped is set once the iterator halts the iteration. The database
+	// swallows the iterutil.StopIteration error when iterating the entities
+	// at each level of the join, so the levels above consult it to stop.
+	stopped bool
 }
 
 func newEvalContext(q *Query) *evalContext {
@@ -48,6 +68,97 @@ func (ec *evalResult) Var(name Var) interface{} {
 	return ec.slots[n].toInterface()
 }
 
+func (ec *evalResult) Attributes(name Var) ([]Attr, error) {
+	e, err := ec.getEntity(name)
+	if err != nil {
+		return nil, err
+	}
+	sc := ec.db.schema
+	var attrs []Attr
+	e.attrs.forEach(func(ord ordinal) (wantMore bool) {
+		if a := sc.attrs[ord]; !isSystemAttribute(a) {
+			attrs = append(attrs, a)
+		}
+		return true
+	})
+	return attrs, nil
+}
+
+func (ec *evalResult) Attr(name Var, a Attr) (interface{}, bool) {
+	e, err := ec.getEntity(name)
+	if err != nil {
+		return nil, false
+	}
+	ord, err := ec.db.schema.getOrdinal(a)
+	if err != nil {
+		return nil, false
+	}
+	tv, ok := e.getTypedValue(ec.db.schema, ord)
+	if !ok {
+		return nil, false
+	}
+	return tv.toInterface(), true
+}
+
+func (ec *evalResult) FollowRef(name Var, a Attr) (interface{}, bool, error) {
+	e, err := ec.getEntity(name)
+	if err != nil {
+		return nil, false, err
+	}
+	sc := ec.db.schema
+	ord, err := sc.getOrdinal(a)
+	if err != nil {
+		return nil, false, err
+	}
+	if typ := sc.attrTypes[ord]; isSystemAttribute(a) || !isEntityType(typ) {
+		return nil, false, errors.Errorf("%v of type %v does not refer to entities", a, typ)
+	}
+	v := (*valuesMap)(e).get(ord)
+	if v == nil {
+		return nil, false, nil
+	}
+	ref, ok := ec.db.entities[v]
+	if !ok {
+		return nil, false, errors.Errorf(
+			"%v of %s refers to %T which is not an entity in the database", a, name, v,
+		)
+	}
+	return ref.getComparableValue(sc, Self), true, nil
+}
+
+func (ec *evalResult) Branch() string {
+	return ec.q.branch
+}
+
+func (ec *evalResult) JoinKey(a Attr) (interface{}, bool) {
+	if !ec.projectJoinKeys {
+		return nil, false
+	}
+	ord, err := ec.q.schema.getOrdinal(a)
+	if err != nil {
+		return nil, false
+	}
+	for _, jk := range ec.q.joinKeys {
+		if jk.attr == ord {
+			return ec.slots[jk.slot].toInterface(), true
+		}
+	}
+	return nil, false
+}
+
+// getEntity returns the entity bound to the variable.
+func (ec *evalResult) getEntity(name Var) (*entity, error) {
+	n, ok := ec.q.variableSlots[name]
+	if !ok {
+		return nil, &UnboundVarError{Var: name}
+	}
+	e, ok := ec.db.entities[ec.slots[n].value]
+	if !ok {
+		return nil, errors.Errorf("variable %s is not bound to an entity", name)
+	}
+	return e, nil
+}
+
 // Iterate is part of the PreparedQuery interface.
 func (ec *evalContext) Iterate(db *Database, ri ResultIterator) error {
 	if db.schema != ec.q.schema {
@@ -56,14 +167,46 @@ func (ec *evalContext) Iterate(db *Database, ri ResultIterator) error {
 			db.schema.name, ec.q.schema.name,
 		)
 	}
-	defer func() { ec.db, ec.ri = nil, nil }()
+	defer func() { ec.db, ec.ri, ec.stopped = nil, nil, false }()
 	ec.db, ec.ri = db, ri
 
 	// TODO(ajwerner): Decide if we should allow depth-zero queries to exist.
 	if ec.depth == 0 {
 		return nil
 	}
-	return ec.iterateNext()
+	if err := ec.evalSubqueries(); err != nil {
+		return err
+	}
+	ec.resetFanoutCounters()
+	if err := ec.iterateNext(); err != nil && !ec.stopped {
+		return err
+	}
+	return nil
+}
+
+// evalSubqueries evaluates each subquery against the database and populates
+// the slot it constrains with the distinct values it produced.
+func (ec *evalContext) evalSubqueries() error {
+	for _, sq := range ec.q.subqueries {
+		var values []typedValue
+		seen := make(map[interface{}]struct{})
+		if err := sq.q.Iterate(ec.db, func(r Result) error {
+			tv := (*evalContext)(r.(*evalResult)).slots[sq.v].typedValue
+			k := tv.toInterface()
+			if _, exists := seen[k]; !exists {
+				seen[k] = struct{}{}
+				values = append(values, tv)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		if values == nil {
+			values = []typedValue{}
+		}
+		ec.slots[sq.dst].any = values
+	}
+	return nil
 }
 
 // iterateNext steps down in the join to either decide that we've
@@ -75,10 +218,30 @@ func (ec *evalContext) iterateNext() error {
 	// We're at the bottom of the iteration, check if all conditions have
 	// been satisfied, and then invoke the iterator.
 	if ec.cur == ec.depth {
-		if ec.haveUnboundSlots() || ec.checkFilters() {
+		if ec.haveUnboundSlots() {
+			ec.reject(ec.cur-1, nil, "variables are unbound")
+			return nil
+		}
+		if ec.checkFilters() {
 			return nil
 		}
-		return ec.ri((*evalResult)(ec))
+		if satisfied, err := ec.checkNegations(); err != nil || !satisfied {
+			return err
+		}
+		if satisfied, err := ec.checkCardinalities(); err != nil || !satisfied {
+			return err
+		}
+		if satisfied, err := ec.checkExtrema(); err != nil || !satisfied {
+			return err
+		}
+		if err := ec.checkFanouts(); err != nil {
+			return err
+		}
+		err := ec.ri((*evalResult)(ec))
+		if iterutil.Done(err) {
+			ec.stopped = true
+		}
+		return err
 	}
 
 	// If we've already populated the next entity in the join as variable,
@@ -95,17 +258,30 @@ func (ec *evalContext) iterateNext() error {
 	if len(anyValues) > 0 {
 		for _, v := range anyValues {
 			where.add(anyAttr, v.value)
-			if err := ec.db.iterate(where, ec); err != nil {
-				return err
+			if err := ec.db.iterate(where, ec); err != nil || ec.stopped {
+				return ec.stopErr(err)
 			}
 		}
 		return nil
 	}
 	// If there's no anyValues, directly iterate the database.
-	return ec.db.iterate(where, ec)
+	return ec.stopErr(ec.db.iterate(where, ec))
+}
+
+// stopErr returns the error with which to return from a level of the join,
+// given the error returned by iterating the level: iterutil.StopIteration if
+// the iteration was halted, so that the level above stops too.
+func (ec *evalContext) stopErr(err error) error {
+	if err == nil && ec.stopped {
+		return iterutil.StopIteration()
+	}
+	return err
 }
 
 func (ec *evalContext) visit(e *entity) error {
+	if ec.explain != nil {
+		ec.explain.examined[ec.cur]++
+	}
 	// Keep track of which slots were filled as part of this step in the
 	// evaluation and then unset them when we pop out of this stack frame.
 	var slotsFilled util.FastIntSet
@@ -141,11 +317,18 @@ func (ec *evalContext) visit(e *entity) error {
 
 			tv, ok := e.getTypedValue(ec.db.schema, f.attr)
 			if !ok {
+				if ec.tracing() {
+					ec.reject(ec.cur, f.clause, "no value for %v", ec.db.schema.ordinalName(f.attr))
+				}
 				return true // we have no value for this attribute, contradiction
 			}
 			if contradiction := maybeSet(
 				ec.slots, f.value, tv, &slotsFilled,
 			); contradiction {
+				if ec.tracing() {
+					ec.reject(ec.cur, f.clause, "value %v of %v does not match",
+						tv.toInterface(), ec.db.schema.ordinalName(f.attr))
+				}
 				return true
 			}
 		}
@@ -157,12 +340,27 @@ func (ec *evalContext) visit(e *entity) error {
 	}
 
 	// Propagate the new information and see if there's a contradiction.
-	if contradiction := setEntitySlots() || unify(
+	if contradiction := setEntitySlots(); contradiction {
+		return nil
+	}
+	if contradiction, f := unifyReturningContradiction(
 		ec.facts, ec.slots, &slotsFilled,
 	); contradiction {
+		if ec.tracing() {
+			ec.reject(ec.cur, f.clause, "contradiction on %v", ec.db.schema.ordinalName(f.attr))
+		}
+		return nil
+	}
+
+	// Check the predicates which refer only to entities bound by now.
+	if !ec.checkPredicatesBoundAt(ec.cur) {
 		return nil
 	}
 
+	if ec.explain != nil {
+		ec.explain.passed[ec.cur]++
+	}
+
 	// Step down to the next variable, or, if at the bottom, ensure that
 	// all the required slots are filled and pass the result to the caller.
 	ec.cur++
@@ -183,6 +381,13 @@ func (ec *evalContext) haveUnboundSlots() bool {
 
 func (ec *evalContext) checkFilters() (done bool) {
 	for _, f := range ec.q.filters {
+		if f.slicePredicate != nil {
+			if !ec.checkSliceFilter(f) {
+				ec.reject(ec.cur-1, f.clause, "filter returned false")
+				return true
+			}
+			continue
+		}
 		// TODO(ajwerner): Catch panics here and convert them to errors.
 		ins := make([]reflect.Value, len(f.input))
 		insI := make([]interface{}, len(f.input))
@@ -200,6 +405,9 @@ func (ec *evalContext) checkFilters() (done bool) {
 				if in.Type().ConvertibleTo(inType) {
 					in = in.Convert(inType)
 				} else {
+					if ec.tracing() {
+						ec.reject(ec.cur-1, f.clause, "input of type %v is not a %v", in.Type(), inType)
+					}
 					return true
 				}
 			}
@@ -208,12 +416,331 @@ func (ec *evalContext) checkFilters() (done bool) {
 		}
 		outs := f.predicate.Call(ins)
 		if !outs[0].Bool() {
+			ec.reject(ec.cur-1, f.clause, "filter returned false")
+			return true
+		}
+	}
+	for i := range ec.q.predicates {
+		if p := &ec.q.predicates[i]; p.boundAt < 0 && !ec.checkPredicate(p) {
+			ec.reject(ec.cur-1, p.clause, "predicate is not satisfied")
 			return true
 		}
 	}
+	for i := range ec.q.hops {
+		if h := &ec.q.hops[i]; !ec.checkHop(h) {
+			ec.reject(ec.cur-1, h.clause, "target is not reachable")
+			return true
+		}
+	}
+	for i := range ec.q.noChildren {
+		if c := &ec.q.noChildren[i]; ec.hasChild(c) {
+			ec.reject(ec.cur-1, c.clause, "entity has a child")
+			return true
+		}
+	}
+	for i := range ec.q.unreachables {
+		if u := &ec.q.unreachables[i]; ec.isReachable(u) {
+			ec.reject(ec.cur-1, u.clause, "target is reachable")
+			return true
+		}
+	}
+	return false
+}
+
+// checkSliceFilter calls the slice predicate of the filter with the values
+// of its inputs.
+func (ec *evalContext) checkSliceFilter(f filter) bool {
+	ins := make([]interface{}, len(f.input))
+	for i, idx := range f.input {
+		ins[i] = ec.slots[idx].typedValue.toInterface()
+	}
+	return f.slicePredicate(ins)
+}
+
+// hasChild returns true if any entity has the entity in the parent slot as
+// its value for the attribute. The search uses an index over the attribute,
+// if there is one, and stops at the first such entity.
+func (ec *evalContext) hasChild(c *noChild) bool {
+	where := getValues()
+	defer putValues(where)
+	where.add(c.attr, ec.slots[c.parent].value)
+	var f childFinder
+	// The finder only returns iterutil.StopIteration, which is not surfaced.
+	_ = ec.db.iterate(where, &f)
+	return f.found
+}
+
+// childFinder is an entityIterator which stops at the first entity.
+type childFinder struct {
+	found bool
+}
+
+func (f *childFinder) visit(*entity) error {
+	f.found = true
+	return iterutil.StopIteration()
+}
+
+// checkHop returns true if the target of the hop is reachable from its
+// source within the allowed number of hops. Because each entity has at most
+// one value for an attribute, the path from the source is unique, so the
+// first time the target is reached is the shortest path to it. The path is
+// never followed more than the allowed number of hops, which bounds the
+// search in the face of cycles. An exact hop of length zero is satisfied
+// only if the target is the source.
+func (ec *evalContext) checkHop(h *hop) bool {
+	sc := ec.db.schema
+	target := ec.slots[h.target].value
+	if h.hops == 0 {
+		return ec.slots[h.src].value == target
+	}
+	e, ok := ec.db.entities[ec.slots[h.src].value]
+	for i := 1; ok && i <= h.hops; i++ {
+		tv, hasValue := e.getTypedValue(sc, h.attr)
+		if !hasValue {
+			return false
+		}
+		if tv.value == target {
+			return !h.exact || i == h.hops
+		}
+		e, ok = ec.db.entities[tv.value]
+	}
 	return false
 }
 
+// isReachable returns true if the target of the constraint is one of its
+// roots or is reachable from one by following the attribute. Because each
+// entity has at most one value for an attribute, the entities reachable from
+// a root form a single path, which is followed until it ends or reaches an
+// entity already visited from this or a previous root.
+func (ec *evalContext) isReachable(u *unreachable) bool {
+	sc := ec.db.schema
+	target := ec.slots[u.target].value
+	visited := make(map[interface{}]struct{})
+	for _, r := range u.roots {
+		v := ec.slots[r].value
+		for {
+			if v == target {
+				return true
+			}
+			if _, ok := visited[v]; ok {
+				break
+			}
+			visited[v] = struct{}{}
+			e, ok := ec.db.entities[v]
+			if !ok {
+				break
+			}
+			tv, hasValue := e.getTypedValue(sc, u.attr)
+			if !hasValue {
+				break
+			}
+			v = tv.value
+		}
+	}
+	return false
+}
+
+// checkPredicatesBoundAt evaluates the predicates which can be checked once
+// the entity at the given position in the join is bound.
+func (ec *evalContext) checkPredicatesBoundAt(cur int) bool {
+	for i := range ec.q.predicates {
+		if p := &ec.q.predicates[i]; p.boundAt == cur && !ec.checkPredicate(p) {
+			ec.reject(cur, p.clause, "predicate is not satisfied")
+			return false
+		}
+	}
+	return true
+}
+
+// checkPredicate evaluates the predicate over the current bindings.
+func (ec *evalContext) checkPredicate(p *predicate) bool {
+	sc := ec.db.schema
+	args := make([]typedValue, len(p.operands))
+	for i, o := range p.operands {
+		s := &ec.slots[o.slot]
+		if sc.attrs[o.attr] == Self {
+			args[i] = s.typedValue
+		} else if e, ok := ec.db.entities[s.value]; ok {
+			args[i], _ = e.getTypedValue(sc, o.attr)
+		}
+	}
+	return p.fn(args)
+}
+
+func (ec *evalContext) resetFanoutCounters() {
+	if len(ec.q.fanouts) == 0 {
+		return
+	}
+	ec.fanoutCounters = make([]fanoutCounter, len(ec.q.fanouts))
+}
+
+// checkFanouts counts the current result against each fanout and returns an
+// error if a limit is exceeded.
+func (ec *evalContext) checkFanouts() error {
+	for i := range ec.q.fanouts {
+		f := &ec.q.fanouts[i]
+		if ec.fanoutCounters[i].increment(ec.slots, f.outer) > f.n {
+			return errors.Errorf(
+				"bindings of %s exceeded max fanout of %d", f.vars, f.n,
+			)
+		}
+	}
+	return nil
+}
+
+// checkNegations returns true if none of the negated queries have results
+// given the current bindings.
+func (ec *evalContext) checkNegations() (satisfied bool, _ error) {
+	for i := range ec.q.negations {
+		n := &ec.q.negations[i]
+		found, err := n.q.exists(ec.db, n.inputs, ec.slots)
+		if err != nil || found {
+			if found {
+				ec.reject(ec.cur-1, n.clause, "negated clause is satisfied")
+			}
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// checkCardinalities returns true if the number of results of each of the
+// counted queries given the current bindings is within its bound. Each query
+// is only evaluated until its threshold is reached.
+func (ec *evalContext) checkCardinalities() (satisfied bool, _ error) {
+	for i := range ec.q.cardinalities {
+		c := &ec.q.cardinalities[i]
+		// The threshold is the number of results at which the outcome is
+		// decided: AtLeast is satisfied, and AtMost is violated.
+		threshold := c.n
+		if !c.atLeast {
+			threshold++
+		}
+		if threshold == 0 {
+			continue
+		}
+		var count int
+		found, err := c.q.existsMatching(ec.db, c.inputs, ec.slots, func([]slot) bool {
+			count++
+			return count >= threshold
+		})
+		if err != nil {
+			return false, err
+		}
+		if found != c.atLeast {
+			if ec.tracing() && c.atLeast {
+				ec.reject(ec.cur-1, c.clause, "fewer than %d solutions", c.n)
+			} else if ec.tracing() {
+				ec.reject(ec.cur-1, c.clause, "more than %d solutions", c.n)
+			}
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// checkExtrema returns true if the bound entities have the maximum (or
+// minimum) values among the entities they are compared with.
+func (ec *evalContext) checkExtrema() (satisfied bool, _ error) {
+	sc := ec.db.schema
+	for i := range ec.q.extrema {
+		x := &ec.q.extrema[i]
+		e, ok := ec.db.entities[ec.slots[x.entity].value]
+		if !ok {
+			ec.reject(ec.cur-1, x.clause, "variable is not bound to an entity")
+			return false, nil
+		}
+		cur, ok := e.getTypedValue(sc, x.attr)
+		if !ok {
+			ec.reject(ec.cur-1, x.clause, "entity has no value for the attribute")
+			return false, nil
+		}
+		// Look for an entity with a greater (or lesser) value.
+		found, err := x.q.existsMatching(ec.db, x.inputs, ec.slots, func(slots []slot) bool {
+			other, ok := ec.db.entities[slots[x.sub].value]
+			if !ok {
+				return false
+			}
+			tv, ok := other.getTypedValue(sc, x.attr)
+			if !ok {
+				return false
+			}
+			less, eq := cur.compare(tv)
+			return !eq && less == x.max
+		})
+		if err != nil || found {
+			if found {
+				ec.reject(ec.cur-1, x.clause, "value is not the extremum")
+			}
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// errFound is used to halt the iteration of a query once a result is found.
+var errFound = errors.New("found")
+
+// exists returns true if the query has any results in the database when the
+// slots of the inputs are bound to the values in the corresponding slots of
+// the enclosing query.
+func (q *Query) exists(db *Database, inputs []negationInput, outer []slot) (bool, error) {
+	return q.existsMatching(db, inputs, outer, nil /* match */)
+}
+
+// existsMatching is like exists, but only considers the results for which
+// match, if non-nil, returns true.
+func (q *Query) existsMatching(
+	db *Database, inputs []negationInput, outer []slot, match func([]slot) bool,
+) (bool, error) {
+	ec := q.getEvalContext()
+	defer q.putEvalContext(ec)
+	return ec.exists(db, inputs, outer, match)
+}
+
+func (ec *evalContext) exists(
+	db *Database, inputs []negationInput, outer []slot, match func([]slot) bool,
+) (found bool, _ error) {
+	defer func() { ec.db, ec.ri = nil, nil }()
+	ec.db = db
+	ec.ri = func(Result) error {
+		if match != nil && !match(ec.slots) {
+			return nil
+		}
+		return errFound
+	}
+
+	// Unset the slots which are bound here so that the evalContext can be
+	// reused.
+	var slotsFilled util.FastIntSet
+	defer func() {
+		slotsFilled.ForEach(func(i int) {
+			ec.slots[i].typedValue = typedValue{}
+		})
+	}()
+	for _, in := range inputs {
+		if contradiction := maybeSet(
+			ec.slots, in.dst, outer[in.src].typedValue, &slotsFilled,
+		); contradiction {
+			return false, nil
+		}
+	}
+	if contradiction := unify(ec.facts, ec.slots, &slotsFilled); contradiction {
+		return false, nil
+	}
+	if err := ec.evalSubqueries(); err != nil {
+		return false, err
+	}
+	ec.resetFanoutCounters()
+	if err := ec.iterateNext(); err != nil {
+		if errors.Is(err, errFound) {
+			return true, nil
+		}
+		return false, err
+	}
+	return false, nil
+}
+
 // Construct a where clause with all the bound values known for the next
 // entity in the join. In the face of an existing any clause for the current
 // entity, the corresponding attribute and values will be returned for use
@@ -0,0 +1,181 @@
+// Copyright 2021 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package rel
+
+import (
+	"sort"
+
+	"github.com/cockroachdb/errors"
+)
+
+// EvaluateAll evaluates each of the queries against the database and returns
+// their results in the order of the queries. Unlike the Result passed to a
+// ResultIterator, the returned results remain valid after evaluation.
+//
+// Queries which would begin their evaluation by scanning all of the entities
+// in the database share a single scan: each entity is dispatched to each of
+// the queries whose leading constraints it satisfies. The other queries, which
+// can begin with a more selective lookup, are evaluated independently. This
+// makes evaluating a large number of rules cheaper than evaluating them one
+// after another. The results of the branches of a query with a UnionQueries
+// clause may be interleaved.
+func EvaluateAll(db *Database, queries []*Query) ([][]Result, error) {
+	results := make([][]Result, len(queries))
+	var scan sharedScan
+	defer func() {
+		for i, ec := range scan.ecs {
+			putValues(scan.wheres[i])
+			ec.db, ec.ri = nil, nil
+			ec.q.putEvalContext(ec)
+		}
+	}()
+	for i, q := range queries {
+		if db.schema != q.schema {
+			return nil, errors.Errorf(
+				"query and database are not from the same schema: %s != %s",
+				db.schema.name, q.schema.name,
+			)
+		}
+		results[i] = []Result{}
+		for _, q := range q.evaluated() {
+			if err := scan.prepare(db, q, &results[i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(scan.ecs) > 0 {
+		where := getValues()
+		defer putValues(where)
+		if err := db.iterate(where, &scan); err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// EvaluateAcross evaluates the query against each of the databases and
+// returns the results from each, keyed like the databases. The results remain
+// valid after evaluation. All the databases must be of the query's schema; no
+// evaluation takes place otherwise.
+func EvaluateAcross(dbs map[string]*Database, q *Query) (map[string][]Result, error) {
+	names := make([]string, 0, len(dbs))
+	for name, db := range dbs {
+		if db.schema != q.schema {
+			names = append(names, name)
+		}
+	}
+	if len(names) > 0 {
+		sort.Strings(names)
+		return nil, errors.Errorf(
+			"database %s and query are not from the same schema: %s != %s",
+			names[0], dbs[names[0]].schema.name, q.schema.name,
+		)
+	}
+	ret := make(map[string][]Result, len(dbs))
+	for name, db := range dbs {
+		results, err := EvaluateAll(db, []*Query{q})
+		if err != nil {
+			return nil, errors.Wrapf(err, "evaluating against database %s", name)
+		}
+		ret[name] = results[0]
+	}
+	return ret, nil
+}
+
+// sharedScan dispatches the entities of a scan to the queries which begin
+// their evaluation with a scan over all entities.
+type sharedScan struct {
+	ecs []*evalContext
+	// wheres are the constraints on the leading entity of each query.
+	wheres []*valuesMap
+}
+
+func (s *sharedScan) visit(e *entity) error {
+	for i, ec := range s.ecs {
+		if !s.wheres[i].matches(e) {
+			continue
+		}
+		if err := ec.visit(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prepare prepares the evaluation of the query, appending its results to
+// the given slice. If its evaluation would begin with a scan over all
+// entities, the query is added to the shared scan; otherwise, it is
+// evaluated immediately.
+func (s *sharedScan) prepare(db *Database, q *Query, results *[]Result) error {
+	ec := q.getEvalContext()
+	ec.db, ec.ri = db, func(Result) error {
+		*results = append(*results, ec.bufferedResult())
+		return nil
+	}
+	where, shared, err := ec.prepareForSharedScan()
+	if shared {
+		s.ecs = append(s.ecs, ec)
+		s.wheres = append(s.wheres, where)
+		return nil
+	}
+	if err == nil && ec.depth > 0 {
+		err = ec.iterateNext()
+	}
+	ec.db, ec.ri = nil, nil
+	q.putEvalContext(ec)
+	return err
+}
+
+// matches returns true if the entity has all the values.
+func (vm *valuesMap) matches(e *entity) bool {
+	if vm.attrs.without(e.attrs) != 0 {
+		return false
+	}
+	matches := true
+	vm.attrs.forEach(func(a ordinal) (wantMore bool) {
+		_, matches = compareOn(a, e.asMap(), vm)
+		return matches
+	})
+	return matches
+}
+
+// prepareForSharedScan prepares the evalContext for evaluation. If the
+// evaluation would begin by scanning all the entities in the database, it
+// returns the constraints on the leading entity so that the scan can be
+// shared. Otherwise, the evaluation should proceed independently.
+func (ec *evalContext) prepareForSharedScan() (where *valuesMap, shared bool, _ error) {
+	if ec.depth == 0 {
+		return nil, false, nil
+	}
+	if err := ec.evalSubqueries(); err != nil {
+		return nil, false, err
+	}
+	ec.resetFanoutCounters()
+	if !ec.slots[ec.q.entities[ec.cur]].empty() {
+		return nil, false, nil
+	}
+	where, _, anyValues := ec.buildWhere()
+	if idx, _ := ec.db.chooseIndex(where.attrs); len(anyValues) > 0 || idx != &ec.db.indexes[0] {
+		putValues(where)
+		return nil, false, nil
+	}
+	return where, true, nil
+}
+
+// bufferedResult returns a copy of the current result which remains valid
+// after the evaluation moves on.
+func (ec *evalContext) bufferedResult() Result {
+	return (*evalResult)(&evalContext{
+		q:     ec.q,
+		db:    ec.db,
+		slots: append([]slot(nil), ec.slots...),
+	})
+}
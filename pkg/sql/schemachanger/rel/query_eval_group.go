@@ -0,0 +1,194 @@
+// Copyright 2021 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package rel
+
+import (
+	"reflect"
+	"sort"
+
+	"github.com/cockroachdb/errors"
+)
+
+// AggFunc is a function aggregating the values of an attribute over the
+// results of a Group.
+type AggFunc int
+
+const (
+	// AggCount counts the results whose entity has a value for the attribute.
+	// The count is an int.
+	AggCount AggFunc = iota
+	// AggMin is the smallest value of the attribute, which must be of an
+	// ordered type.
+	AggMin
+	// AggMax is the largest value of the attribute, which must be of an
+	// ordered type.
+	AggMax
+	// AggSum is the sum of the values of the attribute, which must be of a
+	// numeric type. The sum is of the type of the attribute, and overflows
+	// like it would.
+	AggSum
+)
+
+func (f AggFunc) String() string {
+	switch f {
+	case AggCount:
+		return "count"
+	case AggMin:
+		return "min"
+	case AggMax:
+		return "max"
+	case AggSum:
+		return "sum"
+	default:
+		return "unknown"
+	}
+}
+
+// Group is a group of results produced by evaluating a query with GroupBy.
+// As a Result, it exposes the bindings of the first result of the group. A
+// Group is only valid during the call to the iterator it is passed to.
+type Group interface {
+	Result
+
+	// Len returns the number of results in the group.
+	Len() int
+
+	// Aggregate aggregates the values of the attribute of the entities bound
+	// to the given variable over the results of the group. Results whose
+	// entity has no value for the attribute are ignored; if there are none,
+	// AggMin and AggMax return nil. An entity bound to the variable in
+	// several results of the group is aggregated once for each. An error is
+	// returned if the variable is not bound to an entity, or if the attribute
+	// is not of a type supported by the function.
+	Aggregate(v Var, a Attr, agg AggFunc) (interface{}, error)
+}
+
+// evalGroup implements Group. The evalContext is bound to the first result of
+// the group.
+type evalGroup struct {
+	*evalResult
+	results [][]slot
+}
+
+var _ Group = (*evalGroup)(nil)
+
+// iterateGroups groups the results by the values of the key slots, and
+// passes each group to the iterator, in the order in which their first
+// result appears.
+func (ec *evalContext) iterateGroups(ri ResultIterator, keys []slotIdx, results [][]slot) error {
+	order := make([]int, len(results))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return lessOnSlots(keys, results[order[i]], results[order[j]])
+	})
+	var groups [][]int
+	for i, idx := range order {
+		if i == 0 || lessOnSlots(keys, results[order[i-1]], results[idx]) {
+			groups = append(groups, nil)
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], idx)
+	}
+	// The results within each group retain their order, so the first index
+	// of each group is that of its first result.
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i][0] < groups[j][0]
+	})
+	for _, g := range groups {
+		group := evalGroup{evalResult: (*evalResult)(ec), results: make([][]slot, len(g))}
+		for i, idx := range g {
+			group.results[i] = results[idx]
+		}
+		ec.slots = group.results[0]
+		if err := ri(&group); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Len is part of the Group interface.
+func (g *evalGroup) Len() int { return len(g.results) }
+
+// Aggregate is part of the Group interface.
+func (g *evalGroup) Aggregate(v Var, a Attr, agg AggFunc) (interface{}, error) {
+	sc := g.db.schema
+	ord, err := sc.getOrdinal(a)
+	if err != nil {
+		return nil, err
+	}
+	typ := sc.attrTypes[ord]
+	switch agg {
+	case AggCount:
+	case AggMin, AggMax:
+		if !isOrderedType(typ) {
+			return nil, &TypeMismatchError{Type: typ, Expected: "an ordered type"}
+		}
+	case AggSum:
+		if !isNumericType(typ) {
+			return nil, &TypeMismatchError{Type: typ, Expected: "a numeric type"}
+		}
+	default:
+		return nil, errors.AssertionFailedf("unknown aggregate function %d", int(agg))
+	}
+
+	slots := g.slots
+	defer func() { g.slots = slots }()
+	var count int
+	var extremum typedValue
+	sum := reflect.New(typ).Elem()
+	for _, s := range g.results {
+		g.slots = s
+		e, err := g.getEntity(v)
+		if err != nil {
+			return nil, err
+		}
+		tv, ok := e.getTypedValue(sc, ord)
+		if !ok {
+			continue
+		}
+		count++
+		switch agg {
+		case AggMin, AggMax:
+			less, eq := tv.compare(extremum)
+			if extremum.value == nil || (!eq && less == (agg == AggMin)) {
+				extremum = tv
+			}
+		case AggSum:
+			addNumeric(sum, reflect.ValueOf(tv.toInterface()))
+		}
+	}
+	switch agg {
+	case AggCount:
+		return count, nil
+	case AggSum:
+		return sum.Interface(), nil
+	default:
+		if extremum.value == nil {
+			return nil, nil
+		}
+		return extremum.toInterface(), nil
+	}
+}
+
+// addNumeric adds the value to the settable sum, which is of the same
+// numeric type.
+func addNumeric(sum, v reflect.Value) {
+	switch sum.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		sum.SetInt(sum.Int() + v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		sum.SetUint(sum.Uint() + v.Uint())
+	case reflect.Float32, reflect.Float64:
+		sum.SetFloat(sum.Float() + v.Float())
+	}
+}
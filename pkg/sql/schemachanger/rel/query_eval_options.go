@@ -0,0 +1,344 @@
+// Copyright 2021 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package rel
+
+import (
+	"sort"
+
+	"github.com/cockroachdb/errors"
+)
+
+// EvalOption is used to control the evaluation of a query.
+type EvalOption interface {
+	apply(*evalOptions)
+}
+
+// OrderBy orders the results of the query by the values bound to the
+// variables, in order of precedence. Results with equal values retain their
+// evaluation order. Ordering requires all results to be buffered before any
+// are returned. Variables bound to entities are ordered by identity, which
+// is not meaningful; OrderBy is intended for variables bound to attribute
+// values.
+func OrderBy(v Var, more ...Var) EvalOption {
+	return orderBy(append([]Var{v}, more...))
+}
+
+// First limits the results of the query to the first binding for each
+// distinct value bound to keyVar. When combined with OrderBy, the first
+// binding is the first in the requested order. Otherwise, it is the first
+// binding in evaluation order.
+func First(keyVar Var) EvalOption {
+	return first(keyVar)
+}
+
+// GroupBy groups the results of the query by the values bound to the key
+// variables, and passes a single Result for each group to the iterator, which
+// implements Group. The groups are produced in the order in which their first
+// result is found, and the bindings of a group's Result are those of that
+// result. When combined with OrderBy, the results are ordered before they are
+// grouped, so that both the order of the groups and their first results
+// follow the requested order. When combined with First, the results are
+// limited before they are grouped. Grouping requires all results to be
+// buffered before any are returned.
+func GroupBy(keyVars ...Var) EvalOption {
+	return groupBy(keyVars)
+}
+
+// ProjectJoinKeys exposes the values on which AttrEqVar clauses matched via
+// Result.JoinKey. It is intended for debugging joins.
+func ProjectJoinKeys() EvalOption {
+	return projectJoinKeys{}
+}
+
+// NoCrossJoins causes evaluation to fail with a *CrossJoinError if the query
+// contains entity variables which are not constrained relative to each other
+// by any clause, in which case the results would contain the cross product of
+// their bindings. Such queries are often the result of a mistake.
+func NoCrossJoins() EvalOption {
+	return noCrossJoins{}
+}
+
+// WithRejectionTrace records the candidates rejected during evaluation, and
+// the clauses which rejected them, into the trace. At most trace.Limit
+// rejections are recorded. It is intended for debugging queries which
+// unexpectedly return no results, and slows down evaluation.
+func WithRejectionTrace(trace *RejectionTrace) EvalOption {
+	return rejectionTrace{trace: trace}
+}
+
+type orderBy []Var
+
+func (o orderBy) apply(opts *evalOptions) {
+	opts.orderBy = append(opts.orderBy, o...)
+}
+
+type first Var
+
+func (f first) apply(opts *evalOptions) {
+	opts.first = append(opts.first, Var(f))
+}
+
+type groupBy []Var
+
+func (g groupBy) apply(opts *evalOptions) {
+	opts.groupBy = append(opts.groupBy, g...)
+}
+
+type projectJoinKeys struct{}
+
+func (projectJoinKeys) apply(opts *evalOptions) {
+	opts.projectJoinKeys = true
+}
+
+type noCrossJoins struct{}
+
+func (noCrossJoins) apply(opts *evalOptions) {
+	opts.noCrossJoins = true
+}
+
+type rejectionTrace struct {
+	trace *RejectionTrace
+}
+
+func (r rejectionTrace) apply(opts *evalOptions) {
+	opts.trace = r.trace
+}
+
+type evalOptions struct {
+	orderBy         []Var
+	first           []Var
+	groupBy         []Var
+	projectJoinKeys bool
+	noCrossJoins    bool
+	trace           *RejectionTrace
+}
+
+// evalPlan is the resolved form of evalOptions for a query.
+type evalPlan struct {
+	orderBy         []slotIdx
+	first           slotIdx
+	hasFirst        bool
+	groupBy         []slotIdx
+	projectJoinKeys bool
+	trace           *RejectionTrace
+}
+
+func (q *Query) makeEvalPlan(opts []EvalOption) (evalPlan, error) {
+	var o evalOptions
+	for _, opt := range opts {
+		opt.apply(&o)
+	}
+	if o.noCrossJoins {
+		if components := q.entityComponents(); len(components) > 1 {
+			return evalPlan{}, &CrossJoinError{Components: components}
+		}
+	}
+	p := evalPlan{projectJoinKeys: o.projectJoinKeys, trace: o.trace}
+	getSlot := func(v Var) (slotIdx, error) {
+		idx, ok := q.variableSlots[v]
+		if !ok {
+			return 0, &UnboundVarError{Var: v}
+		}
+		return idx, nil
+	}
+	for _, v := range o.orderBy {
+		idx, err := getSlot(v)
+		if err != nil {
+			return evalPlan{}, errors.Wrap(err, "invalid OrderBy")
+		}
+		p.orderBy = append(p.orderBy, idx)
+	}
+	for _, v := range o.groupBy {
+		idx, err := getSlot(v)
+		if err != nil {
+			return evalPlan{}, errors.Wrap(err, "invalid GroupBy")
+		}
+		p.groupBy = append(p.groupBy, idx)
+	}
+	switch len(o.first) {
+	case 0:
+	case 1:
+		idx, err := getSlot(o.first[0])
+		if err != nil {
+			return evalPlan{}, errors.Wrap(err, "invalid First")
+		}
+		p.first, p.hasFirst = idx, true
+	default:
+		return evalPlan{}, errors.Errorf("First may only be specified once")
+	}
+	return p, nil
+}
+
+func (p *evalPlan) empty() bool {
+	return len(p.orderBy) == 0 && !p.hasFirst && len(p.groupBy) == 0 && !p.projectJoinKeys &&
+		p.trace == nil
+}
+
+// iterateWithPlan iterates the query, applying the plan to its results.
+func (ec *evalContext) iterateWithPlan(db *Database, ri ResultIterator, p evalPlan) error {
+	defer func() { ec.projectJoinKeys, ec.trace = false, nil }()
+	ec.projectJoinKeys, ec.trace = p.projectJoinKeys, p.trace
+	var seen map[interface{}]struct{}
+	if p.hasFirst {
+		seen = make(map[interface{}]struct{})
+	}
+	isFirst := func(slots []slot) bool {
+		if !p.hasFirst {
+			return true
+		}
+		k := slots[p.first].toInterface()
+		if _, ok := seen[k]; ok {
+			return false
+		}
+		seen[k] = struct{}{}
+		return true
+	}
+	if len(p.orderBy) == 0 && len(p.groupBy) == 0 {
+		return ec.Iterate(db, func(r Result) error {
+			if !isFirst(ec.slots) {
+				return nil
+			}
+			return ri(r)
+		})
+	}
+
+	var buffered [][]slot
+	if err := ec.Iterate(db, func(r Result) error {
+		buffered = append(buffered, append([]slot(nil), ec.slots...))
+		return nil
+	}); err != nil {
+		return err
+	}
+	sort.SliceStable(buffered, func(i, j int) bool {
+		return lessOnSlots(p.orderBy, buffered[i], buffered[j])
+	})
+
+	// Replay the buffered results through the evalContext so that they can
+	// be accessed as a Result.
+	slots := ec.slots
+	defer func() { ec.db, ec.slots = nil, slots }()
+	ec.db = db
+	if len(p.groupBy) > 0 {
+		var results [][]slot
+		for _, s := range buffered {
+			if isFirst(s) {
+				results = append(results, s)
+			}
+		}
+		return ec.iterateGroups(ri, p.groupBy, results)
+	}
+	for _, s := range buffered {
+		if !isFirst(s) {
+			continue
+		}
+		ec.slots = s
+		if err := ri((*evalResult)(ec)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// entityComponents partitions the entity variables of the query into sets
+// which are constrained relative to each other, either by sharing a variable
+// or by being referenced together by a clause, in the order in which they
+// are joined.
+func (q *Query) entityComponents() [][]Var {
+	parents := make([]slotIdx, len(q.slots))
+	for i := range parents {
+		parents[i] = slotIdx(i)
+	}
+	find := func(s slotIdx) slotIdx {
+		for parents[s] != s {
+			s, parents[s] = parents[s], parents[parents[s]]
+		}
+		return s
+	}
+	union := func(slots ...slotIdx) {
+		for _, s := range slots[1:] {
+			parents[find(s)] = find(slots[0])
+		}
+	}
+	// Constant values do not relate the entities which share them.
+	for _, f := range q.facts {
+		if s := &q.slots[f.value]; s.empty() && s.any == nil {
+			union(f.variable, f.value)
+		}
+	}
+	for _, f := range q.filters {
+		if len(f.input) > 0 {
+			union(f.input...)
+		}
+	}
+	for _, p := range q.predicates {
+		for _, o := range p.operands {
+			union(p.operands[0].slot, o.slot)
+		}
+	}
+	for _, h := range q.hops {
+		union(h.src, h.target)
+	}
+	for _, u := range q.unreachables {
+		union(append([]slotIdx{u.target}, u.roots...)...)
+	}
+	for _, n := range q.negations {
+		for _, in := range n.inputs {
+			union(n.inputs[0].src, in.src)
+		}
+	}
+	for _, c := range q.cardinalities {
+		for _, in := range c.inputs {
+			union(c.inputs[0].src, in.src)
+		}
+	}
+
+	names := make(map[slotIdx]Var, len(q.variableSlots))
+	for v, s := range q.variableSlots {
+		names[s] = v
+	}
+	var components [][]Var
+	componentIdx := make(map[slotIdx]int)
+	for _, s := range q.entities {
+		root := find(s)
+		idx, ok := componentIdx[root]
+		if !ok {
+			idx = len(components)
+			componentIdx[root] = idx
+			components = append(components, nil)
+		}
+		components[idx] = append(components[idx], names[s])
+	}
+	return components
+}
+
+// lessOnSlots orders two sets of slots by the values in the slots at the
+// given indexes.
+func lessOnSlots(idxs []slotIdx, a, b []slot) bool {
+	for _, idx := range idxs {
+		if less, eq := a[idx].typedValue.compare(b[idx].typedValue); !eq {
+			return less
+		}
+	}
+	return false
+}
+
+// compare orders typed values first by type and then by value. Empty values
+// sort first.
+func (tv typedValue) compare(other typedValue) (less, eq bool) {
+	switch {
+	case tv.value == nil || other.value == nil:
+		return tv.value == nil && other.value != nil, tv.value == nil && other.value == nil
+	case tv.typ != other.typ:
+		return compare(tv.typ, other.typ)
+	default:
+		return compare(tv.value, other.value)
+	}
+}
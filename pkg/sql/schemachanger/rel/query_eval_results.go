@@ -0,0 +1,42 @@
+// Copyright 2021 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package rel
+
+import "github.com/cockroachdb/cockroach/pkg/util/iterutil"
+
+// Results returns a function which evaluates the query against the database
+// with the given options, calling yield with each of the results. Each Result
+// is only valid until yield returns. If yield returns false, the evaluation
+// halts as returning iterutil.StopIteration from a ResultIterator would. If
+// the evaluation fails, yield is called with the error and a nil Result after
+// the results produced so far.
+//
+// The returned function has the shape of a range-over-func iterator, so that
+// it can be ranged over with toolchains which support it:
+//
+//	for r, err := range q.Results(db) {
+//		...
+//	}
+func (q *Query) Results(db *Database, opts ...EvalOption) func(yield func(Result, error) bool) {
+	return func(yield func(Result, error) bool) {
+		var stopped bool
+		err := q.Iterate(db, func(r Result) error {
+			if !yield(r, nil) {
+				stopped = true
+				return iterutil.StopIteration()
+			}
+			return nil
+		}, opts...)
+		if err != nil && !stopped {
+			yield(nil, err)
+		}
+	}
+}
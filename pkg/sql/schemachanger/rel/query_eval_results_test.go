@@ -0,0 +1,100 @@
+// Copyright 2021 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package rel_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/schemachanger/rel"
+	"github.com/stretchr/testify/require"
+)
+
+// TestResults ensures that the function returned by Results yields all the
+// results of the query, halts the evaluation when yield returns false, and
+// yields the error of a failed evaluation.
+func TestResults(t *testing.T) {
+	type item struct {
+		Name string
+	}
+	const name stringAttr = "name"
+	sc := rel.MustSchema("results",
+		rel.EntityMapping(reflect.TypeOf((*item)(nil)),
+			rel.EntityAttr(name, "Name"),
+		),
+	)
+	db, err := rel.NewDatabase(sc, nil /* indexes */)
+	require.NoError(t, err)
+	for _, n := range []string{"a", "b", "c"} {
+		require.NoError(t, db.Insert(&item{Name: n}))
+	}
+	var a, b, na, nb rel.Var = "a", "b", "na", "nb"
+	q, err := rel.NewQuery(sc,
+		a.Type((*item)(nil)),
+		a.AttrEqVar(name, na),
+		b.Type((*item)(nil)),
+		b.AttrEqVar(name, nb),
+	)
+	require.NoError(t, err)
+	pair := func(r rel.Result) string {
+		return r.Var(na).(string) + r.Var(nb).(string)
+	}
+	var exp []string
+	require.NoError(t, q.Iterate(db, func(r rel.Result) error {
+		exp = append(exp, pair(r))
+		return nil
+	}, rel.OrderBy(na, nb)))
+	require.Len(t, exp, 9)
+
+	// collect calls the function returned by Results, stopping after limit
+	// results if limit is positive.
+	collect := func(
+		t *testing.T, results func(func(rel.Result, error) bool), limit int,
+	) (got []string, err error) {
+		results(func(r rel.Result, resErr error) bool {
+			if resErr != nil {
+				require.Nil(t, r)
+				require.NoError(t, err, "yielded more than one error")
+				err = resErr
+				return true
+			}
+			require.NoError(t, err, "yielded a result after an error")
+			got = append(got, pair(r))
+			return limit <= 0 || len(got) < limit
+		})
+		return got, err
+	}
+
+	t.Run("full", func(t *testing.T) {
+		got, err := collect(t, q.Results(db, rel.OrderBy(na, nb)), 0 /* limit */)
+		require.NoError(t, err)
+		require.Equal(t, exp, got)
+	})
+	t.Run("stop", func(t *testing.T) {
+		// Returning false halts the evaluation, including at the outer levels
+		// of the join, with and without options.
+		for _, opts := range [][]rel.EvalOption{nil, {rel.OrderBy(na, nb)}} {
+			got, err := collect(t, q.Results(db, opts...), 2 /* limit */)
+			require.NoError(t, err)
+			require.Len(t, got, 2)
+		}
+		got, err := collect(t, q.Results(db, rel.OrderBy(na, nb)), 1 /* limit */)
+		require.NoError(t, err)
+		require.Equal(t, exp[:1], got)
+	})
+	t.Run("error", func(t *testing.T) {
+		other, err := rel.NewDatabase(rel.MustSchema("other"), nil /* indexes */)
+		require.NoError(t, err)
+		got, err := collect(t, q.Results(other), 0 /* limit */)
+		require.Empty(t, got)
+		require.Regexp(t, "not from the same schema", err)
+	})
+}
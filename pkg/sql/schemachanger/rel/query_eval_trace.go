@@ -0,0 +1,114 @@
+// Copyright 2021 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package rel
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultRejectionTraceLimit is the number of rejections recorded by a
+// RejectionTrace which does not set a Limit.
+const DefaultRejectionTraceLimit = 1000
+
+// RejectionTrace records the candidates rejected during the evaluation of a
+// query, and the clauses which rejected them. It is populated by evaluating
+// a query with WithRejectionTrace and is intended for debugging queries which
+// unexpectedly return no results.
+type RejectionTrace struct {
+	// Limit bounds the number of rejections recorded. If zero,
+	// DefaultRejectionTraceLimit is used.
+	Limit int
+	// Rejections are the recorded rejections, in evaluation order.
+	Rejections []Rejection
+	// Dropped is the number of rejections which were not recorded because
+	// the limit was reached.
+	Dropped int
+}
+
+// Rejection describes the rejection of a candidate during evaluation.
+type Rejection struct {
+	// Entity is the entity most recently bound by the join when the candidate
+	// was rejected.
+	Entity interface{}
+	// Clause is the clause which rejected the candidate. It is nil if the
+	// candidate was rejected because some variables could not be bound.
+	Clause Clause
+	// Reason describes why the clause rejected the candidate.
+	Reason string
+
+	// schema is used to format the attributes of the clause.
+	schema *Schema
+}
+
+func (r Rejection) String() string {
+	return fmt.Sprintf(
+		"%v: %s: %s", r.Entity, clauseString(r.schema.withAttrNames(r.Clause)), r.Reason,
+	)
+}
+
+// clauseString returns the flow-style yaml representation of the clause.
+func clauseString(c Clause) string {
+	if c == nil {
+		return "<nil>"
+	}
+	var n yaml.Node
+	if err := n.Encode(c); err != nil {
+		return fmt.Sprintf("%T", c)
+	}
+	n.Style = yaml.FlowStyle
+	out, err := yaml.Marshal(&n)
+	if err != nil {
+		return fmt.Sprintf("%T", c)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func (t *RejectionTrace) record(r Rejection) {
+	limit := t.Limit
+	if limit == 0 {
+		limit = DefaultRejectionTraceLimit
+	}
+	if len(t.Rejections) >= limit {
+		t.Dropped++
+		return
+	}
+	t.Rejections = append(t.Rejections, r)
+}
+
+// tracing returns true if rejections are being recorded or counted, in which
+// case callers should report them with reject.
+func (ec *evalContext) tracing() bool {
+	return ec.trace != nil || ec.explain != nil
+}
+
+// reject records the rejection of the candidate entity bound at position
+// cur in the join, if a trace is being collected.
+func (ec *evalContext) reject(cur int, c Clause, format string, args ...interface{}) {
+	if ec.explain != nil {
+		ec.explain.reject(ec.q.schema, c)
+	}
+	if ec.trace == nil {
+		return
+	}
+	var e interface{}
+	if cur >= 0 && cur < len(ec.q.entities) {
+		e = ec.slots[ec.q.entities[cur]].toInterface()
+	}
+	ec.trace.record(Rejection{
+		Entity: e,
+		Clause: c,
+		Reason: fmt.Sprintf(format, args...),
+		schema: ec.q.schema,
+	})
+}
@@ -0,0 +1,115 @@
+// Copyright 2021 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package rel
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+)
+
+// ExplainAnalyze evaluates the query against the database and returns a
+// description of its evaluation alongside its results, which remain valid
+// after evaluation. It is intended for validating the estimates used by
+// Optimize.
+//
+// For each entity variable, in join order, the description reports the
+// estimated number of entities which may be bound to it, the number of
+// candidates examined at its position in the join, and the number of those
+// which passed the clauses that could be checked once it was bound. For each
+// clause, it reports the number of candidates the clause rejected. Apart from
+// the counts, the description only depends on the query. For a query with a
+// UnionQueries clause, each branch is described separately.
+func (q *Query) ExplainAnalyze(db *Database) (string, []Result, error) {
+	if db.schema != q.schema {
+		return "", nil, errors.Errorf(
+			"query and database are not from the same schema: %s != %s",
+			db.schema.name, q.schema.name,
+		)
+	}
+	stats := db.Stats()
+	var buf strings.Builder
+	results := []Result{}
+	for _, bq := range q.evaluated() {
+		ec := newEvalContext(bq)
+		ec.explain = newExplainCounters(bq)
+		n := len(results)
+		if err := ec.Iterate(db, func(Result) error {
+			results = append(results, ec.bufferedResult())
+			return nil
+		}); err != nil {
+			return "", nil, err
+		}
+		indent := ""
+		if len(q.branches) > 0 {
+			fmt.Fprintf(&buf, "branch %s:\n", bq.branch)
+			indent = "  "
+		}
+		ec.explain.format(&buf, indent, bq, stats, len(results)-n)
+	}
+	return buf.String(), results, nil
+}
+
+// explainCounters accumulates the counts reported by ExplainAnalyze during
+// the evaluation of a query.
+type explainCounters struct {
+	// examined and passed are the number of candidates examined at each
+	// position of the join, and the number of those which passed the clauses
+	// checked at that position.
+	examined, passed []int
+	// rejected is the number of candidates rejected by each clause, keyed
+	// by its string representation.
+	rejected map[string]int
+	// unbound is the number of candidates rejected because some variables
+	// could not be bound.
+	unbound int
+}
+
+func newExplainCounters(q *Query) *explainCounters {
+	return &explainCounters{
+		examined: make([]int, len(q.entities)),
+		passed:   make([]int, len(q.entities)),
+		rejected: make(map[string]int),
+	}
+}
+
+func (c *explainCounters) reject(sc *Schema, clause Clause) {
+	if clause == nil {
+		c.unbound++
+		return
+	}
+	c.rejected[clauseString(sc.withAttrNames(clause))]++
+}
+
+func (c *explainCounters) format(
+	buf *strings.Builder, indent string, q *Query, stats *DatabaseStats, numResults int,
+) {
+	fmt.Fprintf(buf, "%sentities:\n", indent)
+	for i, v := range q.Entities() {
+		fmt.Fprintf(buf, "%s  $%s: estimated %d, examined %d, passed %d\n",
+			indent, v, stats.estimate(q, q.entities[i]), c.examined[i], c.passed[i])
+	}
+	fmt.Fprintf(buf, "%sclauses:\n", indent)
+	seen := make(map[string]struct{}, len(q.clauses))
+	for _, clause := range q.clauses {
+		s := clauseString(q.schema.withAttrNames(clause))
+		if _, ok := seen[s]; ok {
+			continue
+		}
+		seen[s] = struct{}{}
+		fmt.Fprintf(buf, "%s  %s: rejected %d\n", indent, s, c.rejected[s])
+	}
+	if c.unbound > 0 {
+		fmt.Fprintf(buf, "%s  <unbound variables>: rejected %d\n", indent, c.unbound)
+	}
+	fmt.Fprintf(buf, "%sresults: %d\n", indent, numResults)
+}
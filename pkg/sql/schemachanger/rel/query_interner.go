@@ -0,0 +1,201 @@
+// Copyright 2021 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package rel
+
+import (
+	"reflect"
+
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+)
+
+// ClauseInterner interns the leaf clauses of the queries constructed with it,
+// such that structurally identical leaf clauses of different queries share a
+// single instance, along with the values compiled from their constants. This
+// reduces the memory used by large sets of queries, such as rules, which
+// share many clauses, like Type constraints. See NewQueryWithInterner.
+//
+// The leaf clauses which are interned are those constraining an attribute of
+// a variable, or a variable itself, to be a Var, a value, or one of a set of
+// values, like AttrEq, AttrEqVar, AttrIn, Type, Eq and In. Clauses with
+// constants which are not comparable are not interned. Interned clauses, and
+// their values, are retained for the lifetime of the ClauseInterner.
+//
+// It is safe to use a ClauseInterner concurrently.
+type ClauseInterner struct {
+	mu struct {
+		syncutil.Mutex
+		// clauses are the interned clauses, bucketed by the parts of the
+		// clauses which can be used as a map key.
+		clauses map[internKey][]Clause
+		// numClauses is the number of interned clauses.
+		numClauses int
+		// values are the values compiled from the sets of values of the
+		// interned clauses, keyed by the first element of each set.
+		values map[*interface{}][]typedValue
+	}
+}
+
+// internKey is the part of a leaf clause which can be used as a map key.
+type internKey struct {
+	triple    bool
+	v         Var
+	attribute Attr
+	exprType  reflect.Type
+	exprLen   int
+}
+
+// NewClauseInterner constructs a new ClauseInterner.
+func NewClauseInterner() *ClauseInterner {
+	ci := &ClauseInterner{}
+	ci.mu.clauses = make(map[internKey][]Clause)
+	ci.mu.values = make(map[*interface{}][]typedValue)
+	return ci
+}
+
+// NewQueryWithInterner is like NewQuery, except that the leaf clauses of the
+// query are interned using the ClauseInterner. The Clauses of the query are
+// the interned instances.
+func NewQueryWithInterner(sc *Schema, ci *ClauseInterner, clauses ...Clause) (*Query, error) {
+	return newQueryWithInterner(sc, ci, clauses)
+}
+
+// Len returns the number of distinct clauses interned.
+func (ci *ClauseInterner) Len() int {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+	return ci.mu.numClauses
+}
+
+// intern returns the interned clause structurally identical to the clause,
+// interning it if there's none. Clauses which cannot be interned are returned
+// as is. The ClauseInterner may be nil, in which case nothing is interned.
+func (ci *ClauseInterner) intern(c Clause) Clause {
+	if ci == nil {
+		return c
+	}
+	var k internKey
+	var e expr
+	switch t := c.(type) {
+	case *tripleDecl:
+		k = internKey{triple: true, v: t.entity, attribute: t.attribute}
+		e = t.value
+	case *eqDecl:
+		k = internKey{v: t.v}
+		e = t.expr
+	default:
+		return c
+	}
+	if !isInternableExpr(e) {
+		return c
+	}
+	k.exprType = reflect.TypeOf(e)
+	if a, ok := e.(anyExpr); ok {
+		k.exprLen = len(a)
+	}
+
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+	for _, interned := range ci.mu.clauses[k] {
+		if exprsEqual(e, internedExpr(interned)) {
+			return interned
+		}
+	}
+	ci.mu.clauses[k] = append(ci.mu.clauses[k], c)
+	ci.mu.numClauses++
+	if a, ok := e.(anyExpr); ok && len(a) > 0 {
+		ci.mu.values[&a[0]] = nil
+	}
+	return c
+}
+
+// anyValues returns the values compiled from the set of values using
+// compile, which is only called once for the sets of values of interned
+// clauses. The ClauseInterner may be nil.
+func (ci *ClauseInterner) anyValues(a anyExpr, compile func() []typedValue) []typedValue {
+	if ci == nil || len(a) == 0 {
+		return compile()
+	}
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+	values, interned := ci.mu.values[&a[0]]
+	if !interned {
+		return compile()
+	}
+	if values == nil {
+		values = compile()
+		ci.mu.values[&a[0]] = values
+	}
+	return values
+}
+
+// internedExpr returns the expression of an interned clause.
+func internedExpr(c Clause) expr {
+	switch t := c.(type) {
+	case *tripleDecl:
+		return t.value
+	case *eqDecl:
+		return t.expr
+	default:
+		return nil
+	}
+}
+
+// isInternableExpr returns true if the expression can be compared with
+// exprsEqual.
+func isInternableExpr(e expr) bool {
+	switch t := e.(type) {
+	case Var, boolExpr:
+		return true
+	case valueExpr:
+		return isComparable(t.value)
+	case anyExpr:
+		for _, v := range t {
+			if !isComparable(v) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+func isComparable(v interface{}) bool {
+	return v == nil || reflect.TypeOf(v).Comparable()
+}
+
+// exprsEqual returns true if the two expressions, which must be internable
+// and of the same type, are structurally identical.
+func exprsEqual(a, b expr) bool {
+	switch a := a.(type) {
+	case anyExpr:
+		b := b.(anyExpr)
+		if len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !valuesEqual(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	case valueExpr:
+		return valuesEqual(a.value, b.(valueExpr).value)
+	default:
+		return a == b
+	}
+}
+
+// valuesEqual returns true if the comparable values are of the same type and
+// equal.
+func valuesEqual(a, b interface{}) bool {
+	return reflect.TypeOf(a) == reflect.TypeOf(b) && a == b
+}
@@ -10,7 +10,14 @@
 
 package rel
 
-import "reflect"
+import (
+	"encoding/hex"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+)
 
 // Clause is the basic building block of a query. A query is defined as
 // the conjunction of clauses.
@@ -43,12 +50,765 @@ func (v Var) AttrIn(a Attr, values ...interface{}) Clause {
 	return newTriple(v, a, (anyExpr)(values))
 }
 
+// AttrValidEnum constrains the entity bound to v to have a value for the
+// specified attr which is one of the valid values of an enum. It is
+// equivalent to AttrIn, but names the intent of validating data: wrapping it
+// in Not finds the entities with invalid values, or with no value at all.
+func (v Var) AttrValidEnum(a Attr, valid ...interface{}) Clause {
+	return v.AttrIn(a, valid...)
+}
+
 // AttrEqVar constrains the entity bound to v to have a value for
 // the specified attr equal to the variable value.
 func (v Var) AttrEqVar(a Attr, value Var) Clause {
 	return newTriple(v, a, value)
 }
 
+// AttrInto binds out to the value of the attribute a of the entity bound to
+// v, so that the value can be constrained by other clauses, for instance
+// using Eq or In, or compared with the attributes of other entities by
+// passing out and Self to AttrLtVar and friends. It is syntactic sugar around
+// AttrEqVar, which reads better when out is not otherwise bound.
+func (v Var) AttrInto(a Attr, out Var) Clause {
+	return v.AttrEqVar(a, out)
+}
+
+// AttrEqOtherAttr constrains the entity bound to v to have a value for the
+// attribute a which is equal to the value of the attribute otherAttr of the
+// entity bound to other. The two attributes must be of comparable types.
+// Unlike with AttrEqVar, the value on which the entities are joined is not
+// bound to a variable.
+func (v Var) AttrEqOtherAttr(a Attr, other Var, otherAttr Attr) Clause {
+	return &attrEqAttrDecl{
+		entity:         v,
+		attribute:      a,
+		other:          other,
+		otherAttribute: otherAttr,
+	}
+}
+
+// AttrTrue constrains the entity bound to v to have a true value for the
+// specified attr, which must be of a bool type.
+func (v Var) AttrTrue(a Attr) Clause {
+	return newTriple(v, a, boolExpr(true))
+}
+
+// AttrFalse constrains the entity bound to v to have a false value for the
+// specified attr, which must be of a bool type.
+func (v Var) AttrFalse(a Attr) Clause {
+	return newTriple(v, a, boolExpr(false))
+}
+
+// AttrEqAnyAttr constrains the entity bound to v to have a value for the
+// attribute a which is equal to the value of at least one of the other
+// attributes of the same entity. All of the attributes must be of comparable
+// types.
+func (v Var) AttrEqAnyAttr(a Attr, others ...Attr) Clause {
+	operands := make([]attrRef, 0, len(others)+1)
+	operands = append(operands, attrRef{v: v, a: a})
+	for _, o := range others {
+		operands = append(operands, attrRef{v: v, a: o})
+	}
+	return &predicateDecl{
+		op:       "IN",
+		rhs:      operands[1:],
+		operands: operands,
+		newPredicate: func(types []reflect.Type) (predicateFunc, error) {
+			for _, typ := range types[1:] {
+				if err := checkComparableTypes(types[0], typ); err != nil {
+					return nil, err
+				}
+			}
+			return func(args []typedValue) bool {
+				for _, other := range args[1:] {
+					if args[0].eq(other) {
+						return true
+					}
+				}
+				return false
+			}, nil
+		},
+	}
+}
+
+// AnyAttrEq constrains the entity bound to v to have a value equal to value
+// for at least one of the attributes. Unlike AttrEq, the attribute isn't
+// fixed: entities match via whichever of the attributes has the value, and
+// each entity matches once even if several do. All of the attributes must be
+// of types comparable to that of the value. At least one attribute must be
+// provided; constructing a query with none fails.
+func (v Var) AnyAttrEq(attrs []Attr, value interface{}) Clause {
+	operands := make([]attrRef, len(attrs))
+	for i, a := range attrs {
+		operands[i] = attrRef{v: v, a: a}
+	}
+	return &predicateDecl{
+		op:       "ANY =",
+		rhs:      valueExpr{value: value},
+		operands: operands,
+		newPredicate: func(types []reflect.Type) (predicateFunc, error) {
+			if len(types) == 0 {
+				return nil, errors.Errorf("AnyAttrEq requires at least one attribute")
+			}
+			tv, err := makeComparableValue(value)
+			if err != nil {
+				return nil, err
+			}
+			for _, typ := range types {
+				if err := checkComparableTypes(typ, tv.typ); err != nil {
+					return nil, err
+				}
+			}
+			return func(args []typedValue) bool {
+				for _, arg := range args {
+					if arg.eq(tv) {
+						return true
+					}
+				}
+				return false
+			}, nil
+		},
+	}
+}
+
+// AttrHasPrefix constrains the entity bound to v to have a value for the
+// attribute a, which must be of a string type, that begins with prefix. An
+// empty prefix matches all values.
+//
+// The constraint is expressed as the range of values [prefix, end), where end
+// is the smallest string greater than all the strings beginning with prefix,
+// so that it could be served by a scan over a sorted index on the attribute.
+// There are currently no such indexes, so it is evaluated like a filter.
+func (v Var) AttrHasPrefix(a Attr, prefix string) Clause {
+	return &predicateDecl{
+		op:       "HAS PREFIX",
+		rhs:      valueExpr{value: prefix},
+		operands: []attrRef{{v: v, a: a}},
+		newPredicate: func(types []reflect.Type) (predicateFunc, error) {
+			if types[0].Kind() != reflect.String {
+				return nil, &TypeMismatchError{Type: types[0], Expected: "a string"}
+			}
+			end, hasEnd := prefixEnd(prefix)
+			return func(args []typedValue) bool {
+				if args[0].value == nil {
+					return false
+				}
+				s := reflect.ValueOf(args[0].toInterface()).String()
+				return s >= prefix && (!hasEnd || s < end)
+			}, nil
+		},
+	}
+}
+
+// AttrGlob constrains the entity bound to v to have a value for the attribute
+// a, which must be of a string type, that matches the glob pattern. In the
+// pattern, * matches any sequence of characters, including the empty one, and
+// ? matches any single character; all other characters match themselves. The
+// pattern is anchored: it must match the whole value, not just a substring,
+// so "*_idx" matches "foo_idx" but not "foo_idx_2". The pattern is compiled
+// once, when the clause is constructed.
+func (v Var) AttrGlob(a Attr, pattern string) Clause {
+	re := compileGlob(pattern)
+	return &predicateDecl{
+		op:       "GLOB",
+		rhs:      valueExpr{value: pattern},
+		operands: []attrRef{{v: v, a: a}},
+		newPredicate: func(types []reflect.Type) (predicateFunc, error) {
+			if types[0].Kind() != reflect.String {
+				return nil, &TypeMismatchError{Type: types[0], Expected: "a string"}
+			}
+			return func(args []typedValue) bool {
+				if args[0].value == nil {
+					return false
+				}
+				return re.MatchString(reflect.ValueOf(args[0].toInterface()).String())
+			}, nil
+		},
+	}
+}
+
+// compileGlob compiles the glob pattern accepted by AttrGlob into an
+// equivalent, anchored, regular expression.
+func compileGlob(pattern string) *regexp.Regexp {
+	var buf strings.Builder
+	buf.WriteString(`(?s)^`)
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			buf.WriteString(`.*`)
+		case '?':
+			buf.WriteString(`.`)
+		default:
+			buf.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	buf.WriteString(`$`)
+	return regexp.MustCompile(buf.String())
+}
+
+// AttrIsZero constrains the entity bound to v to have a value for the
+// attribute a which is the zero value of the attribute's type, such as 0, "",
+// false, or a nil slice. This is distinct from the entity not having a value
+// for the attribute at all, as is the case for nil pointer fields, which does
+// not match: for an attribute mapped to a pointer to a scalar, only a non-nil
+// pointer to the zero value matches. The attribute must not refer to entities
+// or be of an interface type, as the zero value of those is the absence of a
+// value.
+func (v Var) AttrIsZero(a Attr) Clause {
+	return &predicateDecl{
+		op:       "IS ZERO",
+		operands: []attrRef{{v: v, a: a}},
+		newPredicate: func(types []reflect.Type) (predicateFunc, error) {
+			if isEntityType(types[0]) {
+				return nil, &TypeMismatchError{
+					Type: types[0], Expected: "a type with a non-nil zero value",
+				}
+			}
+			return func(args []typedValue) bool {
+				if args[0].value == nil {
+					return false
+				}
+				return reflect.ValueOf(args[0].toInterface()).IsZero()
+			}, nil
+		},
+	}
+}
+
+// AttrIsHexKey constrains the entity bound to v to have a value for the
+// attribute a, which must be of a string type, that is a hexadecimal encoded
+// key, as a roachpb.Key is formatted using %x. The empty string encodes the
+// empty key. Values which do not decode, such as those with characters other
+// than hexadecimal digits or an odd number of digits, do not match. Only the
+// encoding is checked: the decoded bytes aren't validated as a key.
+func (v Var) AttrIsHexKey(a Attr) Clause {
+	return &predicateDecl{
+		op:       "IS HEX KEY",
+		operands: []attrRef{{v: v, a: a}},
+		newPredicate: func(types []reflect.Type) (predicateFunc, error) {
+			if types[0].Kind() != reflect.String {
+				return nil, &TypeMismatchError{Type: types[0], Expected: "a string"}
+			}
+			return func(args []typedValue) bool {
+				if args[0].value == nil {
+					return false
+				}
+				_, err := hex.DecodeString(reflect.ValueOf(args[0].toInterface()).String())
+				return err == nil
+			}, nil
+		},
+	}
+}
+
+// AttrInOrUnset constrains the entity bound to v to either have no value for
+// the attribute a, or a value in the set of provided values. Note that, as
+// for AttrIsZero, the zero value of a type is a value: an entity with the
+// zero value for the attribute only matches if the zero value is among the
+// provided values. Only pointer, interface and entity attributes are unset
+// when the field is nil. Entities of types which do not have the attribute
+// at all also match, so this is typically combined with Type.
+//
+// Unlike AttrIn, the clause does not constrain the lookup of the entity.
+func (v Var) AttrInOrUnset(a Attr, values ...interface{}) Clause {
+	return &predicateDecl{
+		op:       "IN OR UNSET",
+		rhs:      anyExpr(values),
+		operands: []attrRef{{v: v, a: a}},
+		newPredicate: func(types []reflect.Type) (predicateFunc, error) {
+			tvs := make([]typedValue, len(values))
+			for i, value := range values {
+				tv, err := makeComparableValue(value)
+				if err != nil {
+					return nil, err
+				}
+				if err := checkComparableTypes(tv.typ, types[0]); err != nil {
+					return nil, err
+				}
+				tvs[i] = tv
+			}
+			return func(args []typedValue) bool {
+				if args[0].value == nil {
+					return true
+				}
+				for _, tv := range tvs {
+					if _, eq := args[0].compare(tv); eq {
+						return true
+					}
+				}
+				return false
+			}, nil
+		},
+	}
+}
+
+// AttrLenEq constrains the entity bound to v to have a value for the
+// attribute a, which must be a slice, of length n. A nil slice has length 0.
+func (v Var) AttrLenEq(a Attr, n int) Clause {
+	return &predicateDecl{
+		op:       "HAS LENGTH",
+		rhs:      valueExpr{value: n},
+		operands: []attrRef{{v: v, a: a}},
+		newPredicate: func(types []reflect.Type) (predicateFunc, error) {
+			if types[0].Kind() != reflect.Slice {
+				return nil, &TypeMismatchError{Type: types[0], Expected: "a slice"}
+			}
+			if n < 0 {
+				return nil, errors.Errorf("invalid length %d", n)
+			}
+			return func(args []typedValue) bool {
+				if args[0].value == nil {
+					return false
+				}
+				return reflect.ValueOf(args[0].toInterface()).Len() == n
+			}, nil
+		},
+	}
+}
+
+// AttrInOtherSlice constrains the entity bound to v to have a value for the
+// attribute a which is an element of the value of the attribute
+// otherSliceAttr, which must be a slice, of the entity bound to other. The
+// type of the elements must be comparable to the type of a. Like the other
+// predicates joining entities, it is checked as soon as both entities are
+// bound.
+func (v Var) AttrInOtherSlice(a Attr, other Var, otherSliceAttr Attr) Clause {
+	rhs := attrRef{v: other, a: otherSliceAttr}
+	return &predicateDecl{
+		op:       "IN ELEMENTS OF",
+		rhs:      rhs,
+		operands: []attrRef{{v: v, a: a}, rhs},
+		newPredicate: func(types []reflect.Type) (predicateFunc, error) {
+			if types[1].Kind() != reflect.Slice {
+				return nil, &TypeMismatchError{Type: types[1], Expected: "a slice"}
+			}
+			if err := checkComparableTypes(types[0], types[1].Elem()); err != nil {
+				return nil, err
+			}
+			compType := reflect.PtrTo(getComparableType(types[1].Elem()))
+			return func(args []typedValue) bool {
+				if args[0].value == nil || args[1].value == nil {
+					return false
+				}
+				return sliceContains(args[1].value, args[0].value, compType)
+			}, nil
+		},
+	}
+}
+
+// AttrChangedFrom constrains the entity bound to v to have a value for the
+// attribute a which differs from the value of the same attribute of the
+// entity bound to counterpart, typically another version of the same entity
+// matched by some identifying attributes. An entity which has a value for the
+// attribute when its counterpart does not, or vice versa, has changed; two
+// entities which both lack a value have not. Values which refer to entities
+// are compared by identity. Like the other predicates joining entities, it is
+// checked as soon as both entities are bound.
+func (v Var) AttrChangedFrom(a Attr, counterpart Var) Clause {
+	rhs := attrRef{v: counterpart, a: a}
+	return &predicateDecl{
+		op:       "CHANGED FROM",
+		rhs:      rhs,
+		operands: []attrRef{{v: v, a: a}, rhs},
+		newPredicate: func(types []reflect.Type) (predicateFunc, error) {
+			if err := checkComparableTypes(types[0], types[1]); err != nil {
+				return nil, err
+			}
+			return func(args []typedValue) bool {
+				if args[0].value == nil || args[1].value == nil {
+					return (args[0].value == nil) != (args[1].value == nil)
+				}
+				return !args[0].eq(args[1])
+			}, nil
+		},
+	}
+}
+
+// AttrContains constrains the entity bound to v to have a value for the
+// attribute a, which must be a slice, which contains value as an element.
+func (v Var) AttrContains(a Attr, value interface{}) Clause {
+	return &predicateDecl{
+		op:       "CONTAINS",
+		rhs:      valueExpr{value: value},
+		operands: []attrRef{{v: v, a: a}},
+		newPredicate: func(types []reflect.Type) (predicateFunc, error) {
+			if types[0].Kind() != reflect.Slice {
+				return nil, &TypeMismatchError{Type: types[0], Expected: "a slice"}
+			}
+			tv, err := makeComparableValue(value)
+			if err != nil {
+				return nil, err
+			}
+			if err := checkComparableTypes(tv.typ, types[0].Elem()); err != nil {
+				return nil, err
+			}
+			compType := reflect.PtrTo(getComparableType(types[0].Elem()))
+			return func(args []typedValue) bool {
+				if args[0].value == nil {
+					return false
+				}
+				return sliceContains(args[0].value, tv.value, compType)
+			}, nil
+		},
+	}
+}
+
+// sliceContains returns true if the slice, in its comparable form, contains
+// the element, in its comparable form of type compType.
+func sliceContains(slice, elem interface{}, compType reflect.Type) bool {
+	elems := reflect.ValueOf(slice).Elem()
+	for i := 0; i < elems.Len(); i++ {
+		if _, eq := compare(elem, elems.Index(i).Addr().Convert(compType).Interface()); eq {
+			return true
+		}
+	}
+	return false
+}
+
+// AttrLt constrains the entity bound to v to have a value for the attribute
+// a which is less than value. The attribute must be of an ordered type: a
+// numeric type, a string type, time.Time, or a type which implements
+// Comparator.
+func (v Var) AttrLt(a Attr, value interface{}) Clause {
+	return newOrderedPredicate(v, a, "<", valueExpr{value: value}, orderedBound{
+		value: value,
+		ok:    func(less, eq bool) bool { return less },
+	})
+}
+
+// AttrGt constrains the entity bound to v to have a value for the attribute
+// a which is greater than value. The attribute must be of an ordered type.
+func (v Var) AttrGt(a Attr, value interface{}) Clause {
+	return newOrderedPredicate(v, a, ">", valueExpr{value: value}, orderedBound{
+		value: value,
+		ok:    func(less, eq bool) bool { return !less && !eq },
+	})
+}
+
+// AttrBetween constrains the entity bound to v to have a value for the
+// attribute a which is in the closed range [lo, hi]. The attribute must be of
+// an ordered type.
+func (v Var) AttrBetween(a Attr, lo, hi interface{}) Clause {
+	return newOrderedPredicate(v, a, "BETWEEN", anyExpr{lo, hi}, orderedBound{
+		value: lo,
+		ok:    func(less, eq bool) bool { return !less },
+	}, orderedBound{
+		value: hi,
+		ok:    func(less, eq bool) bool { return less || eq },
+	})
+}
+
+// ValueConstraint is a constraint on the value of an attribute, for use with
+// AttrCapture. It is constructed using ValueIn, ValueLt, ValueGt,
+// ValueBetween, ValueHasPrefix or ValueGlob, which constrain the value as the
+// corresponding Attr clauses do.
+type ValueConstraint struct {
+	clause func(v Var, a Attr) Clause
+}
+
+// ValueIn constrains the value to be one of the provided values, like AttrIn.
+func ValueIn(values ...interface{}) ValueConstraint {
+	return ValueConstraint{clause: func(v Var, a Attr) Clause {
+		return v.AttrIn(a, values...)
+	}}
+}
+
+// ValueLt constrains the value to be less than the provided value, like
+// AttrLt.
+func ValueLt(value interface{}) ValueConstraint {
+	return ValueConstraint{clause: func(v Var, a Attr) Clause {
+		return v.AttrLt(a, value)
+	}}
+}
+
+// ValueGt constrains the value to be greater than the provided value, like
+// AttrGt.
+func ValueGt(value interface{}) ValueConstraint {
+	return ValueConstraint{clause: func(v Var, a Attr) Clause {
+		return v.AttrGt(a, value)
+	}}
+}
+
+// ValueBetween constrains the value to be in the closed range [lo, hi], like
+// AttrBetween.
+func ValueBetween(lo, hi interface{}) ValueConstraint {
+	return ValueConstraint{clause: func(v Var, a Attr) Clause {
+		return v.AttrBetween(a, lo, hi)
+	}}
+}
+
+// ValueHasPrefix constrains the value to begin with the prefix, like
+// AttrHasPrefix.
+func ValueHasPrefix(prefix string) ValueConstraint {
+	return ValueConstraint{clause: func(v Var, a Attr) Clause {
+		return v.AttrHasPrefix(a, prefix)
+	}}
+}
+
+// ValueGlob constrains the value to match the glob pattern, like AttrGlob.
+func ValueGlob(pattern string) ValueConstraint {
+	return ValueConstraint{clause: func(v Var, a Attr) Clause {
+		return v.AttrGlob(a, pattern)
+	}}
+}
+
+// AttrCapture constrains the entity bound to v to have a value for the
+// attribute a which satisfies the constraint, and binds out to that value.
+// Unlike AttrEq, the constraint may permit more than one value, such as with
+// ValueIn, in which case out is bound to whichever value the entity has. It
+// is syntactic sugar around AttrEqVar and the clause corresponding to the
+// constraint.
+func (v Var) AttrCapture(a Attr, out Var, constraint ValueConstraint) Clause {
+	if constraint.clause == nil {
+		panic(errors.AssertionFailedf("empty ValueConstraint"))
+	}
+	return And(
+		v.AttrEqVar(a, out),
+		constraint.clause(v, a),
+	)
+}
+
+// AttrLtVar constrains the entity bound to v to have a value for the attribute
+// a which is less than the value of the attribute otherAttr of the entity
+// bound to other. The attributes must be of comparable, ordered types.
+//
+// Unlike AttrEqOtherAttr, such an inequality join cannot be used to constrain
+// the lookup of either entity. Instead, it is checked as soon as both entities
+// are bound, which prunes the join before any further entities are joined.
+func (v Var) AttrLtVar(a Attr, other Var, otherAttr Attr) Clause {
+	return newOrderedJoinPredicate(v, a, "<", other, otherAttr,
+		func(less, eq bool) bool { return less })
+}
+
+// AttrLeVar is like AttrLtVar but also permits the values to be equal.
+func (v Var) AttrLeVar(a Attr, other Var, otherAttr Attr) Clause {
+	return newOrderedJoinPredicate(v, a, "<=", other, otherAttr,
+		func(less, eq bool) bool { return less || eq })
+}
+
+// AttrGtVar is like AttrLtVar but constrains the value of the attribute a to
+// be greater than the value of otherAttr.
+func (v Var) AttrGtVar(a Attr, other Var, otherAttr Attr) Clause {
+	return newOrderedJoinPredicate(v, a, ">", other, otherAttr,
+		func(less, eq bool) bool { return !less && !eq })
+}
+
+// AttrGeVar is like AttrGtVar but also permits the values to be equal.
+func (v Var) AttrGeVar(a Attr, other Var, otherAttr Attr) Clause {
+	return newOrderedJoinPredicate(v, a, ">=", other, otherAttr,
+		func(less, eq bool) bool { return !less })
+}
+
+// AttrInRangeOf constrains the entity bound to v to have a value for the
+// attribute a which is in the half-open range [low, high), where low is the
+// value of the attribute lowAttr of the entity bound to lowVar, and high that
+// of highAttr of the entity bound to highVar. For example, it can constrain
+// the ordinal of a child to be within the number of children of its parent.
+// The attributes must be of the same numeric type. Entities without values
+// for any of the attributes do not match. Like the other predicates joining
+// entities, it is checked as soon as all the entities are bound.
+func (v Var) AttrInRangeOf(a Attr, lowVar Var, lowAttr Attr, highVar Var, highAttr Attr) Clause {
+	bounds := []attrRef{{v: lowVar, a: lowAttr}, {v: highVar, a: highAttr}}
+	return &predicateDecl{
+		op:       "IN RANGE OF",
+		rhs:      bounds,
+		operands: []attrRef{{v: v, a: a}, bounds[0], bounds[1]},
+		newPredicate: func(types []reflect.Type) (predicateFunc, error) {
+			for _, typ := range types {
+				if !isNumericType(typ) {
+					return nil, &TypeMismatchError{Type: typ, Expected: "a numeric type"}
+				}
+			}
+			for _, typ := range types[1:] {
+				if err := checkComparableTypes(types[0], typ); err != nil {
+					return nil, err
+				}
+			}
+			return func(args []typedValue) bool {
+				for _, arg := range args {
+					if arg.value == nil {
+						return false
+					}
+				}
+				if less, _ := compare(args[0].value, args[1].value); less {
+					return false
+				}
+				less, _ := compare(args[0].value, args[2].value)
+				return less
+			}, nil
+		},
+	}
+}
+
+func newOrderedJoinPredicate(
+	v Var, a Attr, op string, other Var, otherAttr Attr, ok func(less, eq bool) bool,
+) Clause {
+	rhs := attrRef{v: other, a: otherAttr}
+	return &predicateDecl{
+		op:       op,
+		rhs:      rhs,
+		operands: []attrRef{{v: v, a: a}, rhs},
+		newPredicate: func(types []reflect.Type) (predicateFunc, error) {
+			if !isOrderedType(types[0]) {
+				return nil, &TypeMismatchError{Type: types[0], Expected: "an ordered type"}
+			}
+			if err := checkComparableTypes(types[0], types[1]); err != nil {
+				return nil, err
+			}
+			return func(args []typedValue) bool {
+				if args[0].value == nil || args[1].value == nil {
+					return false
+				}
+				return ok(compare(args[0].value, args[1].value))
+			}, nil
+		},
+	}
+}
+
+// orderedBound is a bound on the value of an attribute. The function is
+// called with the result of comparing the value to the bound.
+type orderedBound struct {
+	value interface{}
+	ok    func(less, eq bool) bool
+}
+
+func newOrderedPredicate(v Var, a Attr, op string, rhs expr, bounds ...orderedBound) Clause {
+	return &predicateDecl{
+		op:       op,
+		rhs:      rhs,
+		operands: []attrRef{{v: v, a: a}},
+		newPredicate: func(types []reflect.Type) (predicateFunc, error) {
+			if !isOrderedType(types[0]) {
+				return nil, &TypeMismatchError{Type: types[0], Expected: "an ordered type"}
+			}
+			values := make([]typedValue, len(bounds))
+			for i, b := range bounds {
+				tv, err := makeComparableValue(b.value)
+				if err != nil {
+					return nil, err
+				}
+				if err := checkComparableTypes(types[0], tv.typ); err != nil {
+					return nil, err
+				}
+				values[i] = tv
+			}
+			return func(args []typedValue) bool {
+				if args[0].value == nil {
+					return false
+				}
+				for i, b := range bounds {
+					if !b.ok(compare(args[0].value, values[i].value)) {
+						return false
+					}
+				}
+				return true
+			}, nil
+		},
+	}
+}
+
+// prefixEnd returns the smallest string greater than all the strings which
+// begin with prefix. If there is no such string, false is returned.
+func prefixEnd(prefix string) (string, bool) {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			return prefix[:i] + string([]byte{prefix[i] + 1}), true
+		}
+	}
+	return "", false
+}
+
+// AttrInResult constrains the entity bound to v to have a value for the
+// attribute a which is one of the values bound to subVar in the results of
+// the query defined by sub.
+//
+// The subquery is uncorrelated: its variables are scoped to the subquery and
+// are distinct from those of the enclosing query, even if they share names.
+// It is evaluated once, against the same database, before each iteration of
+// the enclosing query, and its results are used for the duration of that
+// iteration.
+func (v Var) AttrInResult(a Attr, sub Clause, subVar Var) Clause {
+	return &subqueryDecl{
+		entity:    v,
+		attribute: a,
+		sub:       sub,
+		subVar:    subVar,
+	}
+}
+
+// WithinHops constrains the entity bound to target to be reachable from the
+// entity bound to v by following the attribute a, which must refer to
+// entities, at least once and at most maxHops times. Each target is bound
+// once, regardless of the number of paths which reach it, and cycles are
+// not followed indefinitely.
+func (v Var) WithinHops(a Attr, maxHops int, target Var) Clause {
+	return &hopsDecl{entity: v, attribute: a, hops: maxHops, target: target}
+}
+
+// ExactlyHops is like WithinHops but constrains the shortest path from the
+// entity bound to v to the entity bound to target to be exactly hops long.
+// In a cycle, an entity already reached in fewer hops is not matched again.
+// Zero hops constrains target to be bound to the same entity as v.
+func (v Var) ExactlyHops(a Attr, hops int, target Var) Clause {
+	return &hopsDecl{entity: v, attribute: a, hops: hops, exact: true, target: target}
+}
+
+// AtDepth constrains the entity bound to target to be exactly depth levels
+// below the entity bound to rootVar in a hierarchy in which entities refer to
+// their parent via the attribute a. The entity bound to v is constrained to
+// be the entity bound to target, which allows the clause to be written as
+// v.AtDepth(a, root, depth, v). At depth 0, the target is the root itself.
+// Because each entity has a single parent, the search for the root follows
+// the parents of the target at most depth times, so cycles are safe.
+func (v Var) AtDepth(a Attr, rootVar Var, depth int, target Var) Clause {
+	return And(
+		target.ExactlyHops(a, depth, rootVar),
+		v.AttrEqVar(Self, target),
+	)
+}
+
+// UnreachableFrom constrains the entity bound to target to not be reachable
+// from the entities bound to any of the roots by following the attribute a,
+// which must refer to entities, any number of times. The roots themselves are
+// considered reachable, so the target is never bound to one of them. It
+// complements WithinHops, without any bound on the number of hops: each path
+// is followed until it ends or reaches an entity already visited, so cycles
+// are safe. For example, in a graph in which entities refer to their owner,
+// it matches the entities not owned, directly or not, by the roots.
+func UnreachableFrom(a Attr, roots []Var, target Var) Clause {
+	return &unreachableDecl{
+		attribute: a,
+		roots:     append([]Var(nil), roots...),
+		target:    target,
+	}
+}
+
+// HasNoChildVia constrains the entity bound to v to not be the value of the
+// attribute for any entity; that is, where entities refer to their parent
+// via the attribute, the entity bound to v must have no children. For
+// example, in a tree in which nodes refer to their parent, it matches the
+// leaves. This cannot be expressed with Not, as the child would not be bound
+// by the enclosing query. The children are not enumerated: the search stops
+// at the first child found, and uses an index over the attribute if the
+// database has one.
+func (v Var) HasNoChildVia(a Attr) Clause {
+	return &noChildDecl{entity: v, attribute: a}
+}
+
+// IsMaxOf constrains the entity bound to v to satisfy within and to have the
+// maximum value for the attribute among the entities which satisfy within
+// when bound to v, given the bindings of the other variables of within by the
+// enclosing query. Entities without a value for the attribute are not
+// considered. If several entities share the maximum value, all of them
+// match. The attribute must have an ordered type.
+func (v Var) IsMaxOf(a Attr, within Clause) Clause {
+	return &extremumDecl{entity: v, attribute: a, within: within, max: true}
+}
+
+// IsMinOf is like IsMaxOf but constrains the entity bound to v to have the
+// minimum value for the attribute.
+func (v Var) IsMinOf(a Attr, within Clause) Clause {
+	return &extremumDecl{entity: v, attribute: a, within: within}
+}
+
 // Eq return a clause enforcing that the var is the value
 // provided.
 func (v Var) Eq(value interface{}) Clause {
@@ -61,6 +821,24 @@ func (v Var) In(disjuncts ...interface{}) Clause {
 	return &eqDecl{v, (anyExpr)(disjuncts)}
 }
 
+// OneOfEntities constrains v to be bound to one of the provided entities,
+// which are matched by identity. Entities which are not in the database
+// never match.
+func (v Var) OneOfEntities(entities ...interface{}) Clause {
+	return v.AttrIn(Self, entities...)
+}
+
+// IdentityInto binds id to the identity of the entity bound to v, so that the
+// identities of entities can be compared, for instance using Eq or In, or
+// across subqueries using AttrInResult. Within a database, an entity is
+// identified by the entity itself; for types with an EntityIdentity, that is
+// the first inserted entity with the given identity, such that all the
+// entities with the same identity bind id to the same value. It is syntactic
+// sugar around AttrEqVar with Self.
+func (v Var) IdentityInto(id Var) Clause {
+	return v.AttrEqVar(Self, id)
+}
+
 // Type returns a clause enforcing that the variable has one of the types
 // passed by constraining its Type to the output of passing the
 // args to Types. It is syntactic sugar around existing primitives.
@@ -90,6 +868,26 @@ func (v Var) Entities(attr Attr, entities ...Var) Clause {
 	return And(terms...)
 }
 
+// SamePropertyDifferentEntity constrains the entities bound to x and y to
+// have equal values for the attribute a while being distinct entities. It is
+// syntactic sugar around AttrEqOtherAttr and a negated Self join, and is
+// useful for symmetric relations like "two different columns with the same
+// name". Note that each such pair is found twice, once in each order.
+func SamePropertyDifferentEntity(a Attr, x, y Var) Clause {
+	return And(
+		x.AttrEqOtherAttr(a, y, a),
+		Not(x.AttrEqVar(Self, y)),
+	)
+}
+
+// SameType constrains the entities bound to a and b to be of the same type.
+// It is syntactic sugar around AttrEqOtherAttr with the Type attribute. Note
+// that a and b may be bound to the same entity; combine it with a negated
+// Self join to rule that out.
+func SameType(a, b Var) Clause {
+	return a.AttrEqOtherAttr(Type, b, Type)
+}
+
 // And constructs a clause represents a set of clauses which should
 // be taken in conjunction and exist so that go functions can be written to
 // return a single clause without needing to get involved in appending to
@@ -98,6 +896,81 @@ func And(terms ...Clause) Clause {
 	return (and)(terms)
 }
 
+// MaxFanout wraps a clause and limits the number of bindings of the entities
+// it introduces to n for any single binding of the other entities in the
+// query. If the limit is exceeded, the evaluation of the query fails with an
+// error. It is useful to surface bugs in the shape of the data which would
+// otherwise lead to silently slow queries.
+func MaxFanout(n int, c Clause) Clause {
+	return &fanoutDecl{n: n, c: c}
+}
+
+// Not constructs a clause which is satisfied when the provided clause cannot
+// be satisfied. It is evaluated once all of the variables of the enclosing
+// query are bound, and all of the variables it references must be bound by
+// the enclosing query.
+func Not(c Clause) Clause {
+	return &notDecl{c: c}
+}
+
+// AtLeast constructs a clause which is satisfied when the provided clause has
+// at least n solutions. Like Not, it is evaluated once all of the variables of
+// the enclosing query are bound. The variables of the clause which are bound
+// by the enclosing query correlate it with each result; the others are local
+// to the clause. Evaluation stops as soon as n solutions are found.
+func AtLeast(n int, c Clause) Clause {
+	return &countDecl{n: n, atLeast: true, c: c}
+}
+
+// AtMost is like AtLeast, but is satisfied when the provided clause has at
+// most n solutions. Evaluation stops as soon as n+1 solutions are found.
+func AtMost(n int, c Clause) Clause {
+	return &countDecl{n: n, c: c}
+}
+
+// AntiJoin constructs a clause which is satisfied when no entity bound to b
+// satisfies the clause returned by on for the entity bound to a. It is like
+// AtMost(0, on(a, b)), except that b is always local to the clause: it is
+// renamed so that it's never correlated with, nor visible to, the enclosing
+// query, even if the query uses a variable of the same name. The local
+// variable is named "anti-join:<b>", and is renamed again while building the
+// query if the enclosing query binds that name too, as happens when
+// anti-joins over the same variable are nested; see Var. Other variables of
+// the clause returned by on which are bound by the enclosing query correlate
+// it with each result. Unlike with AtMost, a must be bound by the enclosing
+// query.
+func AntiJoin(a, b Var, on func(a, b Var) Clause) Clause {
+	local := Var("anti-join:" + string(b))
+	return &antiJoinDecl{entity: a, local: local, c: on(a, local)}
+}
+
+// Or constructs a disjunction of clauses. At time of writing, disjunctions
+// are only supported directly beneath Not, where Not(Or(a, b)) is rewritten
+// as And(Not(a), Not(b)).
+func Or(terms ...Clause) Clause {
+	return (or)(terms)
+}
+
+// TaggedClause is a branch of UnionQueries: a clause and the tag identifying
+// the results it produces.
+type TaggedClause struct {
+	Tag    string
+	Clause Clause
+}
+
+// UnionQueries constructs a clause whose results are the union of the results
+// of each of the branches, each taken in conjunction with the other clauses of
+// the query. The results of each branch are produced separately, so a binding
+// satisfying several branches is produced once for each of them, and are
+// tagged with the branch which produced them; see Result.Branch. The branches
+// may differ in shape, but the variables they share with the rest of the
+// query are the outputs common to all of them. The tags must be distinct.
+// UnionQueries is only supported at the top level of a query, and at most
+// once per query.
+func UnionQueries(branches ...TaggedClause) Clause {
+	return unionDecl(branches)
+}
+
 // Filter is used to construct a clause which runs an arbitrary predicate
 // over variables.
 func Filter(name string, vars ...Var) func(predicateFunc interface{}) Clause {
@@ -109,3 +982,16 @@ func Filter(name string, vars ...Var) func(predicateFunc interface{}) Clause {
 		}
 	}
 }
+
+// FilterSlice is like Filter, except that the predicate is passed the values
+// bound to the variables as a slice, in the order of the variables. It is
+// suited to predicates over a number of variables which is only known at
+// runtime, and doesn't need to reflect on the predicate to call it.
+func FilterSlice(name string, vars []Var, pred func([]interface{}) bool) Clause {
+	return &filterDecl{
+		name:          name,
+		vars:          append([]Var(nil), vars...),
+		predicateFunc: pred,
+		slice:         true,
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2021 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package rel
+
+import "github.com/cockroachdb/errors"
+
+// QueryBuilder is used to construct a Query one clause at a time. It is
+// intended for queries which are generated programmatically. Each clause is
+// validated as it is added; rather than panicking, errors are accumulated
+// and returned from Build.
+type QueryBuilder struct {
+	sc      *Schema
+	clauses Clauses
+	err     error
+}
+
+// NewQueryBuilder constructs a QueryBuilder for the schema.
+func NewQueryBuilder(sc *Schema) *QueryBuilder {
+	return &QueryBuilder{sc: sc}
+}
+
+// AttrEq adds a clause constructed by Var.AttrEq.
+func (b *QueryBuilder) AttrEq(v Var, a Attr, value interface{}) *QueryBuilder {
+	return b.add(func() Clause { return v.AttrEq(a, value) })
+}
+
+// AttrIn adds a clause constructed by Var.AttrIn.
+func (b *QueryBuilder) AttrIn(v Var, a Attr, values ...interface{}) *QueryBuilder {
+	return b.add(func() Clause { return v.AttrIn(a, values...) })
+}
+
+// AttrEqVar adds a clause constructed by Var.AttrEqVar.
+func (b *QueryBuilder) AttrEqVar(v Var, a Attr, value Var) *QueryBuilder {
+	return b.add(func() Clause { return v.AttrEqVar(a, value) })
+}
+
+// Eq adds a clause constructed by Var.Eq.
+func (b *QueryBuilder) Eq(v Var, value interface{}) *QueryBuilder {
+	return b.add(func() Clause { return v.Eq(value) })
+}
+
+// In adds a clause constructed by Var.In.
+func (b *QueryBuilder) In(v Var, disjuncts ...interface{}) *QueryBuilder {
+	return b.add(func() Clause { return v.In(disjuncts...) })
+}
+
+// Type adds a clause constructed by Var.Type.
+func (b *QueryBuilder) Type(
+	v Var, valueForTypeOf interface{}, moreValuesForTypeOf ...interface{},
+) *QueryBuilder {
+	return b.add(func() Clause { return v.Type(valueForTypeOf, moreValuesForTypeOf...) })
+}
+
+// Filter adds a clause constructed by Filter.
+func (b *QueryBuilder) Filter(name string, vars []Var, predicateFunc interface{}) *QueryBuilder {
+	return b.add(func() Clause { return Filter(name, vars...)(predicateFunc) })
+}
+
+// FilterSlice adds a clause constructed by FilterSlice.
+func (b *QueryBuilder) FilterSlice(
+	name string, vars []Var, pred func([]interface{}) bool,
+) *QueryBuilder {
+	return b.add(func() Clause { return FilterSlice(name, vars, pred) })
+}
+
+// Clause adds an already constructed clause.
+func (b *QueryBuilder) Clause(c Clause) *QueryBuilder {
+	return b.add(func() Clause { return c })
+}
+
+// Build constructs the query from the clauses which have been added. If any
+// of the clauses were invalid, the accumulated errors are returned.
+func (b *QueryBuilder) Build() (*Query, error) {
+	if b.err != nil {
+		return nil, errors.Wrap(b.err, "failed to construct query")
+	}
+	return NewQuery(b.sc, b.clauses...)
+}
+
+// add constructs the clause and validates it in isolation. Invalid clauses
+// are not retained and their errors are accumulated.
+func (b *QueryBuilder) add(f func() Clause) *QueryBuilder {
+	if c, err := b.validate(f); err != nil {
+		b.err = errors.CombineErrors(b.err, err)
+	} else {
+		b.clauses = append(b.clauses, c)
+	}
+	return b
+}
+
+func (b *QueryBuilder) validate(f func() Clause) (c Clause, err error) {
+	defer func() {
+		switch r := recover().(type) {
+		case nil:
+			return
+		case error:
+			err = r
+		default:
+			err = errors.AssertionFailedf("%v", r)
+		}
+	}()
+	c = f()
+	p := &queryBuilder{
+		sc:            b.sc,
+		variableSlots: map[Var]slotIdx{},
+	}
+	for _, t := range flattened(Clauses{c}) {
+		p.processClause(t)
+	}
+	return c, nil
+}
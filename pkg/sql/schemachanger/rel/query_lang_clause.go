@@ -10,6 +10,8 @@
 
 package rel
 
+import "reflect"
+
 // tripleDecl is the primary syntactic element of the query language.
 // The content indicates that the entity to be bound has an attribute
 // value which conforms to the specified value.
@@ -51,17 +53,163 @@ type and []Clause
 
 func (a and) clause() {}
 
+// attrEqAttrDecl declares that the values of attributes of two entities are
+// equal. It is like a pair of tripleDecls which share an anonymous variable.
+type attrEqAttrDecl struct {
+	entity         Var
+	attribute      Attr
+	other          Var
+	otherAttribute Attr
+}
+
+func (a *attrEqAttrDecl) clause() {}
+
 // filterDecl exposes user-defined predicates to the query language. The
 // predicateFunc should be a function value which takes arguments
 // corresponding to vars which returns a boolean value. Note that the types
 // of the variables will be enforced at runtime; if the values bound to the
 // specified vars do not conform the types of the function inputs, the
 // predicate is determined to have failed. This is in contrast to returning
-// an error.
+// an error. If slice is set, the predicateFunc is a func([]interface{}) bool
+// which is passed the values of all of the vars, whatever their types.
 type filterDecl struct {
 	name          string
 	vars          []Var
 	predicateFunc interface{}
+	slice         bool
 }
 
 func (f filterDecl) clause() {}
+
+// attrRef refers to the value of an attribute of the entity bound to a
+// variable. A reference to the Self attribute refers to the value bound to
+// the variable itself, which need not be an entity.
+type attrRef struct {
+	v Var
+	a Attr
+}
+
+// predicateDecl constrains the values of attributes using an internal
+// predicate which is evaluated once the entities it refers to are bound.
+// Unlike a tripleDecl, the referenced attributes need not be populated; absent
+// values are passed to the predicate which decides what they mean.
+type predicateDecl struct {
+	// op and rhs are used for formatting. The first operand is formatted
+	// on the left-hand side of op. If rhs is nil, op is formatted as a
+	// postfix operator.
+	op  string
+	rhs interface{}
+
+	operands []attrRef
+
+	// newPredicate is called while building the query with the types of the
+	// operands. It returns an error if the types are not acceptable.
+	newPredicate func(types []reflect.Type) (predicateFunc, error)
+}
+
+func (p *predicateDecl) clause() {}
+
+// subqueryDecl declares that the value of an attribute of the entity bound
+// to a variable must be one of the values bound to subVar in the results of
+// an independent query over the same database.
+type subqueryDecl struct {
+	entity    Var
+	attribute Attr
+	sub       Clause
+	subVar    Var
+}
+
+func (s *subqueryDecl) clause() {}
+
+// hopsDecl constrains the target to be reachable from the entity by
+// following the attribute up to hops times, or exactly hops times if exact
+// is set.
+type hopsDecl struct {
+	entity    Var
+	attribute Attr
+	hops      int
+	exact     bool
+	target    Var
+}
+
+func (h *hopsDecl) clause() {}
+
+// noChildDecl constrains the entity to not be the value of the attribute for
+// any entity.
+type noChildDecl struct {
+	entity    Var
+	attribute Attr
+}
+
+func (n *noChildDecl) clause() {}
+
+// unreachableDecl constrains the target to not be reachable from any of the
+// roots by following the attribute any number of times.
+type unreachableDecl struct {
+	attribute Attr
+	roots     []Var
+	target    Var
+}
+
+func (u *unreachableDecl) clause() {}
+
+// extremumDecl declares that the entity must satisfy within and have the
+// maximum (or minimum) value for the attribute among the entities which do.
+type extremumDecl struct {
+	entity    Var
+	attribute Attr
+	within    Clause
+	max       bool
+}
+
+func (x *extremumDecl) clause() {}
+
+// fanoutDecl wraps clauses which introduce entities and limits the number of
+// bindings of those entities for any binding of the other entities.
+type fanoutDecl struct {
+	n int
+	c Clause
+}
+
+func (f *fanoutDecl) clause() {}
+
+// notDecl declares that the clause must not be satisfiable given the
+// bindings of the enclosing query.
+type notDecl struct {
+	c Clause
+}
+
+func (n *notDecl) clause() {}
+
+// countDecl declares that the number of solutions of the clause given the
+// bindings of the enclosing query must be at least (or at most) n.
+type countDecl struct {
+	n       int
+	atLeast bool
+	c       Clause
+}
+
+func (c *countDecl) clause() {}
+
+// antiJoinDecl declares that the clause, in which the local variable is
+// never bound by the enclosing query, must have no solutions given the
+// binding of the entity.
+type antiJoinDecl struct {
+	entity, local Var
+	c             Clause
+}
+
+func (a *antiJoinDecl) clause() {}
+
+// unionDecl declares that the results of the query are the union of the
+// results of each branch, tagged with the branch which produced them. It is
+// only supported at the top level of a query.
+type unionDecl []TaggedClause
+
+func (u unionDecl) clause() {}
+
+// or is a disjunction of clauses. It is only supported directly beneath a
+// notDecl, where it is rewritten into a conjunction of negations.
+type or Clauses
+
+func (o or) clause() {}
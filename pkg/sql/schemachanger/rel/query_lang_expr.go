@@ -37,3 +37,9 @@ func (v valueExpr) expr() {}
 type anyExpr []interface{}
 
 func (a anyExpr) expr() {}
+
+// boolExpr is a bool value which takes on the type of the attribute to which
+// it is compared.
+type boolExpr bool
+
+func (b boolExpr) expr() {}
@@ -0,0 +1,182 @@
+// Copyright 2021 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package rel
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/cockroachdb/errors"
+)
+
+// Rule is a named, parameterized clause which can be applied to different
+// variables to compose queries from a library of rules.
+type Rule struct {
+	name   string
+	params []Var
+	clause Clause
+	// applications is the number of times the rule has been applied. It is
+	// used to give the local variables of each application distinct names.
+	applications uint64
+}
+
+// DefineRule defines a rule with the given parameters. The rule's clause is
+// constructed once, by calling build with the parameters. The other variables
+// of the clause are local to the rule: each application of the rule renames
+// them, so that they don't collide with the variables of the enclosing query
+// or of other applications. The local variables of the n-th application are
+// renamed to "<name>:<n>:<var>"; see Var. The parameters must be distinct.
+func DefineRule(name string, params []Var, build func(params ...Var) Clause) *Rule {
+	seen := make(map[Var]struct{}, len(params))
+	for _, p := range params {
+		if _, exists := seen[p]; exists {
+			panic(errors.Errorf("rule %s: duplicate parameter %s", name, p))
+		}
+		seen[p] = struct{}{}
+	}
+	return &Rule{
+		name:   name,
+		params: append([]Var(nil), params...),
+		clause: build(params...),
+	}
+}
+
+// Name returns the name of the rule.
+func (r *Rule) Name() string { return r.name }
+
+// Apply returns the rule's clause with each parameter replaced by the
+// corresponding argument, and the local variables renamed. The number of
+// arguments must match the number of parameters.
+func (r *Rule) Apply(args ...Var) Clause {
+	if len(args) != len(r.params) {
+		panic(errors.Errorf(
+			"rule %s: expected %d argument(s), got %d", r.name, len(r.params), len(args),
+		))
+	}
+	n := atomic.AddUint64(&r.applications, 1)
+	subst := make(map[Var]Var, len(r.params))
+	for i, p := range r.params {
+		subst[p] = args[i]
+	}
+	return mapVars(r.clause, func(v Var) Var {
+		if arg, ok := subst[v]; ok {
+			return arg
+		}
+		return Var(fmt.Sprintf("%s:%d:%s", r.name, n, v))
+	})
+}
+
+// mapVars returns a copy of the clause in which each variable is replaced by
+// the result of calling f with it.
+func mapVars(c Clause, f func(Var) Var) Clause {
+	vars := func(vs []Var) []Var {
+		ret := make([]Var, len(vs))
+		for i, v := range vs {
+			ret[i] = f(v)
+		}
+		return ret
+	}
+	ref := func(r attrRef) attrRef {
+		return attrRef{v: f(r.v), a: r.a}
+	}
+	mapExpr := func(e expr) expr {
+		if v, ok := e.(Var); ok {
+			return f(v)
+		}
+		return e
+	}
+	clauses := func(cs []Clause) []Clause {
+		ret := make([]Clause, len(cs))
+		for i, c := range cs {
+			ret[i] = mapVars(c, f)
+		}
+		return ret
+	}
+	switch t := c.(type) {
+	case *tripleDecl:
+		return &tripleDecl{entity: f(t.entity), attribute: t.attribute, value: mapExpr(t.value)}
+	case *eqDecl:
+		return &eqDecl{v: f(t.v), expr: mapExpr(t.expr)}
+	case *attrEqAttrDecl:
+		cp := *t
+		cp.entity, cp.other = f(t.entity), f(t.other)
+		return &cp
+	case *filterDecl:
+		cp := *t
+		cp.vars = vars(t.vars)
+		return &cp
+	case *predicateDecl:
+		cp := *t
+		cp.operands = make([]attrRef, len(t.operands))
+		for i, r := range t.operands {
+			cp.operands[i] = ref(r)
+		}
+		switch rhs := t.rhs.(type) {
+		case attrRef:
+			cp.rhs = ref(rhs)
+		case []attrRef:
+			refs := make([]attrRef, len(rhs))
+			for i, r := range rhs {
+				refs[i] = ref(r)
+			}
+			cp.rhs = refs
+		}
+		return &cp
+	case *subqueryDecl:
+		cp := *t
+		cp.entity, cp.subVar, cp.sub = f(t.entity), f(t.subVar), mapVars(t.sub, f)
+		return &cp
+	case *hopsDecl:
+		cp := *t
+		cp.entity, cp.target = f(t.entity), f(t.target)
+		return &cp
+	case *noChildDecl:
+		cp := *t
+		cp.entity = f(t.entity)
+		return &cp
+	case *unreachableDecl:
+		cp := *t
+		cp.target = f(t.target)
+		cp.roots = make([]Var, len(t.roots))
+		for i, r := range t.roots {
+			cp.roots[i] = f(r)
+		}
+		return &cp
+	case *extremumDecl:
+		cp := *t
+		cp.entity, cp.within = f(t.entity), mapVars(t.within, f)
+		return &cp
+	case *fanoutDecl:
+		cp := *t
+		cp.c = mapVars(t.c, f)
+		return &cp
+	case *notDecl:
+		return &notDecl{c: mapVars(t.c, f)}
+	case *countDecl:
+		cp := *t
+		cp.c = mapVars(t.c, f)
+		return &cp
+	case *antiJoinDecl:
+		return &antiJoinDecl{entity: f(t.entity), local: f(t.local), c: mapVars(t.c, f)}
+	case unionDecl:
+		ret := make(unionDecl, len(t))
+		for i, b := range t {
+			ret[i] = TaggedClause{Tag: b.Tag, Clause: mapVars(b.Clause, f)}
+		}
+		return ret
+	case and:
+		return and(clauses(t))
+	case or:
+		return or(clauses(t))
+	default:
+		panic(errors.AssertionFailedf("unknown clause type %T", c))
+	}
+}
@@ -15,6 +15,7 @@ import (
 	"reflect"
 	"strings"
 
+	"github.com/cockroachdb/errors"
 	"gopkg.in/yaml.v3"
 )
 
@@ -39,6 +40,10 @@ func (a anyExpr) encoded() interface{} {
 	return ret
 }
 
+func (b boolExpr) encoded() interface{} {
+	return bool(b)
+}
+
 func (v Var) encoded() interface{} {
 	return "$" + string(v)
 }
@@ -64,6 +69,177 @@ func (f *tripleDecl) MarshalYAML() (interface{}, error) {
 	return clauseStr(fmt.Sprintf("$%s[%s]", f.entity, f.attribute), f.value)
 }
 
+func (a *attrEqAttrDecl) MarshalYAML() (interface{}, error) {
+	return fmt.Sprintf(
+		"$%s[%s] = $%s[%s]", a.entity, a.attribute, a.other, a.otherAttribute,
+	), nil
+}
+
+func (r attrRef) String() string {
+	return fmt.Sprintf("$%s[%s]", r.v, r.a)
+}
+
+func (p *predicateDecl) MarshalYAML() (interface{}, error) {
+	var rhs string
+	switch v := p.rhs.(type) {
+	case nil:
+		return fmt.Sprintf("%s %s", p.operands[0], p.op), nil
+	case attrRef:
+		rhs = v.String()
+	case []attrRef:
+		strs := make([]string, len(v))
+		for i := range v {
+			strs[i] = v[i].String()
+		}
+		rhs = "[" + strings.Join(strs, ", ") + "]"
+	case expr:
+		var err error
+		if rhs, err = exprToString(v); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.AssertionFailedf("unknown predicate rhs type %T", v)
+	}
+	var lhs string
+	if _, isExpr := p.rhs.(expr); isExpr && len(p.operands) != 1 {
+		// The operands are all compared to the expression.
+		strs := make([]string, len(p.operands))
+		for i := range p.operands {
+			strs[i] = p.operands[i].String()
+		}
+		lhs = "[" + strings.Join(strs, ", ") + "]"
+	} else {
+		lhs = p.operands[0].String()
+	}
+	return fmt.Sprintf("%s %s %s", lhs, p.op, rhs), nil
+}
+
+func (h *hopsDecl) MarshalYAML() (interface{}, error) {
+	op := "withinHops"
+	if h.exact {
+		op = "exactlyHops"
+	}
+	return fmt.Sprintf(
+		"$%s[%s] %s(%d) $%s", h.entity, h.attribute, op, h.hops, h.target,
+	), nil
+}
+
+func (n *noChildDecl) MarshalYAML() (interface{}, error) {
+	return fmt.Sprintf("$%s hasNoChildVia(%s)", n.entity, n.attribute), nil
+}
+
+func (u *unreachableDecl) MarshalYAML() (interface{}, error) {
+	roots := make([]string, len(u.roots))
+	for i, r := range u.roots {
+		roots[i] = "$" + string(r)
+	}
+	return fmt.Sprintf(
+		"$%s unreachableFrom(%s)[%s]", u.target, strings.Join(roots, ", "), u.attribute,
+	), nil
+}
+
+func (s *subqueryDecl) MarshalYAML() (interface{}, error) {
+	sub, err := Clauses{s.sub}.encoded()
+	if err != nil {
+		return nil, err
+	}
+	lhs := fmt.Sprintf("$%s[%s] IN subquery($%s)", s.entity, s.attribute, s.subVar)
+	return map[string]interface{}{lhs: sub}, nil
+}
+
+func (x *extremumDecl) MarshalYAML() (interface{}, error) {
+	within, err := Clauses{x.within}.encoded()
+	if err != nil {
+		return nil, err
+	}
+	op := "isMinOf"
+	if x.max {
+		op = "isMaxOf"
+	}
+	lhs := fmt.Sprintf("$%s[%s] %s", x.entity, x.attribute, op)
+	return map[string]interface{}{lhs: within}, nil
+}
+
+func (f *fanoutDecl) MarshalYAML() (interface{}, error) {
+	c, err := Clauses{f.c}.encoded()
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{fmt.Sprintf("maxFanout(%d)", f.n): c}, nil
+}
+
+func (n *notDecl) MarshalYAML() (interface{}, error) {
+	c, err := Clauses{n.c}.encoded()
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"not": c}, nil
+}
+
+func (c *countDecl) MarshalYAML() (interface{}, error) {
+	sub, err := Clauses{c.c}.encoded()
+	if err != nil {
+		return nil, err
+	}
+	op := "atMost"
+	if c.atLeast {
+		op = "atLeast"
+	}
+	return map[string]interface{}{fmt.Sprintf("%s(%d)", op, c.n): sub}, nil
+}
+
+func (a *antiJoinDecl) MarshalYAML() (interface{}, error) {
+	c, err := Clauses{a.c}.encoded()
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{fmt.Sprintf("antiJoin($%s, $%s)", a.entity, a.local): c}, nil
+}
+
+func (u unionDecl) MarshalYAML() (interface{}, error) {
+	branches := make([]interface{}, len(u))
+	for i, b := range u {
+		c, err := Clauses{b.Clause}.encoded()
+		if err != nil {
+			return nil, err
+		}
+		branches[i] = map[string]interface{}{b.Tag: c}
+	}
+	return map[string]interface{}{"union": branches}, nil
+}
+
+func (o or) MarshalYAML() (interface{}, error) {
+	terms := make([]interface{}, len(o))
+	for i, t := range o {
+		var err error
+		if terms[i], err = (Clauses{t}).encoded(); err != nil {
+			return nil, err
+		}
+	}
+	return map[string]interface{}{"or": terms}, nil
+}
+
+// encoded returns the yaml representation of each of the flattened clauses.
+// Unlike MarshalYAML, the result is not a yaml node, so it can be nested in
+// the representation of another clause.
+func (c Clauses) encoded() ([]interface{}, error) {
+	fc := flattened(c)
+	ret := make([]interface{}, len(fc))
+	for i, cl := range fc {
+		m, ok := cl.(interface {
+			MarshalYAML() (interface{}, error)
+		})
+		if !ok {
+			return nil, errors.AssertionFailedf("cannot encode clause %T", cl)
+		}
+		var err error
+		if ret[i], err = m.MarshalYAML(); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
 func clauseStr(lhs string, rhs expr) (string, error) {
 	rhsStr, err := exprToString(rhs)
 	if err != nil {
@@ -80,12 +256,14 @@ func (f filterDecl) MarshalYAML() (interface{}, error) {
 	var buf strings.Builder
 	buf.WriteString(f.name)
 	buf.WriteString("(")
-	ft := reflect.TypeOf(f.predicateFunc)
-	for i := 0; i < ft.NumIn(); i++ {
-		if i > 0 {
-			buf.WriteString(", ")
+	// The function has not been validated, so do not assume its type.
+	if ft := reflect.TypeOf(f.predicateFunc); ft != nil && ft.Kind() == reflect.Func {
+		for i := 0; i < ft.NumIn(); i++ {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			buf.WriteString(ft.In(i).String())
 		}
-		buf.WriteString(ft.In(i).String())
 	}
 	buf.WriteString(")(")
 	for i, v := range f.vars {
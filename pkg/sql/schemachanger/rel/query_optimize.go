@@ -0,0 +1,88 @@
+// Copyright 2021 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package rel
+
+import (
+	"sort"
+
+	"github.com/cockroachdb/errors"
+)
+
+// Optimize returns a query equivalent to q in which the clauses are
+// reordered such that the entities are joined in increasing order of the
+// estimated number of entities which may be bound to them. The estimate for
+// an entity is the smallest number of entities in the database having the
+// value to which one of its attributes is constrained, according to the
+// statistics. Entities with the same estimate retain their relative order.
+//
+// The optimized query produces the same results as q, though not
+// necessarily in the same order unless they are sorted; see Sort. Queries
+// with a UnionQueries clause cannot be optimized.
+func (q *Query) Optimize(stats *DatabaseStats) (*Query, error) {
+	if len(q.branches) > 0 {
+		return nil, errors.Errorf("cannot optimize a query with a UnionQueries clause")
+	}
+	if stats.schema != q.schema {
+		return nil, errors.Errorf(
+			"statistics of schema %s cannot be used for a query of schema %s",
+			stats.schema.name, q.schema.name,
+		)
+	}
+	entities := q.Entities()
+	estimates := make(map[Var]int, len(entities))
+	for _, v := range entities {
+		estimates[v] = stats.estimate(q, q.variableSlots[v])
+	}
+	sort.SliceStable(entities, func(i, j int) bool {
+		return estimates[entities[i]] < estimates[entities[j]]
+	})
+	rank := make(map[Var]int, len(entities))
+	for i, v := range entities {
+		rank[v] = i
+	}
+
+	// The join order is the order in which the entity variables first appear
+	// in the clauses, so order the clauses by the ranks of the entities they
+	// refer to. Clauses referring to a single entity precede those also
+	// referring to entities joined later, so that the latter don't introduce
+	// those entities early. Clauses which refer to no entity go last.
+	type rankedClause struct {
+		c        Clause
+		min, max int
+	}
+	clauses := make([]rankedClause, len(q.clauses))
+	for i, c := range q.clauses {
+		rc := rankedClause{c: c, min: len(entities), max: -1}
+		mapVars(c, func(v Var) Var {
+			if r, ok := rank[v]; ok {
+				if r < rc.min {
+					rc.min = r
+				}
+				if r > rc.max {
+					rc.max = r
+				}
+			}
+			return v
+		})
+		clauses[i] = rc
+	}
+	sort.SliceStable(clauses, func(i, j int) bool {
+		if clauses[i].min != clauses[j].min {
+			return clauses[i].min < clauses[j].min
+		}
+		return clauses[i].max < clauses[j].max
+	})
+	reordered := make(Clauses, len(clauses))
+	for i, rc := range clauses {
+		reordered[i] = rc.c
+	}
+	return NewQuery(q.schema, reordered...)
+}
@@ -0,0 +1,79 @@
+// Copyright 2021 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package rel
+
+import (
+	"github.com/cockroachdb/cockroach/pkg/util/iterutil"
+	"github.com/cockroachdb/errors"
+)
+
+// QueryRegistry is a collection of queries keyed by name. It is intended to
+// be populated during initialization, and it is not safe to register queries
+// concurrently with other operations.
+type QueryRegistry struct {
+	names   []string
+	queries map[string]*Query
+}
+
+// NewQueryRegistry constructs a new QueryRegistry.
+func NewQueryRegistry() *QueryRegistry {
+	return &QueryRegistry{queries: make(map[string]*Query)}
+}
+
+// Register adds the query under the provided name. An error is returned if
+// a query is already registered with that name.
+func (r *QueryRegistry) Register(name string, q *Query) error {
+	if _, exists := r.queries[name]; exists {
+		return errors.Errorf("query %q already registered", name)
+	}
+	r.names = append(r.names, name)
+	r.queries[name] = q
+	return nil
+}
+
+// MustRegister is like Register but panics on error. It returns the query
+// for convenient use in var blocks.
+func (r *QueryRegistry) MustRegister(name string, q *Query) *Query {
+	if err := r.Register(name, q); err != nil {
+		panic(err)
+	}
+	return q
+}
+
+// Get returns the query registered with the provided name.
+func (r *QueryRegistry) Get(name string) (*Query, error) {
+	q, ok := r.queries[name]
+	if !ok {
+		return nil, errors.Errorf("no query registered with name %q", name)
+	}
+	return q, nil
+}
+
+// Names returns the names of the registered queries in the order in which
+// they were registered.
+func (r *QueryRegistry) Names() []string {
+	return append([]string(nil), r.names...)
+}
+
+// ForEach calls fn for each of the registered queries in the order in which
+// they were registered. Iteration can be halted with the use of
+// iterutil.StopIteration.
+func (r *QueryRegistry) ForEach(fn func(name string, q *Query) error) error {
+	for _, name := range r.names {
+		if err := fn(name, r.queries[name]); err != nil {
+			if iterutil.Done(err) {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
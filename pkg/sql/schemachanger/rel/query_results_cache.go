@@ -0,0 +1,103 @@
+// Copyright 2021 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package rel
+
+import (
+	"github.com/cockroachdb/cockroach/pkg/util/cache"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/errors"
+)
+
+// ResultsCache caches the results of evaluating queries against databases.
+// Results are keyed on the fingerprints of the database and of the query, so
+// a cached result is returned only if the database has not been modified
+// since it was computed. Entries computed for a database which has since been
+// modified are never returned again, and are eventually evicted.
+//
+// The cache holds at most the configured number of entries, evicting the
+// least recently used entry first. Each entry retains all of the results of a
+// query, so the memory used by the cache is bounded by the number of entries
+// times the size of the largest result set. Entries also retain the databases
+// against which they were computed.
+//
+// It is safe to use a ResultsCache concurrently.
+type ResultsCache struct {
+	mu struct {
+		syncutil.Mutex
+		entries *cache.UnorderedCache
+	}
+}
+
+type resultsCacheKey struct {
+	db DatabaseFingerprint
+	q  QueryFingerprint
+}
+
+// NewResultsCache constructs a ResultsCache holding at most maxEntries
+// entries.
+func NewResultsCache(maxEntries int) *ResultsCache {
+	c := &ResultsCache{}
+	c.mu.entries = cache.NewUnorderedCache(cache.Config{
+		Policy: cache.CacheLRU,
+		ShouldEvict: func(size int, _, _ interface{}) bool {
+			return size > maxEntries
+		},
+	})
+	return c
+}
+
+// Evaluate returns the results of evaluating the query against the database,
+// computing and caching them if they are not already cached. Like
+// EvaluateAll, the returned results remain valid after evaluation. The
+// returned slice may be modified by the caller but the results must not be.
+//
+// The database must not be modified concurrently with Evaluate.
+func (c *ResultsCache) Evaluate(db *Database, q *Query) ([]Result, error) {
+	if db.schema != q.schema {
+		return nil, errors.Errorf(
+			"query and database are not from the same schema: %s != %s",
+			db.schema.name, q.schema.name,
+		)
+	}
+	k := resultsCacheKey{db: db.Fingerprint(), q: q.Fingerprint()}
+	if results, ok := c.get(k); ok {
+		return append([]Result(nil), results...), nil
+	}
+	results, err := EvaluateAll(db, []*Query{q})
+	if err != nil {
+		return nil, err
+	}
+	c.add(k, results[0])
+	return append([]Result(nil), results[0]...), nil
+}
+
+// Len returns the number of entries in the cache.
+func (c *ResultsCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.mu.entries.Len()
+}
+
+func (c *ResultsCache) get(k resultsCacheKey) ([]Result, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.mu.entries.Get(k)
+	if !ok {
+		return nil, false
+	}
+	return v.([]Result), true
+}
+
+func (c *ResultsCache) add(k resultsCacheKey, results []Result) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.mu.entries.Add(k, results)
+}
@@ -0,0 +1,159 @@
+// Copyright 2021 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package rel
+
+import (
+	"reflect"
+
+	"github.com/cockroachdb/errors"
+)
+
+// QuerySpec describes a query as data, so that it can be loaded from an
+// external format like YAML or JSON. Attributes are referred to by name, that
+// is, by their String() value in the schema.
+type QuerySpec struct {
+	// Constraints constrain the attributes of the entities bound to vars.
+	Constraints []ConstraintSpec `yaml:"constraints" json:"constraints"`
+	// Joins constrain attributes of pairs of entities to be equal.
+	Joins []JoinSpec `yaml:"joins" json:"joins"`
+}
+
+// ConstraintSpec constrains the value of an attribute of the entity bound to
+// a Var. The supported operators and the clauses they map to are:
+//
+//   - eq: AttrEq
+//   - neq: Not(AttrEq), which requires the Var to be bound by other clauses
+//   - in: AttrIn, for which the Value must be a list of values
+//   - lt: AttrLt
+//   - gt: AttrGt
+//   - contains: AttrContains
+//
+// Values decoded from external formats may not have the type of the
+// attribute, so numbers and strings are converted to the type of the
+// attribute, or of its elements for contains.
+type ConstraintSpec struct {
+	Var   Var         `yaml:"var" json:"var"`
+	Attr  string      `yaml:"attr" json:"attr"`
+	Op    string      `yaml:"op" json:"op"`
+	Value interface{} `yaml:"value" json:"value"`
+}
+
+// JoinSpec constrains the attribute Attr of the entity bound to Var to be
+// equal to the attribute OtherAttr of the entity bound to Other. It maps to
+// AttrEqOtherAttr.
+type JoinSpec struct {
+	Var       Var    `yaml:"var" json:"var"`
+	Attr      string `yaml:"attr" json:"attr"`
+	Other     Var    `yaml:"other" json:"other"`
+	OtherAttr string `yaml:"otherAttr" json:"otherAttr"`
+}
+
+// BuildFromSpec builds a query from the spec.
+func BuildFromSpec(spec QuerySpec, sc *Schema) (*Query, error) {
+	clauses := make([]Clause, 0, len(spec.Constraints)+len(spec.Joins))
+	for i, c := range spec.Constraints {
+		clause, err := c.toClause(sc)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid constraint %d", i)
+		}
+		clauses = append(clauses, clause)
+	}
+	for i, j := range spec.Joins {
+		clause, err := j.toClause(sc)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid join %d", i)
+		}
+		clauses = append(clauses, clause)
+	}
+	return NewQuery(sc, clauses...)
+}
+
+func (c ConstraintSpec) toClause(sc *Schema) (Clause, error) {
+	a, typ, err := sc.getAttrByName(c.Attr)
+	if err != nil {
+		return nil, err
+	}
+	switch c.Op {
+	case "eq":
+		return c.Var.AttrEq(a, convertSpecValue(c.Value, typ)), nil
+	case "neq":
+		return Not(c.Var.AttrEq(a, convertSpecValue(c.Value, typ))), nil
+	case "in":
+		vv := reflect.ValueOf(c.Value)
+		if vv.Kind() != reflect.Slice {
+			return nil, errors.Errorf("in requires a list of values, got %T", c.Value)
+		}
+		values := make([]interface{}, vv.Len())
+		for i := range values {
+			values[i] = convertSpecValue(vv.Index(i).Interface(), typ)
+		}
+		return c.Var.AttrIn(a, values...), nil
+	case "lt":
+		return c.Var.AttrLt(a, convertSpecValue(c.Value, typ)), nil
+	case "gt":
+		return c.Var.AttrGt(a, convertSpecValue(c.Value, typ)), nil
+	case "contains":
+		if typ.Kind() == reflect.Slice {
+			typ = typ.Elem()
+		}
+		return c.Var.AttrContains(a, convertSpecValue(c.Value, typ)), nil
+	default:
+		return nil, errors.Errorf("unknown operator %q", c.Op)
+	}
+}
+
+func (j JoinSpec) toClause(sc *Schema) (Clause, error) {
+	a, _, err := sc.getAttrByName(j.Attr)
+	if err != nil {
+		return nil, err
+	}
+	otherAttr, _, err := sc.getAttrByName(j.OtherAttr)
+	if err != nil {
+		return nil, err
+	}
+	return j.Var.AttrEqOtherAttr(a, j.Other, otherAttr), nil
+}
+
+// getAttrByName returns the attribute of the schema with the given name, and
+// its type.
+func (sc *Schema) getAttrByName(name string) (Attr, reflect.Type, error) {
+	for i, a := range sc.attrs {
+		if a.String() == name {
+			return a, sc.attrTypes[i], nil
+		}
+	}
+	return nil, nil, errors.Errorf("unknown attribute %q in schema %s", name, sc.name)
+}
+
+// convertSpecValue converts v, which is a number or a string, to typ if they
+// are of different types of the same kind: numbers are only converted to
+// numbers, and only if no precision is lost, and strings to strings. Other
+// values are returned as is, and fail to type check if need be.
+func convertSpecValue(v interface{}, typ reflect.Type) interface{} {
+	vv := reflect.ValueOf(v)
+	if !vv.IsValid() || vv.Type() == typ {
+		return v
+	}
+	isNumber := func(k reflect.Kind) bool {
+		return k >= reflect.Int && k <= reflect.Float64
+	}
+	switch vk, tk := vv.Kind(), typ.Kind(); {
+	case vk == reflect.String && tk == reflect.String:
+	case isNumber(vk) && isNumber(tk):
+		// Ensure that the conversion is lossless.
+		if back := vv.Convert(typ).Convert(vv.Type()); back.Interface() != v {
+			return v
+		}
+	default:
+		return v
+	}
+	return vv.Convert(typ).Interface()
+}
@@ -10,7 +10,12 @@
 
 package rel
 
-import "testing"
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
 
 // TestMarkers is a pedantic test to get nice code coverage by exercising the
 // marker interface implementations. It can also act as listing of expected
@@ -21,6 +26,7 @@ func TestMarkers(t *testing.T) {
 			anyExpr{},
 			Var(""),
 			valueExpr{},
+			boolExpr(false),
 		} {
 			expr.expr()
 		}
@@ -30,9 +36,93 @@ func TestMarkers(t *testing.T) {
 			&and{},
 			&tripleDecl{},
 			&eqDecl{},
+			&attrEqAttrDecl{},
 			&filterDecl{},
+			&predicateDecl{},
+			&subqueryDecl{},
+			&hopsDecl{},
+			&noChildDecl{},
+			&unreachableDecl{},
+			&fanoutDecl{},
+			&extremumDecl{},
+			&notDecl{},
+			&or{},
 		} {
 			clause.clause()
 		}
 	})
 }
+
+// TestNegatedDisjunction ensures that a negated disjunction is rewritten into
+// independent negations which each require their variables to be bound.
+func TestNegatedDisjunction(t *testing.T) {
+	type A struct{ S, T string }
+	const s, u stringAttr = "s", "u"
+	sc := MustSchema("junk",
+		EntityMapping(reflect.TypeOf((*A)(nil)),
+			EntityAttr(s, "S"),
+			EntityAttr(u, "T"),
+		),
+	)
+	var a, b Var = "a", "b"
+	q, err := NewQuery(sc,
+		Not(Or(a.AttrEq(s, "a"), a.AttrEqVar(u, b))),
+		a.AttrEqVar(s, b),
+	)
+	require.NoError(t, err)
+	require.Len(t, q.negations, 2)
+	require.Len(t, q.negations[0].inputs, 1)
+	require.Equal(t, q.variableSlots[a], q.negations[0].inputs[0].src)
+	require.Len(t, q.negations[1].inputs, 2)
+	for _, in := range q.negations[1].inputs {
+		require.Contains(t, []slotIdx{q.variableSlots[a], q.variableSlots[b]}, in.src)
+	}
+}
+
+type stringAttr string
+
+func (sa stringAttr) String() string { return string(sa) }
+
+func TestPrefixEnd(t *testing.T) {
+	for _, tc := range []struct {
+		prefix string
+		end    string
+		hasEnd bool
+	}{
+		{"", "", false},
+		{"a", "b", true},
+		{"ab", "ac", true},
+		{"a\xff", "b", true},
+		{"\xff\xff", "", false},
+	} {
+		end, hasEnd := prefixEnd(tc.prefix)
+		require.Equal(t, tc.end, end, "%q", tc.prefix)
+		require.Equal(t, tc.hasEnd, hasEnd, "%q", tc.prefix)
+	}
+}
+
+// TestPredicateBoundAt ensures that predicates are checked as soon as all the
+// entities to which they refer are bound.
+func TestPredicateBoundAt(t *testing.T) {
+	type A struct{ I int }
+	const i stringAttr = "i"
+	sc := MustSchema("junk",
+		EntityMapping(reflect.TypeOf((*A)(nil)), EntityAttr(i, "I")),
+	)
+	var a, b, c Var = "a", "b", "c"
+	q, err := NewQuery(sc,
+		a.Type((*A)(nil)),
+		b.Type((*A)(nil)),
+		c.Type((*A)(nil)),
+		c.AttrLtVar(i, a, i),
+		a.AttrLt(i, 3),
+		a.AttrLtVar(i, b, i),
+	)
+	require.NoError(t, err)
+	require.Equal(t, []slotIdx{q.variableSlots[a], q.variableSlots[b], q.variableSlots[c]}, q.entities)
+	var boundAt []int
+	for _, p := range q.predicates {
+		boundAt = append(boundAt, p.boundAt)
+	}
+	require.Equal(t, []int{2, 0, 1}, boundAt)
+}
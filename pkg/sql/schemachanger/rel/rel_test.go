@@ -13,14 +13,22 @@ package rel_test
 import (
 	"fmt"
 	"reflect"
+	"regexp"
+	"sort"
 	"testing"
 
 	"github.com/cockroachdb/cockroach/pkg/sql/schemachanger/rel"
+	"github.com/cockroachdb/cockroach/pkg/sql/schemachanger/rel/internal/catalogtest"
 	"github.com/cockroachdb/cockroach/pkg/sql/schemachanger/rel/internal/comparetest"
 	"github.com/cockroachdb/cockroach/pkg/sql/schemachanger/rel/internal/cyclegraphtest"
 	"github.com/cockroachdb/cockroach/pkg/sql/schemachanger/rel/internal/entitynodetest"
+	"github.com/cockroachdb/cockroach/pkg/sql/schemachanger/rel/internal/itemtest"
+	"github.com/cockroachdb/cockroach/pkg/sql/schemachanger/rel/internal/treetest"
 	"github.com/cockroachdb/cockroach/pkg/sql/schemachanger/rel/reltest"
+	"github.com/cockroachdb/cockroach/pkg/util/iterutil"
+	"github.com/cockroachdb/errors"
 	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
 )
 
 func TestRel(t *testing.T) {
@@ -28,6 +36,9 @@ func TestRel(t *testing.T) {
 		entitynodetest.Suite,
 		cyclegraphtest.Suite,
 		comparetest.Suite,
+		catalogtest.Suite,
+		itemtest.Suite,
+		treetest.Suite,
 	} {
 		t.Run(s.Name, func(t *testing.T) {
 			s.Run(t)
@@ -173,6 +184,1642 @@ func TestInvalidData(t *testing.T) {
 	})
 }
 
+func TestResultAttributes(t *testing.T) {
+	root := &treetest.Node{Name: "root"}
+	a := &treetest.Node{Name: "a", Parent: root, Kind: "leaf"}
+	db := newDatabase(t, treetest.Schema, nil /* indexes */, root, a)
+
+	var n, nameVar rel.Var = "n", "name"
+	q, err := rel.NewQuery(treetest.Schema,
+		n.Type((*treetest.Node)(nil)),
+		n.AttrEqVar(treetest.Name, nameVar),
+	)
+	require.NoError(t, err)
+	got := map[interface{}][]rel.Attr{}
+	require.NoError(t, q.Iterate(db, func(r rel.Result) error {
+		attrs, err := r.Attributes(n)
+		require.NoError(t, err)
+		got[r.Var(n)] = attrs
+
+		v, ok := r.Attr(n, treetest.Name)
+		require.True(t, ok)
+		require.Equal(t, r.Var(nameVar), v)
+		// The value of an attribute which refers to an entity is the entity.
+		v, ok = r.Attr(n, treetest.Parent)
+		if r.Var(n) == a {
+			require.True(t, ok)
+			require.Equal(t, root, v)
+		} else {
+			require.False(t, ok)
+			require.Nil(t, v)
+		}
+
+		// The variable name is not bound to an entity.
+		_, err = r.Attributes(nameVar)
+		require.EqualError(t, err, "variable name is not bound to an entity")
+		_, ok = r.Attr(nameVar, treetest.Name)
+		require.False(t, ok)
+		_, err = r.Attributes("undefined")
+		require.EqualError(t, err, "unknown variable undefined")
+		return nil
+	}))
+	// Attributes which are not pointers always have a value.
+	require.Equal(t, map[interface{}][]rel.Attr{
+		root: {treetest.Name, treetest.Kind, treetest.Ordinal},
+		a:    {treetest.Name, treetest.Parent, treetest.Kind, treetest.Ordinal},
+	}, got)
+}
+
+func TestQueryBuilder(t *testing.T) {
+	const invalidClausePrefix = `failed to construct query: failed to process invalid clause `
+	t.Run("valid", func(t *testing.T) {
+		a := &itemtest.Item{Name: "a"}
+		db := newDatabase(t, itemtest.Schema, nil /* indexes */, a)
+		q, err := rel.NewQueryBuilder(itemtest.Schema).
+			Type("a", (*itemtest.Item)(nil)).
+			AttrEqVar("a", itemtest.Name, "s").
+			Filter("isA", []rel.Var{"s"}, func(s string) bool { return s == "a" }).
+			Build()
+		require.NoError(t, err)
+		var got []interface{}
+		require.NoError(t, q.Iterate(db, func(r rel.Result) error {
+			got = append(got, r.Var("a"))
+			return nil
+		}))
+		require.Equal(t, []interface{}{a}, got)
+	})
+	for _, tc := range []struct {
+		name  string
+		add   func(b *rel.QueryBuilder) *rel.QueryBuilder
+		errRE string
+	}{
+		{
+			name: "AttrEq nil value",
+			add: func(b *rel.QueryBuilder) *rel.QueryBuilder {
+				return b.AttrEq("a", itemtest.Name, nil)
+			},
+			errRE: invalidClausePrefix + `\$a\[Name\] = null: invalid nil`,
+		},
+		{
+			name: "AttrEq unknown attribute",
+			add: func(b *rel.QueryBuilder) *rel.QueryBuilder {
+				return b.AttrEq("a", stringAttr("unknown"), "a")
+			},
+			errRE: invalidClausePrefix + `\$a\[unknown\] = a: unknown attribute unknown`,
+		},
+		{
+			name: "AttrEq type mismatch",
+			add: func(b *rel.QueryBuilder) *rel.QueryBuilder {
+				return b.AttrEq("a", itemtest.Name, 1)
+			},
+			errRE: invalidClausePrefix + `\$a\[Name\] = 1: int is not string`,
+		},
+		{
+			name: "Type nil",
+			add: func(b *rel.QueryBuilder) *rel.QueryBuilder {
+				return b.Type("a", nil)
+			},
+			errRE: invalidClausePrefix + `\$a\[Type\] = null: invalid nil`,
+		},
+		{
+			name: "Filter non-function",
+			add: func(b *rel.QueryBuilder) *rel.QueryBuilder {
+				return b.Filter("notFunc", []rel.Var{"a"}, 1)
+			},
+			errRE: invalidClausePrefix + `notFunc\(\)\(\$a\): non-function int filter function for variables \[a\]`,
+		},
+		{
+			name: "Filter nil function",
+			add: func(b *rel.QueryBuilder) *rel.QueryBuilder {
+				return b.Filter("nilFunc", []rel.Var{"a"}, nil)
+			},
+			errRE: invalidClausePrefix + `nilFunc\(\)\(\$a\): nil filter function for variables \[a\]`,
+		},
+		{
+			name: "Filter wrong arity",
+			add: func(b *rel.QueryBuilder) *rel.QueryBuilder {
+				return b.Filter("noArgs", []rel.Var{"a"}, func() bool { return true })
+			},
+			errRE: `invalid func\(\) bool filter function for variables \[a\] accepts 0 inputs`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := rel.NewQueryBuilder(itemtest.Schema).Type("a", (*itemtest.Item)(nil))
+			var q *rel.Query
+			var err error
+			require.NotPanics(t, func() {
+				q, err = tc.add(b).AttrEqVar("a", itemtest.Name, "s").Build()
+			})
+			require.Nil(t, q)
+			require.Regexp(t, tc.errRE, err)
+		})
+	}
+	t.Run("errors accumulate", func(t *testing.T) {
+		_, err := rel.NewQueryBuilder(itemtest.Schema).
+			AttrEq("a", itemtest.Name, nil).
+			Type("a", nil).
+			Build()
+		require.Regexp(t, `\$a\[Name\] = null: invalid nil`, err)
+		require.Regexp(t, `\$a\[Type\] = null: invalid nil`, fmt.Sprintf("%+v", err))
+	})
+}
+
+func TestQueryRegistry(t *testing.T) {
+	var a rel.Var = "a"
+	q1, err := rel.NewQuery(itemtest.Schema, a.Type((*itemtest.Item)(nil)))
+	require.NoError(t, err)
+	q2, err := rel.NewQuery(itemtest.Schema, a.AttrEq(itemtest.Name, "foo"))
+	require.NoError(t, err)
+
+	r := rel.NewQueryRegistry()
+	require.NoError(t, r.Register("q1", q1))
+	require.Equal(t, q2, r.MustRegister("q2", q2))
+	require.EqualError(t, r.Register("q1", q2), `query "q1" already registered`)
+	require.Panics(t, func() { r.MustRegister("q2", q1) })
+
+	got, err := r.Get("q1")
+	require.NoError(t, err)
+	require.Equal(t, q1, got)
+	got, err = r.Get("q2")
+	require.NoError(t, err)
+	require.Equal(t, q2, got)
+	_, err = r.Get("q3")
+	require.EqualError(t, err, `no query registered with name "q3"`)
+
+	require.Equal(t, []string{"q1", "q2"}, r.Names())
+	var names []string
+	require.NoError(t, r.ForEach(func(name string, q *rel.Query) error {
+		names = append(names, name)
+		return iterutil.StopIteration()
+	}))
+	require.Equal(t, []string{"q1"}, names)
+}
+
+func TestEvalOptions(t *testing.T) {
+	// The items are grouped by their label, and ranked by their value.
+	parent, rank := itemtest.Label, itemtest.Value
+	db := newDatabase(t, itemtest.Schema, nil /* indexes */, []interface{}{
+		&itemtest.Item{Label: "x", Name: "a", Value: 3},
+		&itemtest.Item{Label: "x", Name: "b", Value: 1},
+		&itemtest.Item{Label: "x", Name: "c", Value: 2},
+		&itemtest.Item{Label: "y", Name: "d", Value: 2},
+		&itemtest.Item{Label: "y", Name: "e", Value: 1},
+	}...)
+	var c, p, n, r rel.Var = "c", "p", "n", "r"
+	q, err := rel.NewQuery(itemtest.Schema,
+		c.Type((*itemtest.Item)(nil)),
+		c.AttrEqVar(parent, p),
+		c.AttrEqVar(itemtest.Name, n),
+		c.AttrEqVar(rank, r),
+	)
+	require.NoError(t, err)
+	iterate := func(opts ...rel.EvalOption) (names []string, _ error) {
+		return names, q.Iterate(db, func(res rel.Result) error {
+			names = append(names, res.Var(n).(string))
+			return nil
+		}, opts...)
+	}
+
+	t.Run("first", func(t *testing.T) {
+		got, err := iterate(rel.First(p))
+		require.NoError(t, err)
+		require.Len(t, got, 2)
+		parents := map[string]struct{}{}
+		for _, gotName := range got {
+			require.NoError(t, q.Iterate(db, func(res rel.Result) error {
+				if res.Var(n) == gotName {
+					parents[res.Var(p).(string)] = struct{}{}
+				}
+				return nil
+			}))
+		}
+		require.Equal(t, map[string]struct{}{"x": {}, "y": {}}, parents)
+	})
+	t.Run("order by", func(t *testing.T) {
+		got, err := iterate(rel.OrderBy(r, n))
+		require.NoError(t, err)
+		require.Equal(t, []string{"b", "e", "c", "d", "a"}, got)
+	})
+	t.Run("order by determines first", func(t *testing.T) {
+		got, err := iterate(rel.OrderBy(r, n), rel.First(p))
+		require.NoError(t, err)
+		require.Equal(t, []string{"b", "e"}, got)
+		got, err = iterate(rel.OrderBy(n), rel.First(p))
+		require.NoError(t, err)
+		require.Equal(t, []string{"a", "d"}, got)
+	})
+	t.Run("stop iteration", func(t *testing.T) {
+		var got []string
+		err := q.Iterate(db, func(res rel.Result) error {
+			got = append(got, res.Var(n).(string))
+			return iterutil.StopIteration()
+		}, rel.OrderBy(n))
+		require.True(t, iterutil.Done(err))
+		require.Equal(t, []string{"a"}, got)
+	})
+	t.Run("project join keys", func(t *testing.T) {
+		var other rel.Var = "other"
+		q, err := rel.NewQuery(itemtest.Schema,
+			c.AttrEq(itemtest.Name, "a"),
+			c.AttrEqVar(parent, p),
+			other.AttrEqVar(parent, p),
+		)
+		require.NoError(t, err)
+		var numResults int
+		require.NoError(t, q.Iterate(db, func(res rel.Result) error {
+			numResults++
+			key, ok := res.JoinKey(parent)
+			require.True(t, ok)
+			for _, v := range []rel.Var{c, other} {
+				value, ok := res.Attr(v, parent)
+				require.True(t, ok)
+				require.Equal(t, value, key)
+			}
+			_, ok = res.JoinKey(rank)
+			require.False(t, ok)
+			return nil
+		}, rel.ProjectJoinKeys()))
+		require.Equal(t, 3, numResults)
+		require.NoError(t, q.Iterate(db, func(res rel.Result) error {
+			_, ok := res.JoinKey(parent)
+			require.False(t, ok)
+			return nil
+		}))
+	})
+	t.Run("errors", func(t *testing.T) {
+		_, err := iterate(rel.OrderBy("undefined"))
+		require.EqualError(t, err, "invalid OrderBy: unknown variable undefined")
+		_, err = iterate(rel.First("undefined"))
+		require.EqualError(t, err, "invalid First: unknown variable undefined")
+		_, err = iterate(rel.First(p), rel.First(n))
+		require.EqualError(t, err, "First may only be specified once")
+	})
+}
+
+func TestDatabaseSnapshot(t *testing.T) {
+	a, b, c := &itemtest.Item{Name: "a", Value: 1}, &itemtest.Item{Name: "b", Value: 2},
+		&itemtest.Item{Name: "c", Value: 1}
+	db := newDatabase(t, itemtest.Schema, [][]rel.Attr{{itemtest.Value}}, a, b)
+	var i rel.Var = "i"
+	q, err := rel.NewQuery(itemtest.Schema, i.AttrEq(itemtest.Value, 1))
+	require.NoError(t, err)
+	query := func(db *rel.Database) (names []string) {
+		require.NoError(t, q.Iterate(db, func(r rel.Result) error {
+			names = append(names, r.Var(i).(*itemtest.Item).Name)
+			return nil
+		}))
+		sort.Strings(names)
+		return names
+	}
+
+	snapshot := db.Snapshot()
+	restored := snapshot.Restore()
+	require.Equal(t, itemtest.Schema, restored.Schema())
+	require.Equal(t, []string{"a"}, query(db))
+	require.Equal(t, query(db), query(restored))
+
+	// Inserting into the restored database affects neither the snapshot nor
+	// the original database.
+	require.NoError(t, restored.Insert(c))
+	require.Equal(t, []string{"a", "c"}, query(restored))
+	require.Equal(t, []string{"a"}, query(snapshot.Restore()))
+	require.Equal(t, []string{"a"}, query(db))
+
+	// Inserting into the original database doesn't affect the snapshot.
+	require.NoError(t, db.Insert(&itemtest.Item{Name: "d", Value: 1}))
+	require.Equal(t, []string{"a", "d"}, query(db))
+	require.Equal(t, []string{"a"}, query(snapshot.Restore()))
+}
+
+func TestEvaluateAll(t *testing.T) {
+	var items []interface{}
+	for i := 0; i < 20; i++ {
+		items = append(items, &itemtest.Item{
+			Name: fmt.Sprintf("i%d", i), Group: i % 3, Value: i % 5,
+		})
+	}
+	db := newDatabase(t, itemtest.Schema, [][]rel.Attr{{itemtest.Group}}, items...)
+	var a, b rel.Var = "a", "b"
+	vars := []rel.Var{a, b, "v"}
+	var queries []*rel.Query
+	for _, clauses := range [][]rel.Clause{
+		{a.Type((*itemtest.Item)(nil))},
+		{a.AttrEq(itemtest.Value, 2)},
+		{a.AttrEq(itemtest.Group, 1)},
+		{a.AttrIn(itemtest.Group, 0, 2), a.AttrEq(itemtest.Value, 1)},
+		{a.AttrEqVar(itemtest.Value, "v"), b.AttrEqVar(itemtest.Value, "v"), b.AttrEq(itemtest.Group, 0)},
+		{a.AttrEqVar(itemtest.Value, "v"), b.AttrEqVar(itemtest.Value, "v"), rel.Filter("lt", a, b)(
+			func(a, b *itemtest.Item) bool { return a.Name < b.Name },
+		)},
+		{a.AttrEq(itemtest.Value, 1), b.AttrInResult(itemtest.Group, rel.And(
+			a.AttrEqVar(itemtest.Group, "g"), a.AttrEq(itemtest.Value, 4),
+		), "g")},
+		{a.AttrEq(itemtest.Value, 7)},
+	} {
+		q, err := rel.NewQuery(itemtest.Schema, clauses...)
+		require.NoError(t, err)
+		queries = append(queries, q)
+	}
+	// Include a query more than once.
+	queries = append(queries, queries[0])
+
+	project := func(r rel.Result) []interface{} {
+		var ret []interface{}
+		for _, v := range vars {
+			ret = append(ret, r.Var(v))
+		}
+		return ret
+	}
+	results, err := rel.EvaluateAll(db, queries)
+	require.NoError(t, err)
+	require.Len(t, results, len(queries))
+	for i, q := range queries {
+		var exp, got [][]interface{}
+		require.NoError(t, q.Iterate(db, func(r rel.Result) error {
+			exp = append(exp, project(r))
+			return nil
+		}))
+		for _, r := range results[i] {
+			got = append(got, project(r))
+		}
+		require.ElementsMatch(t, exp, got, "query %d", i)
+	}
+
+	t.Run("schema mismatch", func(t *testing.T) {
+		q, err := rel.NewQuery(treetest.Schema, a.AttrEq(treetest.Name, "i1"))
+		require.NoError(t, err)
+		_, err = rel.EvaluateAll(db, []*rel.Query{queries[0], q})
+		require.Regexp(t, "query and database are not from the same schema", err)
+	})
+}
+
+func TestErrorTypes(t *testing.T) {
+	sc := itemtest.Schema
+	db := newDatabase(t, sc, nil /* indexes */, []interface{}{
+		&itemtest.Item{Name: "a", Value: 1},
+		&itemtest.Item{Name: "b", Value: 1},
+	}...)
+	var a, b rel.Var = "a", "b"
+
+	t.Run("unbound var", func(t *testing.T) {
+		_, err := rel.NewQuery(sc, a.Type((*itemtest.Item)(nil)), rel.Not(b.AttrEq(itemtest.Name, "a")))
+		var unbound *rel.UnboundVarError
+		require.True(t, errors.As(err, &unbound), "%v", err)
+		require.Equal(t, b, unbound.Var)
+
+		q, err := rel.NewQuery(sc, a.Type((*itemtest.Item)(nil)))
+		require.NoError(t, err)
+		err = q.Iterate(db, func(r rel.Result) error { return nil }, rel.OrderBy("undefined"))
+		require.True(t, errors.As(err, &unbound), "%v", err)
+		require.Equal(t, rel.Var("undefined"), unbound.Var)
+	})
+	t.Run("type mismatch", func(t *testing.T) {
+		for _, c := range []rel.Clause{
+			a.AttrEq(itemtest.Name, 1),
+			a.AttrTrue(itemtest.Name),
+			a.AttrHasPrefix(itemtest.Value, "1"),
+			a.AttrEqAnyAttr(itemtest.Name, itemtest.Value),
+		} {
+			_, err := rel.NewQuery(sc, c)
+			var mismatch *rel.TypeMismatchError
+			require.True(t, errors.As(err, &mismatch), "%v", err)
+			require.NotNil(t, mismatch.Type)
+		}
+	})
+	t.Run("filter", func(t *testing.T) {
+		_, err := rel.NewQuery(sc, a.Type((*itemtest.Item)(nil)), rel.Filter("bad", a)(func() bool {
+			panic("unimplemented")
+		}))
+		var filterErr *rel.FilterError
+		require.True(t, errors.As(err, &filterErr), "%v", err)
+		require.Equal(t, "bad", filterErr.Name)
+		require.Equal(t, []rel.Var{a}, filterErr.Vars)
+	})
+	t.Run("cross join", func(t *testing.T) {
+		q, err := rel.NewQuery(sc, a.AttrEq(itemtest.Value, 1), b.AttrEq(itemtest.Value, 1))
+		require.NoError(t, err)
+		var n int
+		require.NoError(t, q.Iterate(db, func(r rel.Result) error { n++; return nil }))
+		require.Equal(t, 4, n)
+		err = q.Iterate(db, func(r rel.Result) error { return nil }, rel.NoCrossJoins())
+		var crossJoin *rel.CrossJoinError
+		require.True(t, errors.As(err, &crossJoin), "%v", err)
+		require.Equal(t, [][]rel.Var{{a}, {b}}, crossJoin.Components)
+
+		for _, clauses := range [][]rel.Clause{
+			{a.AttrEqVar(itemtest.Value, "v"), b.AttrEqVar(itemtest.Value, "v")},
+			{a.AttrEqOtherAttr(itemtest.Value, b, itemtest.Value)},
+			{a.AttrLtVar(itemtest.Value, b, itemtest.Value)},
+			{a.Type((*itemtest.Item)(nil)), b.Type((*itemtest.Item)(nil)), rel.Filter("ne", a, b)(
+				func(a, b *itemtest.Item) bool { return a != b },
+			)},
+		} {
+			q, err := rel.NewQuery(sc, clauses...)
+			require.NoError(t, err)
+			require.NoError(t, q.Iterate(db, func(r rel.Result) error { return nil }, rel.NoCrossJoins()))
+		}
+	})
+}
+
+func TestFrozenDatabase(t *testing.T) {
+	sc := treetest.Schema
+	root := &treetest.Node{Name: "root"}
+	entities := []interface{}{root}
+	for i := 0; i < 64; i++ {
+		entities = append(entities, &treetest.Node{
+			Name: fmt.Sprintf("i%d", i), Ordinal: uint32(i % 4), Parent: root,
+		})
+	}
+	db := newDatabase(t, sc, [][]rel.Attr{{treetest.Ordinal}, {treetest.Parent}}, entities...)
+	require.False(t, db.Frozen())
+	db.Freeze()
+	require.True(t, db.Frozen())
+	require.Regexp(t, "cannot insert \\*treetest.Node into frozen database",
+		db.Insert(&treetest.Node{Name: "late"}))
+
+	// Note that the root also has an ordinal of 0.
+	var a, b rel.Var = "a", "b"
+	queries := []struct {
+		clauses []rel.Clause
+		exp     int
+	}{
+		{[]rel.Clause{a.AttrEq(treetest.Ordinal, uint32(1))}, 16},
+		{[]rel.Clause{a.AttrEqVar(treetest.Ordinal, "v"), b.AttrEqVar(treetest.Ordinal, "v")}, 17*17 + 3*16*16},
+		{[]rel.Clause{a.AttrEq(treetest.Parent, root), rel.Not(a.AttrIn(treetest.Ordinal, uint32(0), uint32(1)))}, 32},
+		{[]rel.Clause{a.AttrEq(treetest.Ordinal, uint32(3)), a.AttrInResult(treetest.Parent, b.AttrEq(treetest.Name, "root"), b)}, 16},
+	}
+	compiled := make([]*rel.Query, len(queries))
+	for i, q := range queries {
+		var err error
+		compiled[i], err = rel.NewQuery(sc, q.clauses...)
+		require.NoError(t, err)
+	}
+
+	const goroutines = 8
+	errCh := make(chan error, goroutines)
+	for g := 0; g < goroutines; g++ {
+		go func() {
+			errCh <- func() error {
+				for iter := 0; iter < 10; iter++ {
+					for i, q := range compiled {
+						var n int
+						if err := q.Iterate(db, func(r rel.Result) error {
+							n++
+							return nil
+						}); err != nil {
+							return err
+						}
+						if n != queries[i].exp {
+							return errors.Errorf("query %d: expected %d results, got %d", i, queries[i].exp, n)
+						}
+					}
+				}
+				return nil
+			}()
+		}()
+	}
+	for g := 0; g < goroutines; g++ {
+		require.NoError(t, <-errCh)
+	}
+}
+
+func TestResultsCache(t *testing.T) {
+	sc := itemtest.Schema
+	newDB := func() *rel.Database {
+		var items []interface{}
+		for i := 0; i < 10; i++ {
+			items = append(items, &itemtest.Item{Name: fmt.Sprintf("i%d", i), Value: i % 2})
+		}
+		return newDatabase(t, sc, nil /* indexes */, items...)
+	}
+	var a rel.Var = "a"
+	newQuery := func(v int) *rel.Query {
+		q, err := rel.NewQuery(sc, a.AttrEq(itemtest.Value, v))
+		require.NoError(t, err)
+		return q
+	}
+	names := func(results []rel.Result) (ret []string) {
+		for _, r := range results {
+			ret = append(ret, r.Var(a).(*itemtest.Item).Name)
+		}
+		sort.Strings(ret)
+		return ret
+	}
+
+	t.Run("hit", func(t *testing.T) {
+		db, q, c := newDB(), newQuery(0), rel.NewResultsCache(10)
+		first, err := c.Evaluate(db, q)
+		require.NoError(t, err)
+		require.Equal(t, []string{"i0", "i2", "i4", "i6", "i8"}, names(first))
+		second, err := c.Evaluate(db, q)
+		require.NoError(t, err)
+		require.Len(t, second, len(first))
+		for i := range first {
+			require.Same(t, first[i], second[i])
+		}
+		require.Equal(t, 1, c.Len())
+	})
+
+	t.Run("invalidated by insert", func(t *testing.T) {
+		db, q, c := newDB(), newQuery(0), rel.NewResultsCache(10)
+		fp := db.Fingerprint()
+		first, err := c.Evaluate(db, q)
+		require.NoError(t, err)
+		require.Len(t, first, 5)
+
+		// Re-inserting an existing entity does not modify the database.
+		var existing *itemtest.Item
+		require.NoError(t, q.Iterate(db, func(r rel.Result) error {
+			existing = r.Var(a).(*itemtest.Item)
+			return iterutil.StopIteration()
+		}))
+		require.NoError(t, db.Insert(existing))
+		require.Equal(t, fp, db.Fingerprint())
+
+		require.NoError(t, db.Insert(&itemtest.Item{Name: "new", Value: 0}))
+		require.NotEqual(t, fp, db.Fingerprint())
+		second, err := c.Evaluate(db, q)
+		require.NoError(t, err)
+		require.Equal(t, []string{"i0", "i2", "i4", "i6", "i8", "new"}, names(second))
+		require.NotSame(t, first[0], second[0])
+	})
+
+	t.Run("distinct databases and queries", func(t *testing.T) {
+		db1, db2, c := newDB(), newDB(), rel.NewResultsCache(10)
+		q1, q2 := newQuery(0), newQuery(0)
+		require.NotEqual(t, db1.Fingerprint(), db2.Fingerprint())
+		require.NotEqual(t, q1.Fingerprint(), q2.Fingerprint())
+		require.NotEqual(t, db1.Fingerprint(), db1.Snapshot().Restore().Fingerprint())
+		for _, db := range []*rel.Database{db1, db2} {
+			for _, q := range []*rel.Query{q1, q2} {
+				results, err := c.Evaluate(db, q)
+				require.NoError(t, err)
+				require.Len(t, results, 5)
+			}
+		}
+		require.Equal(t, 4, c.Len())
+	})
+
+	t.Run("eviction", func(t *testing.T) {
+		db, c := newDB(), rel.NewResultsCache(2)
+		q0, q1, q2 := newQuery(0), newQuery(1), newQuery(0)
+		r0, err := c.Evaluate(db, q0)
+		require.NoError(t, err)
+		_, err = c.Evaluate(db, q1)
+		require.NoError(t, err)
+		// Access q0 so that q1 is the least recently used.
+		_, err = c.Evaluate(db, q0)
+		require.NoError(t, err)
+		_, err = c.Evaluate(db, q2)
+		require.NoError(t, err)
+		require.Equal(t, 2, c.Len())
+		again, err := c.Evaluate(db, q0)
+		require.NoError(t, err)
+		require.Same(t, r0[0], again[0])
+	})
+
+	t.Run("schema mismatch", func(t *testing.T) {
+		q, err := rel.NewQuery(treetest.Schema, a.AttrEq(treetest.Name, "i1"))
+		require.NoError(t, err)
+		_, err = rel.NewResultsCache(1).Evaluate(newDB(), q)
+		require.Regexp(t, "query and database are not from the same schema", err)
+	})
+}
+
+func TestDatabaseValidate(t *testing.T) {
+	// Every node of this schema needs a parent, so a root is its own parent.
+	sc := rel.MustSchema("validate",
+		rel.EntityMapping(reflect.TypeOf((*treetest.Node)(nil)),
+			rel.EntityAttr(treetest.Name, "Name"),
+			rel.EntityAttr(treetest.Parent, "Parent"),
+			rel.EntityAttr(treetest.Note, "Note"),
+			rel.RequiredAttrs(treetest.Parent),
+		),
+	)
+	newDB := func(t *testing.T) *rel.Database {
+		db, err := rel.NewDatabase(sc, nil /* indexes */)
+		require.NoError(t, err)
+		return db
+	}
+	violations := func(t *testing.T, db *rel.Database) []string {
+		err := db.Validate()
+		if err == nil {
+			return nil
+		}
+		var ve *rel.ValidationError
+		require.True(t, errors.As(err, &ve))
+		ret := make([]string, len(ve.Violations))
+		for i, v := range ve.Violations {
+			ret[i] = v.Error()
+		}
+		return ret
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		db := newDB(t)
+		root := &treetest.Node{Name: "root"}
+		root.Parent = root
+		require.NoError(t, db.Insert(&treetest.Node{Name: "child", Parent: root}))
+		require.Nil(t, violations(t, db))
+	})
+
+	t.Run("violations", func(t *testing.T) {
+		db := newDB(t)
+		root := &treetest.Node{Name: "root"}
+		root.Parent = root
+		child := &treetest.Node{Name: "child", Parent: root}
+		orphan := &treetest.Node{Name: "orphan"}
+		noted := &treetest.Node{Name: "noted", Parent: root, Note: &treetest.Comment{Text: "x"}}
+		for _, n := range []*treetest.Node{child, orphan, noted} {
+			require.NoError(t, db.Insert(n))
+		}
+		// Re-parent the child onto a node which is not in the database.
+		child.Parent = &treetest.Node{Name: "missing", Parent: root}
+
+		got := violations(t, db)
+		require.Len(t, got, 4, "%v", got)
+		for _, exp := range []string{
+			`^\*treetest.Node\(0x[0-9a-f]+\): modified after insertion: \[Parent\]$`,
+			`^\*treetest.Node\(0x[0-9a-f]+\): attribute Parent refers to \*treetest.Node\(0x[0-9a-f]+\) which is not in the database$`,
+			`^\*treetest.Node\(0x[0-9a-f]+\): missing required attribute Parent$`,
+			`^\*treetest.Node\(0x[0-9a-f]+\): attribute Note refers to \*treetest.Comment which is not an entity type$`,
+		} {
+			var found bool
+			for _, v := range got {
+				if found = regexp.MustCompile(exp).MatchString(v); found {
+					break
+				}
+			}
+			require.True(t, found, "expected %s in %v", exp, got)
+		}
+		require.Regexp(t, "^database contains 4 violation\\(s\\)\n", db.Validate().Error())
+	})
+
+	t.Run("required attribute not mapped", func(t *testing.T) {
+		_, err := rel.NewSchema("invalid",
+			rel.EntityMapping(reflect.TypeOf((*treetest.Node)(nil)),
+				rel.EntityAttr(treetest.Name, "Name"),
+				rel.RequiredAttrs(treetest.Parent),
+			),
+		)
+		require.Regexp(t, "unknown attribute Parent", err)
+		_, err = rel.NewSchema("invalid",
+			rel.AttrType(treetest.Parent, reflect.TypeOf((*treetest.Node)(nil))),
+			rel.EntityMapping(reflect.TypeOf((*treetest.Node)(nil)),
+				rel.EntityAttr(treetest.Name, "Name"),
+				rel.RequiredAttrs(treetest.Parent),
+			),
+		)
+		require.Regexp(t, "required attribute Parent is not mapped for \\*treetest.Node", err)
+	})
+}
+
+func TestFollowRef(t *testing.T) {
+	sc := treetest.Schema
+	root := &treetest.Node{Name: "root"}
+	child := &treetest.Node{Name: "child", Parent: root, Note: &treetest.Comment{Text: "x"}}
+	db := newDatabase(t, sc, nil /* indexes */, child)
+
+	var n rel.Var = "n"
+	q, err := rel.NewQuery(sc, n.Type((*treetest.Node)(nil)), n.AttrEqVar(treetest.Name, "name"))
+	require.NoError(t, err)
+	check := func(t *testing.T, r rel.Result) {
+		switch r.Var(n) {
+		case child:
+			ref, ok, err := r.FollowRef(n, treetest.Parent)
+			require.NoError(t, err)
+			require.True(t, ok)
+			require.Same(t, root, ref)
+
+			// The comment is not an entity.
+			_, _, err = r.FollowRef(n, treetest.Note)
+			require.Regexp(t, `Note of n refers to \*treetest.Comment which is not an entity in the database`, err)
+		case root:
+			ref, ok, err := r.FollowRef(n, treetest.Parent)
+			require.NoError(t, err)
+			require.False(t, ok)
+			require.Nil(t, ref)
+		default:
+			t.Fatalf("unexpected result %v", r.Var(n))
+		}
+		for _, tc := range []struct {
+			v   rel.Var
+			a   rel.Attr
+			exp string
+		}{
+			{n, treetest.Name, `Name of type string does not refer to entities`},
+			{n, rel.Self, `Self of type interface {} does not refer to entities`},
+			{n, rel.Type, `Type of type reflect.Type does not refer to entities`},
+			{n, stringAttr("missing"), `unknown attribute missing`},
+			{"name", treetest.Parent, `variable name is not bound to an entity`},
+			{"other", treetest.Parent, `unknown variable other`},
+		} {
+			_, _, err := r.FollowRef(tc.v, tc.a)
+			require.Regexp(t, tc.exp, err)
+		}
+	}
+
+	var count int
+	require.NoError(t, q.Iterate(db, func(r rel.Result) error {
+		count++
+		check(t, r)
+		return nil
+	}))
+	require.Equal(t, 2, count)
+
+	// Buffered results can follow references too.
+	results, err := rel.EvaluateAll(db, []*rel.Query{q})
+	require.NoError(t, err)
+	require.Len(t, results[0], 2)
+	for _, r := range results[0] {
+		check(t, r)
+	}
+}
+
+// TestAttrCapture ensures that AttrCapture rejects an empty constraint.
+func TestAttrCapture(t *testing.T) {
+	var c, captured rel.Var = "c", "captured"
+	require.Panics(t, func() {
+		c.AttrCapture(stringAttr("kind"), captured, rel.ValueConstraint{})
+	})
+}
+
+func TestEntityIdentity(t *testing.T) {
+	sc := treetest.Schema
+
+	// The parents are distinct pointers with the same identity.
+	p, pDup := &treetest.Node{Name: "p"}, &treetest.Node{Name: "p"}
+	db := newDatabase(t, sc, [][]rel.Attr{{treetest.Parent}}, []interface{}{
+		&treetest.Node{Name: "a", Parent: p},
+		pDup,
+		&treetest.Node{Name: "b", Parent: pDup},
+	}...)
+	require.NoError(t, db.Validate())
+
+	var x, y rel.Var = "x", "y"
+	results := func(t *testing.T, db *rel.Database, f func(r rel.Result) string, clauses ...rel.Clause) (ret []string) {
+		q, err := rel.NewQuery(sc, clauses...)
+		require.NoError(t, err)
+		require.NoError(t, q.Iterate(db, func(r rel.Result) error {
+			ret = append(ret, f(r))
+			return nil
+		}))
+		sort.Strings(ret)
+		return ret
+	}
+	t.Run("references", func(t *testing.T) {
+		require.Equal(t, []string{"a", "b"}, results(t, db, func(r rel.Result) string {
+			ref, ok, err := r.FollowRef(y, treetest.Parent)
+			require.NoError(t, err)
+			require.True(t, ok)
+			require.True(t, ref == p)
+			return r.Var(y).(*treetest.Node).Name
+		}, x.AttrEq(treetest.Name, "p"), y.AttrEqVar(treetest.Parent, x)))
+	})
+	t.Run("snapshot", func(t *testing.T) {
+		restored := db.Snapshot().Restore()
+		require.NoError(t, restored.Insert(&treetest.Node{Name: "b"}))
+		require.NoError(t, restored.Insert(&treetest.Node{Name: "c"}))
+		require.Equal(t, []string{"a:false", "b:false", "c:false", "p:true"}, results(t, restored, func(r rel.Result) string {
+			return fmt.Sprintf("%s:%t", r.Var(x).(*treetest.Node).Name, r.Var(x) == p)
+		}, x.Type((*treetest.Node)(nil))))
+	})
+}
+
+func TestBuildFromSpec(t *testing.T) {
+	// The items of groups 1 and 2 join the items t and u by value.
+	sc := itemtest.Schema
+	db := newDatabase(t, sc, nil /* indexes */, []interface{}{
+		&itemtest.Item{Name: "t", Value: 1},
+		&itemtest.Item{Name: "u", Value: 2},
+		&itemtest.Item{Name: "a", Group: 1, Value: 1, IDs: []uint32{1}},
+		&itemtest.Item{Name: "b", Group: 1, Value: 2},
+		&itemtest.Item{Name: "c", Group: 1, Value: 3, IDs: []uint32{2, 3}},
+		&itemtest.Item{Name: "a", Group: 2, Value: 1, IDs: []uint32{1, 3}},
+	}...)
+
+	// The spec is loaded from YAML, so its values are ints and strings.
+	const specYAML = `
+constraints:
+- {var: g, attr: Name, op: eq, value: t}
+- {var: i, attr: Value, op: lt, value: 3}
+joins:
+- {var: i, attr: Group, other: g, otherAttr: Value}
+`
+	var spec rel.QuerySpec
+	require.NoError(t, yaml.Unmarshal([]byte(specYAML), &spec))
+	var i rel.Var = "i"
+	items := func(t *testing.T, spec rel.QuerySpec) []string {
+		q, err := rel.BuildFromSpec(spec, sc)
+		require.NoError(t, err)
+		got := []string{}
+		require.NoError(t, q.Iterate(db, func(r rel.Result) error {
+			it := r.Var(i).(*itemtest.Item)
+			got = append(got, fmt.Sprintf("%d.%s", it.Group, it.Name))
+			return nil
+		}))
+		sort.Strings(got)
+		return got
+	}
+	require.Equal(t, []string{"1.a", "1.b"}, items(t, spec))
+
+	constraint := func(attr, op string, value interface{}) rel.ConstraintSpec {
+		return rel.ConstraintSpec{Var: i, Attr: attr, Op: op, Value: value}
+	}
+	inGroup := constraint("Group", "in", []interface{}{1, 2})
+	for _, tc := range []struct {
+		constraint rel.ConstraintSpec
+		exp        []string
+	}{
+		{constraint("Name", "eq", "a"), []string{"1.a", "2.a"}},
+		{constraint("Name", "neq", "a"), []string{"1.b", "1.c"}},
+		{constraint("Value", "in", []interface{}{2, 3.0}), []string{"1.b", "1.c"}},
+		{constraint("Value", "lt", 2), []string{"1.a", "2.a"}},
+		{constraint("Value", "gt", 2), []string{"1.c"}},
+		{constraint("IDs", "contains", 3), []string{"1.c", "2.a"}},
+		{constraint("IDs", "contains", 4), []string{}},
+	} {
+		t.Run(tc.constraint.Op+" "+fmt.Sprint(tc.constraint.Value), func(t *testing.T) {
+			require.Equal(t, tc.exp, items(t, rel.QuerySpec{
+				Constraints: []rel.ConstraintSpec{inGroup, tc.constraint},
+			}))
+		})
+	}
+
+	t.Run("errors", func(t *testing.T) {
+		for _, tc := range []struct {
+			spec rel.QuerySpec
+			exp  string
+		}{
+			{
+				rel.QuerySpec{Constraints: []rel.ConstraintSpec{constraint("Name", "like", "a")}},
+				`invalid constraint 0: unknown operator "like"`,
+			},
+			{
+				rel.QuerySpec{Constraints: []rel.ConstraintSpec{constraint("nope", "eq", "a")}},
+				`invalid constraint 0: unknown attribute "nope" in schema items`,
+			},
+			{
+				rel.QuerySpec{Constraints: []rel.ConstraintSpec{inGroup, constraint("Name", "in", "a")}},
+				`invalid constraint 1: in requires a list of values, got string`,
+			},
+			{
+				rel.QuerySpec{Joins: []rel.JoinSpec{{Var: i, Attr: "Group", Other: "g", OtherAttr: "nope"}}},
+				`invalid join 0: unknown attribute "nope"`,
+			},
+			{
+				// Lossy conversions are not performed.
+				rel.QuerySpec{Constraints: []rel.ConstraintSpec{constraint("Value", "eq", 1.5)}},
+				`unsupported kind float64`,
+			},
+			{
+				rel.QuerySpec{Constraints: []rel.ConstraintSpec{constraint("Name", "contains", "a")}},
+				`string is not a slice`,
+			},
+		} {
+			_, err := rel.BuildFromSpec(tc.spec, sc)
+			require.Regexp(t, tc.exp, err)
+		}
+	})
+}
+
+func TestGroupBy(t *testing.T) {
+	sc := treetest.Schema
+	x, y := &treetest.Node{Name: "x"}, &treetest.Node{Name: "y"}
+	db := newDatabase(t, sc, nil /* indexes */, []interface{}{
+		x, y,
+		&treetest.Node{Parent: y, Name: "d", Ordinal: 2},
+		&treetest.Node{Parent: x, Name: "a", Ordinal: 3},
+		&treetest.Node{Parent: x, Name: "b", Ordinal: 1},
+		&treetest.Node{Parent: y, Name: "e", Ordinal: 5},
+		&treetest.Node{Parent: x, Name: "c", Ordinal: 2},
+	}...)
+
+	var pv, cv, nv rel.Var = "p", "c", "n"
+	q, err := rel.NewQuery(sc,
+		cv.AttrEqVar(treetest.Parent, pv),
+		cv.AttrEqVar(treetest.Name, nv),
+	)
+	require.NoError(t, err)
+	// group summarizes a group: the name of its parent, the name of the
+	// child of its first result, its length, and the aggregates of the
+	// ordinals of its children.
+	type group struct {
+		parent, first        string
+		len                  int
+		count, min, max, sum interface{}
+	}
+	groups := func(t *testing.T, opts ...rel.EvalOption) (ret []group) {
+		require.NoError(t, q.Iterate(db, func(r rel.Result) error {
+			g := r.(rel.Group)
+			gr := group{
+				parent: r.Var(pv).(*treetest.Node).Name,
+				first:  r.Var(nv).(string),
+				len:    g.Len(),
+			}
+			for f, res := range map[rel.AggFunc]*interface{}{
+				rel.AggCount: &gr.count,
+				rel.AggMin:   &gr.min,
+				rel.AggMax:   &gr.max,
+				rel.AggSum:   &gr.sum,
+			} {
+				var err error
+				*res, err = g.Aggregate(cv, treetest.Ordinal, f)
+				require.NoError(t, err)
+			}
+			ret = append(ret, gr)
+			return nil
+		}, append(opts, rel.GroupBy(pv))...))
+		return ret
+	}
+
+	t.Run("by parent", func(t *testing.T) {
+		got := groups(t, rel.OrderBy(nv))
+		require.Equal(t, []group{
+			{parent: "x", first: "a", len: 3, count: 3, min: uint32(1), max: uint32(3), sum: uint32(6)},
+			{parent: "y", first: "d", len: 2, count: 2, min: uint32(2), max: uint32(5), sum: uint32(7)},
+		}, got)
+	})
+	t.Run("order by determines first results", func(t *testing.T) {
+		var ov rel.Var = "o"
+		q, err := rel.NewQuery(sc,
+			cv.AttrEqVar(treetest.Parent, pv),
+			cv.AttrEqVar(treetest.Ordinal, ov),
+		)
+		require.NoError(t, err)
+		var firsts []uint32
+		require.NoError(t, q.Iterate(db, func(r rel.Result) error {
+			firsts = append(firsts, r.Var(ov).(uint32))
+			return nil
+		}, rel.OrderBy(ov), rel.GroupBy(pv)))
+		// The children with the smallest ordinals are b, a child of x, and d, a
+		// child of y.
+		require.Equal(t, []uint32{1, 2}, firsts)
+	})
+	t.Run("first", func(t *testing.T) {
+		got := groups(t, rel.OrderBy(nv), rel.First(pv))
+		require.Equal(t, []group{
+			{parent: "x", first: "a", len: 1, count: 1, min: uint32(3), max: uint32(3), sum: uint32(3)},
+			{parent: "y", first: "d", len: 1, count: 1, min: uint32(2), max: uint32(2), sum: uint32(2)},
+		}, got)
+	})
+	t.Run("errors", func(t *testing.T) {
+		err := q.Iterate(db, func(r rel.Result) error { return nil }, rel.GroupBy("unknown"))
+		require.Regexp(t, "invalid GroupBy: unknown variable unknown", err)
+		require.NoError(t, q.Iterate(db, func(r rel.Result) error {
+			g := r.(rel.Group)
+			_, err := g.Aggregate(cv, treetest.Name, rel.AggSum)
+			var mismatch *rel.TypeMismatchError
+			require.True(t, errors.As(err, &mismatch))
+			_, err = g.Aggregate(nv, treetest.Ordinal, rel.AggCount)
+			require.Regexp(t, "variable n is not bound to an entity", err)
+			return nil
+		}, rel.GroupBy(pv)))
+	})
+}
+
+func TestAntiJoin(t *testing.T) {
+	// The same anti-join constructed twice is formatted identically.
+	var n, c rel.Var = "n", "c"
+	format := func() string {
+		q, err := rel.NewQuery(treetest.Schema,
+			n.Type((*treetest.Node)(nil)),
+			rel.AntiJoin(n, c, func(n, c rel.Var) rel.Clause {
+				return c.AttrEqVar(treetest.Parent, n)
+			}),
+		)
+		require.NoError(t, err)
+		return q.String()
+	}
+	require.Equal(t, format(), format())
+}
+
+func TestRejectionTrace(t *testing.T) {
+	sc := itemtest.Schema
+	items := []interface{}{
+		&itemtest.Item{Name: "a", Value: 30},
+		&itemtest.Item{Name: "b", Value: 40},
+		&itemtest.Item{Name: "c", Value: 50},
+	}
+	db := newDatabase(t, sc, nil /* indexes */, items...)
+
+	var x rel.Var = "x"
+	small := x.AttrLt(itemtest.Value, 18)
+	q, err := rel.NewQuery(sc, x.Type((*itemtest.Item)(nil)), small)
+	require.NoError(t, err)
+	iterate := func(t *testing.T, trace *rel.RejectionTrace) {
+		require.NoError(t, q.Iterate(db, func(r rel.Result) error {
+			t.Fatalf("unexpected result %v", r.Var(x))
+			return nil
+		}, rel.WithRejectionTrace(trace)))
+	}
+	t.Run("all rejected", func(t *testing.T) {
+		var trace rel.RejectionTrace
+		iterate(t, &trace)
+		require.Len(t, trace.Rejections, len(items))
+		var rejected []interface{}
+		for _, r := range trace.Rejections {
+			require.Equal(t, small, r.Clause)
+			require.Regexp(t, `\$x\[Value\] < 18: predicate is not satisfied`, r.String())
+			rejected = append(rejected, r.Entity)
+		}
+		require.ElementsMatch(t, items, rejected)
+		require.Zero(t, trace.Dropped)
+	})
+	t.Run("bounded", func(t *testing.T) {
+		trace := rel.RejectionTrace{Limit: 2}
+		iterate(t, &trace)
+		require.Len(t, trace.Rejections, 2)
+		require.Equal(t, 1, trace.Dropped)
+	})
+	t.Run("untraced", func(t *testing.T) {
+		// The trace is not retained by the query once evaluation completes.
+		var trace rel.RejectionTrace
+		iterate(t, &trace)
+		require.NoError(t, q.Iterate(db, func(r rel.Result) error { return nil }))
+		require.Len(t, trace.Rejections, len(items))
+	})
+}
+
+func TestUnionQueries(t *testing.T) {
+	sc := treetest.Schema
+	t1 := &treetest.Node{Name: "t1", Kind: "table"}
+	t2 := &treetest.Node{Name: "t2", Kind: "table"}
+	db := newDatabase(t, sc, nil /* indexes */, []interface{}{
+		t1, t2,
+		&treetest.Node{Parent: t1, Name: "a", Kind: "column"},
+		&treetest.Node{Parent: t1, Name: "b", Kind: "column"},
+		&treetest.Node{Parent: t2, Name: "c", Kind: "column"},
+		&treetest.Node{Parent: t1, Name: "primary", Kind: "index"},
+	}...)
+
+	// Classify the children of tables by whether they are columns or indexes,
+	// where the index branch also binds the name of the table.
+	var tv, child, tableName rel.Var = "t", "child", "table-name"
+	q, err := rel.NewQuery(sc,
+		tv.AttrEq(treetest.Kind, "table"),
+		child.AttrEqVar(treetest.Parent, tv),
+		rel.UnionQueries(
+			rel.TaggedClause{Tag: "column", Clause: child.AttrEq(treetest.Kind, "column")},
+			rel.TaggedClause{Tag: "index", Clause: rel.And(
+				child.AttrEq(treetest.Kind, "index"),
+				tv.AttrEqVar(treetest.Name, tableName),
+			)},
+		),
+	)
+	require.NoError(t, err)
+	require.Equal(t, []rel.Var{tv, child}, q.Entities())
+
+	toStrings := func(results []rel.Result) (ret []string) {
+		for _, r := range results {
+			n, _ := r.Attr(child, treetest.Name)
+			ret = append(ret, fmt.Sprintf("%s: %s.%s", r.Branch(), r.Var(tv).(*treetest.Node).Name, n))
+		}
+		sort.Strings(ret)
+		return ret
+	}
+	exp := []string{"column: t1.a", "column: t1.b", "column: t2.c", "index: t1.primary"}
+	t.Run("iterate", func(t *testing.T) {
+		var results []string
+		require.NoError(t, q.Iterate(db, func(r rel.Result) error {
+			n, _ := r.Attr(child, treetest.Name)
+			results = append(results, fmt.Sprintf("%s: %s.%s", r.Branch(), r.Var(tv).(*treetest.Node).Name, n))
+			if r.Branch() == "index" {
+				require.Equal(t, "t1", r.Var(tableName))
+			} else {
+				require.Nil(t, r.Var(tableName))
+			}
+			return nil
+		}))
+		sort.Strings(results)
+		require.Equal(t, exp, results)
+	})
+	t.Run("evaluate all", func(t *testing.T) {
+		results, err := rel.EvaluateAll(db, []*rel.Query{q})
+		require.NoError(t, err)
+		require.Equal(t, exp, toStrings(results[0]))
+	})
+	t.Run("stop iteration", func(t *testing.T) {
+		q, err := rel.NewQuery(sc, rel.UnionQueries(
+			rel.TaggedClause{Tag: "column", Clause: child.AttrEq(treetest.Kind, "column")},
+			rel.TaggedClause{Tag: "index", Clause: child.AttrEq(treetest.Kind, "index")},
+		))
+		require.NoError(t, err)
+		var n int
+		require.NoError(t, q.Iterate(db, func(r rel.Result) error {
+			n++
+			return iterutil.StopIteration()
+		}))
+		require.Equal(t, 1, n)
+	})
+	t.Run("no branch", func(t *testing.T) {
+		q, err := rel.NewQuery(sc, tv.AttrEq(treetest.Kind, "table"))
+		require.NoError(t, err)
+		require.NoError(t, q.Iterate(db, func(r rel.Result) error {
+			require.Equal(t, "", r.Branch())
+			return nil
+		}))
+	})
+	t.Run("invalid", func(t *testing.T) {
+		for _, tc := range []struct {
+			clauses []rel.Clause
+			err     string
+		}{
+			{
+				clauses: []rel.Clause{tv.AttrEq(treetest.Kind, "table"), rel.UnionQueries()},
+				err:     "UnionQueries requires at least one branch",
+			},
+			{
+				clauses: []rel.Clause{rel.UnionQueries(
+					rel.TaggedClause{Tag: "a", Clause: tv.AttrEq(treetest.Kind, "table")},
+					rel.TaggedClause{Tag: "a", Clause: tv.AttrEq(treetest.Kind, "column")},
+				)},
+				err: `duplicate UnionQueries branch tag "a"`,
+			},
+			{
+				clauses: []rel.Clause{
+					tv.AttrEq(treetest.Kind, "table"),
+					rel.Not(rel.UnionQueries(
+						rel.TaggedClause{Tag: "a", Clause: tv.AttrEq(treetest.Name, "t1")},
+					)),
+				},
+				err: "UnionQueries is only supported at the top level of a query",
+			},
+			{
+				clauses: []rel.Clause{rel.UnionQueries(
+					rel.TaggedClause{Tag: "a", Clause: tv.AttrEq(treetest.Kind, "table")},
+					rel.TaggedClause{Tag: "b", Clause: tv.AttrEq(treetest.Name, 1)},
+				)},
+				err: `invalid UnionQueries branch "b"`,
+			},
+		} {
+			_, err := rel.NewQuery(sc, tc.clauses...)
+			require.Regexp(t, tc.err, err)
+		}
+	})
+}
+
+func TestNamedAttr(t *testing.T) {
+	name, ordinal, parent := treetest.Name, treetest.Ordinal, treetest.Parent
+	sc := rel.MustSchema("named",
+		rel.EntityMapping(reflect.TypeOf((*treetest.Node)(nil)),
+			rel.EntityAttr(name, "Name"),
+			rel.EntityAttr(ordinal, "Ordinal"),
+			rel.EntityAttr(parent, "Parent"),
+		),
+		rel.NamedAttr(name, "name"),
+		rel.NamedAttr(ordinal, "ordinal"),
+	)
+	require.Equal(t, "name", sc.AttrName(name))
+	require.Equal(t, "ordinal", sc.AttrName(ordinal))
+	require.Equal(t, "Parent", sc.AttrName(parent))
+	require.Equal(t, "Type", sc.AttrName(rel.Type))
+
+	var x, y rel.Var = "x", "y"
+	t.Run("query", func(t *testing.T) {
+		q, err := rel.NewQuery(sc,
+			x.Type((*treetest.Node)(nil)),
+			x.AttrEqVar(parent, y),
+			x.AttrLt(ordinal, uint32(18)),
+			rel.Not(y.AttrEq(name, "a")),
+		)
+		require.NoError(t, err)
+		require.Equal(t, `- $x[Type] = '*treetest.Node'
+- $x[Parent] = $y
+- $x[ordinal] < 18
+- not:
+    - $y[name] = a
+`, q.String())
+	})
+	t.Run("rejection trace", func(t *testing.T) {
+		db := newDatabase(t, sc, nil /* indexes */, &treetest.Node{Name: "a", Ordinal: 30})
+		q, err := rel.NewQuery(sc, x.Type((*treetest.Node)(nil)), x.AttrLt(ordinal, uint32(18)))
+		require.NoError(t, err)
+		var trace rel.RejectionTrace
+		require.NoError(t, q.Iterate(db, func(r rel.Result) error {
+			return nil
+		}, rel.WithRejectionTrace(&trace)))
+		require.Len(t, trace.Rejections, 1)
+		require.Regexp(t, `\$x\[ordinal\] < 18: predicate is not satisfied`, trace.Rejections[0].String())
+	})
+	t.Run("errors", func(t *testing.T) {
+		_, err := rel.NewQuery(sc, x.AttrEq(name, "a"), x.AttrEq(name, "b"))
+		require.Regexp(t, "query contains contradiction on name", err)
+		_, err = rel.NewQuery(sc, x.AttrEq(ordinal, "a"))
+		require.Regexp(t, `failed to process invalid clause \$x\[ordinal\] = a`, err)
+	})
+	t.Run("invalid", func(t *testing.T) {
+		for _, tc := range []struct {
+			opts []rel.SchemaOption
+			err  string
+		}{
+			{
+				opts: []rel.SchemaOption{rel.NamedAttr(name, "")},
+				err:  "empty name for attribute Name",
+			},
+			{
+				opts: []rel.SchemaOption{rel.NamedAttr(stringAttr("q"), "q")},
+				err:  "cannot name attribute q which is not in the schema",
+			},
+			{
+				opts: []rel.SchemaOption{rel.NamedAttr(name, "x"), rel.NamedAttr(name, "y")},
+				err:  `attribute Name named both "x" and "y"`,
+			},
+			{
+				opts: []rel.SchemaOption{rel.NamedAttr(name, "x"), rel.NamedAttr(ordinal, "x")},
+				err:  `name "x" used for both Name and Ordinal`,
+			},
+		} {
+			_, err := rel.NewSchema("invalid", append([]rel.SchemaOption{
+				rel.EntityMapping(reflect.TypeOf((*treetest.Node)(nil)),
+					rel.EntityAttr(name, "Name"),
+					rel.EntityAttr(ordinal, "Ordinal"),
+				),
+			}, tc.opts...)...)
+			require.Regexp(t, tc.err, err)
+		}
+	})
+}
+
+func TestEvaluateAcross(t *testing.T) {
+	sc := itemtest.Schema
+	before := newDatabase(t, sc, nil /* indexes */, []interface{}{
+		&itemtest.Item{Name: "a", Value: 1},
+		&itemtest.Item{Name: "b", Value: 2},
+	}...)
+	after := newDatabase(t, sc, nil /* indexes */, []interface{}{
+		&itemtest.Item{Name: "a", Value: 2},
+		&itemtest.Item{Name: "b", Value: 2},
+		&itemtest.Item{Name: "c", Value: 2},
+	}...)
+
+	var x rel.Var = "x"
+	q, err := rel.NewQuery(sc, x.Type((*itemtest.Item)(nil)), x.AttrEq(itemtest.Value, 2))
+	require.NoError(t, err)
+	names := func(results []rel.Result) (ret []string) {
+		for _, r := range results {
+			ret = append(ret, r.Var(x).(*itemtest.Item).Name)
+		}
+		sort.Strings(ret)
+		return ret
+	}
+
+	t.Run("across", func(t *testing.T) {
+		results, err := rel.EvaluateAcross(map[string]*rel.Database{
+			"before": before,
+			"after":  after,
+		}, q)
+		require.NoError(t, err)
+		require.Len(t, results, 2)
+		require.Equal(t, []string{"b"}, names(results["before"]))
+		require.Equal(t, []string{"a", "b", "c"}, names(results["after"]))
+	})
+	t.Run("empty", func(t *testing.T) {
+		results, err := rel.EvaluateAcross(map[string]*rel.Database{
+			"empty": newDatabase(t, sc, nil /* indexes */),
+		}, q)
+		require.NoError(t, err)
+		require.Equal(t, map[string][]rel.Result{"empty": {}}, results)
+	})
+	t.Run("schema mismatch", func(t *testing.T) {
+		_, err := rel.EvaluateAcross(map[string]*rel.Database{
+			"before": before,
+			"other":  newDatabase(t, treetest.Schema, nil /* indexes */),
+		}, q)
+		require.EqualError(t, err,
+			"database other and query are not from the same schema: tree != items")
+	})
+}
+
+func TestRule(t *testing.T) {
+	named := rel.DefineRule("named", []rel.Var{"x", "n"}, func(params ...rel.Var) rel.Clause {
+		return params[0].AttrEqVar(treetest.Name, params[1])
+	})
+	require.Equal(t, "named", named.Name())
+	require.PanicsWithError(t, "rule named: expected 2 argument(s), got 1", func() {
+		named.Apply("x")
+	})
+	require.PanicsWithError(t, "rule r: duplicate parameter a", func() {
+		rel.DefineRule("r", []rel.Var{"a", "a"}, func(params ...rel.Var) rel.Clause {
+			return params[0].Type((*treetest.Node)(nil))
+		})
+	})
+}
+
+func TestOptimize(t *testing.T) {
+	sc := treetest.Schema
+	var parents []interface{}
+	for i := 0; i < 4; i++ {
+		parents = append(parents, &treetest.Node{Name: fmt.Sprintf("p%d", i), Kind: "parent"})
+	}
+	var children []interface{}
+	for i := 0; i < 16; i++ {
+		c := &treetest.Node{
+			Parent: parents[i%len(parents)].(*treetest.Node),
+			Name:   fmt.Sprintf("c%d", i),
+			Kind:   "common",
+		}
+		if i%8 == 0 {
+			c.Kind = "rare"
+		}
+		children = append(children, c)
+	}
+	db := newDatabase(t, sc, nil /* indexes */, append(parents, children...)...)
+	stats := db.Stats()
+	require.Equal(t, 20, stats.NumEntities())
+	rare, err := stats.Count(treetest.Kind, "rare")
+	require.NoError(t, err)
+	require.Equal(t, 2, rare)
+
+	// The clauses are ordered such that the parents would be joined before
+	// the few children which are rare.
+	var pv, cv, nv rel.Var = "p", "c", "n"
+	q, err := rel.NewQuery(sc,
+		pv.AttrEq(treetest.Kind, "parent"),
+		pv.AttrEqVar(treetest.Name, nv),
+		cv.AttrEqVar(treetest.Parent, pv),
+		cv.AttrEq(treetest.Kind, "rare"),
+	)
+	require.NoError(t, err)
+	require.Equal(t, []rel.Var{pv, cv}, q.Entities())
+	optimized, err := q.Optimize(stats)
+	require.NoError(t, err)
+	require.Equal(t, []rel.Var{cv, pv}, optimized.Entities())
+
+	results := func(q *rel.Query) (ret []string) {
+		require.NoError(t, q.Iterate(db, func(r rel.Result) error {
+			ret = append(ret, fmt.Sprintf("%s/%s", r.Var(nv), r.Var(cv).(*treetest.Node).Name))
+			return nil
+		}))
+		sort.Strings(ret)
+		return ret
+	}
+	exp := []string{"p0/c0", "p0/c8"}
+	require.Equal(t, exp, results(q))
+	require.Equal(t, exp, results(optimized))
+
+	// Optimizing an optimized query does not change the order further.
+	again, err := optimized.Optimize(stats)
+	require.NoError(t, err)
+	require.Equal(t, optimized.Entities(), again.Entities())
+
+	other := newDatabase(t, itemtest.Schema, nil /* indexes */)
+	_, err = q.Optimize(other.Stats())
+	require.Regexp(t, "statistics of schema items cannot be used", err)
+}
+
+func TestFilterSlice(t *testing.T) {
+	sc := itemtest.Schema
+	z := &itemtest.Item{Name: "c", Value: 1}
+	db := newDatabase(t, sc, nil /* indexes */, []interface{}{
+		&itemtest.Item{Name: "a", Value: 1},
+		&itemtest.Item{Name: "b", Value: 1},
+		z,
+		&itemtest.Item{Name: "a", Value: 2},
+	}...)
+
+	var seen [][]interface{}
+	allDistinct := func(values []interface{}) bool {
+		seen = append(seen, values)
+		for i := range values {
+			for j := range values[:i] {
+				if values[i] == values[j] {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	var a, b, c, na, nb, nc rel.Var = "a", "b", "c", "na", "nb", "nc"
+	q, err := rel.NewQuery(sc,
+		a.AttrEq(itemtest.Value, 1),
+		a.AttrEq(itemtest.Name, "a"),
+		b.AttrEq(itemtest.Value, 1),
+		b.AttrEq(itemtest.Name, "b"),
+		c.AttrEq(itemtest.Value, 1),
+		a.AttrEqVar(itemtest.Name, na),
+		b.AttrEqVar(itemtest.Name, nb),
+		c.AttrEqVar(itemtest.Name, nc),
+		rel.FilterSlice("allDistinct", []rel.Var{na, nb, nc}, allDistinct),
+	)
+	require.NoError(t, err)
+	require.Contains(t, q.String(), "allDistinct([]interface {})($na, $nb, $nc)")
+
+	// Only the binding of c to the item whose name is distinct from those of
+	// a and b passes the filter; the others have a duplicate name.
+	var results []*itemtest.Item
+	var trace rel.RejectionTrace
+	require.NoError(t, q.Iterate(db, func(r rel.Result) error {
+		results = append(results, r.Var(c).(*itemtest.Item))
+		return nil
+	}, rel.WithRejectionTrace(&trace)))
+	require.Equal(t, []*itemtest.Item{z}, results)
+	require.Len(t, seen, 3)
+	require.Contains(t, seen, []interface{}{"a", "b", "c"})
+	require.Contains(t, seen, []interface{}{"a", "b", "a"})
+	require.Len(t, trace.Rejections, 2)
+	for _, r := range trace.Rejections {
+		require.Equal(t, "filter returned false", r.Reason)
+	}
+
+	_, err = rel.NewQuery(sc, a.AttrEqVar(itemtest.Name, na), rel.FilterSlice("nil", []rel.Var{na}, nil))
+	var filterErr *rel.FilterError
+	require.True(t, errors.As(err, &filterErr), "%v", err)
+	require.Equal(t, "nil", filterErr.Name)
+}
+
+// TestStopIteration ensures that halting the iteration of a query joining
+// several entities stops the evaluation at every level of the join.
+func TestStopIteration(t *testing.T) {
+	sc := itemtest.Schema
+	db := newDatabase(t, sc, nil /* indexes */, []interface{}{
+		&itemtest.Item{Name: "a"},
+		&itemtest.Item{Name: "b"},
+		&itemtest.Item{Name: "c"},
+	}...)
+	var a, b, c rel.Var = "a", "b", "c"
+	q, err := rel.NewQuery(sc,
+		a.Type((*itemtest.Item)(nil)), b.Type((*itemtest.Item)(nil)), c.Type((*itemtest.Item)(nil)),
+		c.AttrIn(itemtest.Name, "a", "b"),
+	)
+	require.NoError(t, err)
+	var calls int
+	require.NoError(t, q.Iterate(db, func(r rel.Result) error {
+		calls++
+		return iterutil.StopIteration()
+	}))
+	require.Equal(t, 1, calls)
+}
+
+// TestClauseInterner ensures that structurally identical leaf clauses of
+// queries constructed with the same ClauseInterner are shared, and that the
+// results of the queries are the same as without interning.
+func TestClauseInterner(t *testing.T) {
+	sc := itemtest.Schema
+	db := newDatabase(t, sc, nil /* indexes */, []interface{}{
+		&itemtest.Item{Name: "a", Value: 1},
+		&itemtest.Item{Name: "b", Value: 2},
+		&itemtest.Item{Name: "c", Value: 3},
+	}...)
+
+	var x, n rel.Var = "x", "n"
+	makeQueries := func(newQuery func(...rel.Clause) (*rel.Query, error)) (q1, q2 *rel.Query) {
+		q1, err := newQuery(
+			x.Type((*itemtest.Item)(nil)),
+			x.AttrIn(itemtest.Value, 1, 2),
+			x.AttrEqVar(itemtest.Name, n),
+		)
+		require.NoError(t, err)
+		q2, err = newQuery(
+			x.Type((*itemtest.Item)(nil)),
+			x.AttrIn(itemtest.Value, 2, 3),
+			x.AttrEqVar(itemtest.Name, n),
+			n.In("a", "b"),
+		)
+		require.NoError(t, err)
+		return q1, q2
+	}
+	ci := rel.NewClauseInterner()
+	q1, q2 := makeQueries(func(clauses ...rel.Clause) (*rel.Query, error) {
+		return rel.NewQueryWithInterner(sc, ci, clauses...)
+	})
+	c1, c2 := q1.Clauses(), q2.Clauses()
+	require.True(t, c1[0] == c2[0], "Type clauses are not shared")
+	require.True(t, c1[2] == c2[2], "AttrEqVar clauses are not shared")
+	require.False(t, c1[1] == c2[1], "AttrIn clauses with different values are shared")
+	require.Equal(t, 5, ci.Len())
+
+	// Interning the same clauses again adds nothing.
+	q3, _ := makeQueries(func(clauses ...rel.Clause) (*rel.Query, error) {
+		return rel.NewQueryWithInterner(sc, ci, clauses...)
+	})
+	for i, c := range q3.Clauses() {
+		require.True(t, c == c1[i], "clause %d is not shared", i)
+	}
+	require.Equal(t, 5, ci.Len())
+
+	names := func(q *rel.Query) []string {
+		got := []string{}
+		require.NoError(t, q.Iterate(db, func(r rel.Result) error {
+			got = append(got, r.Var(n).(string))
+			return nil
+		}, rel.OrderBy(n)))
+		return got
+	}
+	p1, p2 := makeQueries(func(clauses ...rel.Clause) (*rel.Query, error) {
+		return rel.NewQuery(sc, clauses...)
+	})
+	require.Equal(t, []string{"a", "b"}, names(q1))
+	require.Equal(t, names(p1), names(q1))
+	require.Equal(t, names(p1), names(q3))
+	require.Equal(t, []string{"b"}, names(q2))
+	require.Equal(t, names(p2), names(q2))
+}
+
+func TestExplainAnalyze(t *testing.T) {
+	sc := treetest.Schema
+	var parents []interface{}
+	for i := 0; i < 4; i++ {
+		parents = append(parents, &treetest.Node{Name: fmt.Sprintf("p%d", i), Kind: "parent"})
+	}
+	var children []interface{}
+	for i := 0; i < 16; i++ {
+		c := &treetest.Node{
+			Parent: parents[i%len(parents)].(*treetest.Node),
+			Name:   fmt.Sprintf("c%d", i),
+			Kind:   "common",
+		}
+		if i%8 == 0 {
+			c.Kind = "rare"
+		}
+		children = append(children, c)
+	}
+	db := newDatabase(t, sc, nil /* indexes */, append(parents, children...)...)
+
+	// The filter is checked once all the entities are bound, so the number
+	// of times it is called and the number of times it passes are the ground
+	// truth for the candidates which passed the last position of the join
+	// and for the results.
+	var calls, passes int
+	var pv, cv, nv rel.Var = "p", "c", "n"
+	q, err := rel.NewQuery(sc,
+		pv.AttrEq(treetest.Kind, "parent"),
+		pv.AttrEqVar(treetest.Name, nv),
+		cv.AttrEqVar(treetest.Parent, pv),
+		rel.Filter("isRare", cv)(func(c *treetest.Node) bool {
+			calls++
+			if c.Kind != "rare" {
+				return false
+			}
+			passes++
+			return true
+		}),
+	)
+	require.NoError(t, err)
+
+	out, results, err := q.ExplainAnalyze(db)
+	require.NoError(t, err)
+	require.Len(t, results, passes)
+	var got []string
+	for _, r := range results {
+		got = append(got, fmt.Sprintf("%s/%s", r.Var(nv), r.Var(cv).(*treetest.Node).Name))
+	}
+	sort.Strings(got)
+	require.Equal(t, []string{"p0/c0", "p0/c8"}, got)
+
+	// Apart from the counts, the output only depends on the query.
+	require.Equal(t, `entities:
+  $p: estimated N, examined N, passed N
+  $c: estimated N, examined N, passed N
+clauses:
+  $p[Kind] = parent: rejected N
+  $p[Name] = $n: rejected N
+  $c[Parent] = $p: rejected N
+  isRare(*treetest.Node)($c): rejected N
+results: N
+`, regexp.MustCompile(`\d+`).ReplaceAllString(out, "N"))
+
+	// Each child is examined once, when its parent is bound, and all of them
+	// reach the filter, which rejects those which are not rare. Nothing but
+	// the parent constrains the children, so every node is estimated.
+	numParents, numChildren := len(parents), len(children)
+	require.Equal(t, numChildren, calls)
+	require.Equal(t, fmt.Sprintf(`entities:
+  $p: estimated %[1]d, examined %[1]d, passed %[1]d
+  $c: estimated %[6]d, examined %[2]d, passed %[3]d
+clauses:
+  $p[Kind] = parent: rejected 0
+  $p[Name] = $n: rejected 0
+  $c[Parent] = $p: rejected 0
+  isRare(*treetest.Node)($c): rejected %[4]d
+results: %[5]d
+`, numParents, numChildren, calls, calls-passes, passes, numParents+numChildren), out)
+
+	// The rejections agree with those recorded by a trace.
+	var trace rel.RejectionTrace
+	rejected := calls - passes
+	require.NoError(t, q.Iterate(db, func(rel.Result) error { return nil },
+		rel.WithRejectionTrace(&trace)))
+	require.Len(t, trace.Rejections, rejected)
+
+	other := newDatabase(t, itemtest.Schema, nil /* indexes */)
+	_, _, err = q.ExplainAnalyze(other)
+	require.Regexp(t, "query and database are not from the same schema", err)
+}
+
+// newDatabase constructs a database of the schema with the indexes, and
+// inserts the entities into it.
+func newDatabase(
+	t *testing.T, sc *rel.Schema, indexes [][]rel.Attr, entities ...interface{},
+) *rel.Database {
+	db, err := rel.NewDatabase(sc, indexes)
+	require.NoError(t, err)
+	for _, e := range entities {
+		require.NoError(t, db.Insert(e))
+	}
+	return db
+}
+
 type stringAttr string
 
 func (sa stringAttr) String() string { return string(sa) }
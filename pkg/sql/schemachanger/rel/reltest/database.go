@@ -51,8 +51,9 @@ type QueryTest struct {
 	// Entities is the set of entities of the query in their join order.
 	Entities []rel.Var
 
-	// ErrorRE is used to indicate that the query is invalid and will
-	// result in an error that must match this pattern.
+	// ErrorRE is used to indicate that the query is invalid or that its
+	// evaluation fails, and will result in an error that must match this
+	// pattern.
 	ErrorRE string
 }
 
@@ -77,21 +78,28 @@ func (tc DatabaseTest) run(t *testing.T, s Suite) {
 func (qc QueryTest) run(t *testing.T, db *rel.Database) {
 	var results [][]interface{}
 	q, err := rel.NewQuery(db.Schema(), qc.Query...)
-	if qc.ErrorRE != "" {
+	if qc.ErrorRE != "" && err != nil {
 		require.Regexp(t, qc.ErrorRE, err)
 		return
 	}
 
 	require.NoError(t, err)
-	require.Equal(t, qc.Entities, q.Entities())
-	require.NoError(t, q.Iterate(db, func(r rel.Result) error {
+	if qc.ErrorRE == "" {
+		require.Equal(t, qc.Entities, q.Entities())
+	}
+	err = q.Iterate(db, func(r rel.Result) error {
 		var cur []interface{}
 		for _, v := range qc.ResVars {
 			cur = append(cur, r.Var(v))
 		}
 		results = append(results, cur)
 		return nil
-	}))
+	})
+	if qc.ErrorRE != "" {
+		require.Regexp(t, qc.ErrorRE, err)
+		return
+	}
+	require.NoError(t, err)
 	expResults := append(qc.Results[:0:0], qc.Results...)
 	findResultInExp := func(res []interface{}) (found bool) {
 		for i, exp := range expResults {
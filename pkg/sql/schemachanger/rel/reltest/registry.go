@@ -11,6 +11,7 @@
 package reltest
 
 import (
+	"reflect"
 	"strings"
 	"testing"
 
@@ -85,6 +86,10 @@ func (r *Registry) MustGetName(t *testing.T, v interface{}) string {
 
 // GetName is like MustGetName but does not enforce that it exists.
 func (r *Registry) GetName(i interface{}) (string, bool) {
+	// Values which cannot be registered, like slices, cannot be map keys.
+	if typ := reflect.TypeOf(i); typ != nil && !typ.Comparable() {
+		return "", false
+	}
 	got, ok := r.valueToName[i]
 	return got, ok
 }
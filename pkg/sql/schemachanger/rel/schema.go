@@ -11,6 +11,7 @@
 package rel
 
 import (
+	"fmt"
 	"reflect"
 	"sort"
 	"strings"
@@ -21,10 +22,12 @@ import (
 
 // Schema defines a mapping of entities to their attributes and decomposition.
 type Schema struct {
-	name              string
-	attrs             []Attr
-	attrTypes         []reflect.Type
-	attrToOrdinal     map[Attr]ordinal
+	name          string
+	attrs         []Attr
+	attrTypes     []reflect.Type
+	attrToOrdinal map[Attr]ordinal
+	// attrNames are the names registered for attributes with NamedAttr.
+	attrNames         map[Attr]string
 	entityTypeSchemas map[reflect.Type]*entityTypeSchema
 }
 
@@ -54,6 +57,11 @@ type entityTypeSchema struct {
 	typ        reflect.Type
 	fields     []fieldInfo
 	attrFields map[ordinal][]fieldInfo
+	// required are the attributes which entities of the type must have.
+	required ordinalSet
+	// identity, if non-nil, computes the logical key which identifies
+	// entities of the type in a Database in place of their pointer value.
+	identity func(entity interface{}) interface{}
 }
 
 type fieldInfo struct {
@@ -87,8 +95,9 @@ func buildSchema(name string, opts ...SchemaOption) *Schema {
 
 	// We want to know what all the variable types are.
 	for _, tm := range m.entityMappings {
-		sb.maybeAddTypeMapping(tm.typ, tm.attrMappings)
+		sb.maybeAddTypeMapping(tm.typ, tm.attrMappings, tm.required, tm.identity)
 	}
+	sb.addAttrNames(m.attrNames)
 	return sb.Schema
 }
 
@@ -100,6 +109,11 @@ type schemaBuilder struct {
 func (sb *schemaBuilder) maybeAddAttribute(a Attr, typ reflect.Type) ordinal {
 	// TODO(ajwerner): Validate that t is an okay type for an attribute
 	// to be.
+	if ta, ok := a.(typedAttr); ok {
+		if err := checkType(typ, ta.valueType()); err != nil {
+			panic(errors.Wrapf(err, "type mismatch for %v", sb.AttrName(a)))
+		}
+	}
 	ord, exists := sb.attrToOrdinal[a]
 	if !exists {
 		ord = ordinal(len(sb.attrs))
@@ -113,28 +127,42 @@ func (sb *schemaBuilder) maybeAddAttribute(a Attr, typ reflect.Type) ordinal {
 	}
 	prev := sb.attrTypes[ord]
 	if err := checkType(typ, prev); err != nil {
-		panic(errors.Wrapf(err, "type mismatch for %v", a))
+		panic(errors.Wrapf(err, "type mismatch for %v", sb.AttrName(a)))
 	}
 	return ord
 }
 
+// typedAttr is implemented by attributes which constrain the type of their
+// values; see StringAttr.
+type typedAttr interface {
+	Attr
+	valueType() reflect.Type
+}
+
 // checkType determines whether, either, the typ matches exp or the typ
 // implements exp which is an interface type.
 func checkType(typ, exp reflect.Type) error {
 	switch exp.Kind() {
 	case reflect.Interface:
 		if !typ.Implements(exp) {
-			return errors.Errorf("%v does not implement %v", typ, exp)
+			return &TypeMismatchError{
+				Type: typ, Expected: fmt.Sprintf("an implementation of %v", exp),
+			}
 		}
 	default:
 		if typ != exp {
-			return errors.Errorf("%v is not %v", typ, exp)
+			return &TypeMismatchError{Type: typ, Expected: exp.String()}
 		}
 	}
 	return nil
 }
 
-func (sb *schemaBuilder) maybeAddTypeMapping(t reflect.Type, attributeMappings []attrMapping) {
+func (sb *schemaBuilder) maybeAddTypeMapping(
+	t reflect.Type,
+	attributeMappings []attrMapping,
+	required []Attr,
+	identity func(entity interface{}) interface{},
+) {
 	isStructPointer := func(tt reflect.Type) bool {
 		return tt.Kind() == reflect.Ptr && tt.Elem().Kind() == reflect.Struct
 	}
@@ -165,10 +193,20 @@ func (sb *schemaBuilder) maybeAddTypeMapping(t reflect.Type, attributeMappings [
 		attributeFields[cur] = fieldInfos[i:j]
 		i = j
 	}
+	var requiredAttrs ordinalSet
+	for _, a := range required {
+		ord := sb.mustGetOrdinal(a)
+		if _, ok := attributeFields[ord]; !ok {
+			panic(errors.Errorf("required attribute %v is not mapped for %v", sb.AttrName(a), t))
+		}
+		requiredAttrs = requiredAttrs.add(ord)
+	}
 	sb.entityTypeSchemas[t] = &entityTypeSchema{
 		typ:        t,
 		fields:     fieldInfos,
 		attrFields: attributeFields,
+		required:   requiredAttrs,
+		identity:   identity,
 	}
 }
 
@@ -180,9 +218,9 @@ func (sb *schemaBuilder) addTypeAttrMapping(a Attr, t reflect.Type, sel string)
 	// whether entities exist. We'd otherwise need some mechanism for interning
 	// structs or something like that.
 	isPtr := cur.Kind() == reflect.Ptr
-	isStructPtr := isPtr && cur.Elem().Kind() == reflect.Struct
-	isScalarPtr := isPtr && isSupportScalarKind(cur.Elem().Kind())
-	if !isScalarPtr && !isStructPtr && !isSupportScalarKind(cur.Kind()) {
+	isScalarPtr := isPtr && isSupportScalarType(cur.Elem())
+	isStructPtr := isPtr && !isScalarPtr && cur.Elem().Kind() == reflect.Struct
+	if !isScalarPtr && !isStructPtr && !isSupportScalarType(cur) && !isSupportSliceType(cur) {
 		panic(errors.Errorf(
 			"selector %q of %v has unsupported type %v",
 			sel, t, cur,
@@ -289,7 +327,7 @@ func (sc *Schema) mustGetOrdinal(attribute Attr) ordinal {
 func (sc *Schema) getOrdinal(attribute Attr) (ordinal, error) {
 	ord, ok := sc.attrToOrdinal[attribute]
 	if !ok {
-		return 0, errors.Errorf("unknown attribute %s in schema %s", attribute, sc.name)
+		return 0, errors.Errorf("unknown attribute %s in schema %s", sc.AttrName(attribute), sc.name)
 	}
 	return ord, nil
 }
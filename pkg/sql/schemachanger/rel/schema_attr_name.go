@@ -0,0 +1,175 @@
+// Copyright 2021 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package rel
+
+import "github.com/cockroachdb/errors"
+
+// NamedAttr associates a human-readable name with an attribute of the schema.
+// The name is used in place of the attribute's String representation in
+// diagnostics: error messages, rejection traces, and the rendering of
+// queries. The attribute must be in the schema and the names must be
+// distinct.
+func NamedAttr(a Attr, name string) SchemaOption {
+	return attrName{a: a, name: name}
+}
+
+type attrName struct {
+	a    Attr
+	name string
+}
+
+func (n attrName) apply(mappings *schemaMappings) {
+	mappings.attrNames = append(mappings.attrNames, n)
+}
+
+// AttrName returns the name registered for the attribute with NamedAttr, or
+// its String representation if it has none.
+func (sc *Schema) AttrName(a Attr) string {
+	if name, ok := sc.attrNames[a]; ok {
+		return name
+	}
+	return a.String()
+}
+
+// ordinalName returns the name of the attribute with the given ordinal.
+func (sc *Schema) ordinalName(ord ordinal) string {
+	return sc.AttrName(sc.attrs[ord])
+}
+
+func (sb *schemaBuilder) addAttrNames(names []attrName) {
+	if len(names) == 0 {
+		return
+	}
+	sb.attrNames = make(map[Attr]string, len(names))
+	named := make(map[string]Attr, len(names))
+	for _, n := range names {
+		if _, ok := sb.attrToOrdinal[n.a]; !ok {
+			panic(errors.Errorf("cannot name attribute %v which is not in the schema", n.a))
+		}
+		if n.name == "" {
+			panic(errors.Errorf("empty name for attribute %v", n.a))
+		}
+		if prev, ok := sb.attrNames[n.a]; ok && prev != n.name {
+			panic(errors.Errorf("attribute %v named both %q and %q", n.a, prev, n.name))
+		}
+		if other, ok := named[n.name]; ok && other != n.a {
+			panic(errors.Errorf("name %q used for both %v and %v", n.name, other, n.a))
+		}
+		sb.attrNames[n.a] = n.name
+		named[n.name] = n.a
+	}
+}
+
+// displayAttr formats as the name registered for the attribute it wraps. It
+// is only used to render clauses.
+type displayAttr struct {
+	Attr
+	name string
+}
+
+func (d displayAttr) String() string { return d.name }
+
+// withAttrNames returns a copy of the clause in which the attributes with
+// registered names are formatted using them.
+func (sc *Schema) withAttrNames(c Clause) Clause {
+	if sc == nil || len(sc.attrNames) == 0 || c == nil {
+		return c
+	}
+	named := func(a Attr) Attr {
+		if name, ok := sc.attrNames[a]; ok {
+			return displayAttr{Attr: a, name: name}
+		}
+		return a
+	}
+	namedRef := func(r attrRef) attrRef {
+		return attrRef{v: r.v, a: named(r.a)}
+	}
+	clauses := func(cs []Clause) []Clause {
+		ret := make([]Clause, len(cs))
+		for i, c := range cs {
+			ret[i] = sc.withAttrNames(c)
+		}
+		return ret
+	}
+	switch t := c.(type) {
+	case *tripleDecl:
+		cp := *t
+		cp.attribute = named(t.attribute)
+		return &cp
+	case *attrEqAttrDecl:
+		cp := *t
+		cp.attribute, cp.otherAttribute = named(t.attribute), named(t.otherAttribute)
+		return &cp
+	case *predicateDecl:
+		cp := *t
+		cp.operands = make([]attrRef, len(t.operands))
+		for i, r := range t.operands {
+			cp.operands[i] = namedRef(r)
+		}
+		switch rhs := t.rhs.(type) {
+		case attrRef:
+			cp.rhs = namedRef(rhs)
+		case []attrRef:
+			refs := make([]attrRef, len(rhs))
+			for i, r := range rhs {
+				refs[i] = namedRef(r)
+			}
+			cp.rhs = refs
+		}
+		return &cp
+	case *subqueryDecl:
+		cp := *t
+		cp.attribute, cp.sub = named(t.attribute), sc.withAttrNames(t.sub)
+		return &cp
+	case *hopsDecl:
+		cp := *t
+		cp.attribute = named(t.attribute)
+		return &cp
+	case *noChildDecl:
+		cp := *t
+		cp.attribute = named(t.attribute)
+		return &cp
+	case *unreachableDecl:
+		cp := *t
+		cp.attribute = named(t.attribute)
+		return &cp
+	case *extremumDecl:
+		cp := *t
+		cp.attribute, cp.within = named(t.attribute), sc.withAttrNames(t.within)
+		return &cp
+	case *fanoutDecl:
+		cp := *t
+		cp.c = sc.withAttrNames(t.c)
+		return &cp
+	case *notDecl:
+		return &notDecl{c: sc.withAttrNames(t.c)}
+	case *countDecl:
+		cp := *t
+		cp.c = sc.withAttrNames(t.c)
+		return &cp
+	case *antiJoinDecl:
+		cp := *t
+		cp.c = sc.withAttrNames(t.c)
+		return &cp
+	case unionDecl:
+		ret := make(unionDecl, len(t))
+		for i, b := range t {
+			ret[i] = TaggedClause{Tag: b.Tag, Clause: sc.withAttrNames(b.Clause)}
+		}
+		return ret
+	case and:
+		return and(clauses(t))
+	case or:
+		return or(clauses(t))
+	default:
+		return c
+	}
+}
@@ -42,6 +42,25 @@ func EntityAttr(a Attr, selectors ...string) EntityMappingOption {
 	return attrMapping{a: a, selectors: selectors}
 }
 
+// RequiredAttrs marks attributes, which must be mapped for the entity, as
+// required: every entity of the type must have a value for each of them. This
+// is only useful for attributes mapped to pointer fields, as the others
+// always have a value. It is checked by (*Database).Validate.
+func RequiredAttrs(attrs ...Attr) EntityMappingOption {
+	return requiredAttrs(attrs)
+}
+
+// EntityIdentity specifies a function computing the logical key of entities
+// of the type. By default, entities are identified by their pointer value.
+// When inserting an entity into a Database which already contains a distinct
+// pointer with the same logical key, the already inserted entity is used in
+// its place, both by the Database and in references from other entities
+// inserted thereafter. The keys must be comparable with ==, and are only
+// compared between entities of the same type.
+func EntityIdentity(identity func(entity interface{}) interface{}) EntityMappingOption {
+	return entityIdentity(identity)
+}
+
 // schemaMappings defines how to map data types to Attr.
 type schemaMappings struct {
 
@@ -68,6 +87,10 @@ type schemaMappings struct {
 	// TODO(ajwerner): Support pointers to primitive types as well as interface
 	// values. Interface values get tricky.
 	entityMappings []entityMapping
+
+	// attrNames are the human-readable names of attributes used in
+	// diagnostics.
+	attrNames []attrName
 }
 
 type attrType struct {
@@ -82,6 +105,8 @@ func (a attrType) apply(mappings *schemaMappings) {
 type entityMapping struct {
 	typ          reflect.Type
 	attrMappings []attrMapping
+	required     []Attr
+	identity     func(entity interface{}) interface{}
 }
 
 func (t entityMapping) apply(mappings *schemaMappings) {
@@ -98,3 +123,15 @@ type attrMapping struct {
 func (a attrMapping) apply(tm *entityMapping) {
 	tm.attrMappings = append(tm.attrMappings, a)
 }
+
+type requiredAttrs []Attr
+
+func (r requiredAttrs) apply(tm *entityMapping) {
+	tm.required = append(tm.required, r...)
+}
+
+type entityIdentity func(entity interface{}) interface{}
+
+func (f entityIdentity) apply(tm *entityMapping) {
+	tm.identity = f
+}
@@ -34,7 +34,7 @@ func makeComparableValue(val interface{}) (typedValue, error) {
 	}
 	typ := vv.Type()
 	switch {
-	case isSupportScalarKind(typ.Kind()):
+	case isSupportScalarType(typ), isSupportSliceType(typ):
 		// We need to allocate a new pointer.
 		compType := getComparableType(typ)
 		vvNew := reflect.New(vv.Type())
@@ -45,7 +45,7 @@ func makeComparableValue(val interface{}) (typedValue, error) {
 		}, nil
 	case typ.Kind() == reflect.Ptr:
 		switch {
-		case isSupportScalarKind(typ.Elem().Kind()):
+		case isSupportScalarType(typ.Elem()):
 			compType := getComparableType(typ.Elem())
 			return typedValue{
 				typ:   vv.Type().Elem(),